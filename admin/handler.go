@@ -0,0 +1,679 @@
+// Package admin serves the JSON admin API used to manage slskrr at runtime.
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nerney/slskrr/auth"
+	"github.com/nerney/slskrr/backup"
+	"github.com/nerney/slskrr/blocklist"
+	"github.com/nerney/slskrr/logring"
+	"github.com/nerney/slskrr/searchlog"
+	"github.com/nerney/slskrr/slskd"
+	"github.com/nerney/slskrr/stats"
+	"github.com/nerney/slskrr/store"
+	"github.com/nerney/slskrr/wishlist"
+)
+
+// Downloads acts on tracked downloads.
+type Downloads interface {
+	Grab(ctx context.Context, username string, files []slskd.DownloadRequest, category string) ([]string, error)
+	Retry(ctx context.Context, id string) error
+	Cancel(ctx context.Context, id string) error
+	Speeds(id string) ([]store.SpeedSample, error)
+}
+
+// Operations are maintenance actions for recovering from odd states
+// without a restart.
+type Operations interface {
+	SyncNow(ctx context.Context) error
+	RefreshOptions(ctx context.Context) (string, error)
+}
+
+// Searcher runs Soulseek searches and manages the ones in flight.
+type Searcher interface {
+	SearchAndWait(ctx context.Context, query string, timeout time.Duration) ([]slskd.SearchResponse, error)
+	Searches() []slskd.SearchInfo
+	CancelSearch(ctx context.Context, id string) error
+}
+
+// Browser lists the files a peer shares in a directory.
+type Browser interface {
+	BrowseDirectory(ctx context.Context, username, directory string) ([]slskd.SlskdFile, error)
+}
+
+// Handler serves the admin API under /admin/api/.
+type Handler struct {
+	Keys      *auth.Keyring
+	Auth      *auth.AdminAuth // when unset, any client API key is accepted
+	Downloads Downloads
+	Ops       Operations
+	Blocklist *blocklist.Blocklist
+	Wishlist  *wishlist.Wishlist
+	Stats     *stats.Tally
+	Backups   *backup.Backups // nil when BACKUP_DIR is unset
+	Searches  *searchlog.Log
+
+	Searcher      Searcher
+	Browser       Browser // resolves grabs given by path; nil requires full file lists
+	SearchTimeout time.Duration
+	Logs          *logring.Ring
+
+	once sync.Once
+	mux  *http.ServeMux
+}
+
+func (h *Handler) routes() {
+	h.mux = http.NewServeMux()
+	h.mux.HandleFunc("GET /admin/api/keys", h.adminOnly(h.handleListKeys))
+	h.mux.HandleFunc("POST /admin/api/keys", h.adminOnly(h.handleAddKey))
+	h.mux.HandleFunc("DELETE /admin/api/keys/{label}", h.adminOnly(h.handleRevokeKey))
+	h.mux.HandleFunc("POST /admin/api/sync", h.handleSync)
+	h.mux.HandleFunc("POST /admin/api/slskd/refresh", h.handleRefreshOptions)
+	h.mux.HandleFunc("GET /admin/api/logs", h.handleLogs)
+	h.mux.HandleFunc("POST /admin/api/search", h.handleSearch)
+	h.mux.HandleFunc("GET /admin/api/searches", h.handleListSearches)
+	h.mux.HandleFunc("DELETE /admin/api/searches/{id}", h.handleCancelSearch)
+	h.mux.HandleFunc("POST /admin/api/grab", h.handleGrab)
+	h.mux.HandleFunc("POST /admin/api/downloads/{id}/retry", h.handleRetryDownload)
+	h.mux.HandleFunc("DELETE /admin/api/downloads/{id}", h.handleCancelDownload)
+	h.mux.HandleFunc("GET /admin/api/downloads/{id}/speed", h.handleDownloadSpeed)
+	h.mux.HandleFunc("GET /admin/api/stats", h.handleStats)
+	h.mux.HandleFunc("GET /admin/api/blocklist", h.handleListBlocklist)
+	h.mux.HandleFunc("DELETE /admin/api/blocklist/{username}", h.handleUnblock)
+	h.mux.HandleFunc("GET /admin/api/wishlist", h.handleListWishlist)
+	h.mux.HandleFunc("POST /admin/api/wishlist", h.handleAddWishlist)
+	h.mux.HandleFunc("DELETE /admin/api/wishlist/{id}", h.handleRemoveWishlist)
+	h.mux.HandleFunc("GET /admin/api/requests", h.handleListRequests)
+	h.mux.HandleFunc("GET /admin/api/requests/{id}", h.handleGetRequest)
+	h.mux.HandleFunc("GET /admin/api/backups", h.handleListBackups)
+	h.mux.HandleFunc("POST /admin/api/backups", h.handleBackup)
+	h.mux.HandleFunc("POST /admin/api/backups/{name}/restore", h.handleRestore)
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.once.Do(h.routes)
+	h.Protect(h.mux).ServeHTTP(w, r)
+}
+
+// Protect wraps next with the admin authentication, for operator-only
+// endpoints served outside /admin/api/.
+func (h *Handler) Protect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.Auth.Enabled() {
+			if _, ok := h.Auth.Authenticate(r); !ok {
+				// A browser's first request carries no credentials; only
+				// count the ones that tried some.
+				if r.Header.Get("Authorization") != "" {
+					auth.Reject(r, auth.EventAdminLogin, "admin")
+				}
+				h.Auth.Challenge(w)
+				writeError(w, http.StatusUnauthorized, "Authentication required")
+				return
+			}
+		} else if !h.apiKeyAuthorized(r) {
+			auth.Reject(r, auth.EventAPIKey, "admin")
+			writeError(w, http.StatusUnauthorized, "API Key Incorrect")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// apiKeyAuthorized accepts any client API key, sent as X-Api-Key or ?apikey=.
+// It is only used when no dedicated admin authentication is configured.
+func (h *Handler) apiKeyAuthorized(r *http.Request) bool {
+	key := r.Header.Get("X-Api-Key")
+	if key == "" {
+		key = r.URL.Query().Get("apikey")
+	}
+	_, ok := h.Keys.Check(key)
+	return ok
+}
+
+// adminOnly refuses next unless dedicated admin authentication is
+// configured. Were client API keys enough, any app's key could list, add
+// and revoke every other app's.
+func (h *Handler) adminOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !h.Auth.Enabled() {
+			writeError(w, http.StatusForbidden, "Key management requires ADMIN_USER or ADMIN_AUTH_HEADER")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (h *Handler) handleListKeys(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"keys": h.Keys.List()})
+}
+
+func (h *Handler) handleAddKey(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Label string `json:"label"`
+		Key   string `json:"key"`
+	}
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if req.Label == "" {
+		writeError(w, http.StatusBadRequest, "Missing label")
+		return
+	}
+	if req.Key == "" {
+		req.Key = auth.GenerateKey()
+	}
+
+	err := h.Keys.Add(auth.Key{Label: req.Label, Value: req.Key})
+	if errors.Is(err, auth.ErrDuplicateLabel) || errors.Is(err, auth.ErrDuplicateKey) {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to add api key", "label", req.Label, "error", err)
+		writeError(w, http.StatusInternalServerError, "Failed to persist key")
+		return
+	}
+
+	slog.InfoContext(r.Context(), "api key added", "label", req.Label)
+	// The full key is only returned once, at creation time.
+	writeJSON(w, http.StatusCreated, map[string]any{"label": req.Label, "key": req.Key})
+}
+
+func (h *Handler) handleRevokeKey(w http.ResponseWriter, r *http.Request) {
+	label := r.PathValue("label")
+
+	err := h.Keys.Revoke(label)
+	switch {
+	case errors.Is(err, auth.ErrKeyNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	case errors.Is(err, auth.ErrStaticKey), errors.Is(err, auth.ErrLastKey):
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	case err != nil:
+		slog.ErrorContext(r.Context(), "failed to revoke api key", "label", label, "error", err)
+		writeError(w, http.StatusInternalServerError, "Failed to persist key removal")
+		return
+	}
+
+	slog.InfoContext(r.Context(), "api key revoked", "label", label)
+	writeJSON(w, http.StatusOK, map[string]any{"status": true})
+}
+
+func (h *Handler) handleSync(w http.ResponseWriter, r *http.Request) {
+	if err := h.Ops.SyncNow(r.Context()); err != nil {
+		slog.ErrorContext(r.Context(), "forced sync failed", "error", err)
+		writeError(w, http.StatusBadGateway, "Sync failed")
+		return
+	}
+	slog.InfoContext(r.Context(), "forced transfer sync")
+	writeJSON(w, http.StatusOK, map[string]any{"status": true})
+}
+
+func (h *Handler) handleRefreshOptions(w http.ResponseWriter, r *http.Request) {
+	dir, err := h.Ops.RefreshOptions(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to refresh slskd options", "error", err)
+		writeError(w, http.StatusBadGateway, "Failed to fetch slskd options")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"status": true, "downloadDir": dir})
+}
+
+func (h *Handler) handleLogs(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	level := slog.LevelDebug
+	if v := q.Get("level"); v != "" {
+		if err := level.UnmarshalText([]byte(v)); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid level")
+			return
+		}
+	}
+	limit := 0
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		limit = n
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"logs": h.Logs.Entries(level, limit)})
+}
+
+// searchPeer is one peer's results, with its files grouped by folder so a
+// whole folder can be grabbed at once.
+type searchPeer struct {
+	Username          string         `json:"username"`
+	HasFreeUploadSlot bool           `json:"hasFreeUploadSlot"`
+	UploadSpeed       int64          `json:"uploadSpeed"`
+	QueueLength       int            `json:"queueLength"`
+	Blocked           bool           `json:"blocked"`
+	Folders           []searchFolder `json:"folders"`
+}
+
+type searchFolder struct {
+	Directory string            `json:"directory"`
+	Files     []slskd.SlskdFile `json:"files"`
+}
+
+func (h *Handler) handleSearch(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Query string `json:"query"`
+	}
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		writeError(w, http.StatusBadRequest, "Missing query")
+		return
+	}
+
+	responses, err := h.Searcher.SearchAndWait(r.Context(), req.Query, h.SearchTimeout)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "manual search failed", "query", req.Query, "error", err)
+		writeError(w, http.StatusBadGateway, "Search failed")
+		return
+	}
+
+	// Best peers first; blocked peers are listed last but not hidden.
+	sort.SliceStable(responses, func(i, j int) bool {
+		bi, bj := h.Blocklist.Blocked(responses[i].Username), h.Blocklist.Blocked(responses[j].Username)
+		if bi != bj {
+			return bj
+		}
+		return responses[i].PeerScore() > responses[j].PeerScore()
+	})
+	peers := make([]searchPeer, 0, len(responses))
+	for _, resp := range responses {
+		p := searchPeer{
+			Username:          resp.Username,
+			HasFreeUploadSlot: resp.HasFreeUploadSlot,
+			UploadSpeed:       resp.UploadSpeed,
+			QueueLength:       resp.QueueLength,
+			Blocked:           h.Blocklist.Blocked(resp.Username),
+		}
+		folders := make(map[string]int)
+		for _, f := range resp.Files {
+			dir := f.Filename[:max(strings.LastIndexAny(f.Filename, "\\/"), 0)]
+			i, ok := folders[dir]
+			if !ok {
+				i = len(p.Folders)
+				folders[dir] = i
+				p.Folders = append(p.Folders, searchFolder{Directory: dir})
+			}
+			p.Folders[i].Files = append(p.Folders[i].Files, f)
+		}
+		peers = append(peers, p)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"query": req.Query, "peers": peers})
+}
+
+func (h *Handler) handleListSearches(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"searches": h.Searcher.Searches()})
+}
+
+func (h *Handler) handleCancelSearch(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	err := h.Searcher.CancelSearch(r.Context(), id)
+	switch {
+	case errors.Is(err, slskd.ErrSearchNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	case err != nil:
+		// The search was still stopped locally; only the slskd cleanup failed.
+		slog.WarnContext(r.Context(), "failed to delete cancelled search", "id", id, "error", err)
+	}
+
+	slog.InfoContext(r.Context(), "search cancelled", "id", id)
+	writeJSON(w, http.StatusOK, map[string]any{"status": true})
+}
+
+func (h *Handler) handleGrab(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Username string                  `json:"username"`
+		Path     string                  `json:"path"` // a remote file or folder, instead of files
+		Files    []slskd.DownloadRequest `json:"files"`
+		Category string                  `json:"category"`
+	}
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if req.Username == "" || (len(req.Files) == 0 && req.Path == "") {
+		writeError(w, http.StatusBadRequest, "Missing username, path or files")
+		return
+	}
+	for _, f := range req.Files {
+		if f.Filename == "" {
+			writeError(w, http.StatusBadRequest, "Missing filename")
+			return
+		}
+	}
+
+	var err error
+	req.Files, err = h.resolveFiles(r.Context(), req.Username, req.Path, req.Files)
+	switch {
+	case errors.Is(err, errNotShared):
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	case errors.Is(err, errNoBrowser):
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	case err != nil:
+		slog.ErrorContext(r.Context(), "failed to browse peer", "username", req.Username, "error", err)
+		writeError(w, http.StatusBadGateway, "Failed to browse peer's shares")
+		return
+	}
+
+	ids, err := h.Downloads.Grab(r.Context(), req.Username, req.Files, req.Category)
+	if errors.Is(err, slskd.ErrBlacklisted) {
+		writeError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "manual grab failed", "username", req.Username, "error", err)
+		writeError(w, http.StatusBadGateway, "Failed to queue download")
+		return
+	}
+	slog.InfoContext(r.Context(), "manual grab queued", "username", req.Username, "files", len(ids), "category", req.Category)
+	writeJSON(w, http.StatusCreated, map[string]any{"status": true, "nzo_ids": ids})
+}
+
+var (
+	errNotShared = errors.New("path not found in the peer's shares")
+	errNoBrowser = errors.New("grabs by path need slskd's browse API")
+)
+
+// resolveFiles adds the files a grab names by path, which is either a file
+// or a folder whose files are all grabbed, and fills in the sizes slskd
+// needs for files given without one, by browsing the peer's shares.
+func (h *Handler) resolveFiles(ctx context.Context, username, remotePath string, files []slskd.DownloadRequest) ([]slskd.DownloadRequest, error) {
+	needsBrowse := remotePath != "" || slices.ContainsFunc(files, func(f slskd.DownloadRequest) bool { return f.Size <= 0 })
+	if !needsBrowse {
+		return files, nil
+	}
+	if h.Browser == nil {
+		return nil, errNoBrowser
+	}
+
+	listings := make(map[string][]slskd.SlskdFile)
+	browse := func(dir string) ([]slskd.SlskdFile, error) {
+		if l, ok := listings[dir]; ok {
+			return l, nil
+		}
+		l, err := h.Browser.BrowseDirectory(ctx, username, dir)
+		listings[dir] = l
+		return l, err
+	}
+
+	if remotePath != "" {
+		// Soulseek paths use backslashes; accept either.
+		remotePath = strings.TrimRight(strings.ReplaceAll(remotePath, "/", `\`), `\`)
+		// A folder lists its own files; a file is looked up in its folder.
+		if l, err := browse(remotePath); err == nil && len(l) > 0 {
+			for _, f := range l {
+				files = append(files, slskd.DownloadRequest{Filename: f.Filename, Size: f.Size})
+			}
+		} else {
+			files = append(files, slskd.DownloadRequest{Filename: remotePath})
+		}
+	}
+
+	for i, f := range files {
+		if f.Size > 0 {
+			continue
+		}
+		l, err := browse(slskd.Directory(f.Filename))
+		if err != nil {
+			return nil, err
+		}
+		j := slices.IndexFunc(l, func(sf slskd.SlskdFile) bool { return sameRemotePath(sf.Filename, f.Filename) })
+		if j < 0 {
+			return nil, fmt.Errorf("%w: %s", errNotShared, f.Filename)
+		}
+		files[i] = slskd.DownloadRequest{Filename: l[j].Filename, Size: l[j].Size}
+	}
+	return files, nil
+}
+
+// sameRemotePath compares remote paths whichever separator they use.
+func sameRemotePath(a, b string) bool {
+	return strings.ReplaceAll(a, "/", `\`) == strings.ReplaceAll(b, "/", `\`)
+}
+
+func (h *Handler) handleRetryDownload(w http.ResponseWriter, r *http.Request) {
+	h.downloadAction(w, r, "retry", h.Downloads.Retry)
+}
+
+func (h *Handler) handleCancelDownload(w http.ResponseWriter, r *http.Request) {
+	h.downloadAction(w, r, "cancel", h.Downloads.Cancel)
+}
+
+// handleDownloadSpeed returns a download's recent speed samples, for
+// drawing its progress over time.
+func (h *Handler) handleDownloadSpeed(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	samples, err := h.Downloads.Speeds(id)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	case err != nil:
+		slog.ErrorContext(r.Context(), "failed to load download speed", "id", id, "error", err)
+		writeError(w, http.StatusInternalServerError, "Failed to load download speed")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"samples": samples})
+}
+
+// downloadAction runs action on the download named in the path and maps
+// its error to a status code.
+func (h *Handler) downloadAction(w http.ResponseWriter, r *http.Request, name string, action func(context.Context, string) error) {
+	id := r.PathValue("id")
+
+	err := action(r.Context(), id)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	case errors.Is(err, store.ErrNotFailed):
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	case err != nil:
+		slog.ErrorContext(r.Context(), "download action failed", "action", name, "id", id, "error", err)
+		writeError(w, http.StatusBadGateway, "Failed to "+name+" download")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"status": true})
+}
+
+// handleStats reports grabs and outcomes per category over the last days
+// days (default 30).
+func (h *Handler) handleStats(w http.ResponseWriter, r *http.Request) {
+	days := 30
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "days must be a positive integer")
+			return
+		}
+		days = n
+	}
+	writeJSON(w, http.StatusOK, h.Stats.Report(days))
+}
+
+func (h *Handler) handleListBlocklist(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"peers": h.Blocklist.List()})
+}
+
+func (h *Handler) handleUnblock(w http.ResponseWriter, r *http.Request) {
+	username := r.PathValue("username")
+
+	err := h.Blocklist.Remove(username)
+	switch {
+	case errors.Is(err, blocklist.ErrNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	case err != nil:
+		slog.ErrorContext(r.Context(), "failed to unblock peer", "username", username, "error", err)
+		writeError(w, http.StatusInternalServerError, "Failed to persist blocklist")
+		return
+	}
+
+	slog.InfoContext(r.Context(), "peer unblocked", "username", username)
+	writeJSON(w, http.StatusOK, map[string]any{"status": true})
+}
+
+func (h *Handler) handleListWishlist(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"items": h.Wishlist.List()})
+}
+
+func (h *Handler) handleAddWishlist(w http.ResponseWriter, r *http.Request) {
+	var req wishlist.Item
+	if !decodeBody(w, r, &req) {
+		return
+	}
+
+	item, err := h.Wishlist.Add(req)
+	switch {
+	case errors.Is(err, wishlist.ErrInvalid):
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	case err != nil:
+		slog.ErrorContext(r.Context(), "failed to persist wishlist", "error", err)
+		writeError(w, http.StatusInternalServerError, "Failed to persist wishlist")
+		return
+	}
+
+	slog.InfoContext(r.Context(), "wishlist item added", "id", item.ID, "query", item.Query)
+	writeJSON(w, http.StatusCreated, item)
+}
+
+func (h *Handler) handleRemoveWishlist(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	err := h.Wishlist.Remove(id)
+	switch {
+	case errors.Is(err, wishlist.ErrNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	case err != nil:
+		slog.ErrorContext(r.Context(), "failed to remove wishlist item", "id", id, "error", err)
+		writeError(w, http.StatusInternalServerError, "Failed to persist wishlist")
+		return
+	}
+
+	slog.InfoContext(r.Context(), "wishlist item removed", "id", id)
+	writeJSON(w, http.StatusOK, map[string]any{"status": true})
+}
+
+// handleListRequests lists recent newznab searches, newest first, without
+// their raw responses.
+func (h *Handler) handleListRequests(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"requests": h.Searches.List()})
+}
+
+// handleGetRequest returns a newznab search by request ID, with the raw
+// slskd responses while they're still held in memory.
+func (h *Handler) handleGetRequest(w http.ResponseWriter, r *http.Request) {
+	s, err := h.Searches.Get(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, s)
+}
+
+func (h *Handler) handleListBackups(w http.ResponseWriter, r *http.Request) {
+	if h.Backups == nil {
+		writeError(w, http.StatusNotFound, "Backups are not configured")
+		return
+	}
+	snaps, err := h.Backups.List()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to list backups", "error", err)
+		writeError(w, http.StatusInternalServerError, "Failed to list backups")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"backups": snaps})
+}
+
+func (h *Handler) handleBackup(w http.ResponseWriter, r *http.Request) {
+	if h.Backups == nil {
+		writeError(w, http.StatusNotFound, "Backups are not configured")
+		return
+	}
+	snap, err := h.Backups.Snapshot()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to back up state", "error", err)
+		writeError(w, http.StatusInternalServerError, "Failed to back up state")
+		return
+	}
+	slog.InfoContext(r.Context(), "backed up state", "name", snap.Name, "size", snap.Size)
+	writeJSON(w, http.StatusCreated, snap)
+}
+
+// handleRestore stages a snapshot to replace the state files at the next
+// start; the running process keeps its current state until restarted.
+func (h *Handler) handleRestore(w http.ResponseWriter, r *http.Request) {
+	if h.Backups == nil {
+		writeError(w, http.StatusNotFound, "Backups are not configured")
+		return
+	}
+	name := r.PathValue("name")
+
+	err := h.Backups.Stage(name)
+	switch {
+	case errors.Is(err, backup.ErrNotFound), errors.Is(err, backup.ErrInvalidName):
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	case err != nil:
+		slog.ErrorContext(r.Context(), "failed to stage backup", "name", name, "error", err)
+		writeError(w, http.StatusInternalServerError, "Failed to stage backup")
+		return
+	}
+
+	slog.InfoContext(r.Context(), "backup staged for restore", "name", name)
+	writeJSON(w, http.StatusOK, map[string]any{"status": true, "restart": true})
+}
+
+// decodeBody decodes a JSON request body into v, writing a 413 or 400 error
+// and returning false on failure.
+func decodeBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	err := json.NewDecoder(r.Body).Decode(v)
+	var maxErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxErr):
+		writeError(w, http.StatusRequestEntityTooLarge, "Request body too large")
+		return false
+	case err != nil:
+		writeError(w, http.StatusBadRequest, "Invalid JSON body")
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, code int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		slog.Error("failed to write JSON response", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, map[string]any{"status": false, "error": msg})
+}
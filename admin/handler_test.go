@@ -0,0 +1,748 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nerney/slskrr/auth"
+	"github.com/nerney/slskrr/backup"
+	"github.com/nerney/slskrr/blocklist"
+	"github.com/nerney/slskrr/logring"
+	"github.com/nerney/slskrr/searchlog"
+	"github.com/nerney/slskrr/slskd"
+	"github.com/nerney/slskrr/stats"
+	"github.com/nerney/slskrr/store"
+	"github.com/nerney/slskrr/wishlist"
+)
+
+func newTestHandler() *Handler {
+	return &Handler{
+		Keys: auth.NewKeyring(auth.Key{Label: "default", Value: "testapikey"}),
+	}
+}
+
+// newKeysTestHandler returns a handler whose key management is unlocked by
+// basic auth as admin:secret.
+func newKeysTestHandler() *Handler {
+	h := newTestHandler()
+	h.Auth = &auth.AdminAuth{Username: "admin", Password: "secret"}
+	return h
+}
+
+func TestHandler_RequiresAPIKey(t *testing.T) {
+	h := newTestHandler()
+
+	req := httptest.NewRequest("GET", "/admin/api/keys", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d", rec.Code)
+	}
+}
+
+func TestHandler_AddListRevokeKey(t *testing.T) {
+	h := newKeysTestHandler()
+
+	req := httptest.NewRequest("POST", "/admin/api/keys", strings.NewReader(`{"label":"lidarr"}`))
+	req.SetBasicAuth("admin", "secret")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var created map[string]string
+	json.NewDecoder(rec.Body).Decode(&created)
+	if created["key"] == "" {
+		t.Fatal("expected generated key in response")
+	}
+
+	// The new key works immediately
+	if label, ok := h.Keys.Check(created["key"]); !ok || label != "lidarr" {
+		t.Errorf("expected the new key accepted as lidarr, got %q %v", label, ok)
+	}
+
+	req = httptest.NewRequest("GET", "/admin/api/keys", nil)
+	req.SetBasicAuth("admin", "secret")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	var list struct {
+		Keys []auth.KeyInfo `json:"keys"`
+	}
+	json.NewDecoder(rec.Body).Decode(&list)
+	if len(list.Keys) != 2 {
+		t.Fatalf("expected 2 keys, got %d", len(list.Keys))
+	}
+	if strings.Contains(rec.Body.String(), created["key"]) {
+		t.Error("key list should not expose full key values")
+	}
+
+	req = httptest.NewRequest("DELETE", "/admin/api/keys/lidarr", nil)
+	req.SetBasicAuth("admin", "secret")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+
+	if _, ok := h.Keys.Check(created["key"]); ok {
+		t.Error("expected revoked key to be rejected")
+	}
+}
+
+func TestHandler_KeysRequireAdminAuth(t *testing.T) {
+	// Without admin auth a client key, even with no other keys configured,
+	// can't manage keys.
+	for name, h := range map[string]*Handler{"client key": newTestHandler(), "no keys": {Keys: auth.NewKeyring()}} {
+		for _, req := range []*http.Request{
+			httptest.NewRequest("GET", "/admin/api/keys", nil),
+			httptest.NewRequest("POST", "/admin/api/keys", strings.NewReader(`{"label":"intruder"}`)),
+			httptest.NewRequest("DELETE", "/admin/api/keys/default", nil),
+		} {
+			req.Header.Set("X-Api-Key", "testapikey")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != http.StatusForbidden {
+				t.Errorf("%s: %s %s: expected 403, got %d", name, req.Method, req.URL.Path, rec.Code)
+			}
+		}
+		if len(h.Keys.List()) > 1 {
+			t.Errorf("%s: expected no key added, got %+v", name, h.Keys.List())
+		}
+	}
+
+	// Other admin routes still take a client key.
+	h := newTestHandler()
+	h.Downloads = &fakeDownloads{}
+	req := httptest.NewRequest("GET", "/admin/api/downloads/x/speed", nil)
+	req.Header.Set("X-Api-Key", "testapikey")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code == http.StatusUnauthorized || rec.Code == http.StatusForbidden {
+		t.Errorf("expected a client key accepted outside key management, got %d", rec.Code)
+	}
+}
+
+func TestHandler_AddDuplicateKey(t *testing.T) {
+	h := newKeysTestHandler()
+
+	// A taken label, then a taken key under a new label.
+	for _, body := range []string{`{"label":"default"}`, `{"label":"copy","key":"testapikey"}`} {
+		req := httptest.NewRequest("POST", "/admin/api/keys", strings.NewReader(body))
+		req.SetBasicAuth("admin", "secret")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusConflict {
+			t.Errorf("%s: expected 409, got %d", body, rec.Code)
+		}
+	}
+	if h.Keys.Len() != 1 {
+		t.Errorf("expected no key added, got %d keys", h.Keys.Len())
+	}
+}
+
+func TestHandler_RevokeStaticKey(t *testing.T) {
+	h := newKeysTestHandler()
+
+	req := httptest.NewRequest("DELETE", "/admin/api/keys/default", nil)
+	req.SetBasicAuth("admin", "secret")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusConflict {
+		t.Errorf("expected 409, got %d", rec.Code)
+	}
+}
+
+func TestHandler_RevokeLastKey(t *testing.T) {
+	h := newKeysTestHandler()
+	h.Keys = auth.NewKeyring()
+	h.Keys.Add(auth.Key{Label: "lidarr", Value: "runtimekey"})
+
+	req := httptest.NewRequest("DELETE", "/admin/api/keys/lidarr", nil)
+	req.SetBasicAuth("admin", "secret")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d", rec.Code)
+	}
+}
+
+func TestHandler_AdminAuthReplacesAPIKey(t *testing.T) {
+	h := newTestHandler()
+	h.Auth = &auth.AdminAuth{Username: "admin", Password: "secret"}
+
+	// A client API key no longer grants admin access
+	req := httptest.NewRequest("GET", "/admin/api/keys", nil)
+	req.Header.Set("X-Api-Key", "testapikey")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d", rec.Code)
+	}
+	if rec.Header().Get("WWW-Authenticate") == "" {
+		t.Error("expected basic auth challenge")
+	}
+
+	req = httptest.NewRequest("GET", "/admin/api/keys", nil)
+	req.SetBasicAuth("admin", "secret")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d", rec.Code)
+	}
+}
+
+type fakeDownloads struct {
+	err     error
+	ids     []string
+	grabbed []slskd.DownloadRequest
+}
+
+func (f *fakeDownloads) Grab(_ context.Context, username string, files []slskd.DownloadRequest, category string) ([]string, error) {
+	f.ids = append(f.ids, "grab:"+username+":"+category)
+	f.grabbed = append(f.grabbed, files...)
+	return []string{"nzo_1"}, f.err
+}
+
+func (f *fakeDownloads) Retry(_ context.Context, id string) error {
+	f.ids = append(f.ids, "retry:"+id)
+	return f.err
+}
+
+func (f *fakeDownloads) Cancel(_ context.Context, id string) error {
+	f.ids = append(f.ids, "cancel:"+id)
+	return f.err
+}
+
+func (f *fakeDownloads) Speeds(id string) ([]store.SpeedSample, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return []store.SpeedSample{{BytesDownloaded: 50, BytesPerSecond: 10}}, nil
+}
+
+func TestHandler_DownloadSpeed(t *testing.T) {
+	h := newTestHandler()
+	h.Downloads = &fakeDownloads{}
+
+	req := httptest.NewRequest("GET", "/admin/api/downloads/nzo_1/speed", nil)
+	req.Header.Set("X-Api-Key", "testapikey")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var resp struct {
+		Samples []store.SpeedSample `json:"samples"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Samples) != 1 || resp.Samples[0].BytesPerSecond != 10 {
+		t.Errorf("unexpected samples: %+v", resp.Samples)
+	}
+
+	h.Downloads = &fakeDownloads{err: store.ErrNotFound}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", rec.Code)
+	}
+
+	h.Downloads = &fakeDownloads{err: errors.New("database is locked")}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500, got %d", rec.Code)
+	}
+}
+
+func TestHandler_DownloadActions(t *testing.T) {
+	downloads := &fakeDownloads{}
+	h := newTestHandler()
+	h.Downloads = downloads
+
+	req := httptest.NewRequest("POST", "/admin/api/downloads/nzo_1/retry", nil)
+	req.Header.Set("X-Api-Key", "testapikey")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	req = httptest.NewRequest("DELETE", "/admin/api/downloads/nzo_2", nil)
+	req.Header.Set("X-Api-Key", "testapikey")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+
+	if strings.Join(downloads.ids, ",") != "retry:nzo_1,cancel:nzo_2" {
+		t.Errorf("unexpected actions: %v", downloads.ids)
+	}
+}
+
+func TestHandler_DownloadActionErrors(t *testing.T) {
+	tests := map[error]int{
+		store.ErrNotFound:  http.StatusNotFound,
+		store.ErrNotFailed: http.StatusConflict,
+		errors.New("boom"): http.StatusBadGateway,
+	}
+	for err, want := range tests {
+		h := newTestHandler()
+		h.Downloads = &fakeDownloads{err: err}
+
+		req := httptest.NewRequest("POST", "/admin/api/downloads/nzo_1/retry", nil)
+		req.Header.Set("X-Api-Key", "testapikey")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("%v: expected %d, got %d", err, want, rec.Code)
+		}
+	}
+}
+
+func TestHandler_Stats(t *testing.T) {
+	h := newTestHandler()
+	h.Stats = stats.New()
+	h.Stats.Grab("radarr")
+	h.Stats.Complete("radarr", time.Minute)
+
+	req := httptest.NewRequest("GET", "/admin/api/stats?days=7", nil)
+	req.Header.Set("X-Api-Key", "testapikey")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var report stats.Report
+	json.NewDecoder(rec.Body).Decode(&report)
+	if len(report.Categories) != 1 || report.Categories[0].Completed != 1 || report.Categories[0].AvgCompletionSeconds != 60 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+
+	req = httptest.NewRequest("GET", "/admin/api/stats?days=0", nil)
+	req.Header.Set("X-Api-Key", "testapikey")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for days=0, got %d", rec.Code)
+	}
+}
+
+func TestHandler_ListAndUnblock(t *testing.T) {
+	h := newTestHandler()
+	h.Blocklist = blocklist.New(1, time.Hour)
+	h.Blocklist.Strike("baduser", "nzo_1", "import failed")
+
+	req := httptest.NewRequest("GET", "/admin/api/blocklist", nil)
+	req.Header.Set("X-Api-Key", "testapikey")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var list struct {
+		Peers []blocklist.Entry `json:"peers"`
+	}
+	json.NewDecoder(rec.Body).Decode(&list)
+	if len(list.Peers) != 1 || list.Peers[0].Username != "baduser" {
+		t.Fatalf("unexpected blocklist: %+v", list.Peers)
+	}
+
+	req = httptest.NewRequest("DELETE", "/admin/api/blocklist/baduser", nil)
+	req.Header.Set("X-Api-Key", "testapikey")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if h.Blocklist.Blocked("baduser") {
+		t.Error("expected peer unblocked")
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown peer, got %d", rec.Code)
+	}
+}
+
+func TestHandler_Wishlist(t *testing.T) {
+	h := newTestHandler()
+	h.Wishlist = wishlist.New()
+
+	req := httptest.NewRequest("POST", "/admin/api/wishlist", strings.NewReader(`{"query":""}`))
+	req.Header.Set("X-Api-Key", "testapikey")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for empty query, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest("POST", "/admin/api/wishlist", strings.NewReader(`{"query":"rare live set","category":"lidarr","formats":["flac"]}`))
+	req.Header.Set("X-Api-Key", "testapikey")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var item wishlist.Item
+	json.NewDecoder(rec.Body).Decode(&item)
+	if item.ID == "" || item.Category != "lidarr" {
+		t.Fatalf("unexpected item: %+v", item)
+	}
+
+	req = httptest.NewRequest("GET", "/admin/api/wishlist", nil)
+	req.Header.Set("X-Api-Key", "testapikey")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var list struct {
+		Items []wishlist.Item `json:"items"`
+	}
+	json.NewDecoder(rec.Body).Decode(&list)
+	if len(list.Items) != 1 {
+		t.Fatalf("expected 1 item, got %d", len(list.Items))
+	}
+
+	req = httptest.NewRequest("DELETE", "/admin/api/wishlist/"+item.ID, nil)
+	req.Header.Set("X-Api-Key", "testapikey")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || h.Wishlist.Len() != 0 {
+		t.Errorf("expected item removed, got %d", rec.Code)
+	}
+}
+
+type fakeSearcher struct {
+	responses []slskd.SearchResponse
+	active    []slskd.SearchInfo
+	cancelled []string
+}
+
+func (f *fakeSearcher) SearchAndWait(_ context.Context, _ string, _ time.Duration) ([]slskd.SearchResponse, error) {
+	return f.responses, nil
+}
+
+func (f *fakeSearcher) Searches() []slskd.SearchInfo {
+	return f.active
+}
+
+func (f *fakeSearcher) CancelSearch(_ context.Context, id string) error {
+	for _, s := range f.active {
+		if s.ID == id {
+			f.cancelled = append(f.cancelled, id)
+			return nil
+		}
+	}
+	return slskd.ErrSearchNotFound
+}
+
+func TestHandler_SearchAndGrab(t *testing.T) {
+	h := newTestHandler()
+	h.Blocklist = blocklist.New(1, time.Hour)
+	h.Blocklist.Strike("badpeer", "nzo_0", "")
+	h.Searcher = &fakeSearcher{responses: []slskd.SearchResponse{
+		{Username: "badpeer", HasFreeUploadSlot: true, Files: []slskd.SlskdFile{{Filename: `a\1.flac`}}},
+		{Username: "slowpeer", QueueLength: 100, Files: []slskd.SlskdFile{{Filename: `a\1.flac`}}},
+		{Username: "fastpeer", HasFreeUploadSlot: true, Files: []slskd.SlskdFile{
+			{Filename: `Music\Album\01.flac`, Size: 10},
+			{Filename: `Music\Album\02.flac`, Size: 20},
+			{Filename: `Music\Other\01.flac`, Size: 30},
+		}},
+	}}
+	downloads := &fakeDownloads{}
+	h.Downloads = downloads
+
+	req := httptest.NewRequest("POST", "/admin/api/search", strings.NewReader(`{"query":"album"}`))
+	req.Header.Set("X-Api-Key", "testapikey")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var result struct {
+		Peers []searchPeer `json:"peers"`
+	}
+	json.NewDecoder(rec.Body).Decode(&result)
+	if len(result.Peers) != 3 || result.Peers[0].Username != "fastpeer" || result.Peers[2].Username != "badpeer" || !result.Peers[2].Blocked {
+		t.Fatalf("unexpected ranking: %+v", result.Peers)
+	}
+	folders := result.Peers[0].Folders
+	if len(folders) != 2 || folders[0].Directory != `Music\Album` || len(folders[0].Files) != 2 {
+		t.Errorf("unexpected folders: %+v", folders)
+	}
+
+	req = httptest.NewRequest("POST", "/admin/api/grab", strings.NewReader(`{"username":"fastpeer","category":"music","files":[{"filename":"Music\\Album\\01.flac","size":10}]}`))
+	req.Header.Set("X-Api-Key", "testapikey")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if downloads.ids[0] != "grab:fastpeer:music" || downloads.grabbed[0].Filename != `Music\Album\01.flac` {
+		t.Errorf("unexpected grab: %v %+v", downloads.ids, downloads.grabbed)
+	}
+
+	req = httptest.NewRequest("POST", "/admin/api/grab", strings.NewReader(`{"username":"fastpeer"}`))
+	req.Header.Set("X-Api-Key", "testapikey")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without files, got %d", rec.Code)
+	}
+}
+
+func TestHandler_Logs(t *testing.T) {
+	h := newTestHandler()
+	h.Logs = logring.New(10)
+	log := slog.New(h.Logs.Handler(slog.NewTextHandler(io.Discard, nil)))
+	log.Info("grabbed", "id", "nzo_1")
+	log.Warn("slow peer")
+	log.Error("import failed")
+
+	req := httptest.NewRequest("GET", "/admin/api/logs?level=warn&limit=1", nil)
+	req.Header.Set("X-Api-Key", "testapikey")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var resp struct {
+		Logs []logring.Entry `json:"logs"`
+	}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if len(resp.Logs) != 1 || resp.Logs[0].Message != "import failed" {
+		t.Errorf("unexpected logs: %+v", resp.Logs)
+	}
+
+	req = httptest.NewRequest("GET", "/admin/api/logs?level=loud", nil)
+	req.Header.Set("X-Api-Key", "testapikey")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for invalid level, got %d", rec.Code)
+	}
+}
+
+type fakeOps struct {
+	syncs int
+	err   error
+}
+
+func (f *fakeOps) SyncNow(context.Context) error {
+	f.syncs++
+	return f.err
+}
+
+func (f *fakeOps) RefreshOptions(context.Context) (string, error) {
+	return "/data/downloads", f.err
+}
+
+func TestHandler_Operations(t *testing.T) {
+	ops := &fakeOps{}
+	h := newTestHandler()
+	h.Ops = ops
+
+	req := httptest.NewRequest("POST", "/admin/api/sync", nil)
+	req.Header.Set("X-Api-Key", "testapikey")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || ops.syncs != 1 {
+		t.Fatalf("expected sync to run, got %d after %d syncs", rec.Code, ops.syncs)
+	}
+
+	req = httptest.NewRequest("POST", "/admin/api/slskd/refresh", nil)
+	req.Header.Set("X-Api-Key", "testapikey")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var resp map[string]any
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if rec.Code != http.StatusOK || resp["downloadDir"] != "/data/downloads" {
+		t.Errorf("unexpected refresh response %d: %v", rec.Code, resp)
+	}
+
+	ops.err = errors.New("slskd down")
+	req = httptest.NewRequest("POST", "/admin/api/sync", nil)
+	req.Header.Set("X-Api-Key", "testapikey")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("expected 502 when sync fails, got %d", rec.Code)
+	}
+}
+
+func TestHandler_ListAndCancelSearches(t *testing.T) {
+	searcher := &fakeSearcher{active: []slskd.SearchInfo{{ID: "s1", Query: "runaway", State: "InProgress", FileCount: 9000}}}
+	h := newTestHandler()
+	h.Searcher = searcher
+
+	req := httptest.NewRequest("GET", "/admin/api/searches", nil)
+	req.Header.Set("X-Api-Key", "testapikey")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var list struct {
+		Searches []slskd.SearchInfo `json:"searches"`
+	}
+	json.NewDecoder(rec.Body).Decode(&list)
+	if len(list.Searches) != 1 || list.Searches[0].Query != "runaway" {
+		t.Fatalf("unexpected searches: %+v", list.Searches)
+	}
+
+	req = httptest.NewRequest("DELETE", "/admin/api/searches/s1", nil)
+	req.Header.Set("X-Api-Key", "testapikey")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || len(searcher.cancelled) != 1 {
+		t.Fatalf("expected search cancelled, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest("DELETE", "/admin/api/searches/s2", nil)
+	req.Header.Set("X-Api-Key", "testapikey")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown search, got %d", rec.Code)
+	}
+}
+
+func TestHandler_Backups(t *testing.T) {
+	h := newTestHandler()
+	do := func(method, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("X-Api-Key", "testapikey")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do("GET", "/admin/api/backups"); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 without backups configured, got %d", rec.Code)
+	}
+
+	dataDir := t.TempDir()
+	os.WriteFile(filepath.Join(dataDir, "store.json"), []byte(`[]`), 0o644)
+	h.Backups = &backup.Backups{DataDir: dataDir, Dir: t.TempDir()}
+
+	rec := do("POST", "/admin/api/backups")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var snap backup.Snapshot
+	json.NewDecoder(rec.Body).Decode(&snap)
+
+	var list struct{ Backups []backup.Snapshot }
+	json.NewDecoder(do("GET", "/admin/api/backups").Body).Decode(&list)
+	if len(list.Backups) != 1 || list.Backups[0].Name != snap.Name {
+		t.Fatalf("unexpected backups: %+v", list.Backups)
+	}
+
+	if rec := do("POST", "/admin/api/backups/"+snap.Name+"/restore"); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, "restore", "store.json")); err != nil {
+		t.Errorf("expected the snapshot staged: %v", err)
+	}
+	if rec := do("POST", "/admin/api/backups/slskrr-20000101T000000Z.tar.gz/restore"); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown backup, got %d", rec.Code)
+	}
+}
+
+func TestHandler_Requests(t *testing.T) {
+	h := newTestHandler()
+	h.Searches = searchlog.New(10)
+	h.Searches.Record(&searchlog.Search{
+		RequestID: "req-1",
+		Query:     "heat",
+		Slskd:     []searchlog.Query{{Query: "heat", SearchID: "s1"}},
+		Responses: []slskd.SearchResponse{{Username: "peer"}},
+	})
+	do := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		req.Header.Set("X-Api-Key", "testapikey")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	var list struct{ Requests []searchlog.Search }
+	json.NewDecoder(do("/admin/api/requests").Body).Decode(&list)
+	if len(list.Requests) != 1 || list.Requests[0].Responses != nil {
+		t.Fatalf("expected the search listed without responses, got %+v", list.Requests)
+	}
+
+	var s searchlog.Search
+	json.NewDecoder(do("/admin/api/requests/req-1").Body).Decode(&s)
+	if len(s.Slskd) != 1 || s.Slskd[0].SearchID != "s1" || len(s.Responses) != 1 {
+		t.Errorf("unexpected search: %+v", s)
+	}
+	if rec := do("/admin/api/requests/missing"); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", rec.Code)
+	}
+}
+
+type fakeBrowser map[string][]slskd.SlskdFile
+
+func (b fakeBrowser) BrowseDirectory(_ context.Context, _, directory string) ([]slskd.SlskdFile, error) {
+	if files, ok := b[directory]; ok {
+		return files, nil
+	}
+	return nil, errors.New("directory request failed with status 404")
+}
+
+func TestHandler_GrabByPath(t *testing.T) {
+	h := newTestHandler()
+	grab := func(body string) (*httptest.ResponseRecorder, *fakeDownloads) {
+		downloads := &fakeDownloads{}
+		h.Downloads = downloads
+		req := httptest.NewRequest("POST", "/admin/api/grab", strings.NewReader(body))
+		req.Header.Set("X-Api-Key", "testapikey")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec, downloads
+	}
+
+	if rec, _ := grab(`{"username":"peer","path":"Music\\Album"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without a browser, got %d", rec.Code)
+	}
+
+	h.Browser = fakeBrowser{
+		`Music\Album`: {
+			{Filename: `Music\Album\01.flac`, Size: 10},
+			{Filename: `Music\Album\02.flac`, Size: 20},
+		},
+	}
+
+	rec, downloads := grab(`{"username":"peer","path":"Music\\Album\\"}`)
+	if rec.Code != http.StatusCreated || len(downloads.grabbed) != 2 {
+		t.Fatalf("expected the whole folder grabbed, got %d %+v", rec.Code, downloads.grabbed)
+	}
+
+	// A file given by path, or without a size, gets its size from its folder.
+	rec, downloads = grab(`{"username":"peer","path":"Music/Album/02.flac","files":[{"filename":"Music\\Album\\01.flac"}]}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	want := []slskd.DownloadRequest{{Filename: `Music\Album\01.flac`, Size: 10}, {Filename: `Music\Album\02.flac`, Size: 20}}
+	if !slices.Equal(downloads.grabbed, want) {
+		t.Errorf("got %+v, want %+v", downloads.grabbed, want)
+	}
+
+	if rec, _ := grab(`{"username":"peer","path":"Music\\Album\\03.flac"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a file the peer doesn't share, got %d", rec.Code)
+	}
+	if rec, _ := grab(`{"username":"peer","path":"Elsewhere\\01.flac"}`); rec.Code != http.StatusBadGateway {
+		t.Errorf("expected 502 when browsing fails, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// AdminAuth protects the admin API separately from the client API keys.
+// It accepts HTTP basic auth, or a user header set by a trusted
+// forward-auth proxy (Authelia, Authentik, oauth2-proxy, ...).
+type AdminAuth struct {
+	Username       string
+	Password       string
+	Header         string // e.g. "Remote-User"
+	TrustedProxies []netip.Prefix
+}
+
+// Enabled reports whether any admin authentication method is configured.
+func (a *AdminAuth) Enabled() bool {
+	return a != nil && (a.Username != "" || a.Header != "")
+}
+
+// Authenticate returns the authenticated admin user for the request.
+func (a *AdminAuth) Authenticate(r *http.Request) (string, bool) {
+	if a.Header != "" && a.fromTrustedProxy(r) {
+		if user := r.Header.Get(a.Header); user != "" {
+			return user, true
+		}
+	}
+	if a.Username != "" {
+		user, pass, ok := r.BasicAuth()
+		if !ok {
+			return "", false
+		}
+		userOK := subtle.ConstantTimeCompare([]byte(user), []byte(a.Username)) == 1
+		passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(a.Password)) == 1
+		if userOK && passOK {
+			return user, true
+		}
+	}
+	return "", false
+}
+
+// Challenge sets the WWW-Authenticate header when basic auth is configured.
+func (a *AdminAuth) Challenge(w http.ResponseWriter) {
+	if a.Username != "" {
+		w.Header().Set("WWW-Authenticate", `Basic realm="slskrr admin", charset="UTF-8"`)
+	}
+}
+
+func (a *AdminAuth) fromTrustedProxy(r *http.Request) bool {
+	return FromProxy(r, a.TrustedProxies)
+}
+
+// FromProxy reports whether r's remote address is in one of proxies.
+func FromProxy(r *http.Request, proxies []netip.Prefix) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range proxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParsePrefixes parses a comma-separated list of CIDRs or bare IP addresses.
+func ParsePrefixes(s string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if !strings.Contains(part, "/") {
+			addr, err := netip.ParseAddr(part)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q: %w", part, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", part, err)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
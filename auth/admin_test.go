@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminAuth_Basic(t *testing.T) {
+	a := &AdminAuth{Username: "admin", Password: "hunter2"}
+
+	req := httptest.NewRequest("GET", "/admin/api/keys", nil)
+	req.SetBasicAuth("admin", "hunter2")
+	if user, ok := a.Authenticate(req); !ok || user != "admin" {
+		t.Errorf("expected admin authenticated, got %q %v", user, ok)
+	}
+
+	req.SetBasicAuth("admin", "wrong")
+	if _, ok := a.Authenticate(req); ok {
+		t.Error("expected wrong password to be rejected")
+	}
+}
+
+func TestAdminAuth_ForwardHeader(t *testing.T) {
+	proxies, err := ParsePrefixes("10.0.0.0/8, 192.168.1.5")
+	if err != nil {
+		t.Fatalf("parse prefixes: %v", err)
+	}
+	a := &AdminAuth{Header: "Remote-User", TrustedProxies: proxies}
+
+	req := httptest.NewRequest("GET", "/admin/api/keys", nil)
+	req.RemoteAddr = "10.1.2.3:54321"
+	req.Header.Set("Remote-User", "alice")
+	if user, ok := a.Authenticate(req); !ok || user != "alice" {
+		t.Errorf("expected alice from trusted proxy, got %q %v", user, ok)
+	}
+
+	// The same header from an untrusted address must be ignored
+	req.RemoteAddr = "203.0.113.9:54321"
+	if _, ok := a.Authenticate(req); ok {
+		t.Error("expected header from untrusted address to be rejected")
+	}
+}
+
+func TestParsePrefixes_Invalid(t *testing.T) {
+	if _, err := ParsePrefixes("not-an-ip"); err == nil {
+		t.Fatal("expected error for invalid address")
+	}
+}
@@ -0,0 +1,268 @@
+// Package auth implements API key checking for the indexer and download
+// client facades.
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Key is an accepted API key with a label used to identify the client in logs.
+type Key struct {
+	Label string `json:"label"`
+	Value string `json:"key"`
+}
+
+var (
+	ErrDuplicateLabel = errors.New("a key with this label already exists")
+	ErrDuplicateKey   = errors.New("this key is already accepted under another label")
+	ErrKeyNotFound    = errors.New("key not found")
+	ErrStaticKey      = errors.New("key is configured via environment and cannot be revoked at runtime")
+	ErrLastKey        = errors.New("the last key cannot be revoked; add its replacement first")
+)
+
+// Keyring holds the set of accepted API keys. Static keys come from the
+// environment; runtime keys are added and revoked through the admin API and
+// persisted to disk when a path is set. A nil or empty keyring accepts every
+// request, matching the behavior of an unset API_KEY, so once a key is set
+// the last one can't be revoked.
+type Keyring struct {
+	mu      sync.RWMutex
+	static  []Key
+	runtime []Key
+	path    string
+}
+
+func NewKeyring(keys ...Key) *Keyring {
+	return &Keyring{static: keys}
+}
+
+// ParseKeys parses a comma-separated list of keys, each optionally prefixed
+// with "label:". Unlabeled keys are named by position among the keys
+// (key1, key2, ...). The same key or label listed twice is an error.
+func ParseKeys(s string) ([]Key, error) {
+	var keys []Key
+	labels := make(map[string]string) // by value
+	seen := make(map[string]bool)     // labels
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		k := Key{Label: fmt.Sprintf("key%d", len(keys)+1), Value: part}
+		if label, value, ok := strings.Cut(part, ":"); ok {
+			k.Label = strings.TrimSpace(label)
+			k.Value = strings.TrimSpace(value)
+		}
+		if k.Value == "" {
+			return nil, fmt.Errorf("empty key for label %q", k.Label)
+		}
+		if other, ok := labels[k.Value]; ok {
+			return nil, fmt.Errorf("key for label %q repeats the key for %q", k.Label, other)
+		}
+		if seen[k.Label] {
+			return nil, fmt.Errorf("label %q is used twice", k.Label)
+		}
+		labels[k.Value] = k.Label
+		seen[k.Label] = true
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// GenerateKey returns a random 32-character hex key.
+func GenerateKey() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Check reports whether value matches an accepted key and returns its label.
+// Every key is compared so the timing doesn't reveal which one matched.
+func (k *Keyring) Check(value string) (string, bool) {
+	if k == nil {
+		return "", true
+	}
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if len(k.static)+len(k.runtime) == 0 {
+		return "", true
+	}
+	label, ok := "", false
+	for _, keys := range [][]Key{k.static, k.runtime} {
+		for _, key := range keys {
+			if subtle.ConstantTimeCompare([]byte(value), []byte(key.Value)) == 1 {
+				label, ok = key.Label, true
+			}
+		}
+	}
+	return label, ok
+}
+
+// Len returns the number of accepted keys.
+func (k *Keyring) Len() int {
+	if k == nil {
+		return 0
+	}
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return len(k.static) + len(k.runtime)
+}
+
+// KeyInfo describes an accepted key without exposing its full value.
+type KeyInfo struct {
+	Label  string `json:"label"`
+	Hint   string `json:"hint"`
+	Source string `json:"source"` // "env" or "runtime"
+}
+
+// List returns all accepted keys with their values masked.
+func (k *Keyring) List() []KeyInfo {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	result := make([]KeyInfo, 0, len(k.static)+len(k.runtime))
+	for _, key := range k.static {
+		result = append(result, KeyInfo{Label: key.Label, Hint: mask(key.Value), Source: "env"})
+	}
+	for _, key := range k.runtime {
+		result = append(result, KeyInfo{Label: key.Label, Hint: mask(key.Value), Source: "runtime"})
+	}
+	return result
+}
+
+// Add accepts a new runtime key and persists the runtime set. Both its
+// label and its value must be new.
+func (k *Keyring) Add(key Key) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.indexOf(key.Label) >= 0 || k.isStatic(key.Label) {
+		return ErrDuplicateLabel
+	}
+	if k.accepts(key.Value) {
+		return ErrDuplicateKey
+	}
+	k.runtime = append(k.runtime, key)
+	if err := k.save(); err != nil {
+		k.runtime = k.runtime[:len(k.runtime)-1]
+		return err
+	}
+	return nil
+}
+
+// Revoke removes a runtime key by label and persists the runtime set. It
+// refuses to remove the last accepted key, which would leave the APIs open.
+func (k *Keyring) Revoke(label string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	i := k.indexOf(label)
+	if i < 0 {
+		if k.isStatic(label) {
+			return ErrStaticKey
+		}
+		return ErrKeyNotFound
+	}
+	if len(k.static)+len(k.runtime) == 1 {
+		return ErrLastKey
+	}
+	prev := k.runtime
+	k.runtime = append(k.runtime[:i:i], k.runtime[i+1:]...)
+	if err := k.save(); err != nil {
+		k.runtime = prev
+		return err
+	}
+	return nil
+}
+
+// Load reads runtime keys from path and persists future changes there.
+// A missing file is not an error.
+func (k *Keyring) Load(path string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.path = path
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read keys file: %w", err)
+	}
+	var keys []Key
+	if err := json.Unmarshal(b, &keys); err != nil {
+		return fmt.Errorf("decode keys file: %w", err)
+	}
+	k.runtime = keys
+	return nil
+}
+
+func (k *Keyring) indexOf(label string) int {
+	for i, key := range k.runtime {
+		if key.Label == label {
+			return i
+		}
+	}
+	return -1
+}
+
+func (k *Keyring) isStatic(label string) bool {
+	for _, key := range k.static {
+		if key.Label == label {
+			return true
+		}
+	}
+	return false
+}
+
+// accepts reports whether value is already an accepted key. Callers must
+// hold k.mu.
+func (k *Keyring) accepts(value string) bool {
+	for _, keys := range [][]Key{k.static, k.runtime} {
+		for _, key := range keys {
+			if key.Value == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// save writes the runtime keys atomically. Callers must hold k.mu.
+func (k *Keyring) save() error {
+	if k.path == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(k.runtime, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode keys: %w", err)
+	}
+	tmp := k.path + ".tmp"
+	if err := os.MkdirAll(filepath.Dir(k.path), 0o755); err != nil {
+		return fmt.Errorf("create keys dir: %w", err)
+	}
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return fmt.Errorf("write keys file: %w", err)
+	}
+	if err := os.Rename(tmp, k.path); err != nil {
+		return fmt.Errorf("replace keys file: %w", err)
+	}
+	return nil
+}
+
+func mask(value string) string {
+	if len(value) <= 4 {
+		return "****"
+	}
+	return "****" + value[len(value)-4:]
+}
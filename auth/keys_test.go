@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestParseKeys(t *testing.T) {
+	keys, err := ParseKeys("radarr:abc, sonarr:def ,ghi")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(keys) != 3 {
+		t.Fatalf("expected 3 keys, got %d", len(keys))
+	}
+	if keys[0].Label != "radarr" || keys[0].Value != "abc" {
+		t.Errorf("unexpected first key: %+v", keys[0])
+	}
+	if keys[1].Label != "sonarr" || keys[1].Value != "def" {
+		t.Errorf("unexpected second key: %+v", keys[1])
+	}
+	if keys[2].Label != "key3" || keys[2].Value != "ghi" {
+		t.Errorf("unexpected third key: %+v", keys[2])
+	}
+}
+
+func TestParseKeys_EmptyValue(t *testing.T) {
+	if _, err := ParseKeys("radarr:"); err == nil {
+		t.Fatal("expected error for empty key value")
+	}
+}
+
+func TestParseKeys_SkippedEntries(t *testing.T) {
+	keys, err := ParseKeys("abc, ,,def,")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(keys) != 2 || keys[0].Label != "key1" || keys[1].Label != "key2" {
+		t.Errorf("expected empty entries not to count, got %+v", keys)
+	}
+}
+
+func TestParseKeys_Duplicate(t *testing.T) {
+	for _, s := range []string{"abc,abc", "radarr:abc, sonarr:abc", "abc, sonarr: abc"} {
+		if _, err := ParseKeys(s); err == nil {
+			t.Errorf("%q: expected error for a repeated key", s)
+		}
+	}
+	// An unlabeled key's generated label counts too.
+	for _, s := range []string{"radarr:abc,radarr:def", "key2:abc,def"} {
+		if _, err := ParseKeys(s); err == nil {
+			t.Errorf("%q: expected error for a repeated label", s)
+		}
+	}
+}
+
+func TestKeyring_Check(t *testing.T) {
+	k := NewKeyring(Key{Label: "radarr", Value: "abc"}, Key{Label: "sonarr", Value: "def"})
+
+	label, ok := k.Check("def")
+	if !ok || label != "sonarr" {
+		t.Errorf("expected sonarr match, got %q %v", label, ok)
+	}
+	if _, ok := k.Check("wrong"); ok {
+		t.Error("expected wrong key to be rejected")
+	}
+	if _, ok := k.Check(""); ok {
+		t.Error("expected empty key to be rejected")
+	}
+}
+
+func TestKeyring_EmptyAcceptsAll(t *testing.T) {
+	var nilRing *Keyring
+	if _, ok := nilRing.Check("anything"); !ok {
+		t.Error("nil keyring should accept all keys")
+	}
+	if _, ok := NewKeyring().Check("anything"); !ok {
+		t.Error("empty keyring should accept all keys")
+	}
+}
+
+func TestKeyring_AddRevokePersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+
+	k := NewKeyring(Key{Label: "default", Value: "static"})
+	if err := k.Load(path); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if err := k.Add(Key{Label: "lidarr", Value: "newkey"}); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := k.Add(Key{Label: "default", Value: "other"}); err != ErrDuplicateLabel {
+		t.Errorf("expected ErrDuplicateLabel, got %v", err)
+	}
+	for _, value := range []string{"static", "newkey"} {
+		if err := k.Add(Key{Label: "copy", Value: value}); err != ErrDuplicateKey {
+			t.Errorf("%s: expected ErrDuplicateKey, got %v", value, err)
+		}
+	}
+	if label, ok := k.Check("newkey"); !ok || label != "lidarr" {
+		t.Errorf("expected runtime key accepted, got %q %v", label, ok)
+	}
+
+	// A fresh keyring loading the same file sees the runtime key
+	reloaded := NewKeyring()
+	if err := reloaded.Load(path); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if _, ok := reloaded.Check("newkey"); !ok {
+		t.Error("expected runtime key to survive reload")
+	}
+
+	if err := k.Revoke("default"); err != ErrStaticKey {
+		t.Errorf("expected ErrStaticKey, got %v", err)
+	}
+	if err := k.Revoke("lidarr"); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	if _, ok := k.Check("newkey"); ok {
+		t.Error("expected revoked key to be rejected")
+	}
+	if err := k.Revoke("lidarr"); err != ErrKeyNotFound {
+		t.Errorf("expected ErrKeyNotFound, got %v", err)
+	}
+}
+
+func TestKeyring_RevokeLastKey(t *testing.T) {
+	k := NewKeyring()
+	k.Add(Key{Label: "radarr", Value: "old"})
+	if err := k.Revoke("radarr"); err != ErrLastKey {
+		t.Fatalf("expected ErrLastKey, got %v", err)
+	}
+	if _, ok := k.Check("anything"); ok {
+		t.Error("expected the keyring to keep rejecting unknown keys")
+	}
+
+	// Rotating works by adding the replacement first.
+	k.Add(Key{Label: "radarr-new", Value: "new"})
+	if err := k.Revoke("radarr"); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	if _, ok := k.Check("old"); ok {
+		t.Error("expected the revoked key to be rejected")
+	}
+}
+
+func TestKeyring_ListMasksValues(t *testing.T) {
+	k := NewKeyring(Key{Label: "radarr", Value: "abcdef123456"})
+	list := k.List()
+	if len(list) != 1 {
+		t.Fatalf("expected 1 key, got %d", len(list))
+	}
+	if list[0].Hint != "****3456" {
+		t.Errorf("expected masked hint, got %s", list[0].Hint)
+	}
+	if list[0].Source != "env" {
+		t.Errorf("expected env source, got %s", list[0].Source)
+	}
+}
@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+
+	"github.com/nerney/slskrr/metrics"
+)
+
+// Security events counted by Reject.
+const (
+	EventAPIKey     = "api_key"     // wrong or missing client API key
+	EventToken      = "token"       // download token that doesn't decode
+	EventAdminLogin = "admin_login" // rejected admin credentials
+)
+
+var rejections = metrics.NewCounter("slskrr_security_rejections_total",
+	"Requests rejected for bad credentials or tokens, by event and facade.",
+	"event", "facade")
+
+// Reject records a request refused for event on facade (newznab, sabnzbd,
+// admin or hooks): it is counted and logged at WARN with the caller's
+// address, so probing against an exposed facade stands out. args are
+// extra slog key-value pairs.
+func Reject(r *http.Request, event, facade string, args ...any) {
+	rejections.Inc(event, facade)
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	attrs := []any{"event", event, "facade", facade, "remote", host, "path", r.URL.Path}
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+		attrs = append(attrs, "forwarded_for", fwd)
+	}
+	slog.WarnContext(r.Context(), "request rejected", append(attrs, args...)...)
+}
@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReject(t *testing.T) {
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+	defer slog.SetDefault(prev)
+
+	before := rejections.Value(EventAPIKey, "newznab")
+	req := httptest.NewRequest("GET", "/api?t=search&apikey=wrong", nil)
+	req.RemoteAddr = "203.0.113.7:4242"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	Reject(req, EventAPIKey, "newznab")
+
+	if n := rejections.Value(EventAPIKey, "newznab") - before; n != 1 {
+		t.Errorf("expected one rejection counted, got %v", n)
+	}
+	log := buf.String()
+	for _, want := range []string{"level=WARN", "event=api_key", "remote=203.0.113.7", "forwarded_for=198.51.100.1"} {
+		if !strings.Contains(log, want) {
+			t.Errorf("expected %q in log: %s", want, log)
+		}
+	}
+	if strings.Contains(log, "wrong") {
+		t.Errorf("the rejected key must not be logged: %s", log)
+	}
+}
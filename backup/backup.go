@@ -0,0 +1,290 @@
+// Package backup snapshots slskrr's persisted state into a backup directory
+// and stages snapshots to be restored, so the queue, history and runtime
+// settings survive the loss of DATA_DIR.
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotFound    = errors.New("backup not found")
+	ErrInvalidName = errors.New("invalid backup name")
+)
+
+// stagedDir is the DATA_DIR subdirectory a restored snapshot is unpacked
+// into until the next start applies it.
+const stagedDir = "restore"
+
+const timeLayout = "20060102T150405Z"
+
+var namePattern = regexp.MustCompile(`^slskrr-\d{8}T\d{6}Z\.tar\.gz$`)
+
+// Snapshot describes one backup archive.
+type Snapshot struct {
+	Name string    `json:"name"`
+	Size int64     `json:"size"`
+	Time time.Time `json:"time"`
+}
+
+// Backups writes snapshots of the JSON state files in DataDir to Dir,
+// keeping the newest Keep (all when Keep is 0).
+type Backups struct {
+	DataDir string
+	Dir     string
+	Keep    int
+
+	// Flush, when set, is called before each snapshot so state held in
+	// memory is on disk first.
+	Flush func() error
+
+	mu  sync.Mutex
+	now func() time.Time
+}
+
+// Snapshot archives the state files now and prunes old snapshots.
+func (b *Backups) Snapshot() (Snapshot, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.Flush != nil {
+		if err := b.Flush(); err != nil {
+			return Snapshot{}, fmt.Errorf("flush state: %w", err)
+		}
+	}
+	files, err := filepath.Glob(filepath.Join(b.DataDir, "*.json"))
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("list state files: %w", err)
+	}
+	if err := os.MkdirAll(b.Dir, 0o755); err != nil {
+		return Snapshot{}, fmt.Errorf("create backup dir: %w", err)
+	}
+
+	now := time.Now
+	if b.now != nil {
+		now = b.now
+	}
+	t := now().UTC().Truncate(time.Second)
+	name := "slskrr-" + t.Format(timeLayout) + ".tar.gz"
+	path := filepath.Join(b.Dir, name)
+	if err := writeArchive(path, files); err != nil {
+		return Snapshot{}, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("stat backup: %w", err)
+	}
+	b.prune()
+	return Snapshot{Name: name, Size: info.Size(), Time: t}, nil
+}
+
+// writeArchive writes files into a gzipped tar at path, atomically.
+func writeArchive(path string, files []string) (err error) {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("create backup: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(tmp)
+		}
+	}()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, file := range files {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("read %s: %w", filepath.Base(file), err)
+		}
+		hdr := &tar.Header{Name: filepath.Base(file), Mode: 0o644, Size: int64(len(b)), ModTime: time.Now()}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("write backup: %w", err)
+		}
+		if _, err := tw.Write(b); err != nil {
+			return fmt.Errorf("write backup: %w", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("write backup: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("write backup: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("write backup: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("replace backup: %w", err)
+	}
+	return nil
+}
+
+// prune removes all but the newest Keep snapshots.
+func (b *Backups) prune() {
+	if b.Keep <= 0 {
+		return
+	}
+	snaps, err := b.List()
+	if err != nil {
+		slog.Error("failed to list backups", "error", err)
+		return
+	}
+	for _, s := range snaps[min(b.Keep, len(snaps)):] {
+		if err := os.Remove(filepath.Join(b.Dir, s.Name)); err != nil {
+			slog.Error("failed to remove old backup", "name", s.Name, "error", err)
+		}
+	}
+}
+
+// List returns the snapshots in Dir, newest first.
+func (b *Backups) List() ([]Snapshot, error) {
+	entries, err := os.ReadDir(b.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read backup dir: %w", err)
+	}
+	var snaps []Snapshot
+	for _, e := range entries {
+		if !namePattern.MatchString(e.Name()) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		t, _ := time.Parse(timeLayout, strings.TrimSuffix(strings.TrimPrefix(e.Name(), "slskrr-"), ".tar.gz"))
+		snaps = append(snaps, Snapshot{Name: e.Name(), Size: info.Size(), Time: t})
+	}
+	sort.Slice(snaps, func(i, j int) bool { return snaps[i].Time.After(snaps[j].Time) })
+	return snaps, nil
+}
+
+// Stage unpacks the named snapshot into DataDir's restore directory, to
+// replace the state files at the next start. Restoring over the live files
+// would be undone by the running process's next flush.
+func (b *Backups) Stage(name string) error {
+	if !namePattern.MatchString(name) {
+		return ErrInvalidName
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	f, err := os.Open(filepath.Join(b.Dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("open backup: %w", err)
+	}
+	defer f.Close()
+
+	// Unpack beside the restore directory and swap it in, so a failed
+	// stage never leaves a partial restore to be applied.
+	dir := filepath.Join(b.DataDir, stagedDir)
+	tmp := dir + ".tmp"
+	if err := os.RemoveAll(tmp); err != nil {
+		return fmt.Errorf("clear restore dir: %w", err)
+	}
+	if err := os.MkdirAll(tmp, 0o755); err != nil {
+		return fmt.Errorf("create restore dir: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("read backup: %w", err)
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read backup: %w", err)
+		}
+		// Only plain state files, by base name, so an archive can't
+		// write outside the restore directory.
+		if hdr.Typeflag != tar.TypeReg || hdr.Name != filepath.Base(hdr.Name) || filepath.Ext(hdr.Name) != ".json" {
+			continue
+		}
+		out, err := os.Create(filepath.Join(tmp, hdr.Name))
+		if err != nil {
+			return fmt.Errorf("stage %s: %w", hdr.Name, err)
+		}
+		_, err = io.Copy(out, tr)
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("stage %s: %w", hdr.Name, err)
+		}
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("clear restore dir: %w", err)
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		return fmt.Errorf("stage backup: %w", err)
+	}
+	return nil
+}
+
+// ApplyStaged moves a snapshot staged by Stage into dataDir, replacing the
+// state files it contains. It must run before any state is loaded. It
+// returns the names of the restored files.
+func ApplyStaged(dataDir string) ([]string, error) {
+	dir := filepath.Join(dataDir, stagedDir)
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("list staged files: %w", err)
+	}
+	var restored []string
+	for _, file := range files {
+		name := filepath.Base(file)
+		if err := os.Rename(file, filepath.Join(dataDir, name)); err != nil {
+			return restored, fmt.Errorf("restore %s: %w", name, err)
+		}
+		restored = append(restored, name)
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return restored, fmt.Errorf("clear restore dir: %w", err)
+	}
+	return restored, nil
+}
+
+// Run takes a snapshot every interval until ctx is cancelled.
+func (b *Backups) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s, err := b.Snapshot()
+			if err != nil {
+				slog.Error("failed to back up state", "error", err)
+				continue
+			}
+			slog.Info("backed up state", "name", s.Name, "size", s.Size)
+		}
+	}
+}
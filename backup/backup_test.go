@@ -0,0 +1,72 @@
+package backup
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBackups_SnapshotStageApply(t *testing.T) {
+	dataDir, dir := t.TempDir(), t.TempDir()
+	os.WriteFile(filepath.Join(dataDir, "store.json"), []byte(`["v1"]`), 0o644)
+	os.WriteFile(filepath.Join(dataDir, "keys.json"), []byte(`{}`), 0o644)
+
+	flushed := 0
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	b := &Backups{DataDir: dataDir, Dir: dir, Keep: 2, Flush: func() error { flushed++; return nil }}
+	b.now = func() time.Time { return now }
+
+	first, err := b.Snapshot()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if first.Name != "slskrr-20260102T030405Z.tar.gz" || flushed != 1 {
+		t.Errorf("unexpected snapshot %+v (flushed %d)", first, flushed)
+	}
+
+	os.WriteFile(filepath.Join(dataDir, "store.json"), []byte(`["v2"]`), 0o644)
+	for range 2 {
+		now = now.Add(time.Hour)
+		if _, err := b.Snapshot(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	snaps, _ := b.List()
+	if len(snaps) != 2 || snaps[0].Name != "slskrr-20260102T050405Z.tar.gz" {
+		t.Fatalf("expected the newest 2 snapshots kept, got %+v", snaps)
+	}
+
+	if err := b.Stage(first.Name); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a pruned snapshot, got %v", err)
+	}
+	if err := b.Stage("../store.json"); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("expected ErrInvalidName, got %v", err)
+	}
+
+	os.WriteFile(filepath.Join(dataDir, "store.json"), []byte(`["v3"]`), 0o644)
+	if err := b.Stage(snaps[1].Name); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dataDir, "store.json")); string(b) != `["v3"]` {
+		t.Errorf("expected live state untouched until applied, got %s", b)
+	}
+
+	restored, err := ApplyStaged(dataDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(restored) != 2 {
+		t.Errorf("expected 2 files restored, got %v", restored)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dataDir, "store.json")); string(b) != `["v2"]` {
+		t.Errorf("expected restored state, got %s", b)
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, stagedDir)); !os.IsNotExist(err) {
+		t.Error("expected the restore dir removed")
+	}
+	if restored, err := ApplyStaged(dataDir); err != nil || len(restored) != 0 {
+		t.Errorf("expected nothing to apply, got %v %v", restored, err)
+	}
+}
@@ -0,0 +1,173 @@
+// Package blocklist tracks Soulseek peers whose downloads keep failing
+// and keeps them out of search results for a cooldown period.
+package blocklist
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxIDs bounds the download IDs remembered per peer for de-duplication.
+const maxIDs = 20
+
+var ErrNotFound = errors.New("username not on the blocklist")
+
+// Entry is a peer's failure record.
+type Entry struct {
+	Username     string    `json:"username"`
+	Strikes      int       `json:"strikes"`
+	LastReason   string    `json:"lastReason,omitempty"`
+	LastStrike   time.Time `json:"lastStrike"`
+	BlockedUntil time.Time `json:"blockedUntil,omitempty"`
+	IDs          []string  `json:"ids,omitempty"` // downloads already counted
+}
+
+// Blocklist counts strikes against peers. Once a peer reaches Threshold
+// strikes it is blocked for Cooldown; strikes older than Cooldown are
+// forgotten. A nil blocklist blocks nobody.
+type Blocklist struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*Entry
+	path    string
+	now     func() time.Time
+}
+
+func New(threshold int, cooldown time.Duration) *Blocklist {
+	return &Blocklist{
+		Threshold: threshold,
+		Cooldown:  cooldown,
+		entries:   make(map[string]*Entry),
+		now:       time.Now,
+	}
+}
+
+// Strike records a failed download from username. id identifies the
+// download so the same failure reported twice (e.g. download failed, then
+// import failed) only counts once. It returns the updated entry.
+func (b *Blocklist) Strike(username, id, reason string) (Entry, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	e, ok := b.entries[username]
+	if !ok {
+		e = &Entry{Username: username}
+		b.entries[username] = e
+	}
+	if id != "" && slices.Contains(e.IDs, id) {
+		return *e, nil
+	}
+	if now.Sub(e.LastStrike) > b.Cooldown && !now.Before(e.BlockedUntil) {
+		e.Strikes = 0
+	}
+
+	e.Strikes++
+	e.LastStrike = now
+	e.LastReason = reason
+	if id != "" {
+		e.IDs = append(e.IDs, id)
+		if len(e.IDs) > maxIDs {
+			e.IDs = e.IDs[len(e.IDs)-maxIDs:]
+		}
+	}
+	if e.Strikes >= b.Threshold {
+		e.BlockedUntil = now.Add(b.Cooldown)
+	}
+	return *e, b.save()
+}
+
+// Blocked reports whether username is currently blocked.
+func (b *Blocklist) Blocked(username string) bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e, ok := b.entries[username]
+	return ok && b.now().Before(e.BlockedUntil)
+}
+
+// List returns every peer with strikes, most recent first.
+func (b *Blocklist) List() []Entry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	result := make([]Entry, 0, len(b.entries))
+	for _, e := range b.entries {
+		result = append(result, *e)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].LastStrike.After(result[j].LastStrike) })
+	return result
+}
+
+// Remove clears a peer's strikes and unblocks it.
+func (b *Blocklist) Remove(username string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.entries[username]; !ok {
+		return ErrNotFound
+	}
+	delete(b.entries, username)
+	return b.save()
+}
+
+// Load reads entries from path and persists future changes there. A
+// missing file is not an error.
+func (b *Blocklist) Load(path string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.path = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read blocklist file: %w", err)
+	}
+	var entries []*Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("decode blocklist file: %w", err)
+	}
+	for _, e := range entries {
+		b.entries[e.Username] = e
+	}
+	return nil
+}
+
+// save writes all entries atomically. Callers must hold b.mu.
+func (b *Blocklist) save() error {
+	if b.path == "" {
+		return nil
+	}
+	entries := make([]*Entry, 0, len(b.entries))
+	for _, e := range b.entries {
+		entries = append(entries, e)
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode blocklist: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(b.path), 0o755); err != nil {
+		return fmt.Errorf("create blocklist dir: %w", err)
+	}
+	tmp := b.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("write blocklist file: %w", err)
+	}
+	if err := os.Rename(tmp, b.path); err != nil {
+		return fmt.Errorf("replace blocklist file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package blocklist
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBlocklist_StrikesBlockAfterThreshold(t *testing.T) {
+	b := New(2, time.Hour)
+
+	b.Strike("peer", "nzo_1", "import failed")
+	if b.Blocked("peer") {
+		t.Fatal("expected one strike not to block")
+	}
+	// The same download reported twice only counts once.
+	b.Strike("peer", "nzo_1", "download failed")
+	if b.Blocked("peer") {
+		t.Fatal("expected duplicate strike to be ignored")
+	}
+	e, _ := b.Strike("peer", "nzo_2", "import failed")
+	if e.Strikes != 2 || !b.Blocked("peer") {
+		t.Fatalf("expected peer blocked after 2 strikes, got %+v", e)
+	}
+	if b.Blocked("other") {
+		t.Error("expected other peers unaffected")
+	}
+}
+
+func TestBlocklist_CooldownExpires(t *testing.T) {
+	now := time.Now()
+	b := New(1, time.Hour)
+	b.now = func() time.Time { return now }
+
+	b.Strike("peer", "nzo_1", "")
+	if !b.Blocked("peer") {
+		t.Fatal("expected peer blocked")
+	}
+	now = now.Add(2 * time.Hour)
+	if b.Blocked("peer") {
+		t.Fatal("expected block to expire after cooldown")
+	}
+
+	// Old strikes are forgotten once the cooldown has passed.
+	b.Threshold = 2
+	if e, _ := b.Strike("peer", "nzo_2", ""); e.Strikes != 1 {
+		t.Errorf("expected strikes to reset, got %d", e.Strikes)
+	}
+}
+
+func TestBlocklist_NilBlocksNobody(t *testing.T) {
+	var b *Blocklist
+	if b.Blocked("peer") {
+		t.Error("nil blocklist should block nobody")
+	}
+}
+
+func TestBlocklist_RemovePersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.json")
+
+	b := New(1, time.Hour)
+	if err := b.Load(path); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	b.Strike("peer1", "nzo_1", "bad")
+	b.Strike("peer2", "nzo_2", "bad")
+	if err := b.Remove("peer2"); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if err := b.Remove("peer2"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	reloaded := New(1, time.Hour)
+	if err := reloaded.Load(path); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if !reloaded.Blocked("peer1") || reloaded.Blocked("peer2") {
+		t.Errorf("unexpected reloaded entries: %+v", reloaded.List())
+	}
+}
@@ -0,0 +1,123 @@
+package blocklist
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/nerney/slskrr/auth"
+	"github.com/nerney/slskrr/store"
+)
+
+// strikeEvents are the *arr webhook event types that count against the
+// peer a download came from.
+var strikeEvents = map[string]bool{
+	"DownloadFailed":            true, // Sonarr/Radarr "On Download Failed"
+	"DownloadFailure":           true, // Lidarr/Readarr "On Download Failure"
+	"ImportFailure":             true, // Lidarr/Readarr "On Import Failure"
+	"ManualInteractionRequired": true, // Sonarr/Radarr import needs a human
+}
+
+// Hook receives Sonarr, Radarr and Lidarr webhook events (Settings →
+// Connect → Webhook) and strikes the Soulseek peer behind each failed
+// download. Other event types are acknowledged and ignored.
+type Hook struct {
+	Blocklist *Blocklist
+	Store     *store.Store
+	Keys      *auth.Keyring
+}
+
+type arrEvent struct {
+	EventType  string `json:"eventType"`
+	DownloadID string `json:"downloadId"`
+	Message    string `json:"message"`
+	Download   struct {
+		ID string `json:"downloadId"` // some events nest it
+	} `json:"download"`
+	DownloadStatusMessages []struct {
+		Messages []string `json:"messages"`
+	} `json:"downloadStatusMessages"`
+}
+
+// reason summarizes why the download failed.
+func (e *arrEvent) reason() string {
+	if e.Message != "" {
+		return e.Message
+	}
+	var msgs []string
+	for _, m := range e.DownloadStatusMessages {
+		msgs = append(msgs, m.Messages...)
+	}
+	if len(msgs) > 0 {
+		return strings.Join(msgs, "; ")
+	}
+	return e.EventType
+}
+
+func (h *Hook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]any{"status": false, "error": "Method not allowed"})
+		return
+	}
+	key := r.Header.Get("X-Api-Key")
+	if key == "" {
+		key = r.URL.Query().Get("apikey")
+	}
+	if _, ok := h.Keys.Check(key); !ok {
+		auth.Reject(r, auth.EventAPIKey, "hooks")
+		writeJSON(w, http.StatusUnauthorized, map[string]any{"status": false, "error": "API Key Incorrect"})
+		return
+	}
+
+	var ev arrEvent
+	if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"status": false, "error": "Invalid JSON body"})
+		return
+	}
+	id := ev.DownloadID
+	if id == "" {
+		id = ev.Download.ID
+	}
+	// *arr disables webhooks that keep failing, so anything we can't act
+	// on (tests, other events, other download clients) is still a 200.
+	if !strikeEvents[ev.EventType] || id == "" {
+		writeJSON(w, http.StatusOK, map[string]any{"status": true, "ignored": true})
+		return
+	}
+	dl := h.Store.Lookup(id)
+	if dl == nil {
+		slog.DebugContext(r.Context(), "ignoring failure event for unknown download", "event", ev.EventType, "id", id)
+		writeJSON(w, http.StatusOK, map[string]any{"status": true, "ignored": true})
+		return
+	}
+
+	entry, err := h.Blocklist.Strike(dl.Username, id, ev.reason())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to persist blocklist", "error", err)
+	}
+	blocked := h.Blocklist.Blocked(dl.Username)
+	slog.InfoContext(r.Context(), "peer struck for failed download",
+		"event", ev.EventType,
+		"id", id,
+		"username", dl.Username,
+		"filename", dl.Filename,
+		"strikes", entry.Strikes,
+		"blocked", blocked,
+		"reason", entry.LastReason,
+	)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"status":   true,
+		"username": dl.Username,
+		"strikes":  entry.Strikes,
+		"blocked":  blocked,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		slog.Error("failed to write JSON response", "error", err)
+	}
+}
@@ -0,0 +1,74 @@
+package blocklist
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nerney/slskrr/auth"
+	"github.com/nerney/slskrr/store"
+)
+
+func postEvent(h *Hook, apikey, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/hooks/arr?apikey="+apikey, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHook_StrikesPeerOfFailedDownload(t *testing.T) {
+	st := store.New()
+	id := st.Add("baduser", "Music\\Album\\01.flac", 1000, "lidarr")
+	// *arr usually removes the failed download before the webhook fires.
+	st.Remove(id)
+	h := &Hook{
+		Blocklist: New(1, time.Hour),
+		Store:     st,
+		Keys:      auth.NewKeyring(auth.Key{Label: "default", Value: "testapikey"}),
+	}
+
+	rec := postEvent(h, "testapikey", `{"eventType":"ImportFailure","downloadId":"`+id+`","message":"Missing tracks"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Username string `json:"username"`
+		Blocked  bool   `json:"blocked"`
+	}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp.Username != "baduser" || !resp.Blocked {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+	if e := h.Blocklist.List(); len(e) != 1 || e[0].LastReason != "Missing tracks" {
+		t.Errorf("unexpected entries: %+v", e)
+	}
+}
+
+func TestHook_IgnoresOtherEvents(t *testing.T) {
+	st := store.New()
+	id := st.Add("user", "file.mkv", 1000, "radarr")
+	h := &Hook{Blocklist: New(1, time.Hour), Store: st, Keys: auth.NewKeyring(auth.Key{Label: "default", Value: "testapikey"})}
+
+	for _, body := range []string{
+		`{"eventType":"Test"}`,
+		`{"eventType":"Download","downloadId":"` + id + `"}`,
+		`{"eventType":"DownloadFailed","downloadId":"SABnzbd_nzo_other"}`,
+	} {
+		if rec := postEvent(h, "testapikey", body); rec.Code != http.StatusOK {
+			t.Errorf("%s: expected 200, got %d", body, rec.Code)
+		}
+	}
+	if len(h.Blocklist.List()) != 0 {
+		t.Errorf("expected no strikes, got %+v", h.Blocklist.List())
+	}
+}
+
+func TestHook_RequiresAPIKey(t *testing.T) {
+	h := &Hook{Blocklist: New(1, time.Hour), Store: store.New(), Keys: auth.NewKeyring(auth.Key{Label: "default", Value: "testapikey"})}
+	if rec := postEvent(h, "wrong", `{"eventType":"Test"}`); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d", rec.Code)
+	}
+}
@@ -1,27 +1,198 @@
 package main
 
 import (
+	"cmp"
 	"fmt"
+	"net/netip"
+	"net/url"
 	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/nerney/slskrr/auth"
+	"github.com/nerney/slskrr/musicbrainz"
+	"github.com/nerney/slskrr/newznab"
+	"github.com/nerney/slskrr/notify"
+	"github.com/nerney/slskrr/postprocess"
+	"github.com/nerney/slskrr/sabnzbd"
+	"github.com/nerney/slskrr/slskd"
 )
 
 type Config struct {
-	SlskdURL      string
-	SlskdAPIKey   string
-	ListenAddr    string
-	APIKey        string
-	SearchTimeout time.Duration
-	DownloadDir   string
+	SlskdURL        string
+	SlskdAPIKey     string
+	ListenAddr      string
+	BasePath        string // URL prefix when served under a subpath, e.g. "/slskrr"
+	ExternalURL     string // base of self-referencing links, e.g. "http://slskrr:6969"; empty derives it per request
+	APIKey          string
+	APIKeys         []auth.Key
+	SearchTimeout   time.Duration
+	MaxTimeout      time.Duration                   // most a search's timeout parameter can ask for; 0 ignores it
+	MaxPeerFiles    int                             // best wanted files considered per peer in search results; 0 is unlimited
+	MaxFiles        int                             // files considered per search; 0 is unlimited
+	MaxResults      int                             // most search results per page, advertised in caps
+	QueryCooldown   time.Duration                   // repeated queries within this reuse the last search; 0 disables
+	EarlyResults    int                             // good results that end a search before its timeout; 0 disables
+	EarlyScore      int                             // peer score a result needs to count towards EarlyResults
+	RelaxEmpty      bool                            // retry without size floors when every result is filtered out
+	ReleaseNames    bool                            // title results with synthesized release names
+	QueryCleanup    []newznab.QueryRule             // cleanups applied to app queries; nil searches them as sent
+	TMDBAPIKey      string                          // enables original-title searches for Radarr's id searches
+	MusicBrainzURL  string                          // enables MBID lookups for music searches; empty disables
+	Adult           bool                            // offer the 6000 (XXX) categories for Whisparr
+	BandwidthMax    int64                           // bytes/s a percentage SAB speedlimit is a share of; 0 disables percentages
+	MaxDownloadAge  time.Duration                   // how long a download attempt may stay in flight; 0 disables
+	SyncInterval    time.Duration                   // how often slskd's transfers are polled
+	SlskdHub        bool                            // subscribe to slskd's transfer and search hubs, polling as the fallback
+	AlternatePeers  bool                            // retry failed transfers from another peer sharing the file
+	MinFreeSpace    int64                           // free bytes below which health reports degraded; 0 disables the check
+	SABVersion      string                          // SABnzbd version reported to clients
+	SABCategories   []string                        // categories offered besides "Default"
+	Companions      []string                        // patterns of files queued beside grabbed audio; nil disables
+	HotSearches     int                             // most reused queries refreshed in the background
+	TestResponse    newznab.TestResponse            // what a search without a query returns
+	TestResponses   map[string]newznab.TestResponse // by API key label or search function
+	SlskdWait       time.Duration                   // how long to wait for slskd at startup; 0 skips the wait
+	DownloadDir     string
+	DataDir         string // where the store and runtime keys are persisted; empty disables persistence
+	BackupDir       string // where snapshots of DataDir are written; empty disables backups
+	BackupInterval  time.Duration
+	BackupKeep      int // newest snapshots kept; 0 keeps all
+	AdminAuth       auth.AdminAuth
+	CORSOrigins     []string
+	TrustedProxies  []netip.Prefix
+	MaxURLLength    int
+	MaxBodySize     int64
+	RateLimit       int // requests per minute per client on /api and /sabnzbd/api; 0 disables
+	RateBurst       int
+	OTLPEndpoint    string // OTLP/HTTP collector base URL; empty disables tracing
+	ServiceName     string
+	WebhookURLs     []string
+	WebhookEvents   []notify.Event
+	Discord         notify.Discord
+	DiscordEvents   []notify.Event
+	Ntfy            notify.Ntfy
+	NtfyEvents      []notify.Event
+	Pushover        notify.Pushover
+	PushoverEvents  []notify.Event
+	Telegram        notify.Telegram
+	TelegramEvents  []notify.Event
+	TelegramButtons bool // offer Retry/Cancel buttons on failures
+	Apprise         notify.Apprise
+	AppriseEvents   []notify.Event
+	NotifyTemplates *notify.Templates
+	Hooks           map[notify.Event]*notify.Exec // local commands run on events
+
+	LidarrURL         string // enables the wanted-list syncer when set
+	LidarrAPIKey      string
+	LidarrInterval    time.Duration
+	LidarrMaxSearches int
+	LidarrCutoff      bool
+	LidarrFormats     []string
+	LidarrCategory    string
+	LidarrCooldown    time.Duration
+
+	BlocklistStrikes  int           // failures before a peer is blocked
+	BlocklistCooldown time.Duration // how long a block, and a strike, lasts
+	SlskdBlacklist    bool          // also leave out and refuse slskd's blacklisted users
+
+	WishlistInterval    time.Duration
+	WishlistMaxSearches int
+
+	LogBufferSize int // log records kept for /admin/api/logs; 0 disables
+	SearchLogSize int // newznab searches kept for /admin/api/requests; 0 disables
+
+	SearchJanitor    bool          // periodically delete stale searches from slskd
+	SearchJanitorAge time.Duration // age after which a search is stale
+
+	SlskdOptionsTTL time.Duration // how long slskd's options are cached
+	SlskdDownAfter  time.Duration // how long slskd may fail to answer before notifiers hear of it
+
+	MaxSearches        int           // slskd searches run at once, the rest queued; 0 means no limit
+	SearchQueueTimeout time.Duration // how long a queued search waits for its turn
+
+	FlattenFolders    bool            // move completed files to <category>/<release>/
+	FlattenLink       bool            // hard-link them there instead, leaving slskd's copy
+	SanitizeFilenames bool            // rename completed files for Windows-family filesystems
+	SanitizeRules     map[rune]string // character replacements applied when sanitizing
+	VerifyAudio       bool            // check completed FLAC and MP3 files for damage
+	TagAudio          bool            // fill in missing tags on completed FLAC and MP3 files
+	Sidecar           string          // provenance sidecar format: "nfo", "json" or empty for none
+
+	TranscodeTo          string   // target audio format; empty disables transcoding
+	TranscodeFrom        []string // source formats that get transcoded
+	TranscodeBitrate     string   // empty uses the target format's default
+	TranscodeKeep        bool     // keep the original next to the transcoded file
+	TranscodeConcurrency int
+	FFmpegPath           string
+
+	PUID, PGID int          // owner given to completed files; -1 leaves it unchanged
+	Umask      *os.FileMode // mode bits cleared on completed files; nil leaves modes unchanged
+
+	CategoryScripts map[string]string // category (or "*") to post-processing script
+	ScriptTimeout   time.Duration
 }
 
+// LoadConfig reads the configuration from the environment, falling back on
+// the file named by CONFIG_FILE for variables left unset.
 func LoadConfig() (*Config, error) {
+	if err := openConfigFile(); err != nil {
+		return nil, err
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, configFile.annotate(err)
+	}
+	if err := configFile.unknown(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func loadConfig() (*Config, error) {
 	cfg := &Config{
-		SlskdURL:      os.Getenv("SLSKD_URL"),
-		SlskdAPIKey:   os.Getenv("SLSKD_API_KEY"),
-		ListenAddr:    os.Getenv("LISTEN_ADDR"),
-		APIKey:        os.Getenv("API_KEY"),
-		DownloadDir:   os.Getenv("DOWNLOAD_DIR"),
+		SlskdURL:       getenv("SLSKD_URL"),
+		SlskdAPIKey:    getenv("SLSKD_API_KEY"),
+		ListenAddr:     getenv("LISTEN_ADDR"),
+		BasePath:       normalizeBasePath(getenv("BASE_PATH")),
+		APIKey:         getenv("API_KEY"),
+		DownloadDir:    getenv("DOWNLOAD_DIR"),
+		DataDir:        getenv("DATA_DIR"),
+		OTLPEndpoint:   getenv("OTEL_EXPORTER_OTLP_ENDPOINT"),
+		ServiceName:    getenv("OTEL_SERVICE_NAME"),
+		LidarrURL:      getenv("LIDARR_URL"),
+		LidarrAPIKey:   getenv("LIDARR_API_KEY"),
+		LidarrCategory: getenv("LIDARR_CATEGORY"),
+		TMDBAPIKey:     getenv("TMDB_API_KEY"),
+		Discord: notify.Discord{
+			WebhookURL: getenv("DISCORD_WEBHOOK_URL"),
+			Username:   getenv("DISCORD_USERNAME"),
+		},
+		Ntfy: notify.Ntfy{
+			TopicURL: getenv("NTFY_URL"),
+			Token:    getenv("NTFY_TOKEN"),
+		},
+		Pushover: notify.Pushover{
+			Token: getenv("PUSHOVER_TOKEN"),
+			User:  getenv("PUSHOVER_USER"),
+		},
+		Telegram: notify.Telegram{
+			Token:  getenv("TELEGRAM_BOT_TOKEN"),
+			ChatID: getenv("TELEGRAM_CHAT_ID"),
+		},
+		Apprise: notify.Apprise{
+			URL:      getenv("APPRISE_URL"),
+			Services: getenv("APPRISE_SERVICES"),
+			Tag:      getenv("APPRISE_TAG"),
+		},
+		AdminAuth: auth.AdminAuth{
+			Username: getenv("ADMIN_USER"),
+			Password: getenv("ADMIN_PASSWORD"),
+			Header:   getenv("ADMIN_AUTH_HEADER"),
+		},
 	}
 
 	if cfg.SlskdURL == "" {
@@ -36,8 +207,384 @@ func LoadConfig() (*Config, error) {
 	if cfg.DownloadDir == "" {
 		cfg.DownloadDir = "/downloads/complete"
 	}
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = "slskrr"
+	}
+
+	if v := getenv("EXTERNAL_URL"); v != "" {
+		u, err := url.Parse(v)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" {
+			return nil, fmt.Errorf("invalid EXTERNAL_URL: must be an http(s) URL like http://slskrr:6969")
+		}
+		cfg.ExternalURL = strings.TrimSuffix(v, "/")
+	}
+
+	if v := getenv("API_KEYS"); v != "" {
+		keys, err := auth.ParseKeys(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid API_KEYS: %w", err)
+		}
+		// API_KEY joins the keyring labeled "default", see Keyring.
+		for _, k := range keys {
+			switch {
+			case cfg.APIKey == "":
+			case k.Label == "default":
+				return nil, fmt.Errorf(`invalid API_KEYS: label "default" is taken by API_KEY`)
+			case k.Value == cfg.APIKey:
+				return nil, fmt.Errorf("invalid API_KEYS: key for label %q repeats API_KEY", k.Label)
+			}
+		}
+		cfg.APIKeys = keys
+	}
+
+	if cfg.AdminAuth.Username != "" && cfg.AdminAuth.Password == "" {
+		return nil, fmt.Errorf("ADMIN_PASSWORD is required when ADMIN_USER is set")
+	}
+	if v := getenv("ADMIN_TRUSTED_PROXIES"); v != "" {
+		proxies, err := auth.ParsePrefixes(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ADMIN_TRUSTED_PROXIES: %w", err)
+		}
+		cfg.AdminAuth.TrustedProxies = proxies
+	}
+	if cfg.AdminAuth.Header != "" && len(cfg.AdminAuth.TrustedProxies) == 0 {
+		return nil, fmt.Errorf("ADMIN_TRUSTED_PROXIES is required when ADMIN_AUTH_HEADER is set")
+	}
+	if v := getenv("TRUSTED_PROXIES"); v != "" {
+		proxies, err := auth.ParsePrefixes(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TRUSTED_PROXIES: %w", err)
+		}
+		cfg.TrustedProxies = proxies
+	}
+
+	for _, o := range strings.Split(getenv("CORS_ORIGINS"), ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			cfg.CORSOrigins = append(cfg.CORSOrigins, strings.TrimSuffix(o, "/"))
+		}
+	}
+
+	for _, u := range strings.Split(getenv("WEBHOOK_URLS"), ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			cfg.WebhookURLs = append(cfg.WebhookURLs, u)
+		}
+	}
+	var err error
+	if cfg.WebhookEvents, err = eventsEnv("WEBHOOK_EVENTS", notify.AllEvents); err != nil {
+		return nil, err
+	}
+	if cfg.DiscordEvents, err = eventsEnv("DISCORD_EVENTS", notify.AllEvents); err != nil {
+		return nil, err
+	}
+	// Phone alerts default to the outcomes and slskd outages only.
+	outcomes := []notify.Event{notify.EventComplete, notify.EventFailure, notify.EventUnreachable, notify.EventRecovered}
+	if cfg.NtfyEvents, err = eventsEnv("NTFY_EVENTS", outcomes); err != nil {
+		return nil, err
+	}
+	if cfg.PushoverEvents, err = eventsEnv("PUSHOVER_EVENTS", outcomes); err != nil {
+		return nil, err
+	}
+	if (cfg.Pushover.Token == "") != (cfg.Pushover.User == "") {
+		return nil, fmt.Errorf("PUSHOVER_TOKEN and PUSHOVER_USER must be set together")
+	}
+	if cfg.TelegramEvents, err = eventsEnv("TELEGRAM_EVENTS", notify.AllEvents); err != nil {
+		return nil, err
+	}
+	if (cfg.Telegram.Token == "") != (cfg.Telegram.ChatID == "") {
+		return nil, fmt.Errorf("TELEGRAM_BOT_TOKEN and TELEGRAM_CHAT_ID must be set together")
+	}
+	if cfg.TelegramButtons, err = boolEnv("TELEGRAM_BUTTONS", false); err != nil {
+		return nil, err
+	}
+	if cfg.AppriseEvents, err = eventsEnv("APPRISE_EVENTS", notify.AllEvents); err != nil {
+		return nil, err
+	}
+	cfg.NotifyTemplates, err = notify.ParseTemplates(getenv("NOTIFY_TITLE_TEMPLATE"), getenv("NOTIFY_TEMPLATE"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid notification template: %w", err)
+	}
+	hookTimeout, err := durationEnv("HOOK_TIMEOUT", time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range []struct {
+		env   string
+		event notify.Event
+	}{
+		{"ON_GRAB", notify.EventGrab},
+		{"ON_DOWNLOAD_COMPLETE", notify.EventComplete},
+		{"ON_DOWNLOAD_FAILED", notify.EventFailure},
+		{"ON_SLSKD_UNREACHABLE", notify.EventUnreachable},
+		{"ON_SLSKD_RECOVERED", notify.EventRecovered},
+	} {
+		line := getenv(h.env)
+		if line == "" {
+			continue
+		}
+		hook, err := notify.ParseExec(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", h.env, err)
+		}
+		hook.Timeout = hookTimeout
+		if cfg.Hooks == nil {
+			cfg.Hooks = make(map[notify.Event]*notify.Exec)
+		}
+		cfg.Hooks[h.event] = hook
+	}
+
+	if cfg.LidarrURL != "" && cfg.LidarrAPIKey == "" {
+		return nil, fmt.Errorf("LIDARR_API_KEY is required when LIDARR_URL is set")
+	}
+	if cfg.LidarrCategory == "" {
+		cfg.LidarrCategory = "lidarr"
+	}
+
+	grabCompanions, err := boolEnv("GRAB_COMPANIONS", true)
+	if err != nil {
+		return nil, err
+	}
+	companions := getenv("COMPANION_FILES")
+	if grabCompanions {
+		cfg.Companions = slskd.DefaultCompanions
+		if v := companions; v != "" {
+			cfg.Companions = nil
+			for _, p := range strings.Split(v, ",") {
+				if p = strings.TrimSpace(p); p != "" {
+					if _, err := path.Match(p, ""); err != nil {
+						return nil, fmt.Errorf("invalid COMPANION_FILES pattern %q: %w", p, err)
+					}
+					cfg.Companions = append(cfg.Companions, p)
+				}
+			}
+		}
+	}
+
+	cfg.SABVersion = cmp.Or(getenv("SAB_VERSION"), sabnzbd.DefaultVersion)
+	cfg.SABCategories = sabnzbd.DefaultCategories
+	if v := getenv("SAB_CATEGORIES"); v != "" {
+		cfg.SABCategories = nil
+		for _, c := range strings.Split(v, ",") {
+			if c = strings.TrimSpace(c); c != "" && !strings.EqualFold(c, "Default") && !slices.Contains(cfg.SABCategories, c) {
+				cfg.SABCategories = append(cfg.SABCategories, c)
+			}
+		}
+	}
+	// The wanted-list syncer grabs under its own category, which must be
+	// offered for Lidarr to accept the download client.
+	if cfg.LidarrURL != "" && !slices.Contains(cfg.SABCategories, cfg.LidarrCategory) {
+		cfg.SABCategories = append(slices.Clip(cfg.SABCategories), cfg.LidarrCategory)
+	}
+	if cfg.LidarrInterval, err = durationEnv("LIDARR_INTERVAL", 15*time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.LidarrCooldown, err = durationEnv("LIDARR_COOLDOWN", 24*time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.LidarrMaxSearches, err = intEnv("LIDARR_MAX_SEARCHES", 5); err != nil {
+		return nil, err
+	}
+	if cfg.LidarrCutoff, err = boolEnv("LIDARR_CUTOFF", false); err != nil {
+		return nil, err
+	}
+	formats := getenv("LIDARR_FORMATS")
+	if formats == "" {
+		formats = "flac,mp3"
+	}
+	for _, f := range strings.Split(formats, ",") {
+		if f = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(f), ".")); f != "" {
+			cfg.LidarrFormats = append(cfg.LidarrFormats, f)
+		}
+	}
+
+	if cfg.BlocklistStrikes, err = intEnv("BLOCKLIST_STRIKES", 2); err != nil {
+		return nil, err
+	}
+	if cfg.BlocklistStrikes < 1 {
+		return nil, fmt.Errorf("BLOCKLIST_STRIKES must be at least 1")
+	}
+	if cfg.BlocklistCooldown, err = durationEnv("BLOCKLIST_COOLDOWN", 7*24*time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.SlskdBlacklist, err = boolEnv("SLSKD_BLACKLIST", true); err != nil {
+		return nil, err
+	}
+	if cfg.WishlistInterval, err = durationEnv("WISHLIST_INTERVAL", time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.WishlistMaxSearches, err = intEnv("WISHLIST_MAX_SEARCHES", 10); err != nil {
+		return nil, err
+	}
+
+	if cfg.BackupDir = getenv("BACKUP_DIR"); cfg.BackupDir != "" && cfg.DataDir == "" {
+		return nil, fmt.Errorf("BACKUP_DIR requires DATA_DIR")
+	}
+	if cfg.BackupInterval, err = durationEnv("BACKUP_INTERVAL", 24*time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.BackupKeep, err = intEnv("BACKUP_KEEP", 7); err != nil {
+		return nil, err
+	}
+	if cfg.BackupKeep < 0 {
+		return nil, fmt.Errorf("BACKUP_KEEP must not be negative")
+	}
+
+	if cfg.LogBufferSize, err = intEnv("LOG_BUFFER_SIZE", 1000); err != nil {
+		return nil, err
+	}
+	if cfg.SearchLogSize, err = intEnv("SEARCH_LOG_SIZE", 50); err != nil {
+		return nil, err
+	}
+	if cfg.SearchJanitor, err = boolEnv("SEARCH_JANITOR", true); err != nil {
+		return nil, err
+	}
+	if cfg.SearchJanitorAge, err = durationEnv("SEARCH_JANITOR_AGE", time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.MaxPeerFiles, err = intEnv("MAX_PEER_FILES", 1000); err != nil {
+		return nil, err
+	}
+	if cfg.MaxFiles, err = intEnv("MAX_FILES", 10000); err != nil {
+		return nil, err
+	}
+	if cfg.EarlyResults, err = intEnv("EARLY_RETURN_RESULTS", 0); err != nil {
+		return nil, err
+	}
+	cfg.EarlyScore = 500
+	if v := getenv("EARLY_RETURN_SCORE"); v != "" {
+		// Scores go negative for peers with a queue, so this isn't an intEnv.
+		if cfg.EarlyScore, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid EARLY_RETURN_SCORE: must be an integer")
+		}
+	}
+	if cfg.RelaxEmpty, err = boolEnv("RELAX_EMPTY_RESULTS", false); err != nil {
+		return nil, err
+	}
+	if cfg.ReleaseNames, err = boolEnv("RELEASE_NAMES", false); err != nil {
+		return nil, err
+	}
+	musicBrainz, err := boolEnv("MUSICBRAINZ", false)
+	if err != nil {
+		return nil, err
+	}
+	// Read even when off, so a config file setting it isn't taken for a typo.
+	musicBrainzURL := getenv("MUSICBRAINZ_URL")
+	if musicBrainz {
+		cfg.MusicBrainzURL = cmp.Or(musicBrainzURL, musicbrainz.DefaultBaseURL)
+	}
+	if cfg.Adult, err = boolEnv("ADULT_CATEGORIES", false); err != nil {
+		return nil, err
+	}
+	if cfg.MaxResults, err = intEnv("MAX_RESULTS", 100); err != nil {
+		return nil, err
+	}
+	if cfg.MaxResults == 0 {
+		return nil, fmt.Errorf("invalid MAX_RESULTS: must be positive")
+	}
+	if cfg.SlskdOptionsTTL, err = durationEnv("SLSKD_OPTIONS_TTL", 5*time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.SlskdDownAfter, err = durationEnv("SLSKD_DOWN_AFTER", time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.MaxSearches, err = intEnv("MAX_SEARCHES", 0); err != nil {
+		return nil, err
+	}
+	if cfg.SearchQueueTimeout, err = durationEnv("SEARCH_QUEUE_TIMEOUT", time.Minute); err != nil {
+		return nil, err
+	}
 
-	timeout := os.Getenv("SEARCH_TIMEOUT")
+	if cfg.FlattenFolders, err = boolEnv("FLATTEN_FOLDERS", false); err != nil {
+		return nil, err
+	}
+	switch mode := strings.ToLower(getenv("FLATTEN_MODE")); mode {
+	case "", "move":
+	case "hardlink":
+		cfg.FlattenLink = true
+	default:
+		return nil, fmt.Errorf("invalid FLATTEN_MODE: must be move or hardlink")
+	}
+	if cfg.SanitizeFilenames, err = boolEnv("SANITIZE_FILENAMES", false); err != nil {
+		return nil, err
+	}
+	cfg.SanitizeRules = postprocess.DefaultRules
+	if rules := getenv("SANITIZE_RULES"); rules != "" {
+		if cfg.SanitizeRules, err = postprocess.ParseRules(rules); err != nil {
+			return nil, fmt.Errorf("invalid SANITIZE_RULES: %w", err)
+		}
+	}
+	if cfg.VerifyAudio, err = boolEnv("VERIFY_AUDIO", false); err != nil {
+		return nil, err
+	}
+	if cfg.TagAudio, err = boolEnv("TAG_AUDIO", false); err != nil {
+		return nil, err
+	}
+	switch cfg.Sidecar = strings.ToLower(getenv("SIDECAR")); cfg.Sidecar {
+	case "", postprocess.SidecarNFO, postprocess.SidecarJSON:
+	default:
+		return nil, fmt.Errorf("invalid SIDECAR: must be nfo or json")
+	}
+	if cfg.TranscodeTo = strings.ToLower(getenv("TRANSCODE_TO")); cfg.TranscodeTo != "" && !postprocess.ValidTarget(cfg.TranscodeTo) {
+		return nil, fmt.Errorf("invalid TRANSCODE_TO: must be one of opus, mp3, m4a, ogg or flac")
+	}
+	from := getenv("TRANSCODE_FROM")
+	if from == "" {
+		from = "flac,wav,aiff"
+	}
+	for _, f := range strings.Split(from, ",") {
+		if f = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(f), ".")); f != "" {
+			cfg.TranscodeFrom = append(cfg.TranscodeFrom, f)
+		}
+	}
+	cfg.TranscodeBitrate = getenv("TRANSCODE_BITRATE")
+	if cfg.TranscodeKeep, err = boolEnv("TRANSCODE_KEEP_ORIGINAL", false); err != nil {
+		return nil, err
+	}
+	if cfg.TranscodeConcurrency, err = intEnv("TRANSCODE_CONCURRENCY", 2); err != nil {
+		return nil, err
+	}
+	if cfg.FFmpegPath = getenv("FFMPEG_PATH"); cfg.FFmpegPath == "" {
+		cfg.FFmpegPath = "ffmpeg"
+	}
+	if scripts := getenv("CATEGORY_SCRIPTS"); scripts != "" {
+		if cfg.CategoryScripts, err = postprocess.ParseScripts(scripts); err != nil {
+			return nil, fmt.Errorf("invalid CATEGORY_SCRIPTS: %w", err)
+		}
+	}
+	if cfg.ScriptTimeout, err = durationEnv("SCRIPT_TIMEOUT", 10*time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.PUID, err = intEnv("PUID", -1); err != nil {
+		return nil, err
+	}
+	if cfg.PGID, err = intEnv("PGID", -1); err != nil {
+		return nil, err
+	}
+	if v := getenv("UMASK"); v != "" {
+		n, err := strconv.ParseUint(v, 8, 32)
+		if err != nil || n > 0o777 {
+			return nil, fmt.Errorf("invalid UMASK: must be an octal mask like 022")
+		}
+		umask := os.FileMode(n)
+		cfg.Umask = &umask
+	}
+
+	if cfg.RateLimit, err = intEnv("RATE_LIMIT", 0); err != nil {
+		return nil, err
+	}
+	if cfg.RateBurst, err = intEnv("RATE_BURST", 0); err != nil {
+		return nil, err
+	}
+
+	if cfg.MaxURLLength, err = intEnv("MAX_URL_LENGTH", 8192); err != nil {
+		return nil, err
+	}
+	maxBody, err := intEnv("MAX_BODY_SIZE", 10<<20)
+	if err != nil {
+		return nil, err
+	}
+	cfg.MaxBodySize = int64(maxBody)
+
+	timeout := getenv("SEARCH_TIMEOUT")
 	if timeout == "" {
 		cfg.SearchTimeout = 30 * time.Second
 	} else {
@@ -47,6 +594,239 @@ func LoadConfig() (*Config, error) {
 		}
 		cfg.SearchTimeout = d
 	}
+	cfg.MaxTimeout = 90 * time.Second
+	if v := getenv("SEARCH_TIMEOUT_MAX"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid SEARCH_TIMEOUT_MAX: must be a non-negative duration")
+		}
+		cfg.MaxTimeout = d
+	}
+
+	if v := getenv("QUERY_COOLDOWN"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid QUERY_COOLDOWN: must be a non-negative duration")
+		}
+		cfg.QueryCooldown = d
+	}
+
+	if cfg.HotSearches, err = intEnv("HOT_SEARCHES", 0); err != nil {
+		return nil, err
+	}
+	if cfg.HotSearches > 0 && cfg.QueryCooldown == 0 {
+		return nil, fmt.Errorf("HOT_SEARCHES requires QUERY_COOLDOWN")
+	}
+
+	cfg.QueryCleanup = newznab.DefaultQueryRules
+	if v := getenv("QUERY_CLEANUP"); v != "" {
+		if cfg.QueryCleanup, err = newznab.ParseQueryRules(v); err != nil {
+			return nil, fmt.Errorf("invalid QUERY_CLEANUP: %w", err)
+		}
+	}
+
+	if v := getenv("TEST_RESPONSE"); v != "" {
+		if cfg.TestResponse, err = newznab.ParseTestResponse(v); err != nil {
+			return nil, fmt.Errorf("invalid TEST_RESPONSE: %w", err)
+		}
+	}
+	if v := getenv("TEST_RESPONSES"); v != "" {
+		if cfg.TestResponses, err = newznab.ParseTestResponses(v); err != nil {
+			return nil, fmt.Errorf("invalid TEST_RESPONSES: %w", err)
+		}
+	}
+
+	cfg.MinFreeSpace = 1 << 30
+	if v := getenv("MIN_FREE_SPACE"); v != "" {
+		if cfg.MinFreeSpace, err = sabnzbd.ParseRate(v); err != nil {
+			return nil, fmt.Errorf("invalid MIN_FREE_SPACE: %w", err)
+		}
+	}
+
+	if v := getenv("BANDWIDTH_MAX"); v != "" {
+		if cfg.BandwidthMax, err = sabnzbd.ParseRate(v); err != nil {
+			return nil, fmt.Errorf("invalid BANDWIDTH_MAX: %w", err)
+		}
+	}
+
+	if v := getenv("MAX_DOWNLOAD_AGE"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid MAX_DOWNLOAD_AGE: must be a non-negative duration")
+		}
+		cfg.MaxDownloadAge = d
+	}
+	if cfg.AlternatePeers, err = boolEnv("ALTERNATE_PEERS", true); err != nil {
+		return nil, err
+	}
+	if cfg.SyncInterval, err = durationEnv("SYNC_INTERVAL", 5*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.SyncInterval > 30*time.Second {
+		// Health checks take a minute without a sync as a stuck loop.
+		return nil, fmt.Errorf("invalid SYNC_INTERVAL: must be at most 30s")
+	}
+	if cfg.SlskdHub, err = boolEnv("SLSKD_HUB", false); err != nil {
+		return nil, err
+	}
+
+	wait := getenv("SLSKD_WAIT")
+	if wait == "" {
+		cfg.SlskdWait = 2 * time.Minute
+	} else {
+		d, err := time.ParseDuration(wait)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SLSKD_WAIT: %w", err)
+		}
+		cfg.SlskdWait = d
+	}
 
 	return cfg, nil
 }
+
+// intEnv reads a non-negative integer env var, returning def when unset.
+func intEnv(name string, def int) (int, error) {
+	v := getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s: must be a non-negative integer", name)
+	}
+	return n, nil
+}
+
+// durationEnv reads a positive duration env var, returning def when unset.
+func durationEnv(name string, def time.Duration) (time.Duration, error) {
+	v := getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid %s: must be a positive duration", name)
+	}
+	return d, nil
+}
+
+// boolEnv reads a boolean env var, returning def when unset.
+func boolEnv(name string, def bool) (bool, error) {
+	v := getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s: must be true or false", name)
+	}
+	return b, nil
+}
+
+// eventsEnv reads a comma-separated list of notification events, returning
+// def when unset.
+func eventsEnv(name string, def []notify.Event) ([]notify.Event, error) {
+	events, err := notify.ParseEvents(getenv(name))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	if len(events) == 0 {
+		return def, nil
+	}
+	return events, nil
+}
+
+// normalizeBasePath ensures a leading slash and no trailing slash, mapping "/"
+// to the empty string.
+func normalizeBasePath(p string) string {
+	p = strings.Trim(strings.TrimSpace(p), "/")
+	if p == "" {
+		return ""
+	}
+	return "/" + p
+}
+
+// Keyring builds the set of accepted client API keys from API_KEY and API_KEYS.
+func (c *Config) Keyring() *auth.Keyring {
+	var keys []auth.Key
+	if c.APIKey != "" {
+		keys = append(keys, auth.Key{Label: "default", Value: c.APIKey})
+	}
+	keys = append(keys, c.APIKeys...)
+	return auth.NewKeyring(keys...)
+}
+
+// Notifiers builds the notification dispatcher from the configured targets,
+// returning nil when none are configured.
+func (c *Config) Notifiers() *notify.Dispatcher {
+	d := &notify.Dispatcher{Templates: c.NotifyTemplates}
+	for i, u := range c.WebhookURLs {
+		d.Add(fmt.Sprintf("webhook-%d", i+1), &notify.Webhook{URL: u}, c.WebhookEvents)
+	}
+	if c.Discord.WebhookURL != "" {
+		d.Add("discord", &c.Discord, c.DiscordEvents)
+	}
+	if c.Ntfy.TopicURL != "" {
+		d.Add("ntfy", &c.Ntfy, c.NtfyEvents)
+	}
+	if c.Pushover.Token != "" {
+		d.Add("pushover", &c.Pushover, c.PushoverEvents)
+	}
+	if c.Telegram.Token != "" {
+		d.Add("telegram", &c.Telegram, c.TelegramEvents)
+	}
+	if c.Apprise.URL != "" {
+		d.Add("apprise", &c.Apprise, c.AppriseEvents)
+	}
+	for _, ev := range notify.AllEvents {
+		if hook, ok := c.Hooks[ev]; ok {
+			d.Add("hook-"+string(ev), hook, []notify.Event{ev})
+		}
+	}
+	if d.Len() == 0 {
+		return nil
+	}
+	return d
+}
+
+// PostProcess builds the pipeline run over completed downloads, returning
+// nil when no step is enabled.
+func (c *Config) PostProcess() *postprocess.Pipeline {
+	p := &postprocess.Pipeline{}
+	// Verify first, so a damaged file is refetched before anything moves it.
+	if c.VerifyAudio {
+		p.Steps = append(p.Steps, &postprocess.Verify{})
+	}
+	if c.FlattenFolders {
+		p.Steps = append(p.Steps, &postprocess.Flatten{Link: c.FlattenLink})
+	}
+	if c.SanitizeFilenames {
+		p.Steps = append(p.Steps, &postprocess.Sanitize{Rules: c.SanitizeRules})
+	}
+	if c.TagAudio {
+		p.Steps = append(p.Steps, &postprocess.Tags{})
+	}
+	if c.TranscodeTo != "" {
+		t := postprocess.NewTranscode(c.FFmpegPath, c.TranscodeTo, c.TranscodeBitrate, c.TranscodeFrom, c.TranscodeConcurrency)
+		t.KeepOriginal = c.TranscodeKeep
+		p.Steps = append(p.Steps, t)
+	}
+	if c.Sidecar != "" {
+		p.Steps = append(p.Steps, &postprocess.Sidecar{Format: c.Sidecar})
+	}
+	if c.PUID >= 0 || c.PGID >= 0 || c.Umask != nil {
+		perms := &postprocess.Permissions{UID: c.PUID, GID: c.PGID}
+		if c.Umask != nil {
+			perms.FileMode = 0o666 &^ *c.Umask
+			perms.DirMode = 0o777 &^ *c.Umask
+		}
+		p.Steps = append(p.Steps, perms)
+	}
+	if len(c.CategoryScripts) > 0 {
+		p.Steps = append(p.Steps, &postprocess.Script{Scripts: c.CategoryScripts, Timeout: c.ScriptTimeout, Version: c.SABVersion})
+	}
+	if !p.Enabled() {
+		return nil
+	}
+	return p
+}
@@ -2,8 +2,13 @@ package main
 
 import (
 	"os"
+	"slices"
 	"testing"
 	"time"
+
+	"github.com/nerney/slskrr/newznab"
+	"github.com/nerney/slskrr/notify"
+	"github.com/nerney/slskrr/postprocess"
 )
 
 func TestLoadConfig_RequiredFields(t *testing.T) {
@@ -53,6 +58,18 @@ func TestLoadConfig_Defaults(t *testing.T) {
 	if cfg.APIKey != "" {
 		t.Errorf("expected empty API key, got %s", cfg.APIKey)
 	}
+	if cfg.SlskdWait != 2*time.Minute {
+		t.Errorf("expected default slskd wait 2m, got %v", cfg.SlskdWait)
+	}
+	if cfg.MaxURLLength != 8192 {
+		t.Errorf("expected default max URL length 8192, got %d", cfg.MaxURLLength)
+	}
+	if cfg.MaxBodySize != 10<<20 {
+		t.Errorf("expected default max body size 10MiB, got %d", cfg.MaxBodySize)
+	}
+	if cfg.MaxPeerFiles != 1000 || cfg.MaxFiles != 10000 {
+		t.Errorf("expected default file caps 1000/10000, got %d/%d", cfg.MaxPeerFiles, cfg.MaxFiles)
+	}
 }
 
 func TestLoadConfig_CustomValues(t *testing.T) {
@@ -62,6 +79,7 @@ func TestLoadConfig_CustomValues(t *testing.T) {
 	os.Setenv("API_KEY", "radarrkey")
 	os.Setenv("SEARCH_TIMEOUT", "1m")
 	os.Setenv("DOWNLOAD_DIR", "/data/downloads")
+	os.Setenv("DATA_DIR", "/config")
 	defer func() {
 		os.Unsetenv("SLSKD_URL")
 		os.Unsetenv("SLSKD_API_KEY")
@@ -69,6 +87,7 @@ func TestLoadConfig_CustomValues(t *testing.T) {
 		os.Unsetenv("API_KEY")
 		os.Unsetenv("SEARCH_TIMEOUT")
 		os.Unsetenv("DOWNLOAD_DIR")
+		os.Unsetenv("DATA_DIR")
 	}()
 
 	cfg, err := LoadConfig()
@@ -94,6 +113,9 @@ func TestLoadConfig_CustomValues(t *testing.T) {
 	if cfg.DownloadDir != "/data/downloads" {
 		t.Errorf("got %s", cfg.DownloadDir)
 	}
+	if cfg.DataDir != "/config" {
+		t.Errorf("got %s", cfg.DataDir)
+	}
 }
 
 func TestLoadConfig_InvalidTimeout(t *testing.T) {
@@ -111,3 +133,867 @@ func TestLoadConfig_InvalidTimeout(t *testing.T) {
 		t.Fatal("expected error for invalid SEARCH_TIMEOUT")
 	}
 }
+
+func TestLoadConfig_QueryCooldown(t *testing.T) {
+	t.Setenv("SLSKD_URL", "http://localhost:5030")
+	t.Setenv("SLSKD_API_KEY", "key")
+
+	t.Setenv("QUERY_COOLDOWN", "15m")
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.QueryCooldown != 15*time.Minute {
+		t.Errorf("expected 15m cooldown, got %v", cfg.QueryCooldown)
+	}
+
+	t.Setenv("QUERY_COOLDOWN", "-1m")
+	if _, err := LoadConfig(); err == nil {
+		t.Error("expected error for negative QUERY_COOLDOWN")
+	}
+}
+
+func TestLoadConfig_MaxSearches(t *testing.T) {
+	t.Setenv("SLSKD_URL", "http://localhost:5030")
+	t.Setenv("SLSKD_API_KEY", "key")
+
+	t.Setenv("MAX_SEARCHES", "")
+	t.Setenv("SEARCH_QUEUE_TIMEOUT", "")
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MaxSearches != 0 || cfg.SearchQueueTimeout != time.Minute {
+		t.Errorf("expected no limit and a 1m queue timeout, got %d and %v", cfg.MaxSearches, cfg.SearchQueueTimeout)
+	}
+
+	t.Setenv("MAX_SEARCHES", "4")
+	t.Setenv("SEARCH_QUEUE_TIMEOUT", "90s")
+	if cfg, err = LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MaxSearches != 4 || cfg.SearchQueueTimeout != 90*time.Second {
+		t.Errorf("unexpected limit %d or queue timeout %v", cfg.MaxSearches, cfg.SearchQueueTimeout)
+	}
+
+	t.Setenv("MAX_SEARCHES", "-1")
+	if _, err := LoadConfig(); err == nil {
+		t.Error("expected error for negative MAX_SEARCHES")
+	}
+}
+
+func TestLoadConfig_EarlyReturn(t *testing.T) {
+	t.Setenv("SLSKD_URL", "http://localhost:5030")
+	t.Setenv("SLSKD_API_KEY", "key")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.EarlyResults != 0 || cfg.EarlyScore != 500 {
+		t.Errorf("expected early return off with score 500, got %d/%d", cfg.EarlyResults, cfg.EarlyScore)
+	}
+
+	t.Setenv("EARLY_RETURN_RESULTS", "5")
+	t.Setenv("EARLY_RETURN_SCORE", "-50")
+	if cfg, err = LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.EarlyResults != 5 || cfg.EarlyScore != -50 {
+		t.Errorf("expected 5 results above -50, got %d/%d", cfg.EarlyResults, cfg.EarlyScore)
+	}
+
+	t.Setenv("EARLY_RETURN_RESULTS", "-1")
+	if _, err := LoadConfig(); err == nil {
+		t.Error("expected error for negative EARLY_RETURN_RESULTS")
+	}
+}
+
+func TestLoadConfig_APIKeys(t *testing.T) {
+	os.Setenv("SLSKD_URL", "http://localhost:5030")
+	os.Setenv("SLSKD_API_KEY", "key")
+	os.Setenv("API_KEY", "legacy")
+	os.Setenv("API_KEYS", "radarr:abc,sonarr:def")
+	defer func() {
+		os.Unsetenv("SLSKD_URL")
+		os.Unsetenv("SLSKD_API_KEY")
+		os.Unsetenv("API_KEY")
+		os.Unsetenv("API_KEYS")
+	}()
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.APIKeys) != 2 {
+		t.Fatalf("expected 2 keys, got %d", len(cfg.APIKeys))
+	}
+
+	keys := cfg.Keyring()
+	if keys.Len() != 3 {
+		t.Errorf("expected 3 accepted keys, got %d", keys.Len())
+	}
+	if label, ok := keys.Check("legacy"); !ok || label != "default" {
+		t.Errorf("expected API_KEY accepted as default, got %q %v", label, ok)
+	}
+	if label, ok := keys.Check("def"); !ok || label != "sonarr" {
+		t.Errorf("expected sonarr key accepted, got %q %v", label, ok)
+	}
+}
+
+func TestLoadConfig_APIKeysCollideWithAPIKey(t *testing.T) {
+	t.Setenv("SLSKD_URL", "http://localhost:5030")
+	t.Setenv("SLSKD_API_KEY", "key")
+	t.Setenv("API_KEY", "legacy")
+	for _, keys := range []string{"radarr:abc,default:def", "radarr:abc,sonarr:legacy", "abc,legacy"} {
+		t.Setenv("API_KEYS", keys)
+		if _, err := LoadConfig(); err == nil {
+			t.Errorf("%q: expected error for a key colliding with API_KEY", keys)
+		}
+	}
+
+	// Without API_KEY the label is free.
+	t.Setenv("API_KEY", "")
+	t.Setenv("API_KEYS", "default:def")
+	if _, err := LoadConfig(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestLoadConfig_AdminAuth(t *testing.T) {
+	os.Setenv("SLSKD_URL", "http://localhost:5030")
+	os.Setenv("SLSKD_API_KEY", "key")
+	os.Setenv("ADMIN_AUTH_HEADER", "Remote-User")
+	defer func() {
+		os.Unsetenv("SLSKD_URL")
+		os.Unsetenv("SLSKD_API_KEY")
+		os.Unsetenv("ADMIN_AUTH_HEADER")
+		os.Unsetenv("ADMIN_TRUSTED_PROXIES")
+		os.Unsetenv("ADMIN_USER")
+	}()
+
+	if _, err := LoadConfig(); err == nil {
+		t.Fatal("expected error when ADMIN_AUTH_HEADER is set without trusted proxies")
+	}
+
+	os.Setenv("ADMIN_TRUSTED_PROXIES", "172.16.0.0/12")
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.AdminAuth.Enabled() {
+		t.Error("expected admin auth to be enabled")
+	}
+
+	os.Setenv("ADMIN_USER", "admin")
+	if _, err := LoadConfig(); err == nil {
+		t.Fatal("expected error when ADMIN_USER is set without ADMIN_PASSWORD")
+	}
+}
+
+func TestNormalizeBasePath(t *testing.T) {
+	tests := map[string]string{
+		"":         "",
+		"/":        "",
+		"slskrr":   "/slskrr",
+		"/slskrr/": "/slskrr",
+		"/a/b":     "/a/b",
+	}
+	for in, want := range tests {
+		if got := normalizeBasePath(in); got != want {
+			t.Errorf("normalizeBasePath(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestLoadConfig_ExternalURL(t *testing.T) {
+	t.Setenv("SLSKD_URL", "http://localhost:5030")
+	t.Setenv("SLSKD_API_KEY", "testkey")
+
+	t.Setenv("EXTERNAL_URL", "http://slskrr:6969/")
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ExternalURL != "http://slskrr:6969" {
+		t.Errorf("expected trailing slash trimmed, got %s", cfg.ExternalURL)
+	}
+
+	for _, bad := range []string{"slskrr:6969", "ftp://slskrr", "http://", "http://slskrr/?a=b"} {
+		t.Setenv("EXTERNAL_URL", bad)
+		if _, err := LoadConfig(); err == nil {
+			t.Errorf("expected error for EXTERNAL_URL %q", bad)
+		}
+	}
+}
+
+func TestLoadConfig_InvalidRateLimit(t *testing.T) {
+	os.Setenv("SLSKD_URL", "http://localhost:5030")
+	os.Setenv("SLSKD_API_KEY", "key")
+	os.Setenv("RATE_LIMIT", "-5")
+	defer func() {
+		os.Unsetenv("SLSKD_URL")
+		os.Unsetenv("SLSKD_API_KEY")
+		os.Unsetenv("RATE_LIMIT")
+	}()
+
+	if _, err := LoadConfig(); err == nil {
+		t.Fatal("expected error for negative RATE_LIMIT")
+	}
+}
+
+func TestLoadConfig_CORSOrigins(t *testing.T) {
+	os.Setenv("SLSKD_URL", "http://localhost:5030")
+	os.Setenv("SLSKD_API_KEY", "key")
+	os.Setenv("CORS_ORIGINS", "https://dash.example.com/, http://organizr.lan")
+	defer func() {
+		os.Unsetenv("SLSKD_URL")
+		os.Unsetenv("SLSKD_API_KEY")
+		os.Unsetenv("CORS_ORIGINS")
+	}()
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.CORSOrigins) != 2 || cfg.CORSOrigins[0] != "https://dash.example.com" {
+		t.Errorf("unexpected origins: %v", cfg.CORSOrigins)
+	}
+}
+
+func TestLoadConfig_TrustedProxies(t *testing.T) {
+	os.Setenv("SLSKD_URL", "http://localhost:5030")
+	os.Setenv("SLSKD_API_KEY", "key")
+	os.Setenv("TRUSTED_PROXIES", "10.0.0.0/8, 192.168.1.5")
+	defer func() {
+		os.Unsetenv("SLSKD_URL")
+		os.Unsetenv("SLSKD_API_KEY")
+		os.Unsetenv("TRUSTED_PROXIES")
+	}()
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.TrustedProxies) != 2 || cfg.TrustedProxies[1].String() != "192.168.1.5/32" {
+		t.Errorf("unexpected proxies: %v", cfg.TrustedProxies)
+	}
+
+	os.Setenv("TRUSTED_PROXIES", "not-an-address")
+	if _, err := LoadConfig(); err == nil {
+		t.Error("expected error for an invalid TRUSTED_PROXIES")
+	}
+}
+
+func TestLoadConfig_TestResponses(t *testing.T) {
+	t.Setenv("SLSKD_URL", "http://localhost:5030")
+	t.Setenv("SLSKD_API_KEY", "key")
+	t.Setenv("TEST_RESPONSE", "empty")
+	t.Setenv("TEST_RESPONSES", "lidarr:recent")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.TestResponse != newznab.TestEmpty || cfg.TestResponses["lidarr"] != newznab.TestRecent {
+		t.Errorf("unexpected test responses: %q %v", cfg.TestResponse, cfg.TestResponses)
+	}
+
+	t.Setenv("TEST_RESPONSE", "sometimes")
+	if _, err := LoadConfig(); err == nil {
+		t.Error("expected an error for an unknown TEST_RESPONSE")
+	}
+}
+
+func TestLoadConfig_QueryCleanup(t *testing.T) {
+	t.Setenv("SLSKD_URL", "http://localhost:5030")
+	t.Setenv("SLSKD_API_KEY", "key")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(cfg.QueryCleanup, newznab.DefaultQueryRules) {
+		t.Errorf("expected default rules, got %v", cfg.QueryCleanup)
+	}
+
+	t.Setenv("QUERY_CLEANUP", "none")
+	if cfg, err = LoadConfig(); err != nil || cfg.QueryCleanup != nil {
+		t.Errorf("expected cleanup disabled, got %v %v", cfg.QueryCleanup, err)
+	}
+
+	t.Setenv("QUERY_CLEANUP", "aka,articles")
+	if _, err := LoadConfig(); err == nil {
+		t.Error("expected an error for an unknown rule")
+	}
+}
+
+func TestLoadConfig_SearchTimeoutMax(t *testing.T) {
+	t.Setenv("SLSKD_URL", "http://localhost:5030")
+	t.Setenv("SLSKD_API_KEY", "key")
+
+	cfg, err := LoadConfig()
+	if err != nil || cfg.MaxTimeout != 90*time.Second {
+		t.Fatalf("expected a 90s default, got %v %v", cfg.MaxTimeout, err)
+	}
+	t.Setenv("SEARCH_TIMEOUT_MAX", "0")
+	if cfg, err = LoadConfig(); err != nil || cfg.MaxTimeout != 0 {
+		t.Errorf("expected overrides disabled, got %v %v", cfg.MaxTimeout, err)
+	}
+	t.Setenv("SEARCH_TIMEOUT_MAX", "-1m")
+	if _, err := LoadConfig(); err == nil {
+		t.Error("expected an error for a negative duration")
+	}
+}
+
+func TestLoadConfig_SABIdentity(t *testing.T) {
+	os.Setenv("SLSKD_URL", "http://localhost:5030")
+	os.Setenv("SLSKD_API_KEY", "key")
+	defer func() {
+		os.Unsetenv("SLSKD_URL")
+		os.Unsetenv("SLSKD_API_KEY")
+		os.Unsetenv("SAB_VERSION")
+		os.Unsetenv("SAB_CATEGORIES")
+		os.Unsetenv("LIDARR_URL")
+		os.Unsetenv("LIDARR_API_KEY")
+		os.Unsetenv("LIDARR_CATEGORY")
+	}()
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.SABVersion != "4.0.0" || !slices.Contains(cfg.SABCategories, "readarr") {
+		t.Errorf("unexpected defaults: %q %v", cfg.SABVersion, cfg.SABCategories)
+	}
+
+	os.Setenv("SAB_VERSION", "4.3.2")
+	os.Setenv("SAB_CATEGORIES", "movies, tv, Default, movies")
+	os.Setenv("LIDARR_URL", "http://lidarr:8686")
+	os.Setenv("LIDARR_API_KEY", "lkey")
+	os.Setenv("LIDARR_CATEGORY", "music")
+	cfg, err = LoadConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.SABVersion != "4.3.2" || !slices.Equal(cfg.SABCategories, []string{"movies", "tv", "music"}) {
+		t.Errorf("unexpected identity: %q %v", cfg.SABVersion, cfg.SABCategories)
+	}
+}
+
+func TestLoadConfig_Companions(t *testing.T) {
+	os.Setenv("SLSKD_URL", "http://localhost:5030")
+	os.Setenv("SLSKD_API_KEY", "key")
+	defer func() {
+		os.Unsetenv("SLSKD_URL")
+		os.Unsetenv("SLSKD_API_KEY")
+		os.Unsetenv("GRAB_COMPANIONS")
+		os.Unsetenv("COMPANION_FILES")
+	}()
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Contains(cfg.Companions, "*.cue") {
+		t.Errorf("expected default companions, got %v", cfg.Companions)
+	}
+
+	os.Setenv("COMPANION_FILES", "*.cue, folder.jpg")
+	if cfg, err = LoadConfig(); err != nil || !slices.Equal(cfg.Companions, []string{"*.cue", "folder.jpg"}) {
+		t.Errorf("unexpected companions: %v %v", cfg.Companions, err)
+	}
+
+	os.Setenv("COMPANION_FILES", "[")
+	if _, err = LoadConfig(); err == nil {
+		t.Error("expected error for malformed pattern")
+	}
+
+	os.Setenv("GRAB_COMPANIONS", "false")
+	if cfg, err = LoadConfig(); err != nil || cfg.Companions != nil {
+		t.Errorf("expected companions disabled, got %v %v", cfg.Companions, err)
+	}
+}
+
+func TestLoadConfig_Webhooks(t *testing.T) {
+	os.Setenv("SLSKD_URL", "http://localhost:5030")
+	os.Setenv("SLSKD_API_KEY", "key")
+	os.Setenv("WEBHOOK_URLS", "http://hooks.lan/a, http://hooks.lan/b")
+	os.Setenv("WEBHOOK_EVENTS", "complete,failure")
+	defer func() {
+		os.Unsetenv("SLSKD_URL")
+		os.Unsetenv("SLSKD_API_KEY")
+		os.Unsetenv("WEBHOOK_URLS")
+		os.Unsetenv("WEBHOOK_EVENTS")
+	}()
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.WebhookURLs) != 2 || len(cfg.WebhookEvents) != 2 {
+		t.Errorf("unexpected webhook config: %v %v", cfg.WebhookURLs, cfg.WebhookEvents)
+	}
+	if n := cfg.Notifiers().Len(); n != 2 {
+		t.Errorf("expected 2 notifiers, got %d", n)
+	}
+
+	os.Setenv("WEBHOOK_EVENTS", "complete,bogus")
+	if _, err := LoadConfig(); err == nil {
+		t.Fatal("expected error for unknown webhook event")
+	}
+}
+
+func TestLoadConfig_Discord(t *testing.T) {
+	os.Setenv("SLSKD_URL", "http://localhost:5030")
+	os.Setenv("SLSKD_API_KEY", "key")
+	os.Setenv("DISCORD_WEBHOOK_URL", "https://discord.com/api/webhooks/1/abc")
+	os.Setenv("DISCORD_EVENTS", "failure")
+	defer func() {
+		os.Unsetenv("SLSKD_URL")
+		os.Unsetenv("SLSKD_API_KEY")
+		os.Unsetenv("DISCORD_WEBHOOK_URL")
+		os.Unsetenv("DISCORD_EVENTS")
+	}()
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.DiscordEvents) != 1 || cfg.DiscordEvents[0] != notify.EventFailure {
+		t.Errorf("unexpected discord events: %v", cfg.DiscordEvents)
+	}
+	if n := cfg.Notifiers().Len(); n != 1 {
+		t.Errorf("expected 1 notifier, got %d", n)
+	}
+}
+
+func TestLoadConfig_PhoneNotifiers(t *testing.T) {
+	os.Setenv("SLSKD_URL", "http://localhost:5030")
+	os.Setenv("SLSKD_API_KEY", "key")
+	os.Setenv("NTFY_URL", "https://ntfy.sh/slskrr")
+	os.Setenv("PUSHOVER_TOKEN", "app")
+	defer func() {
+		os.Unsetenv("SLSKD_URL")
+		os.Unsetenv("SLSKD_API_KEY")
+		os.Unsetenv("NTFY_URL")
+		os.Unsetenv("PUSHOVER_TOKEN")
+		os.Unsetenv("PUSHOVER_USER")
+	}()
+
+	if _, err := LoadConfig(); err == nil {
+		t.Fatal("expected error when PUSHOVER_TOKEN is set without PUSHOVER_USER")
+	}
+
+	os.Setenv("PUSHOVER_USER", "usr")
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.NtfyEvents) != 4 || cfg.NtfyEvents[0] != notify.EventComplete || cfg.NtfyEvents[2] != notify.EventUnreachable {
+		t.Errorf("expected ntfy to default to complete/failure and slskd outages, got %v", cfg.NtfyEvents)
+	}
+	if n := cfg.Notifiers().Len(); n != 2 {
+		t.Errorf("expected 2 notifiers, got %d", n)
+	}
+}
+
+func TestLoadConfig_Telegram(t *testing.T) {
+	os.Setenv("SLSKD_URL", "http://localhost:5030")
+	os.Setenv("SLSKD_API_KEY", "key")
+	os.Setenv("TELEGRAM_BOT_TOKEN", "123:abc")
+	os.Setenv("TELEGRAM_BUTTONS", "yes")
+	defer func() {
+		os.Unsetenv("SLSKD_URL")
+		os.Unsetenv("SLSKD_API_KEY")
+		os.Unsetenv("TELEGRAM_BOT_TOKEN")
+		os.Unsetenv("TELEGRAM_CHAT_ID")
+		os.Unsetenv("TELEGRAM_BUTTONS")
+	}()
+
+	if _, err := LoadConfig(); err == nil {
+		t.Fatal("expected error when TELEGRAM_BOT_TOKEN is set without TELEGRAM_CHAT_ID")
+	}
+
+	os.Setenv("TELEGRAM_CHAT_ID", "42")
+	if _, err := LoadConfig(); err == nil {
+		t.Fatal("expected error for invalid TELEGRAM_BUTTONS")
+	}
+
+	os.Setenv("TELEGRAM_BUTTONS", "true")
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.TelegramButtons || cfg.Notifiers().Len() != 1 {
+		t.Errorf("unexpected telegram config: buttons=%v notifiers=%d", cfg.TelegramButtons, cfg.Notifiers().Len())
+	}
+}
+
+func TestLoadConfig_NotifyTemplates(t *testing.T) {
+	os.Setenv("SLSKD_URL", "http://localhost:5030")
+	os.Setenv("SLSKD_API_KEY", "key")
+	os.Setenv("NOTIFY_TEMPLATE", "{{.Title}} ({{.Nope}})")
+	defer func() {
+		os.Unsetenv("SLSKD_URL")
+		os.Unsetenv("SLSKD_API_KEY")
+		os.Unsetenv("NOTIFY_TEMPLATE")
+	}()
+
+	if _, err := LoadConfig(); err == nil {
+		t.Fatal("expected error for template referencing an unknown field")
+	}
+
+	os.Setenv("NOTIFY_TEMPLATE", "{{.Title}} ({{.Category}})")
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.NotifyTemplates == nil {
+		t.Error("expected templates to be parsed")
+	}
+}
+
+func TestLoadConfig_Hooks(t *testing.T) {
+	os.Setenv("SLSKD_URL", "http://localhost:5030")
+	os.Setenv("SLSKD_API_KEY", "key")
+	os.Setenv("ON_DOWNLOAD_FAILED", `/scripts/failed.sh "{{.Nope}}"`)
+	defer func() {
+		os.Unsetenv("SLSKD_URL")
+		os.Unsetenv("SLSKD_API_KEY")
+		os.Unsetenv("ON_DOWNLOAD_FAILED")
+		os.Unsetenv("ON_GRAB")
+	}()
+
+	if _, err := LoadConfig(); err == nil {
+		t.Fatal("expected error for a hook template with an unknown field")
+	}
+
+	os.Setenv("ON_DOWNLOAD_FAILED", `/scripts/failed.sh "{{.Title}}" {{.Error}}`)
+	os.Setenv("ON_GRAB", "/scripts/grab.sh")
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	hook := cfg.Hooks[notify.EventFailure]
+	if hook == nil || hook.Command != "/scripts/failed.sh" || len(hook.Args) != 2 || hook.Timeout != time.Minute {
+		t.Errorf("unexpected failure hook: %+v", hook)
+	}
+	if d := cfg.Notifiers(); d.Len() != 2 {
+		t.Errorf("expected a notifier per hook, got %d", d.Len())
+	}
+}
+
+func TestLoadConfig_Lidarr(t *testing.T) {
+	os.Setenv("SLSKD_URL", "http://localhost:5030")
+	os.Setenv("SLSKD_API_KEY", "key")
+	os.Setenv("LIDARR_URL", "http://lidarr:8686")
+	os.Setenv("LIDARR_FORMATS", ".FLAC, mp3")
+	defer func() {
+		os.Unsetenv("SLSKD_URL")
+		os.Unsetenv("SLSKD_API_KEY")
+		os.Unsetenv("LIDARR_URL")
+		os.Unsetenv("LIDARR_API_KEY")
+		os.Unsetenv("LIDARR_FORMATS")
+		os.Unsetenv("LIDARR_INTERVAL")
+	}()
+
+	if _, err := LoadConfig(); err == nil {
+		t.Fatal("expected error when LIDARR_URL is set without LIDARR_API_KEY")
+	}
+
+	os.Setenv("LIDARR_API_KEY", "lkey")
+	os.Setenv("LIDARR_INTERVAL", "0s")
+	if _, err := LoadConfig(); err == nil {
+		t.Fatal("expected error for zero LIDARR_INTERVAL")
+	}
+
+	os.Unsetenv("LIDARR_INTERVAL")
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.LidarrInterval != 15*time.Minute || cfg.LidarrCategory != "lidarr" || cfg.LidarrMaxSearches != 5 {
+		t.Errorf("unexpected lidarr defaults: %+v", cfg)
+	}
+	if len(cfg.LidarrFormats) != 2 || cfg.LidarrFormats[0] != "flac" {
+		t.Errorf("unexpected formats: %v", cfg.LidarrFormats)
+	}
+}
+
+func TestLoadConfig_Blocklist(t *testing.T) {
+	os.Setenv("SLSKD_URL", "http://localhost:5030")
+	os.Setenv("SLSKD_API_KEY", "key")
+	os.Setenv("BLOCKLIST_STRIKES", "0")
+	defer func() {
+		os.Unsetenv("SLSKD_URL")
+		os.Unsetenv("SLSKD_API_KEY")
+		os.Unsetenv("BLOCKLIST_STRIKES")
+	}()
+
+	if _, err := LoadConfig(); err == nil {
+		t.Fatal("expected error for BLOCKLIST_STRIKES=0")
+	}
+
+	os.Unsetenv("BLOCKLIST_STRIKES")
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.BlocklistStrikes != 2 || cfg.BlocklistCooldown != 7*24*time.Hour || !cfg.SlskdBlacklist {
+		t.Errorf("unexpected blocklist defaults: %d %v %v", cfg.BlocklistStrikes, cfg.BlocklistCooldown, cfg.SlskdBlacklist)
+	}
+
+	t.Setenv("SLSKD_BLACKLIST", "false")
+	if cfg, err = LoadConfig(); err != nil || cfg.SlskdBlacklist {
+		t.Errorf("expected SLSKD_BLACKLIST=false to turn it off, got %v %v", cfg, err)
+	}
+}
+
+func TestLoadConfig_Sanitize(t *testing.T) {
+	os.Setenv("SLSKD_URL", "http://localhost:5030")
+	os.Setenv("SLSKD_API_KEY", "key")
+	os.Setenv("SANITIZE_RULES", "ab")
+	defer func() {
+		os.Unsetenv("SLSKD_URL")
+		os.Unsetenv("SLSKD_API_KEY")
+		os.Unsetenv("SANITIZE_RULES")
+		os.Unsetenv("SANITIZE_FILENAMES")
+	}()
+
+	if _, err := LoadConfig(); err == nil {
+		t.Fatal("expected error for invalid SANITIZE_RULES")
+	}
+
+	os.Setenv("SANITIZE_RULES", ":=-")
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.PostProcess() != nil {
+		t.Error("expected no pipeline by default")
+	}
+
+	os.Setenv("SANITIZE_FILENAMES", "true")
+	os.Setenv("FLATTEN_FOLDERS", "true")
+	defer os.Unsetenv("FLATTEN_FOLDERS")
+	if cfg, err = LoadConfig(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p := cfg.PostProcess(); len(p.Steps) != 2 || p.Steps[0].Name() != "flatten" || cfg.SanitizeRules[':'] != "-" {
+		t.Errorf("expected flatten then sanitize with custom rules, got %+v", cfg.SanitizeRules)
+	}
+
+	t.Setenv("FLATTEN_MODE", "hardlink")
+	if cfg, err = LoadConfig(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fl, ok := cfg.PostProcess().Steps[0].(*postprocess.Flatten); !ok || !fl.Link {
+		t.Errorf("expected flatten to hard-link, got %+v", cfg.PostProcess().Steps[0])
+	}
+	t.Setenv("FLATTEN_MODE", "copy")
+	if _, err := LoadConfig(); err == nil {
+		t.Error("expected error for invalid FLATTEN_MODE")
+	}
+
+	t.Setenv("FLATTEN_MODE", "")
+	t.Setenv("VERIFY_AUDIO", "true")
+	if cfg, err = LoadConfig(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p := cfg.PostProcess(); len(p.Steps) != 3 || p.Steps[0].Name() != "verify" {
+		t.Errorf("expected verify before anything else, got %+v", p.Steps)
+	}
+}
+
+func TestLoadConfig_Transcode(t *testing.T) {
+	os.Setenv("SLSKD_URL", "http://localhost:5030")
+	os.Setenv("SLSKD_API_KEY", "key")
+	os.Setenv("TRANSCODE_TO", "wma")
+	defer func() {
+		os.Unsetenv("SLSKD_URL")
+		os.Unsetenv("SLSKD_API_KEY")
+		os.Unsetenv("TRANSCODE_TO")
+		os.Unsetenv("TRANSCODE_FROM")
+	}()
+
+	if _, err := LoadConfig(); err == nil {
+		t.Fatal("expected error for unsupported TRANSCODE_TO")
+	}
+
+	os.Setenv("TRANSCODE_TO", "opus")
+	os.Setenv("TRANSCODE_FROM", ".FLAC, wav")
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	step, ok := cfg.PostProcess().Steps[0].(*postprocess.Transcode)
+	if !ok || step.Target != "opus" || len(step.From) != 2 || step.From[0] != "flac" || step.FFmpeg != "ffmpeg" {
+		t.Errorf("unexpected transcode step: %+v", cfg.PostProcess().Steps)
+	}
+}
+
+func TestLoadConfig_CategoryScripts(t *testing.T) {
+	os.Setenv("SLSKD_URL", "http://localhost:5030")
+	os.Setenv("SLSKD_API_KEY", "key")
+	os.Setenv("CATEGORY_SCRIPTS", "radarr")
+	defer func() {
+		os.Unsetenv("SLSKD_URL")
+		os.Unsetenv("SLSKD_API_KEY")
+		os.Unsetenv("CATEGORY_SCRIPTS")
+	}()
+
+	if _, err := LoadConfig(); err == nil {
+		t.Fatal("expected error for an entry without a script")
+	}
+
+	os.Setenv("CATEGORY_SCRIPTS", "radarr:/scripts/movie.sh")
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s, ok := cfg.PostProcess().Steps[0].(*postprocess.Script)
+	if !ok || s.Scripts["radarr"] != "/scripts/movie.sh" || s.Timeout != 10*time.Minute {
+		t.Errorf("unexpected script step: %+v", cfg.PostProcess().Steps)
+	}
+}
+
+func TestLoadConfig_Sidecar(t *testing.T) {
+	os.Setenv("SLSKD_URL", "http://localhost:5030")
+	os.Setenv("SLSKD_API_KEY", "key")
+	os.Setenv("SIDECAR", "xml")
+	defer func() {
+		os.Unsetenv("SLSKD_URL")
+		os.Unsetenv("SLSKD_API_KEY")
+		os.Unsetenv("SIDECAR")
+	}()
+
+	if _, err := LoadConfig(); err == nil {
+		t.Fatal("expected error for unknown SIDECAR format")
+	}
+
+	os.Setenv("SIDECAR", "JSON")
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	p := cfg.PostProcess()
+	if s, ok := p.Steps[0].(*postprocess.Sidecar); !ok || s.Format != postprocess.SidecarJSON {
+		t.Errorf("expected a JSON sidecar step, got %+v", p.Steps)
+	}
+}
+
+func TestLoadConfig_Permissions(t *testing.T) {
+	os.Setenv("SLSKD_URL", "http://localhost:5030")
+	os.Setenv("SLSKD_API_KEY", "key")
+	os.Setenv("UMASK", "999")
+	defer func() {
+		os.Unsetenv("SLSKD_URL")
+		os.Unsetenv("SLSKD_API_KEY")
+		os.Unsetenv("UMASK")
+		os.Unsetenv("PUID")
+	}()
+
+	if _, err := LoadConfig(); err == nil {
+		t.Fatal("expected error for non-octal UMASK")
+	}
+
+	os.Setenv("UMASK", "002")
+	os.Setenv("PUID", "1000")
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	p := cfg.PostProcess()
+	if !p.Enabled() {
+		t.Fatal("expected a permissions step")
+	}
+	perms, ok := p.Steps[0].(*postprocess.Permissions)
+	if !ok || perms.UID != 1000 || perms.GID != -1 || perms.FileMode != 0o664 || perms.DirMode != 0o775 {
+		t.Errorf("unexpected permissions step: %+v", p.Steps[0])
+	}
+}
+
+func TestLoadConfig_Backups(t *testing.T) {
+	t.Setenv("SLSKD_URL", "http://localhost:5030")
+	t.Setenv("SLSKD_API_KEY", "key")
+	t.Setenv("BACKUP_DIR", "/backups")
+
+	if _, err := LoadConfig(); err == nil {
+		t.Error("expected an error for BACKUP_DIR without DATA_DIR")
+	}
+
+	t.Setenv("DATA_DIR", "/data")
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.BackupInterval != 24*time.Hour || cfg.BackupKeep != 7 {
+		t.Errorf("unexpected defaults: %v %d", cfg.BackupInterval, cfg.BackupKeep)
+	}
+
+	t.Setenv("BACKUP_KEEP", "-1")
+	if _, err := LoadConfig(); err == nil {
+		t.Error("expected an error for a negative BACKUP_KEEP")
+	}
+}
+
+func TestLoadConfig_MaxDownloadAge(t *testing.T) {
+	t.Setenv("SLSKD_URL", "http://localhost:5030")
+	t.Setenv("SLSKD_API_KEY", "key")
+	t.Setenv("MAX_DOWNLOAD_AGE", "24h")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.MaxDownloadAge != 24*time.Hour {
+		t.Errorf("expected 24h, got %v", cfg.MaxDownloadAge)
+	}
+
+	t.Setenv("MAX_DOWNLOAD_AGE", "-1h")
+	if _, err := LoadConfig(); err == nil {
+		t.Error("expected an error for a negative age")
+	}
+}
+
+func TestLoadConfig_SyncInterval(t *testing.T) {
+	t.Setenv("SLSKD_URL", "http://localhost:5030")
+	t.Setenv("SLSKD_API_KEY", "key")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.SyncInterval != 5*time.Second {
+		t.Errorf("expected 5s default, got %v", cfg.SyncInterval)
+	}
+
+	t.Setenv("SYNC_INTERVAL", "2s")
+	if cfg, err = LoadConfig(); err != nil || cfg.SyncInterval != 2*time.Second {
+		t.Errorf("expected 2s, got %v (%v)", cfg.SyncInterval, err)
+	}
+
+	t.Setenv("SYNC_INTERVAL", "1m")
+	if _, err := LoadConfig(); err == nil {
+		t.Error("expected an error for an interval health checks would flag")
+	}
+}
+
+func TestLoadConfig_MusicBrainz(t *testing.T) {
+	t.Setenv("SLSKD_URL", "http://localhost:5030")
+	t.Setenv("SLSKD_API_KEY", "key")
+
+	cfg, err := LoadConfig()
+	if err != nil || cfg.MusicBrainzURL != "" {
+		t.Fatalf("expected MusicBrainz off by default, got %q (%v)", cfg.MusicBrainzURL, err)
+	}
+	t.Setenv("MUSICBRAINZ", "true")
+	if cfg, err = LoadConfig(); err != nil || cfg.MusicBrainzURL != "https://musicbrainz.org/ws/2" {
+		t.Errorf("expected the public service, got %q (%v)", cfg.MusicBrainzURL, err)
+	}
+	t.Setenv("MUSICBRAINZ_URL", "http://mb.local/ws/2")
+	if cfg, err = LoadConfig(); err != nil || cfg.MusicBrainzURL != "http://mb.local/ws/2" {
+		t.Errorf("expected the mirror, got %q (%v)", cfg.MusicBrainzURL, err)
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// configFile is the file named by CONFIG_FILE, which getenv falls back on
+// for variables the environment leaves unset. It is nil without one.
+var configFile *fileConfig
+
+// fileConfig is a parsed config file: a flat YAML mapping whose keys are the
+// environment variable names, in any case and with - or _ between words.
+type fileConfig struct {
+	path   string
+	values map[string]fileValue // by variable name
+	read   map[string]bool      // variables looked up since the file was opened
+}
+
+type fileValue struct {
+	key   string // as written in the file
+	value string
+	line  int
+}
+
+// getenv returns the environment variable name, or the config file's value
+// for it when the environment doesn't set it.
+func getenv(name string) string {
+	if configFile == nil {
+		return os.Getenv(name)
+	}
+	configFile.read[name] = true
+	if v, ok := os.LookupEnv(name); ok {
+		return v
+	}
+	return configFile.values[name].value
+}
+
+// openConfigFile reads CONFIG_FILE, if set, for getenv to fall back on.
+func openConfigFile() error {
+	configFile = nil
+	p := os.Getenv("CONFIG_FILE")
+	if p == "" {
+		return nil
+	}
+	f, err := readConfigFile(p)
+	if err != nil {
+		return err
+	}
+	configFile = f
+	return nil
+}
+
+// readConfigFile parses the YAML subset config files are written in: one
+// key: value per line, values plain or quoted, and lists either inline as
+// [a, b] or as indented "- item" lines, joined with commas as the
+// environment variables take them. Comments start with #.
+func readConfigFile(p string) (*fileConfig, error) {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, fmt.Errorf("config file: %w", err)
+	}
+	f := &fileConfig{path: p, values: make(map[string]fileValue), read: make(map[string]bool)}
+
+	var list *fileValue // key whose block list is being read
+	var items []string
+	endList := func() {
+		if list != nil {
+			list.value = strings.Join(items, ",")
+			f.values[configName(list.key)] = *list
+			list, items = nil, nil
+		}
+	}
+
+	sc := bufio.NewScanner(strings.NewReader(string(data)))
+	for n := 1; sc.Scan(); n++ {
+		raw := strings.TrimRight(sc.Text(), " \t\r")
+		line := strings.TrimSpace(raw)
+		if line == "" || strings.HasPrefix(line, "#") || line == "---" {
+			continue
+		}
+		if raw[0] == ' ' || raw[0] == '\t' {
+			item, ok := strings.CutPrefix(line, "-")
+			if list == nil || !ok {
+				return nil, fmt.Errorf("%s:%d: unexpected indentation; only top-level keys and lists are supported", p, n)
+			}
+			v, err := configScalar(strings.TrimSpace(item))
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %s: %w", p, n, list.key, err)
+			}
+			items = append(items, v)
+			continue
+		}
+		endList()
+
+		key, rest, ok := strings.Cut(line, ":")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" || strings.ContainsAny(key, " \t\"'") {
+			return nil, fmt.Errorf("%s:%d: expected key: value", p, n)
+		}
+		name := configName(key)
+		if prev, dup := f.values[name]; dup {
+			return nil, fmt.Errorf("%s:%d: %s is already set on line %d", p, n, key, prev.line)
+		}
+		fv := fileValue{key: key, line: n}
+		rest = strings.TrimSpace(rest)
+		switch {
+		case rest == "" || strings.HasPrefix(rest, "#"):
+			list = &fv
+			continue
+		case strings.HasPrefix(rest, "|") || strings.HasPrefix(rest, ">"):
+			return nil, fmt.Errorf("%s:%d: %s: block scalars are not supported; quote the value instead", p, n, key)
+		case strings.HasPrefix(rest, "["):
+			inner, ok := strings.CutSuffix(stripComment(rest), "]")
+			if !ok {
+				return nil, fmt.Errorf("%s:%d: %s: unterminated list", p, n, key)
+			}
+			var vals []string
+			for _, item := range strings.Split(inner[1:], ",") {
+				if item = strings.TrimSpace(item); item == "" {
+					continue
+				}
+				v, err := configScalar(item)
+				if err != nil {
+					return nil, fmt.Errorf("%s:%d: %s: %w", p, n, key, err)
+				}
+				vals = append(vals, v)
+			}
+			fv.value = strings.Join(vals, ",")
+		default:
+			if fv.value, err = configScalar(rest); err != nil {
+				return nil, fmt.Errorf("%s:%d: %s: %w", p, n, key, err)
+			}
+		}
+		f.values[name] = fv
+	}
+	endList()
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("config file: %w", err)
+	}
+	return f, nil
+}
+
+// configName maps a config file key to the environment variable it sets.
+func configName(key string) string {
+	return strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+}
+
+// configScalar returns the value of a plain, single- or double-quoted
+// scalar, dropping any trailing comment.
+func configScalar(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		end := 1
+		for end < len(s) && s[end] != '"' {
+			if s[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(s) {
+			return "", fmt.Errorf("unterminated string")
+		}
+		if rest := strings.TrimSpace(s[end+1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+			return "", fmt.Errorf("unexpected %q after string", rest)
+		}
+		v, err := strconv.Unquote(s[:end+1])
+		if err != nil {
+			return "", fmt.Errorf("invalid string: %w", err)
+		}
+		return v, nil
+	case strings.HasPrefix(s, "'"):
+		var b strings.Builder
+		for i := 1; i < len(s); i++ {
+			if s[i] != '\'' {
+				b.WriteByte(s[i])
+				continue
+			}
+			if i+1 < len(s) && s[i+1] == '\'' {
+				b.WriteByte('\'')
+				i++
+				continue
+			}
+			if rest := strings.TrimSpace(s[i+1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+				return "", fmt.Errorf("unexpected %q after string", rest)
+			}
+			return b.String(), nil
+		}
+		return "", fmt.Errorf("unterminated string")
+	}
+	v := stripComment(s)
+	if v == "~" || v == "null" {
+		return "", nil
+	}
+	return v, nil
+}
+
+// stripComment drops a trailing " # comment" from a plain value.
+func stripComment(s string) string {
+	if i := strings.Index(s, " #"); i >= 0 {
+		s = s[:i]
+	}
+	if i := strings.Index(s, "\t#"); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s)
+}
+
+var envNamePattern = regexp.MustCompile(`[A-Z][A-Z0-9_]*`)
+
+// annotate points err at the config file line that set the variable it
+// names, when the environment didn't override it.
+func (f *fileConfig) annotate(err error) error {
+	if f == nil {
+		return err
+	}
+	for _, name := range envNamePattern.FindAllString(err.Error(), -1) {
+		if _, set := os.LookupEnv(name); set {
+			continue
+		}
+		if v, ok := f.values[name]; ok {
+			return fmt.Errorf("%s:%d: %s: %w", f.path, v.line, v.key, err)
+		}
+	}
+	return err
+}
+
+// unknown reports the first key in the file that no setting looked up,
+// which is most likely a typo.
+func (f *fileConfig) unknown() error {
+	if f == nil {
+		return nil
+	}
+	var unread []fileValue
+	for name, v := range f.values {
+		if !f.read[name] && name != "CONFIG_FILE" {
+			unread = append(unread, v)
+		}
+	}
+	if len(unread) == 0 {
+		return nil
+	}
+	v := slices.MinFunc(unread, func(a, b fileValue) int { return cmp.Compare(a.line, b.line) })
+	return fmt.Errorf("%s:%d: unknown key %s", f.path, v.line, v.key)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "slskrr.yml")
+	if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", p)
+	return p
+}
+
+func TestLoadConfig_File(t *testing.T) {
+	writeConfigFile(t, `# slskrr
+slskd_url: http://slskd:5030
+SLSKD_API_KEY: "from-file"   # quoted
+search-timeout: 45s
+listen_addr: ':7000'
+sab_categories: [radarr, "sonarr"]
+cors_origins:
+  - http://a.example
+  - 'http://b.example'
+`)
+	os.Unsetenv("SLSKD_URL")
+	os.Unsetenv("SEARCH_TIMEOUT")
+	os.Unsetenv("LISTEN_ADDR")
+	os.Unsetenv("SAB_CATEGORIES")
+	os.Unsetenv("CORS_ORIGINS")
+	t.Setenv("SLSKD_API_KEY", "from-env")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.SlskdURL != "http://slskd:5030" {
+		t.Errorf("expected SLSKD_URL from the file, got %s", cfg.SlskdURL)
+	}
+	if cfg.SlskdAPIKey != "from-env" {
+		t.Errorf("expected the environment to override the file, got %s", cfg.SlskdAPIKey)
+	}
+	if cfg.SearchTimeout != 45*time.Second || cfg.ListenAddr != ":7000" {
+		t.Errorf("unexpected timeout %v or listen address %s", cfg.SearchTimeout, cfg.ListenAddr)
+	}
+	if !slices.Equal(cfg.SABCategories, []string{"radarr", "sonarr"}) {
+		t.Errorf("unexpected categories %v", cfg.SABCategories)
+	}
+	if !slices.Equal(cfg.CORSOrigins, []string{"http://a.example", "http://b.example"}) {
+		t.Errorf("unexpected origins %v", cfg.CORSOrigins)
+	}
+}
+
+func TestLoadConfig_FileGatedKeys(t *testing.T) {
+	// Settings of a feature that's off are still known keys.
+	writeConfigFile(t, `musicbrainz_url: http://mb.lan:5000
+grab_companions: false
+companion_files: [cover.jpg]
+`)
+	t.Setenv("SLSKD_URL", "http://localhost:5030")
+	t.Setenv("SLSKD_API_KEY", "testkey")
+	os.Unsetenv("MUSICBRAINZ")
+	os.Unsetenv("MUSICBRAINZ_URL")
+	os.Unsetenv("GRAB_COMPANIONS")
+	os.Unsetenv("COMPANION_FILES")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MusicBrainzURL != "" || cfg.Companions != nil {
+		t.Errorf("expected the features left off, got %q and %v", cfg.MusicBrainzURL, cfg.Companions)
+	}
+}
+
+func TestLoadConfig_FileErrors(t *testing.T) {
+	t.Setenv("SLSKD_URL", "http://localhost:5030")
+	t.Setenv("SLSKD_API_KEY", "testkey")
+	os.Unsetenv("SEARCH_TIMEOUT")
+
+	for _, tc := range []struct {
+		content string
+		want    string
+	}{
+		{"listen_addr: :7000\nsearch_timeout: soon\n", "slskrr.yml:2: search_timeout: invalid SEARCH_TIMEOUT"},
+		{"listen_addr: :7000\nserch_timeout: 30s\n", "slskrr.yml:2: unknown key serch_timeout"},
+		{"listen_addr: :7000\nlisten_addr: :8000\n", "slskrr.yml:2: listen_addr is already set on line 1"},
+		{"notify:\n  discord: x\n", "slskrr.yml:2: unexpected indentation"},
+		{"api_key: \"open\n", "slskrr.yml:1: api_key: unterminated string"},
+		{"just a line\n", "slskrr.yml:1: expected key: value"},
+	} {
+		writeConfigFile(t, tc.content)
+		_, err := LoadConfig()
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%q: expected error containing %q, got %v", tc.content, tc.want, err)
+		}
+	}
+
+	// A bad value overridden by the environment is not the file's fault.
+	writeConfigFile(t, "search_timeout: soon\n")
+	t.Setenv("SEARCH_TIMEOUT", "never")
+	if _, err := LoadConfig(); err == nil || strings.Contains(err.Error(), "slskrr.yml") {
+		t.Errorf("expected an error naming only the variable, got %v", err)
+	}
+
+	t.Setenv("CONFIG_FILE", filepath.Join(t.TempDir(), "missing.yml"))
+	if _, err := LoadConfig(); err == nil {
+		t.Error("expected an error for a missing config file")
+	}
+}
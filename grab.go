@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// runGrab queues a Soulseek file or folder through the running server's
+// admin API and returns the process exit code:
+//
+//	slskrr grab [-category name] <username> <remote path>
+//
+// It authenticates with ADMIN_USER/ADMIN_PASSWORD when set, else API_KEY.
+func runGrab(args []string) int {
+	fs := flag.NewFlagSet("grab", flag.ContinueOnError)
+	category := fs.String("category", "", "category the download is listed under")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: slskrr grab [-category name] <username> <remote path>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+
+	if err := openConfigFile(); err != nil {
+		fmt.Fprintf(os.Stderr, "grab failed: %v\n", err)
+		return 1
+	}
+
+	body, _ := json.Marshal(map[string]string{
+		"username": fs.Arg(0),
+		"path":     fs.Arg(1),
+		"category": *category,
+	})
+	url := localURL(getenv("LISTEN_ADDR"), normalizeBasePath(getenv("BASE_PATH"))) + "/admin/api/grab"
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grab failed: %v\n", err)
+		return 1
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if user := getenv("ADMIN_USER"); user != "" {
+		req.SetBasicAuth(user, getenv("ADMIN_PASSWORD"))
+	} else {
+		req.Header.Set("X-Api-Key", getenv("API_KEY"))
+	}
+
+	// Browsing a peer can take a while.
+	client := &http.Client{Timeout: 2 * time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "grab failed: %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		IDs   []string `json:"nzo_ids"`
+		Error string   `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&result)
+	if resp.StatusCode != http.StatusCreated {
+		fmt.Fprintf(os.Stderr, "grab failed: %d %s\n", resp.StatusCode, result.Error)
+		return 1
+	}
+	for _, id := range result.IDs {
+		fmt.Println(id)
+	}
+	return 0
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRunGrab(t *testing.T) {
+	var got map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/slskrr/admin/api/grab" || r.Header.Get("X-Api-Key") != "key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"status":true,"nzo_ids":["SABnzbd_nzo_1"]}`))
+	}))
+	defer srv.Close()
+
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	t.Setenv("LISTEN_ADDR", ":"+port)
+	t.Setenv("BASE_PATH", "/slskrr")
+	t.Setenv("ADMIN_USER", "")
+	t.Setenv("API_KEY", "key")
+
+	if code := runGrab([]string{"-category", "music", "peer", `Music\Album`}); code != 0 {
+		t.Fatalf("expected exit 0, got %d", code)
+	}
+	if got["username"] != "peer" || got["path"] != `Music\Album` || got["category"] != "music" {
+		t.Errorf("unexpected request: %v", got)
+	}
+
+	if code := runGrab([]string{"peer"}); code != 2 {
+		t.Errorf("expected exit 2 for a missing path, got %d", code)
+	}
+	t.Setenv("API_KEY", "wrong")
+	if code := runGrab([]string{"peer", `Music\Album`}); code != 1 {
+		t.Errorf("expected exit 1 when the server refuses, got %d", code)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd)
+
+package health
+
+import "errors"
+
+// freeSpace is unsupported here; the disk check reports it.
+func freeSpace(string) (uint64, error) {
+	return 0, errors.New("free space not supported on this platform")
+}
@@ -0,0 +1,150 @@
+// Package health implements the liveness and readiness probes, reporting
+// the state of each dependency slskrr needs to serve requests.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Check is a named dependency probe. It returns nil when the dependency is
+// usable.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+
+	// Degrades marks a dependency slskrr keeps serving without, like free
+	// disk space: its failure makes the report "degraded" rather than
+	// "fail", and readiness holds.
+	Degrades bool
+}
+
+// Result is the outcome of a single check.
+type Result struct {
+	Status  string `json:"status"` // "ok" or "fail"
+	Error   string `json:"error,omitempty"`
+	Latency string `json:"latency"`
+}
+
+// Report is the JSON body returned by the health endpoints.
+type Report struct {
+	Status string            `json:"status"`           // "ok", "degraded" or "fail"
+	Reason string            `json:"reason,omitempty"` // the first failure making Status "fail"
+	Checks map[string]Result `json:"checks"`
+}
+
+// Handler serves a probe. All checks run concurrently under Timeout; the
+// response is 503 if any check that doesn't merely degrade fails, unless
+// Live is set.
+type Handler struct {
+	Checks  []Check
+	Timeout time.Duration
+
+	// Live answers 200 whatever the checks find, for liveness probes: the
+	// process is serving, and the body tells monitoring what is failing.
+	Live bool
+}
+
+// Run executes every check and aggregates the results. Checks still running
+// when the timeout expires are reported as failed, so a wedged dependency
+// can't hang the probe.
+func (h *Handler) Run(ctx context.Context) Report {
+	timeout := h.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	type named struct {
+		name string
+		res  Result
+	}
+	results := make(chan named, len(h.Checks))
+	start := time.Now()
+	for _, c := range h.Checks {
+		go func(c Check) {
+			err := c.Run(ctx)
+			res := Result{Status: "ok", Latency: time.Since(start).Round(time.Millisecond).String()}
+			if err != nil {
+				res.Status = "fail"
+				res.Error = err.Error()
+			}
+			results <- named{c.Name, res}
+		}(c)
+	}
+
+	report := Report{Status: "ok", Checks: make(map[string]Result, len(h.Checks))}
+	for range h.Checks {
+		select {
+		case n := <-results:
+			report.Checks[n.name] = n.res
+		case <-ctx.Done():
+		}
+	}
+	for _, c := range h.Checks {
+		if _, ok := report.Checks[c.Name]; !ok {
+			report.Checks[c.Name] = Result{Status: "fail", Error: "timed out", Latency: timeout.String()}
+		}
+	}
+	for _, c := range h.Checks {
+		switch {
+		case report.Checks[c.Name].Status == "ok":
+		case c.Degrades:
+			if report.Status == "ok" {
+				report.Status = "degraded"
+			}
+		default:
+			if report.Status != "fail" {
+				report.Reason = c.Name + ": " + report.Checks[c.Name].Error
+			}
+			report.Status = "fail"
+		}
+	}
+	return report
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	report := h.Run(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Status == "fail" && !h.Live {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		slog.Error("failed to write health response", "error", err)
+	}
+}
+
+// Recent returns a check that fails when last is more than maxAge ago,
+// e.g. for a background loop that has stalled.
+func Recent(last func() time.Time, maxAge time.Duration) func(context.Context) error {
+	return func(context.Context) error {
+		if lag := time.Since(last()); lag > maxAge {
+			return fmt.Errorf("last ran %s ago", lag.Round(time.Second))
+		}
+		return nil
+	}
+}
+
+// FreeSpace returns a check that fails when any of dirs is on a filesystem
+// with less than minFree bytes available.
+func FreeSpace(minFree uint64, dirs ...string) func(context.Context) error {
+	return func(context.Context) error {
+		for _, dir := range dirs {
+			free, err := freeSpace(dir)
+			if err != nil {
+				return fmt.Errorf("%s: %w", dir, err)
+			}
+			if free < minFree {
+				return fmt.Errorf("%s: %d MiB free, want %d MiB", dir, free>>20, minFree>>20)
+			}
+		}
+		return nil
+	}
+}
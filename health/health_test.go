@@ -0,0 +1,142 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandler_AllOK(t *testing.T) {
+	h := &Handler{Checks: []Check{
+		{Name: "a", Run: func(context.Context) error { return nil }},
+		{Name: "b", Run: func(context.Context) error { return nil }},
+	}}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/ready", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d", rec.Code)
+	}
+	var report Report
+	json.NewDecoder(rec.Body).Decode(&report)
+	if report.Status != "ok" || len(report.Checks) != 2 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+}
+
+func TestHandler_FailingCheck(t *testing.T) {
+	h := &Handler{Checks: []Check{
+		{Name: "slskd", Run: func(context.Context) error { return errors.New("connection refused") }},
+		{Name: "store", Run: func(context.Context) error { return nil }},
+	}}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/ready", nil))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503, got %d", rec.Code)
+	}
+	var report Report
+	json.NewDecoder(rec.Body).Decode(&report)
+	if report.Checks["slskd"].Error != "connection refused" {
+		t.Errorf("expected slskd failure reason, got %+v", report.Checks["slskd"])
+	}
+	if report.Checks["store"].Status != "ok" {
+		t.Error("expected store check to pass")
+	}
+	if report.Reason != "slskd: connection refused" {
+		t.Errorf("expected the failure as the reason, got %q", report.Reason)
+	}
+}
+
+func TestHandler_Timeout(t *testing.T) {
+	h := &Handler{
+		Timeout: 10 * time.Millisecond,
+		Checks: []Check{{Name: "slow", Run: func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}}},
+	}
+
+	report := h.Run(context.Background())
+	if report.Status != "fail" {
+		t.Error("expected slow check to fail on timeout")
+	}
+}
+
+func TestHandler_CheckIgnoringContext(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	h := &Handler{
+		Timeout: 10 * time.Millisecond,
+		Checks: []Check{{Name: "wedged", Run: func(context.Context) error {
+			<-block
+			return nil
+		}}},
+	}
+
+	report := h.Run(context.Background())
+	if report.Checks["wedged"].Error != "timed out" {
+		t.Errorf("expected wedged check to time out, got %+v", report.Checks["wedged"])
+	}
+}
+
+func TestHandler_Degraded(t *testing.T) {
+	h := &Handler{Checks: []Check{
+		{Name: "slskd", Run: func(context.Context) error { return nil }},
+		{Name: "disk", Run: func(context.Context) error { return errors.New("1 MiB free") }, Degrades: true},
+	}}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/ready", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected 200 while degraded, got %d", rec.Code)
+	}
+	var report Report
+	json.NewDecoder(rec.Body).Decode(&report)
+	if report.Status != "degraded" || report.Checks["disk"].Status != "fail" {
+		t.Errorf("unexpected report: %+v", report)
+	}
+
+	h.Checks[0].Run = func(context.Context) error { return errors.New("connection refused") }
+	if report := h.Run(context.Background()); report.Status != "fail" {
+		t.Errorf("expected a failed critical check to win, got %s", report.Status)
+	}
+	h.Live = true
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/health", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"status":"fail"`) {
+		t.Errorf("expected liveness to answer 200 with the report, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRecent(t *testing.T) {
+	last := time.Now().Add(-time.Minute)
+	check := Recent(func() time.Time { return last }, 30*time.Second)
+	if err := check(context.Background()); err == nil || !strings.Contains(err.Error(), "1m0s ago") {
+		t.Errorf("expected a stale loop reported, got %v", err)
+	}
+	last = time.Now()
+	if err := check(context.Background()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestFreeSpace(t *testing.T) {
+	dir := t.TempDir()
+	if err := FreeSpace(1, dir)(context.Background()); err != nil {
+		t.Errorf("expected a byte free in %s: %v", dir, err)
+	}
+	if err := FreeSpace(1<<62, dir)(context.Background()); err == nil {
+		t.Error("expected an exabyte-scale floor to fail")
+	}
+	if err := FreeSpace(1, dir+"/missing")(context.Background()); err == nil {
+		t.Error("expected an error for a missing directory")
+	}
+}
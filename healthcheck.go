@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// runHealthcheck probes the local /ready endpoint and returns the process
+// exit code, so Docker HEALTHCHECK works without curl in the image.
+func runHealthcheck() int {
+	if err := openConfigFile(); err != nil {
+		fmt.Fprintf(os.Stderr, "healthcheck failed: %v\n", err)
+		return 1
+	}
+	url := readyURL(getenv("LISTEN_ADDR"), normalizeBasePath(getenv("BASE_PATH")))
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "healthcheck failed: %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "healthcheck failed: %s returned %d\n", url, resp.StatusCode)
+		return 1
+	}
+	return 0
+}
+
+// readyURL builds the loopback URL of the readiness endpoint for the given
+// listen address, mapping wildcard hosts to localhost.
+func readyURL(listenAddr, basePath string) string {
+	return localURL(listenAddr, basePath) + "/ready"
+}
+
+// localURL builds the loopback base URL of a server listening on
+// listenAddr under basePath.
+func localURL(listenAddr, basePath string) string {
+	if listenAddr == "" {
+		listenAddr = ":6969"
+	}
+	host, port, err := net.SplitHostPort(listenAddr)
+	if err != nil {
+		host, port = "", "6969"
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	return "http://" + net.JoinHostPort(host, port) + basePath
+}
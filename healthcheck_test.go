@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadyURL(t *testing.T) {
+	tests := []struct {
+		addr, base, want string
+	}{
+		{"", "", "http://127.0.0.1:6969/ready"},
+		{":8080", "", "http://127.0.0.1:8080/ready"},
+		{"0.0.0.0:6969", "/slskrr", "http://127.0.0.1:6969/slskrr/ready"},
+		{"[::]:6969", "", "http://127.0.0.1:6969/ready"},
+		{"10.0.0.2:6969", "", "http://10.0.0.2:6969/ready"},
+	}
+	for _, tt := range tests {
+		if got := readyURL(tt.addr, tt.base); got != tt.want {
+			t.Errorf("readyURL(%q, %q) = %s, want %s", tt.addr, tt.base, got, tt.want)
+		}
+	}
+}
+
+func TestRunHealthcheck(t *testing.T) {
+	ready := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ready" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if !ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	t.Setenv("LISTEN_ADDR", ":"+port)
+	t.Setenv("BASE_PATH", "")
+
+	if code := runHealthcheck(); code != 0 {
+		t.Errorf("expected exit 0 when ready, got %d", code)
+	}
+	ready = false
+	if code := runHealthcheck(); code != 1 {
+		t.Errorf("expected exit 1 when not ready, got %d", code)
+	}
+}
@@ -0,0 +1,130 @@
+// Package lidarr searches Soulseek for the albums Lidarr wants and grabs
+// them directly, then asks Lidarr to import the downloaded folders. It
+// replaces the Prowlarr → indexer → download client round trip for
+// music-only setups.
+package lidarr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client talks to the Lidarr v1 API.
+type Client struct {
+	BaseURL    string
+	APIKey     string
+	HTTPClient *http.Client
+}
+
+func NewClient(baseURL, apiKey string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		APIKey:     apiKey,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Album is a wanted album as returned by the wanted endpoints.
+type Album struct {
+	ID       int       `json:"id"`
+	Title    string    `json:"title"`
+	Artist   Artist    `json:"artist"`
+	Releases []Release `json:"releases"`
+}
+
+type Artist struct {
+	ArtistName string `json:"artistName"`
+}
+
+type Release struct {
+	TrackCount int  `json:"trackCount"`
+	Monitored  bool `json:"monitored"`
+}
+
+// TrackCount returns the track count of the monitored release, or of the
+// first release when none is monitored.
+func (a *Album) TrackCount() int {
+	for _, r := range a.Releases {
+		if r.Monitored {
+			return r.TrackCount
+		}
+	}
+	if len(a.Releases) > 0 {
+		return a.Releases[0].TrackCount
+	}
+	return 0
+}
+
+// Wanted returns up to limit missing albums, or albums that don't meet
+// their quality cutoff when cutoff is true.
+func (c *Client) Wanted(ctx context.Context, cutoff bool, limit int) ([]Album, error) {
+	kind := "missing"
+	if cutoff {
+		kind = "cutoff"
+	}
+	q := url.Values{
+		"page":          {"1"},
+		"pageSize":      {fmt.Sprint(limit)},
+		"includeArtist": {"true"},
+		"monitored":     {"true"},
+		"sortKey":       {"releaseDate"},
+		"sortDirection": {"descending"},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+"/api/v1/wanted/"+kind+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("create wanted request: %w", err)
+	}
+
+	var page struct {
+		Records []Album `json:"records"`
+	}
+	if err := c.do(req, &page); err != nil {
+		return nil, fmt.Errorf("get wanted/%s: %w", kind, err)
+	}
+	return page.Records, nil
+}
+
+// ImportFolder asks Lidarr to scan dir and import the album found there.
+func (c *Client) ImportFolder(ctx context.Context, dir string) error {
+	body, _ := json.Marshal(map[string]string{
+		"name":       "DownloadedAlbumsScan",
+		"path":       dir,
+		"importMode": "Move",
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/api/v1/command", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create command request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if err := c.do(req, nil); err != nil {
+		return fmt.Errorf("queue import of %s: %w", dir, err)
+	}
+	return nil
+}
+
+// do executes req, decoding a JSON response into out when non-nil.
+func (c *Client) do(req *http.Request, out any) error {
+	req.Header.Set("X-Api-Key", c.APIKey)
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("request failed with status %d", resp.StatusCode)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
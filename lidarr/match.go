@@ -0,0 +1,99 @@
+package lidarr
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/nerney/slskrr/slskd"
+	"github.com/nerney/slskrr/textnorm"
+)
+
+// Candidate is one peer's folder that looks like the wanted album.
+type Candidate struct {
+	Username  string
+	Directory string // remote directory, with the peer's separators
+	Format    string // extension shared by the audio files, e.g. "flac"
+	Files     []slskd.SlskdFile
+	score     int
+}
+
+// Size returns the total size of the candidate's files.
+func (c *Candidate) Size() int64 {
+	var n int64
+	for _, f := range c.Files {
+		n += f.Size
+	}
+	return n
+}
+
+// BestMatch picks the folder most likely to be album from the search
+// responses: one whose path contains every word of the album title, holding
+// at least the expected number of tracks in a single allowed format.
+// formats is in preference order. It returns nil when nothing qualifies.
+func BestMatch(album *Album, responses []slskd.SearchResponse, formats []string) *Candidate {
+	rank := make(map[string]int, len(formats))
+	for i, f := range formats {
+		rank[f] = len(formats) - i
+	}
+	words := titleWords(album.Title)
+	tracks := album.TrackCount()
+
+	var candidates []*Candidate
+	for _, resp := range responses {
+		// Group this peer's files by folder and format.
+		type key struct{ dir, format string }
+		groups := make(map[key]*Candidate)
+		var order []key
+		for _, f := range resp.Files {
+			normalized := strings.ReplaceAll(f.Filename, "\\", "/")
+			format := strings.TrimPrefix(strings.ToLower(path.Ext(normalized)), ".")
+			if rank[format] == 0 {
+				continue
+			}
+			dir := f.Filename[:len(f.Filename)-len(path.Base(normalized))]
+			k := key{dir, format}
+			c, ok := groups[k]
+			if !ok {
+				c = &Candidate{Username: resp.Username, Directory: strings.TrimRight(dir, "\\/"), Format: format}
+				groups[k] = c
+				order = append(order, k)
+			}
+			c.Files = append(c.Files, f)
+		}
+
+		for _, k := range order {
+			c := groups[k]
+			if tracks > 0 && (len(c.Files) < tracks || len(c.Files) > tracks*2) {
+				continue
+			}
+			if !containsWords(textnorm.Fold(c.Directory), words) {
+				continue
+			}
+			c.score = rank[c.Format]*1000 + resp.PeerScore()
+			candidates = append(candidates, c)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].score > candidates[j].score })
+	return candidates[0]
+}
+
+// titleWords splits an album title into folded alphanumeric words.
+func titleWords(title string) []string {
+	return strings.FieldsFunc(textnorm.Fold(title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+func containsWords(s string, words []string) bool {
+	for _, w := range words {
+		if !strings.Contains(s, w) {
+			return false
+		}
+	}
+	return true
+}
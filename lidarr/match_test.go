@@ -0,0 +1,65 @@
+package lidarr
+
+import (
+	"testing"
+
+	"github.com/nerney/slskrr/slskd"
+)
+
+func tracks(dir, ext string, n int) []slskd.SlskdFile {
+	files := make([]slskd.SlskdFile, n)
+	for i := range files {
+		files[i] = slskd.SlskdFile{Filename: dir + `\` + string(rune('a'+i)) + "." + ext, Size: 30 << 20}
+	}
+	return files
+}
+
+func TestBestMatch(t *testing.T) {
+	album := &Album{
+		Title:    "OK Computer",
+		Releases: []Release{{TrackCount: 12}, {TrackCount: 3, Monitored: true}},
+	}
+	responses := []slskd.SearchResponse{
+		{Username: "mp3peer", HasFreeUploadSlot: true, Files: tracks(`Music\Radiohead\OK Computer`, "mp3", 3)},
+		{Username: "flacpeer", QueueLength: 10, Files: tracks(`Music\Radiohead\1997 - OK Computer [FLAC]`, "flac", 3)},
+		{Username: "wrongalbum", HasFreeUploadSlot: true, Files: tracks(`Music\Radiohead\Kid A`, "flac", 3)},
+		{Username: "partial", HasFreeUploadSlot: true, Files: tracks(`Music\OK Computer`, "flac", 2)},
+	}
+
+	best := BestMatch(album, responses, []string{"flac", "mp3"})
+	if best == nil {
+		t.Fatal("expected a match")
+	}
+	if best.Username != "flacpeer" || best.Format != "flac" || len(best.Files) != 3 {
+		t.Errorf("expected the flac folder, got %s %s %d files", best.Username, best.Format, len(best.Files))
+	}
+	if best.Directory != `Music\Radiohead\1997 - OK Computer [FLAC]` {
+		t.Errorf("unexpected directory %q", best.Directory)
+	}
+
+	best = BestMatch(album, responses, []string{"mp3"})
+	if best == nil || best.Username != "mp3peer" {
+		t.Errorf("expected mp3 folder when only mp3 is allowed, got %+v", best)
+	}
+}
+
+func TestBestMatch_NoCandidates(t *testing.T) {
+	album := &Album{Title: "Amnesiac", Releases: []Release{{TrackCount: 11, Monitored: true}}}
+	responses := []slskd.SearchResponse{
+		{Username: "peer", Files: tracks(`Music\Amnesiac`, "flac", 4)},
+	}
+	if best := BestMatch(album, responses, []string{"flac"}); best != nil {
+		t.Errorf("expected no match for an incomplete folder, got %+v", best)
+	}
+}
+
+func TestBestMatch_DecomposedPath(t *testing.T) {
+	album := &Album{Title: "Ágætis byrjun", Releases: []Release{{TrackCount: 2, Monitored: true}}}
+	responses := []slskd.SearchResponse{
+		// Shared from a Mac: accents decomposed into base letter + mark.
+		{Username: "macpeer", Files: tracks("Music\\Sigur Rós\\Ágætis byrjun", "flac", 2)},
+	}
+	if best := BestMatch(album, responses, []string{"flac"}); best == nil || best.Username != "macpeer" {
+		t.Errorf("expected the decomposed folder to match, got %+v", best)
+	}
+}
@@ -0,0 +1,223 @@
+package lidarr
+
+import (
+	"context"
+	"log/slog"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/nerney/slskrr/blocklist"
+	"github.com/nerney/slskrr/postprocess"
+	"github.com/nerney/slskrr/slskd"
+	"github.com/nerney/slskrr/store"
+)
+
+// Syncer periodically searches Soulseek for Lidarr's wanted albums, queues
+// the best folder for each in slskd, and asks Lidarr to import it once every
+// file has arrived. Grabbed files are tracked in the store like SABnzbd
+// grabs, so they get the same retries, notifications and queue visibility.
+type Syncer struct {
+	Lidarr        *Client
+	Slskd         *slskd.Client
+	Store         *store.Store
+	DownloadDir   string   // where slskd's downloads appear to Lidarr
+	Category      string   // store category for grabbed files
+	Formats       []string // accepted audio formats, most preferred first
+	Interval      time.Duration
+	SearchTimeout time.Duration
+	MaxSearches   int           // albums searched per run
+	Cutoff        bool          // also search albums below their quality cutoff
+	Cooldown      time.Duration // before re-searching an album that failed or had no match
+	Blocklist     *blocklist.Blocklist
+	Companions    []string // patterns of extra files (cue, log, art) queued from the album's folder
+
+	// Only touched by the Run goroutine.
+	pending map[int]*grab     // by album ID
+	skipped map[int]time.Time // album ID → when it may be searched again
+}
+
+// grab is an album queued in slskd and awaiting import.
+type grab struct {
+	album    Album
+	ids      []string // store download IDs
+	localDir string
+}
+
+// Run syncs immediately and then every Interval until ctx is cancelled.
+func (s *Syncer) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		s.RunOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce imports finished grabs and searches for more wanted albums.
+func (s *Syncer) RunOnce(ctx context.Context) {
+	if s.pending == nil {
+		s.pending = make(map[int]*grab)
+		s.skipped = make(map[int]time.Time)
+	}
+	s.checkPending(ctx)
+	if ctx.Err() == nil {
+		s.searchWanted(ctx)
+	}
+}
+
+// checkPending imports grabs whose files have all completed and gives up on
+// grabs with failed or cancelled files.
+func (s *Syncer) checkPending(ctx context.Context) {
+	for albumID, g := range s.pending {
+		var completed, failed, missing int
+		for _, id := range g.ids {
+			dl := s.Store.Get(id)
+			switch {
+			case dl == nil:
+				missing++
+			case dl.Status == store.StatusCompleted:
+				completed++
+				if dl.Path != "" {
+					// Post-processing may have moved it; import the whole
+					// release rather than one of its disc folders.
+					g.localDir = path.Dir(dl.Path)
+					if postprocess.IsDiscFolder(path.Base(g.localDir)) {
+						g.localDir = path.Dir(g.localDir)
+					}
+				}
+			case dl.Status == store.StatusFailed:
+				failed++
+			}
+		}
+
+		switch {
+		case missing > 0 || failed > 0:
+			if completed+failed+missing < len(g.ids) {
+				continue // wait for the rest to settle before giving up
+			}
+			slog.Warn("lidarr album grab failed",
+				"artist", g.album.Artist.ArtistName,
+				"album", g.album.Title,
+				"failed", failed,
+				"removed", missing,
+			)
+			delete(s.pending, albumID)
+			s.skipped[albumID] = time.Now().Add(s.Cooldown)
+		case completed == len(g.ids):
+			if err := s.Lidarr.ImportFolder(ctx, g.localDir); err != nil {
+				slog.Error("failed to trigger lidarr import, will retry", "dir", g.localDir, "error", err)
+				continue
+			}
+			slog.Info("lidarr import triggered",
+				"artist", g.album.Artist.ArtistName,
+				"album", g.album.Title,
+				"dir", g.localDir,
+			)
+			delete(s.pending, albumID)
+		}
+	}
+}
+
+// searchWanted searches for up to MaxSearches wanted albums that aren't
+// already grabbed or cooling down.
+func (s *Syncer) searchWanted(ctx context.Context) {
+	albums, err := s.Lidarr.Wanted(ctx, false, s.MaxSearches*4)
+	if err != nil {
+		slog.Error("failed to fetch lidarr wanted albums", "error", err)
+		return
+	}
+	if s.Cutoff {
+		cutoff, err := s.Lidarr.Wanted(ctx, true, s.MaxSearches*4)
+		if err != nil {
+			slog.Error("failed to fetch lidarr cutoff-unmet albums", "error", err)
+		}
+		albums = append(albums, cutoff...)
+	}
+
+	now := time.Now()
+	searched := 0
+	for i := range albums {
+		album := &albums[i]
+		if searched >= s.MaxSearches || ctx.Err() != nil {
+			return
+		}
+		if _, ok := s.pending[album.ID]; ok {
+			continue
+		}
+		if until, ok := s.skipped[album.ID]; ok && now.Before(until) {
+			continue
+		}
+		searched++
+		s.searchAlbum(ctx, album)
+	}
+}
+
+func (s *Syncer) searchAlbum(ctx context.Context, album *Album) {
+	query := album.Artist.ArtistName + " " + album.Title
+	log := slog.With("artist", album.Artist.ArtistName, "album", album.Title)
+
+	responses, err := s.Slskd.SearchAndWait(ctx, query, s.SearchTimeout)
+	if err != nil {
+		log.Error("lidarr album search failed", "error", err)
+		return
+	}
+
+	responses = slices.DeleteFunc(responses, func(r slskd.SearchResponse) bool {
+		return s.Blocklist.Blocked(r.Username)
+	})
+	best := BestMatch(album, responses, s.Formats)
+	if best == nil {
+		log.Info("no match for lidarr album", "responses", len(responses))
+		s.skipped[album.ID] = time.Now().Add(s.Cooldown)
+		return
+	}
+
+	files := make([]slskd.DownloadRequest, len(best.Files))
+	for i, f := range best.Files {
+		files[i] = slskd.DownloadRequest{Filename: f.Filename, Size: f.Size}
+	}
+	if err := s.Slskd.Download(ctx, best.Username, files); err != nil {
+		log.Error("failed to queue lidarr album", "username", best.Username, "error", err)
+		s.skipped[album.ID] = time.Now().Add(s.Cooldown)
+		return
+	}
+
+	// Companion files complete the rip but aren't waited for: the import
+	// shouldn't hang on a missing cover.
+	if companions, err := s.Slskd.Companions(ctx, best.Username, files, s.Companions); err != nil {
+		log.Warn("failed to browse for companion files", "username", best.Username, "error", err)
+	} else if len(companions) > 0 {
+		if err := s.Slskd.Download(ctx, best.Username, companions); err != nil {
+			log.Warn("failed to queue companion files", "username", best.Username, "error", err)
+		}
+	}
+
+	g := &grab{album: *album, localDir: path.Join(s.DownloadDir, folderName(best.Directory))}
+	for _, f := range best.Files {
+		id := s.Store.Add(best.Username, f.Filename, f.Size, s.Category)
+		s.Store.SetAlbum(id, album.Artist.ArtistName, album.Title)
+		s.Store.SetBitRate(id, f.BitRate)
+		g.ids = append(g.ids, id)
+	}
+	s.pending[album.ID] = g
+	log.Info("grabbed lidarr album",
+		"username", best.Username,
+		"directory", best.Directory,
+		"format", best.Format,
+		"files", len(best.Files),
+		"size", best.Size(),
+	)
+}
+
+// folderName returns the last component of a peer's directory path, which
+// is the folder slskd downloads the files into.
+func folderName(dir string) string {
+	return path.Base(strings.ReplaceAll(dir, "\\", "/"))
+}
@@ -0,0 +1,151 @@
+package lidarr
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nerney/slskrr/slskd"
+	"github.com/nerney/slskrr/store"
+)
+
+func TestSyncer_GrabAndImport(t *testing.T) {
+	var imported string
+	lidarrSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Key") != "lidarrkey" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v1/wanted/missing":
+			w.Write([]byte(`{"records":[{"id":7,"title":"OK Computer","artist":{"artistName":"Radiohead"},"releases":[{"trackCount":3,"monitored":true}]}]}`))
+		case "/api/v1/command":
+			var cmd map[string]string
+			json.NewDecoder(r.Body).Decode(&cmd)
+			if cmd["name"] == "DownloadedAlbumsScan" {
+				imported = cmd["path"]
+			}
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer lidarrSrv.Close()
+
+	var queued []slskd.DownloadRequest
+	slskdSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/api/v0/searches":
+			json.NewEncoder(w).Encode(slskd.SearchResult{ID: "s1"})
+		case r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/api/v0/searches/"):
+			json.NewEncoder(w).Encode(slskd.SearchResult{ID: "s1", IsComplete: true, Responses: []slskd.SearchResponse{
+				{Username: "flacpeer", HasFreeUploadSlot: true, Files: tracks(`Music\Radiohead\OK Computer`, "flac", 3)},
+			}})
+		case r.Method == "POST" && strings.HasPrefix(r.URL.Path, "/api/v0/transfers/downloads/"):
+			json.NewDecoder(r.Body).Decode(&queued)
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer slskdSrv.Close()
+
+	st := store.New()
+	s := &Syncer{
+		Lidarr:        NewClient(lidarrSrv.URL, "lidarrkey"),
+		Slskd:         slskd.NewClient(slskdSrv.URL, "slskdkey"),
+		Store:         st,
+		DownloadDir:   "/downloads/complete",
+		Category:      "lidarr",
+		Formats:       []string{"flac"},
+		SearchTimeout: 10 * time.Second,
+		MaxSearches:   5,
+		Cooldown:      time.Hour,
+	}
+
+	s.RunOnce(context.Background())
+	if len(queued) != 3 {
+		t.Fatalf("expected 3 files queued in slskd, got %d", len(queued))
+	}
+	all := st.All()
+	if len(all) != 3 || all[0].Category != "lidarr" {
+		t.Fatalf("expected 3 tracked downloads, got %d", len(all))
+	}
+
+	// A second run while the grab is pending must not search again.
+	queued = nil
+	s.RunOnce(context.Background())
+	if queued != nil {
+		t.Error("expected no new grab while the album is pending")
+	}
+	if imported != "" {
+		t.Error("expected no import before the files complete")
+	}
+
+	for _, dl := range all {
+		st.UpdateTransfer(dl.ID, dl.Size, store.StatusCompleted)
+	}
+	s.checkPending(context.Background())
+	if imported != "/downloads/complete/OK Computer" {
+		t.Errorf("expected import of the album folder, got %q", imported)
+	}
+	if len(s.pending) != 0 {
+		t.Error("expected the grab to be done after import")
+	}
+}
+
+func TestSyncer_FailedGrabCoolsDown(t *testing.T) {
+	st := store.New()
+	s := &Syncer{Store: st, Cooldown: time.Hour}
+	s.pending = map[int]*grab{}
+	s.skipped = map[int]time.Time{}
+
+	id1 := st.Add("peer", "a.flac", 1, "lidarr")
+	id2 := st.Add("peer", "b.flac", 1, "lidarr")
+	s.pending[7] = &grab{ids: []string{id1, id2}}
+
+	st.UpdateTransfer(id1, 0, store.StatusFailed)
+	s.checkPending(context.Background())
+	if _, ok := s.pending[7]; !ok {
+		t.Fatal("expected to wait while other files are still in progress")
+	}
+
+	st.UpdateTransfer(id2, 1, store.StatusCompleted)
+	s.checkPending(context.Background())
+	if _, ok := s.pending[7]; ok {
+		t.Fatal("expected failed grab to be dropped")
+	}
+	if s.skipped[7].IsZero() {
+		t.Error("expected album to cool down before being searched again")
+	}
+}
+
+func TestSyncer_ImportsProcessedFolder(t *testing.T) {
+	var imported string
+	lidarrSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var cmd map[string]string
+		json.NewDecoder(r.Body).Decode(&cmd)
+		imported = cmd["path"]
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{}`))
+	}))
+	defer lidarrSrv.Close()
+
+	st := store.New()
+	s := &Syncer{Lidarr: NewClient(lidarrSrv.URL, "lidarrkey"), Store: st}
+	s.pending = map[int]*grab{}
+	id := st.Add("peer", `Music\OK Computer\CD1\01.flac`, 1, "lidarr")
+	s.pending[7] = &grab{ids: []string{id}, localDir: "/downloads/complete/CD1"}
+
+	st.UpdateTransfer(id, 1, store.StatusProcessing)
+	st.FinishProcessing(id, "/downloads/complete/lidarr/OK Computer/CD1/01.flac", nil)
+	s.checkPending(context.Background())
+	if imported != "/downloads/complete/lidarr/OK Computer" {
+		t.Errorf("expected import of the moved release folder, got %q", imported)
+	}
+}
@@ -0,0 +1,132 @@
+// Package logring keeps the most recent log records in memory so they can
+// be read through the admin API when container logs are out of reach.
+package logring
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Entry is a captured log record.
+type Entry struct {
+	Time    time.Time      `json:"time"`
+	Level   string         `json:"level"`
+	Message string         `json:"msg"`
+	Attrs   map[string]any `json:"attrs,omitempty"`
+}
+
+// Ring holds the last Size log entries. A nil ring holds nothing.
+type Ring struct {
+	mu      sync.Mutex
+	entries []Entry
+	next    int // index the next entry is written to once full
+}
+
+func New(size int) *Ring {
+	return &Ring{entries: make([]Entry, 0, size)}
+}
+
+func (r *Ring) add(e Entry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch {
+	case cap(r.entries) == 0:
+		return
+	case len(r.entries) < cap(r.entries):
+		r.entries = append(r.entries, e)
+		return
+	}
+	r.entries[r.next] = e
+	r.next = (r.next + 1) % len(r.entries)
+}
+
+// Entries returns up to limit of the most recent entries at or above level,
+// oldest first. A limit of 0 returns all of them.
+func (r *Ring) Entries(level slog.Level, limit int) []Entry {
+	if r == nil {
+		return []Entry{}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := []Entry{}
+	n := len(r.entries)
+	for i := n - 1; i >= 0 && (limit <= 0 || len(result) < limit); i-- {
+		e := r.entries[(r.next+i)%n]
+		var l slog.Level
+		if l.UnmarshalText([]byte(e.Level)) == nil && l < level {
+			continue
+		}
+		result = append(result, e)
+	}
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
+	}
+	return result
+}
+
+// Handler returns a slog.Handler that records into the ring and passes
+// every record on to next. Only records next is enabled for are captured.
+func (r *Ring) Handler(next slog.Handler) slog.Handler {
+	return &handler{next: next, ring: r}
+}
+
+type handler struct {
+	next   slog.Handler
+	ring   *Ring
+	attrs  []slog.Attr // from WithAttrs, keys already prefixed
+	prefix string      // from WithGroup, e.g. "group."
+}
+
+func (h *handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *handler) Handle(ctx context.Context, rec slog.Record) error {
+	e := Entry{Time: rec.Time, Level: rec.Level.String(), Message: rec.Message}
+	if len(h.attrs) > 0 || rec.NumAttrs() > 0 {
+		e.Attrs = make(map[string]any, len(h.attrs)+rec.NumAttrs())
+		for _, a := range h.attrs {
+			addAttr(e.Attrs, "", a)
+		}
+		rec.Attrs(func(a slog.Attr) bool {
+			addAttr(e.Attrs, h.prefix, a)
+			return true
+		})
+	}
+	h.ring.add(e)
+	return h.next.Handle(ctx, rec)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	prefixed := make([]slog.Attr, 0, len(h.attrs)+len(attrs))
+	prefixed = append(prefixed, h.attrs...)
+	for _, a := range attrs {
+		prefixed = append(prefixed, slog.Attr{Key: h.prefix + a.Key, Value: a.Value})
+	}
+	return &handler{next: h.next.WithAttrs(attrs), ring: h.ring, attrs: prefixed, prefix: h.prefix}
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return &handler{next: h.next.WithGroup(name), ring: h.ring, attrs: h.attrs, prefix: h.prefix + name + "."}
+}
+
+// addAttr flattens a into m, joining group keys with dots. Values that
+// don't encode meaningfully as JSON, like errors and durations, are stored
+// as their string form.
+func addAttr(m map[string]any, prefix string, a slog.Attr) {
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindGroup:
+		for _, ga := range v.Group() {
+			addAttr(m, prefix+a.Key+".", ga)
+		}
+	case slog.KindString, slog.KindInt64, slog.KindUint64, slog.KindFloat64, slog.KindBool, slog.KindTime:
+		m[prefix+a.Key] = v.Any()
+	default:
+		m[prefix+a.Key] = v.String()
+	}
+}
@@ -0,0 +1,63 @@
+package logring
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestRing_KeepsMostRecent(t *testing.T) {
+	r := New(3)
+	log := slog.New(r.Handler(slog.NewTextHandler(io.Discard, nil)))
+	for _, msg := range []string{"one", "two", "three", "four"} {
+		log.Info(msg)
+	}
+
+	entries := r.Entries(slog.LevelDebug, 0)
+	if len(entries) != 3 || entries[0].Message != "two" || entries[2].Message != "four" {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+	if got := r.Entries(slog.LevelDebug, 2); len(got) != 2 || got[0].Message != "three" {
+		t.Errorf("expected the 2 most recent entries, got %+v", got)
+	}
+}
+
+func TestRing_FiltersByLevel(t *testing.T) {
+	r := New(10)
+	log := slog.New(r.Handler(slog.NewTextHandler(io.Discard, nil)))
+	log.Debug("not enabled")
+	log.Info("info")
+	log.Warn("warn")
+	log.Error("error")
+
+	if got := r.Entries(slog.LevelDebug, 0); len(got) != 3 {
+		t.Errorf("expected records below the handler level to be skipped, got %+v", got)
+	}
+	if got := r.Entries(slog.LevelWarn, 0); len(got) != 2 || got[0].Level != "WARN" {
+		t.Errorf("expected warn and error only, got %+v", got)
+	}
+}
+
+func TestRing_CapturesAttrs(t *testing.T) {
+	r := New(10)
+	log := slog.New(r.Handler(slog.NewTextHandler(io.Discard, nil)))
+	log.With("component", "sync").WithGroup("dl").Error("failed",
+		"id", "nzo_1",
+		"error", errors.New("boom"),
+		"elapsed", 2*time.Second,
+	)
+
+	attrs := r.Entries(slog.LevelDebug, 0)[0].Attrs
+	if attrs["component"] != "sync" || attrs["dl.id"] != "nzo_1" || attrs["dl.error"] != "boom" || attrs["dl.elapsed"] != "2s" {
+		t.Errorf("unexpected attrs: %+v", attrs)
+	}
+}
+
+func TestRing_NilIsEmpty(t *testing.T) {
+	var r *Ring
+	if got := r.Entries(slog.LevelDebug, 0); len(got) != 0 {
+		t.Errorf("expected no entries, got %+v", got)
+	}
+}
@@ -2,23 +2,58 @@ package main
 
 import (
 	"context"
+	"expvar"
+	"flag"
+	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/exec"
 	"os/signal"
+	"path/filepath"
+	"slices"
 	"syscall"
 	"time"
 
+	"github.com/nerney/slskrr/admin"
+	"github.com/nerney/slskrr/backup"
+	"github.com/nerney/slskrr/blocklist"
+	"github.com/nerney/slskrr/health"
+	"github.com/nerney/slskrr/lidarr"
+	"github.com/nerney/slskrr/logring"
+	"github.com/nerney/slskrr/metrics"
+	"github.com/nerney/slskrr/middleware"
+	"github.com/nerney/slskrr/musicbrainz"
 	"github.com/nerney/slskrr/newznab"
 	"github.com/nerney/slskrr/sabnzbd"
+	"github.com/nerney/slskrr/searchlog"
 	"github.com/nerney/slskrr/slskd"
+	"github.com/nerney/slskrr/stats"
 	"github.com/nerney/slskrr/store"
+	"github.com/nerney/slskrr/systemd"
+	"github.com/nerney/slskrr/tmdb"
+	"github.com/nerney/slskrr/tracing"
+	"github.com/nerney/slskrr/wishlist"
 )
 
 func main() {
-	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
+	if len(os.Args) > 1 && os.Args[1] == "healthcheck" {
+		os.Exit(runHealthcheck())
+	}
+	if len(os.Args) > 1 && os.Args[1] == "grab" {
+		os.Exit(runGrab(os.Args[2:]))
+	}
+	configPath := flag.String("config", "", "YAML config file; overrides CONFIG_FILE")
+	flag.Parse()
+	if *configPath != "" {
+		os.Setenv("CONFIG_FILE", *configPath)
+	}
+
+	logHandler := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
 		Level: slog.LevelInfo,
-	})))
+	})
+	slog.SetDefault(slog.New(middleware.ContextHandler{Handler: logHandler}))
 
 	cfg, err := LoadConfig()
 	if err != nil {
@@ -26,79 +61,423 @@ func main() {
 		os.Exit(1)
 	}
 
+	var logs *logring.Ring
+	if cfg.LogBufferSize > 0 {
+		logs = logring.New(cfg.LogBufferSize)
+		slog.SetDefault(slog.New(middleware.ContextHandler{Handler: logs.Handler(logHandler)}))
+	}
+
 	slskdClient := slskd.NewClient(cfg.SlskdURL, cfg.SlskdAPIKey)
+	slskdClient.OptionsTTL = cfg.SlskdOptionsTTL
+	slskdClient.MaxSearches = cfg.MaxSearches
+	slskdClient.QueueTimeout = cfg.SearchQueueTimeout
 	st := store.New()
+	keys := cfg.Keyring()
+	blocked := blocklist.New(cfg.BlocklistStrikes, cfg.BlocklistCooldown)
+	wanted := wishlist.New()
+	tally := stats.New()
+	var searches *searchlog.Log
+	if cfg.SearchLogSize > 0 {
+		searches = searchlog.New(cfg.SearchLogSize)
+	}
+	if cfg.DataDir != "" {
+		// A restore staged through the admin API replaces the state files
+		// before anything reads them.
+		restored, err := backup.ApplyStaged(cfg.DataDir)
+		if err != nil {
+			slog.Error("failed to restore backup", "error", err)
+			os.Exit(1)
+		}
+		if len(restored) > 0 {
+			slog.Info("restored state from backup", "files", restored)
+		}
+		if err := keys.Load(filepath.Join(cfg.DataDir, "keys.json")); err != nil {
+			slog.Error("failed to load runtime api keys", "error", err)
+			os.Exit(1)
+		}
+		if err := blocked.Load(filepath.Join(cfg.DataDir, "blocklist.json")); err != nil {
+			slog.Error("failed to load blocklist", "error", err)
+			os.Exit(1)
+		}
+		if err := wanted.Load(filepath.Join(cfg.DataDir, "wishlist.json")); err != nil {
+			slog.Error("failed to load wishlist", "error", err)
+			os.Exit(1)
+		}
+		if err := tally.Load(filepath.Join(cfg.DataDir, "stats.json")); err != nil {
+			slog.Error("failed to load stats", "error", err)
+			os.Exit(1)
+		}
+		if searches != nil {
+			if err := searches.Load(filepath.Join(cfg.DataDir, "searches.json")); err != nil {
+				slog.Error("failed to load search log", "error", err)
+				os.Exit(1)
+			}
+		}
+		if err := st.Open(filepath.Join(cfg.DataDir, "store.json")); err != nil {
+			slog.Error("failed to load store", "error", err)
+			os.Exit(1)
+		}
+		slog.Info("loaded store", "downloads", st.Len())
+	}
 
-	// Try to discover slskd's download directory if not explicitly configured
-	if cfg.DownloadDir == "/downloads/complete" {
-		if dir, err := slskdClient.GetDownloadDir(context.Background()); err == nil && dir != "" {
+	// Try to discover slskd's download directory if not explicitly
+	// configured. slskd often starts after us under Compose, so this is a
+	// single quick attempt; awaitSlskd tries again once slskd answers.
+	discoverDownloadDir := cfg.DownloadDir == "/downloads/complete"
+	discovered := false
+	if discoverDownloadDir {
+		dirCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if dir, err := slskdClient.GetDownloadDir(dirCtx); err == nil && dir != "" {
 			slog.Info("discovered slskd download directory", "dir", dir)
-			cfg.DownloadDir = dir
+			cfg.DownloadDir, discovered = dir, true
 		}
+		cancel()
 	}
 
 	// Compute the base URL for self-referencing download links
-	baseURL := "http://localhost" + cfg.ListenAddr
+	baseURL := "http://localhost" + cfg.ListenAddr + cfg.BasePath
+	if cfg.ExternalURL != "" {
+		baseURL = cfg.ExternalURL
+	}
 
 	newznabHandler := &newznab.Handler{
-		SlskdClient:   slskdClient,
-		APIKey:        cfg.APIKey,
-		SearchTimeout: cfg.SearchTimeout,
-		BaseURL:       baseURL,
+		SlskdClient:    slskdClient,
+		Keys:           keys,
+		SearchTimeout:  cfg.SearchTimeout,
+		MaxTimeout:     cfg.MaxTimeout,
+		BaseURL:        baseURL,
+		ExternalURL:    cfg.ExternalURL,
+		Limiter:        middleware.NewRateLimiter(cfg.RateLimit, cfg.RateBurst),
+		Blocklist:      blocked,
+		SlskdBlacklist: cfg.SlskdBlacklist,
+		MaxPeerFiles:   cfg.MaxPeerFiles,
+		MaxFiles:       cfg.MaxFiles,
+		ResultLimit:    cfg.MaxResults,
+		QueryCooldown:  cfg.QueryCooldown,
+		EarlyResults:   cfg.EarlyResults,
+		EarlyScore:     cfg.EarlyScore,
+		RelaxEmpty:     cfg.RelaxEmpty,
+		ReleaseNames:   cfg.ReleaseNames,
+		QueryCleanup:   cfg.QueryCleanup,
+		HotSearches:    cfg.HotSearches,
+		TestResponse:   cfg.TestResponse,
+		TestResponses:  cfg.TestResponses,
+		Adult:          cfg.Adult,
+		Searches:       searches,
+	}
+	if cfg.TMDBAPIKey != "" {
+		newznabHandler.TMDB = tmdb.NewClient(cfg.TMDBAPIKey)
 	}
+	if cfg.MusicBrainzURL != "" {
+		newznabHandler.MusicBrainz = musicbrainz.NewClient()
+		newznabHandler.MusicBrainz.BaseURL = cfg.MusicBrainzURL
+	}
+
+	notifiers := cfg.Notifiers()
 
 	sabHandler := &sabnzbd.Handler{
 		SlskdClient: slskdClient,
 		Store:       st,
-		APIKey:      cfg.APIKey,
+		Keys:        keys,
 		DownloadDir: cfg.DownloadDir,
+		Limiter:     middleware.NewRateLimiter(cfg.RateLimit, cfg.RateBurst),
+		Notifier:    notifiers,
+		Stats:       tally,
+		Searches:    searches,
+		PostProcess: cfg.PostProcess(),
+
+		DiscoverDownloadDir: discoverDownloadDir,
+		BandwidthMax:        cfg.BandwidthMax,
+		MaxAge:              cfg.MaxDownloadAge,
+		SyncInterval:        cfg.SyncInterval,
+		SlskdBlacklist:      cfg.SlskdBlacklist,
+		Blocklist:           blocked,
+		Version:             cfg.SABVersion,
+		Categories:          cfg.SABCategories,
+		Companions:          cfg.Companions,
+	}
+	if cfg.AlternatePeers {
+		sabHandler.AlternateSearch = cfg.SearchTimeout
+	}
+
+	adminHandler := &admin.Handler{
+		Keys:      keys,
+		Auth:      &cfg.AdminAuth,
+		Downloads: sabHandler,
+		Ops:       sabHandler,
+		Blocklist: blocked,
+		Wishlist:  wanted,
+		Stats:     tally,
+		Searches:  searches,
+
+		Searcher:      slskdClient,
+		Browser:       slskdClient,
+		SearchTimeout: cfg.SearchTimeout,
+		Logs:          logs,
+	}
+	var backups *backup.Backups
+	if cfg.BackupDir != "" {
+		backups = &backup.Backups{DataDir: cfg.DataDir, Dir: cfg.BackupDir, Keep: cfg.BackupKeep, Flush: st.Flush}
+		adminHandler.Backups = backups
+	}
+	if cfg.TelegramButtons && cfg.Telegram.Token != "" {
+		cfg.Telegram.Actions = sabHandler
+	}
+	if cfg.TranscodeTo != "" {
+		if _, err := exec.LookPath(cfg.FFmpegPath); err != nil {
+			slog.Warn("ffmpeg not found; completed downloads will fail to transcode", "ffmpeg", cfg.FFmpegPath, "error", err)
+		}
+	}
+	if !cfg.AdminAuth.Enabled() {
+		slog.Warn("admin API is protected by client API keys only; set ADMIN_USER/ADMIN_PASSWORD or ADMIN_AUTH_HEADER to separate them")
 	}
 
 	mux := http.NewServeMux()
 	mux.Handle("/api", newznabHandler)
-	mux.Handle("/sabnzbd/api", sabHandler)
-	mux.HandleFunc("/health", func(w http.ResponseWriter, _ *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("ok"))
-	})
+	mux.Handle("/torznab/api", newznabHandler.Torznab())
+	mux.Handle("/sabnzbd/api", middleware.CORS(cfg.CORSOrigins, sabHandler))
+	mux.Handle("/admin/api/", middleware.CORS(cfg.CORSOrigins, adminHandler))
+	mux.Handle("/hooks/arr", &blocklist.Hook{Blocklist: blocked, Store: st, Keys: keys})
+	checks := healthChecks(cfg, slskdClient, st, sabHandler)
+	ready := &health.Handler{Checks: checks}
+	mux.Handle("/health", &health.Handler{Checks: checks, Live: true})
+	mux.Handle("/ready", ready)
+	mux.Handle("/health/ready", ready)
+	// Liveness only: the process is serving. Restarting it won't bring
+	// slskd back, so a probe that restarts on failure checks nothing else.
+	mux.Handle("/health/live", &health.Handler{Live: true})
+
+	publishVars(st, slskdClient, notifiers)
+	registerMetrics(st, slskdClient)
+	mux.Handle("/debug/vars", adminHandler.Protect(expvar.Handler()))
+	mux.Handle("/metrics", adminHandler.Protect(metrics.Handler()))
+
+	var handler http.Handler = tracing.Middleware(mux)
+	if cfg.BasePath != "" {
+		handler = middleware.BasePath(cfg.BasePath, handler)
+	}
+	handler = middleware.TrustedProxies(cfg.TrustedProxies, handler)
+	handler = middleware.Limits(cfg.MaxURLLength, cfg.MaxBodySize, handler)
+	handler = middleware.Logging(handler)
 
 	srv := &http.Server{
-		Addr:         cfg.ListenAddr,
-		Handler:      mux,
-		ReadTimeout:  60 * time.Second,
-		WriteTimeout: 120 * time.Second,
+		Addr:           cfg.ListenAddr,
+		Handler:        handler,
+		ReadTimeout:    60 * time.Second,
+		WriteTimeout:   max(120*time.Second, cfg.MaxTimeout+30*time.Second), // room for the longest search a request can ask for
+		MaxHeaderBytes: 64 << 10,
 	}
 
 	// Start background sync
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	go sabHandler.SyncDownloads(ctx)
+	if cfg.SlskdHub {
+		transfers := slskdClient.Hub(slskd.TransfersHub)
+		sabHandler.TransferHub = transfers
+		go transfers.Run(ctx, sabHandler.TransferEvent)
+		go slskdClient.Hub(slskd.SearchHub).Run(ctx, slskdClient.SearchEvent)
+	}
+	syncDone := make(chan struct{})
+	go func() {
+		sabHandler.SyncDownloads(ctx)
+		close(syncDone)
+	}()
+	if cfg.DataDir != "" {
+		go st.AutoFlush(ctx, 30*time.Second)
+	}
+	if backups != nil {
+		go backups.Run(ctx, cfg.BackupInterval)
+	}
+	if cfg.SearchJanitor {
+		go slskdClient.RunJanitor(ctx, 10*time.Minute, cfg.SearchJanitorAge)
+	}
+	go newznabHandler.RefreshHotSearches(ctx)
+	if notifiers != nil {
+		watch := &slskdWatch{
+			Probe: func(ctx context.Context) error {
+				_, err := slskdClient.GetServerState(ctx)
+				return err
+			},
+			Notifier:  notifiers,
+			URL:       cfg.SlskdURL,
+			DownAfter: cfg.SlskdDownAfter,
+		}
+		go watch.Run(ctx, min(15*time.Second, cfg.SlskdDownAfter))
+	}
+
+	if cfg.LidarrURL != "" {
+		syncer := &lidarr.Syncer{
+			Lidarr:        lidarr.NewClient(cfg.LidarrURL, cfg.LidarrAPIKey),
+			Slskd:         slskdClient,
+			Store:         st,
+			DownloadDir:   cfg.DownloadDir,
+			Category:      cfg.LidarrCategory,
+			Formats:       cfg.LidarrFormats,
+			Interval:      cfg.LidarrInterval,
+			SearchTimeout: cfg.SearchTimeout,
+			MaxSearches:   cfg.LidarrMaxSearches,
+			Cutoff:        cfg.LidarrCutoff,
+			Cooldown:      cfg.LidarrCooldown,
+			Blocklist:     blocked,
+			Companions:    cfg.Companions,
+		}
+		go syncer.Run(ctx)
+		slog.Info("lidarr wanted-list sync enabled", "url", cfg.LidarrURL, "interval", cfg.LidarrInterval)
+	}
+
+	searcher := &wishlist.Searcher{
+		Wishlist:      wanted,
+		Slskd:         slskdClient,
+		Store:         st,
+		Blocklist:     blocked,
+		Notifier:      notifiers,
+		Interval:      cfg.WishlistInterval,
+		SearchTimeout: cfg.SearchTimeout,
+		MaxSearches:   cfg.WishlistMaxSearches,
+	}
+	go searcher.Run(ctx)
 
-	// Graceful shutdown
+	if cfg.Telegram.Actions != nil {
+		go cfg.Telegram.Run(ctx)
+	}
+
+	if cfg.OTLPEndpoint != "" {
+		tracer := &tracing.Tracer{Endpoint: cfg.OTLPEndpoint, ServiceName: cfg.ServiceName}
+		tracing.Init(tracer)
+		go tracer.Run(ctx, 5*time.Second)
+		slog.Info("tracing enabled", "endpoint", cfg.OTLPEndpoint)
+	}
+
+	// Graceful shutdown: refuse new grabs, cancel the searches we own so
+	// in-flight requests return promptly, drain the server, let the current
+	// sync iteration finish, then persist the store.
+	shutdownDone := make(chan struct{})
 	go func() {
+		defer close(shutdownDone)
 		sigCh := make(chan os.Signal, 1)
 		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 		<-sigCh
 		slog.Info("shutting down...")
-		cancel()
+		systemd.Notify("STOPPING=1")
+		sabHandler.Drain()
+
 		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer shutdownCancel()
+		if n := slskdClient.CancelSearches(shutdownCtx); n > 0 {
+			slog.Info("cancelled in-flight searches", "count", n)
+		}
 		if err := srv.Shutdown(shutdownCtx); err != nil {
 			slog.Error("server shutdown error", "error", err)
 		}
+
+		cancel()
+		<-syncDone
+		notifiers.Close(shutdownCtx)
+		if err := st.Flush(); err != nil {
+			slog.Error("failed to flush store", "error", err)
+		}
 	}()
 
 	slog.Info("starting slskrr",
 		"addr", cfg.ListenAddr,
 		"slskd", cfg.SlskdURL,
+		"apiKeys", keys.Len(),
+		"notifiers", notifiers.Len(),
 		"newznab", baseURL+"/api",
+		"torznab", baseURL+"/torznab/api",
 		"sabnzbd", baseURL+"/sabnzbd/api",
+		"admin", baseURL+"/admin/api/",
 	)
 
-	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+	ln, err := net.Listen("tcp", cfg.ListenAddr)
+	if err != nil {
+		slog.Error("failed to listen", "addr", cfg.ListenAddr, "error", err)
+		os.Exit(1)
+	}
+	if _, err := systemd.Notify("READY=1"); err != nil {
+		slog.Warn("systemd notify failed", "error", err)
+	}
+	if interval := systemd.WatchdogInterval(); interval > 0 {
+		slog.Info("systemd watchdog enabled", "interval", interval)
+		go systemd.Watchdog(ctx, interval, func() bool {
+			return time.Since(sabHandler.LastSync()) < interval
+		})
+	}
+
+	// Serve without waiting for slskd: /ready reports not ready until it
+	// answers, and a start that blocked on it would outlast systemd's start
+	// timeout and fail Docker's health check.
+	if cfg.SlskdWait > 0 {
+		go awaitSlskd(ctx, slskdClient, sabHandler, cfg.SlskdWait, discoverDownloadDir && !discovered)
+	}
+
+	if err := srv.Serve(ln); err != http.ErrServerClosed {
 		slog.Error("server error", "error", err)
 		os.Exit(1)
 	}
 
+	<-shutdownDone
 	slog.Info("slskrr stopped")
 }
+
+// awaitSlskd waits up to maxWait for slskd to answer, logging its state,
+// and then discovers its download directory if rediscover is set.
+func awaitSlskd(ctx context.Context, client *slskd.Client, sab *sabnzbd.Handler, maxWait time.Duration, rediscover bool) {
+	state, err := client.WaitReady(ctx, maxWait)
+	switch {
+	case ctx.Err() != nil:
+		return
+	case err != nil:
+		slog.Warn("slskd still unreachable", "error", err)
+		return
+	case !state.IsLoggedIn:
+		slog.Warn("slskd is up but not logged in to Soulseek", "state", state.State)
+	default:
+		slog.Info("slskd is ready", "state", state.State)
+	}
+	if rediscover {
+		if dir, err := sab.RefreshOptions(ctx); err != nil {
+			slog.Warn("failed to discover slskd download directory", "error", err)
+		} else {
+			slog.Info("discovered slskd download directory", "dir", dir)
+		}
+	}
+}
+
+// healthChecks returns the dependency probes served by /health and /ready.
+func healthChecks(cfg *Config, client *slskd.Client, st *store.Store, sab *sabnzbd.Handler) []health.Check {
+	checks := []health.Check{
+		{Name: "slskd", Run: func(ctx context.Context) error {
+			_, err := client.GetServerState(ctx)
+			return err
+		}},
+		{Name: "soulseek", Run: func(ctx context.Context) error {
+			state, err := client.GetServerState(ctx)
+			if err != nil {
+				return fmt.Errorf("slskd unavailable: %w", err)
+			}
+			if !state.IsLoggedIn {
+				return fmt.Errorf("not logged in (state: %s)", state.State)
+			}
+			return nil
+		}},
+		{Name: "store", Run: func(context.Context) error {
+			// Taking the store lock proves it isn't wedged; Run times this out.
+			st.Len()
+			return nil
+		}},
+		// The sync loop runs every SYNC_INTERVAL (at most 30s); a minute
+		// without one means it's stuck.
+		{Name: "sync", Run: health.Recent(sab.LastSync, time.Minute), Degrades: true},
+	}
+	var dirs []string
+	for _, dir := range []string{cfg.DownloadDir, cfg.DataDir} {
+		if dir != "" && !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	if cfg.MinFreeSpace > 0 && len(dirs) > 0 {
+		checks = append(checks, health.Check{Name: "disk", Run: health.FreeSpace(uint64(cfg.MinFreeSpace), dirs...), Degrades: true})
+	}
+	return checks
+}
@@ -0,0 +1,326 @@
+// Package metrics exports counters, gauges and histograms in the Prometheus
+// text exposition format, without depending on the Prometheus client.
+// Metrics register themselves with a package-level registry when created,
+// like expvar, and Handler serves all of them.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	mu      sync.Mutex
+	metrics = make(map[string]metric)
+)
+
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+// register adds m under name, panicking on duplicates like expvar.Publish.
+func register(name string, m metric) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, dup := metrics[name]; dup {
+		panic("metrics: duplicate metric " + name)
+	}
+	metrics[name] = m
+}
+
+// Handler serves every registered metric in the Prometheus text format.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		mu.Lock()
+		names := make([]string, 0, len(metrics))
+		for name := range metrics {
+			names = append(names, name)
+		}
+		registered := make([]metric, len(names))
+		slices.Sort(names)
+		for i, name := range names {
+			registered[i] = metrics[name]
+		}
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		for _, m := range registered {
+			m.write(bw)
+		}
+		bw.Flush()
+	})
+}
+
+// desc is what every metric has: a name, help text and label names.
+type desc struct {
+	name, help, kind string
+	labels           []string
+}
+
+func (d *desc) header(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, strings.ReplaceAll(d.help, "\n", " "), d.name, d.kind)
+}
+
+// key joins label values into a map key, checking their number.
+func (d *desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", d.name, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// labelPairs formats label values as {a="x",b="y"}, with extra pairs
+// appended; it returns "" when there are none.
+func (d *desc) labelPairs(values []string, extra ...string) string {
+	if len(values) == 0 && len(extra) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, v := range values {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(d.labels[i] + "=" + quote(v))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		if b.Len() > 1 {
+			b.WriteByte(',')
+		}
+		b.WriteString(extra[i] + "=" + quote(extra[i+1]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func quote(v string) string {
+	v = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+	return `"` + v + `"`
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// series is one label combination's value.
+type series struct {
+	labels []string
+	value  float64
+}
+
+// values holds a counter's or gauge's series.
+type values struct {
+	desc
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+func newValues(kind, name, help string, labels []string) *values {
+	return &values{desc: desc{name: name, help: help, kind: kind, labels: labels}, series: make(map[string]*series)}
+}
+
+func (v *values) update(labelValues []string, f func(float64) float64) {
+	k := v.key(labelValues)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s, ok := v.series[k]
+	if !ok {
+		s = &series{labels: slices.Clone(labelValues)}
+		v.series[k] = s
+	}
+	s.value = f(s.value)
+}
+
+// Value returns the current value of the series with labelValues.
+func (v *values) Value(labelValues ...string) float64 {
+	k := v.key(labelValues)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if s, ok := v.series[k]; ok {
+		return s.value
+	}
+	return 0
+}
+
+func (v *values) write(w *bufio.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.header(w)
+	for _, k := range sortedKeys(v.series) {
+		s := v.series[k]
+		fmt.Fprintf(w, "%s%s %s\n", v.name, v.labelPairs(s.labels), formatFloat(s.value))
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// Counter is a value that only goes up.
+type Counter struct{ *values }
+
+// NewCounter registers a counter with the given label names.
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{newValues("counter", name, help, labels)}
+	register(name, c)
+	return c
+}
+
+// Inc adds one to the series with labelValues.
+func (c *Counter) Inc(labelValues ...string) { c.Add(1, labelValues...) }
+
+// Add adds n, which must not be negative, to the series with labelValues.
+func (c *Counter) Add(n float64, labelValues ...string) {
+	if n < 0 {
+		panic("metrics: counter " + c.name + " decreased")
+	}
+	c.update(labelValues, func(v float64) float64 { return v + n })
+}
+
+// Gauge is a value that goes up and down.
+type Gauge struct{ *values }
+
+// NewGauge registers a gauge with the given label names.
+func NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{newValues("gauge", name, help, labels)}
+	register(name, g)
+	return g
+}
+
+// Set sets the series with labelValues to v.
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.update(labelValues, func(float64) float64 { return v })
+}
+
+// Add adds n to the series with labelValues.
+func (g *Gauge) Add(n float64, labelValues ...string) {
+	g.update(labelValues, func(v float64) float64 { return v + n })
+}
+
+// GaugeFunc is a gauge computed when metrics are served, for values that
+// already live elsewhere, such as the number of downloads in each status.
+type GaugeFunc struct {
+	desc
+	collect func(emit func(v float64, labelValues ...string))
+}
+
+// NewGaugeFunc registers a gauge whose series collect emits on every scrape.
+func NewGaugeFunc(name, help string, collect func(emit func(v float64, labelValues ...string)), labels ...string) *GaugeFunc {
+	g := &GaugeFunc{desc: desc{name: name, help: help, kind: "gauge", labels: labels}, collect: collect}
+	register(name, g)
+	return g
+}
+
+func (g *GaugeFunc) write(w *bufio.Writer) {
+	collected := make(map[string]*series)
+	g.collect(func(v float64, labelValues ...string) {
+		collected[g.key(labelValues)] = &series{labels: slices.Clone(labelValues), value: v}
+	})
+	g.header(w)
+	for _, k := range sortedKeys(collected) {
+		s := collected[k]
+		fmt.Fprintf(w, "%s%s %s\n", g.name, g.labelPairs(s.labels), formatFloat(s.value))
+	}
+}
+
+// Histogram counts observations into buckets.
+type Histogram struct {
+	desc
+	buckets []float64 // upper bounds, ascending
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labels []string
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram registers a histogram with the given bucket upper bounds,
+// in ascending order, and label names.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		desc:    desc{name: name, help: help, kind: "histogram", labels: labels},
+		buckets: buckets,
+		series:  make(map[string]*histogramSeries),
+	}
+	register(name, h)
+	return h
+}
+
+// Observe records v in the series with labelValues.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	k := h.key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[k]
+	if !ok {
+		s = &histogramSeries{labels: slices.Clone(labelValues), counts: make([]uint64, len(h.buckets))}
+		h.series[k] = s
+	}
+	if i, _ := slices.BinarySearch(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += v
+}
+
+// Count returns how many values the series with labelValues has observed.
+func (h *Histogram) Count(labelValues ...string) uint64 {
+	k := h.key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s, ok := h.series[k]; ok {
+		return s.count
+	}
+	return 0
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(w)
+	for _, k := range sortedKeys(h.series) {
+		s := h.series[k]
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(s.labels, "le", formatFloat(le)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(s.labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelPairs(s.labels), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelPairs(s.labels), s.count)
+	}
+}
+
+// ExponentialBuckets returns count bucket bounds starting at start, each
+// factor times the last.
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func scrape(t *testing.T) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	return rec.Body.String()
+}
+
+func TestCounterAndGauge(t *testing.T) {
+	c := NewCounter("test_requests_total", "Requests.", "code")
+	c.Inc("200")
+	c.Add(2, "200")
+	c.Inc(`5"0"0`)
+	g := NewGauge("test_temperature", "Temperature.")
+	g.Set(21.5)
+	g.Add(-1)
+
+	if v := c.Value("200"); v != 3 {
+		t.Errorf("expected 3, got %v", v)
+	}
+	body := scrape(t)
+	for _, want := range []string{
+		"# HELP test_requests_total Requests.\n# TYPE test_requests_total counter\n",
+		`test_requests_total{code="200"} 3`,
+		`test_requests_total{code="5\"0\"0"} 1`,
+		"# TYPE test_temperature gauge\ntest_temperature 20.5\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in:\n%s", want, body)
+		}
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram("test_duration_seconds", "Duration.", []float64{1, 5}, "kind")
+	h.Observe(0.5, "a")
+	h.Observe(1, "a")
+	h.Observe(3, "a")
+	h.Observe(10, "a")
+
+	if n := h.Count("a"); n != 4 {
+		t.Errorf("expected 4 observations, got %d", n)
+	}
+	body := scrape(t)
+	for _, want := range []string{
+		`test_duration_seconds_bucket{kind="a",le="1"} 2`,
+		`test_duration_seconds_bucket{kind="a",le="5"} 3`,
+		`test_duration_seconds_bucket{kind="a",le="+Inf"} 4`,
+		`test_duration_seconds_sum{kind="a"} 14.5`,
+		`test_duration_seconds_count{kind="a"} 4`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in:\n%s", want, body)
+		}
+	}
+}
+
+func TestGaugeFunc(t *testing.T) {
+	NewGaugeFunc("test_queue", "Queue.", func(emit func(float64, ...string)) {
+		emit(2, "queued")
+		emit(1, "failed")
+	}, "status")
+
+	body := scrape(t)
+	if !strings.Contains(body, "test_queue{status=\"failed\"} 1\ntest_queue{status=\"queued\"} 2\n") {
+		t.Errorf("expected sorted series in:\n%s", body)
+	}
+}
+
+func TestLabelCountMismatch(t *testing.T) {
+	c := NewCounter("test_mismatch_total", "Mismatch.", "a", "b")
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for the wrong number of label values")
+		}
+	}()
+	c.Inc("only-one")
+}
@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORS_AllowedOrigin(t *testing.T) {
+	called := false
+	h := CORS([]string{"https://dash.example.com"}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	req := httptest.NewRequest("GET", "/sabnzbd/api?mode=queue", nil)
+	req.Header.Set("Origin", "https://dash.example.com")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if !called {
+		t.Error("expected request to reach handler")
+	}
+	if rec.Header().Get("Access-Control-Allow-Origin") != "https://dash.example.com" {
+		t.Errorf("expected origin echoed, got %q", rec.Header().Get("Access-Control-Allow-Origin"))
+	}
+	if rec.Header().Get("Access-Control-Allow-Credentials") != "true" {
+		t.Error("expected credentials allowed for explicit origin")
+	}
+}
+
+func TestCORS_DisallowedOrigin(t *testing.T) {
+	h := CORS([]string{"https://dash.example.com"}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest("GET", "/sabnzbd/api", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Error("expected no CORS headers for unlisted origin")
+	}
+}
+
+func TestCORS_Preflight(t *testing.T) {
+	called := false
+	h := CORS([]string{"*"}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	req := httptest.NewRequest("OPTIONS", "/admin/api/keys", nil)
+	req.Header.Set("Origin", "https://anything.example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if called {
+		t.Error("preflight should not reach the handler")
+	}
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("expected 204, got %d", rec.Code)
+	}
+	if rec.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Error("expected wildcard origin")
+	}
+	if rec.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Error("wildcard origin must not allow credentials")
+	}
+}
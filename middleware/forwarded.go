@@ -0,0 +1,80 @@
+// Package middleware provides HTTP middleware shared by the slskrr endpoints.
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/nerney/slskrr/auth"
+)
+
+type (
+	basePathKey     struct{}
+	trustedProxyKey struct{}
+)
+
+// BasePath serves next under prefix (e.g. "/slskrr"), stripping it from the
+// request path and recording it so ExternalURL can rebuild self-links.
+// Requests outside the prefix get a 404.
+func BasePath(prefix string, next http.Handler) http.Handler {
+	stripped := http.StripPrefix(prefix, next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != prefix && !strings.HasPrefix(r.URL.Path, prefix+"/") {
+			http.NotFound(w, r)
+			return
+		}
+		ctx := context.WithValue(r.Context(), basePathKey{}, prefix)
+		stripped.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// TrustedProxies marks requests from proxies as coming through a trusted
+// reverse proxy, so ExternalURL honours their X-Forwarded headers. Anyone
+// else's are ignored: they would let a client point download links anywhere.
+func TrustedProxies(proxies []netip.Prefix, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth.FromProxy(r, proxies) {
+			r = r.WithContext(context.WithValue(r.Context(), trustedProxyKey{}, true))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ExternalURL returns the base URL clients should use to reach slskrr.
+// Behind a trusted reverse proxy it is rebuilt from X-Forwarded-Proto,
+// -Host and -Prefix plus the configured base path; otherwise fallback is
+// returned.
+func ExternalURL(r *http.Request, fallback string) string {
+	if trusted, _ := r.Context().Value(trustedProxyKey{}).(bool); !trusted {
+		return fallback
+	}
+	host := firstValue(r.Header.Get("X-Forwarded-Host"))
+	if host == "" {
+		return fallback
+	}
+	proto := firstValue(r.Header.Get("X-Forwarded-Proto"))
+	if proto != "http" && proto != "https" {
+		proto = "http"
+	}
+	prefix := strings.TrimSuffix(firstValue(r.Header.Get("X-Forwarded-Prefix")), "/")
+	if prefix != "" && !strings.HasPrefix(prefix, "/") {
+		prefix = "/" + prefix
+	}
+	// A proxy passing the base path through may also send it as the prefix.
+	base, _ := r.Context().Value(basePathKey{}).(string)
+	if strings.HasSuffix(prefix, base) {
+		base = ""
+	}
+	return proto + "://" + host + prefix + base
+}
+
+// firstValue returns the first entry of a comma-separated header value, as
+// set by chained proxies.
+func firstValue(v string) string {
+	if i := strings.IndexByte(v, ','); i >= 0 {
+		v = v[:i]
+	}
+	return strings.TrimSpace(v)
+}
@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+// httptest requests come from 192.0.2.1.
+var testProxies = []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}
+
+// externalURL returns what ExternalURL makes of req behind the middleware.
+func externalURL(h func(http.Handler) http.Handler, req *http.Request) string {
+	var got string
+	h(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = ExternalURL(r, "fallback")
+	})).ServeHTTP(httptest.NewRecorder(), req)
+	return got
+}
+
+func TestExternalURL_Fallback(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api", nil)
+	if got := ExternalURL(req, "http://localhost:6969"); got != "http://localhost:6969" {
+		t.Errorf("expected fallback, got %s", got)
+	}
+}
+
+func TestExternalURL_Forwarded(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api", nil)
+	req.Header.Set("X-Forwarded-Proto", "https, http")
+	req.Header.Set("X-Forwarded-Host", "media.example.com, internal")
+	req.Header.Set("X-Forwarded-Prefix", "/slskrr/")
+
+	trusted := func(next http.Handler) http.Handler { return TrustedProxies(testProxies, next) }
+	want := "https://media.example.com/slskrr"
+	if got := externalURL(trusted, req); got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+
+	untrusted := func(next http.Handler) http.Handler { return TrustedProxies(nil, next) }
+	if got := externalURL(untrusted, req); got != "fallback" {
+		t.Errorf("expected headers from an untrusted address ignored, got %s", got)
+	}
+	req.RemoteAddr = "203.0.113.7:4000"
+	if got := externalURL(trusted, req); got != "fallback" {
+		t.Errorf("expected headers from outside the proxies ignored, got %s", got)
+	}
+}
+
+func TestExternalURL_BasePathForwarded(t *testing.T) {
+	behind := func(next http.Handler) http.Handler {
+		return TrustedProxies(testProxies, BasePath("/slskrr", next))
+	}
+	for prefix, want := range map[string]string{
+		"":              "https://media.example.com/slskrr",
+		"/slskrr":       "https://media.example.com/slskrr",
+		"/apps":         "https://media.example.com/apps/slskrr",
+		"/apps/slskrr/": "https://media.example.com/apps/slskrr",
+	} {
+		req := httptest.NewRequest("GET", "/slskrr/api", nil)
+		req.Header.Set("X-Forwarded-Proto", "https")
+		req.Header.Set("X-Forwarded-Host", "media.example.com")
+		req.Header.Set("X-Forwarded-Prefix", prefix)
+		if got := externalURL(behind, req); got != want {
+			t.Errorf("prefix %q: expected %s, got %s", prefix, want, got)
+		}
+	}
+}
+
+func TestBasePath(t *testing.T) {
+	var gotPath, gotURL string
+	h := TrustedProxies(testProxies, BasePath("/slskrr", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotURL = ExternalURL(r, "fallback")
+	})))
+
+	req := httptest.NewRequest("GET", "/slskrr/api?t=caps", nil)
+	req.Header.Set("X-Forwarded-Host", "example.com")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if gotPath != "/api" {
+		t.Errorf("expected stripped path /api, got %s", gotPath)
+	}
+	if gotURL != "http://example.com/slskrr" {
+		t.Errorf("expected base path in external URL, got %s", gotURL)
+	}
+
+	req = httptest.NewRequest("GET", "/api", nil)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 outside base path, got %d", rec.Code)
+	}
+}
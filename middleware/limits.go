@@ -0,0 +1,21 @@
+package middleware
+
+import (
+	"net/http"
+)
+
+// Limits rejects requests whose URL exceeds maxURL bytes with 414 and caps
+// request bodies at maxBody bytes; handlers reading past the cap get an
+// *http.MaxBytesError. Zero disables the corresponding limit.
+func Limits(maxURL int, maxBody int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if maxURL > 0 && len(r.URL.RawPath)+len(r.URL.Path)+len(r.URL.RawQuery) > maxURL {
+			http.Error(w, "URI too long", http.StatusRequestURITooLong)
+			return
+		}
+		if maxBody > 0 && r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, maxBody)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
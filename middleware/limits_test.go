@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLimits_URLTooLong(t *testing.T) {
+	called := false
+	h := Limits(64, 0, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	req := httptest.NewRequest("GET", "/api?t=search&q="+strings.Repeat("a", 100), nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusRequestURITooLong {
+		t.Errorf("expected 414, got %d", rec.Code)
+	}
+	if called {
+		t.Error("handler should not run for oversized URL")
+	}
+}
+
+func TestLimits_BodyTooLarge(t *testing.T) {
+	var readErr error
+	h := Limits(0, 10, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, readErr = io.ReadAll(r.Body)
+	}))
+
+	req := httptest.NewRequest("POST", "/sabnzbd/api", strings.NewReader(strings.Repeat("x", 100)))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	var maxErr *http.MaxBytesError
+	if !errors.As(readErr, &maxErr) {
+		t.Errorf("expected MaxBytesError, got %v", readErr)
+	}
+}
@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLogging_AssignsRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(ContextHandler{slog.NewTextHandler(&buf, nil)}))
+	defer slog.SetDefault(prev)
+
+	var seen string
+	h := Logging(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestID(r.Context())
+		slog.InfoContext(r.Context(), "inside handler")
+	}))
+
+	req := httptest.NewRequest("GET", "/api?t=search&apikey=secret", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if seen == "" {
+		t.Fatal("expected request ID in handler context")
+	}
+	if rec.Header().Get("X-Request-Id") != seen {
+		t.Errorf("expected response header %s, got %s", seen, rec.Header().Get("X-Request-Id"))
+	}
+
+	logs := buf.String()
+	if strings.Count(logs, "request_id="+seen) != 2 {
+		t.Errorf("expected handler and access log lines tagged with request ID, got: %s", logs)
+	}
+	if strings.Contains(logs, "secret") {
+		t.Error("access log must not include the API key")
+	}
+}
+
+func TestLogging_ReusesIncomingID(t *testing.T) {
+	var seen string
+	h := Logging(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestID(r.Context())
+	}))
+
+	req := httptest.NewRequest("GET", "/health", nil)
+	req.Header.Set("X-Request-Id", "from-proxy-123")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if seen != "from-proxy-123" {
+		t.Errorf("expected incoming ID reused, got %s", seen)
+	}
+
+	req.Header.Set("X-Request-Id", "bad id\nwith newline")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if seen == "bad id\nwith newline" {
+		t.Error("expected invalid incoming ID to be replaced")
+	}
+}
@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_BurstAndRefill(t *testing.T) {
+	l := NewRateLimiter(60, 2) // 1 token/second, burst 2
+	now := time.Unix(1000, 0)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatalf("request %d should be allowed within burst", i+1)
+		}
+	}
+	ok, wait := l.Allow("a")
+	if ok {
+		t.Fatal("expected third request to be limited")
+	}
+	if wait <= 0 || wait > time.Second {
+		t.Errorf("expected wait up to 1s, got %v", wait)
+	}
+
+	// Other clients have their own bucket
+	if ok, _ := l.Allow("b"); !ok {
+		t.Error("expected separate bucket for another client")
+	}
+
+	now = now.Add(time.Second)
+	if ok, _ := l.Allow("a"); !ok {
+		t.Error("expected token to refill after 1s")
+	}
+}
+
+func TestRateLimiter_NilAllowsAll(t *testing.T) {
+	l := NewRateLimiter(0, 0)
+	if l != nil {
+		t.Fatal("expected nil limiter when disabled")
+	}
+	if ok, _ := l.Allow("a"); !ok {
+		t.Error("nil limiter should allow")
+	}
+}
+
+func TestClientKey(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api?apikey=abc", nil)
+	if got := ClientKey(req); got != "key:abc" {
+		t.Errorf("expected key:abc, got %s", got)
+	}
+	req = httptest.NewRequest("GET", "/api", nil)
+	req.RemoteAddr = "10.0.0.5:1234"
+	if got := ClientKey(req); got != "ip:10.0.0.5" {
+		t.Errorf("expected ip:10.0.0.5, got %s", got)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	if got := RetryAfter(1500 * time.Millisecond); got != "2" {
+		t.Errorf("expected 2, got %s", got)
+	}
+	if got := RetryAfter(0); got != "1" {
+		t.Errorf("expected 1, got %s", got)
+	}
+}
@@ -0,0 +1,197 @@
+// Package musicbrainz looks up artists and albums on MusicBrainz, so
+// Lidarr's searches by MBID can be run with the canonical names and album
+// folders checked against the album's track count.
+package musicbrainz
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBaseURL is the MusicBrainz web service.
+const DefaultBaseURL = "https://musicbrainz.org/ws/2"
+
+// userAgent identifies slskrr, as MusicBrainz requires of every client.
+const userAgent = "slskrr ( https://github.com/nerney/slskrr )"
+
+// Client talks to the MusicBrainz web service. Requests are spaced a
+// second apart, MusicBrainz's rate limit, and lookups are cached for the
+// life of the process.
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	Interval   time.Duration // least time between requests; 0 means none
+
+	mu      sync.Mutex
+	cache   map[string]any
+	limitMu sync.Mutex
+	last    time.Time
+}
+
+func NewClient() *Client {
+	return &Client{
+		BaseURL:    DefaultBaseURL,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+		Interval:   time.Second,
+	}
+}
+
+// Album is the part of a MusicBrainz release group searches use.
+// TrackCounts are the distinct track counts of its releases, DiscTracks
+// those of their individual discs.
+type Album struct {
+	Artist      string
+	Title       string
+	Year        string
+	TrackCounts []int
+	DiscTracks  []int
+}
+
+// Complete reports whether a folder of tracks holds a whole release of the
+// album, or a whole disc of one. Without track counts any folder is.
+func (a *Album) Complete(tracks int) bool {
+	if len(a.TrackCounts) == 0 {
+		return true
+	}
+	return slices.Contains(a.TrackCounts, tracks) || slices.Contains(a.DiscTracks, tracks)
+}
+
+// Artist looks up an artist's name by MBID.
+func (c *Client) Artist(ctx context.Context, mbid string) (string, error) {
+	if v, ok := c.cached("artist:" + mbid); ok {
+		return v.(string), nil
+	}
+	var artist struct {
+		Name string `json:"name"`
+	}
+	if err := c.get(ctx, "/artist/"+url.PathEscape(mbid), nil, &artist); err != nil {
+		return "", fmt.Errorf("get artist %s: %w", mbid, err)
+	}
+	c.store("artist:"+mbid, artist.Name)
+	return artist.Name, nil
+}
+
+// Album looks up an album by its release group MBID, which is what Lidarr
+// knows albums by, along with the track counts of its releases.
+func (c *Client) Album(ctx context.Context, mbid string) (*Album, error) {
+	if v, ok := c.cached("album:" + mbid); ok {
+		return v.(*Album), nil
+	}
+
+	var group struct {
+		Title        string `json:"title"`
+		FirstRelease string `json:"first-release-date"`
+		ArtistCredit []struct {
+			Name       string `json:"name"`
+			JoinPhrase string `json:"joinphrase"`
+		} `json:"artist-credit"`
+	}
+	err := c.get(ctx, "/release-group/"+url.PathEscape(mbid), url.Values{"inc": {"artist-credits"}}, &group)
+	if err != nil {
+		return nil, fmt.Errorf("get album %s: %w", mbid, err)
+	}
+	a := &Album{Title: group.Title}
+	for _, credit := range group.ArtistCredit {
+		a.Artist += credit.Name + credit.JoinPhrase
+	}
+	if len(group.FirstRelease) >= 4 {
+		a.Year = group.FirstRelease[:4]
+	}
+
+	var releases struct {
+		Releases []struct {
+			Media []struct {
+				TrackCount int `json:"track-count"`
+			} `json:"media"`
+		} `json:"releases"`
+	}
+	err = c.get(ctx, "/release", url.Values{"release-group": {mbid}, "inc": {"media"}, "limit": {"100"}}, &releases)
+	if err != nil {
+		return nil, fmt.Errorf("get releases of %s: %w", mbid, err)
+	}
+	for _, r := range releases.Releases {
+		total := 0
+		for _, m := range r.Media {
+			total += m.TrackCount
+			if len(r.Media) > 1 && !slices.Contains(a.DiscTracks, m.TrackCount) {
+				a.DiscTracks = append(a.DiscTracks, m.TrackCount)
+			}
+		}
+		if total > 0 && !slices.Contains(a.TrackCounts, total) {
+			a.TrackCounts = append(a.TrackCounts, total)
+		}
+	}
+	slices.Sort(a.TrackCounts)
+	slices.Sort(a.DiscTracks)
+
+	c.store("album:"+mbid, a)
+	return a, nil
+}
+
+func (c *Client) cached(key string) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.cache[key]
+	return v, ok
+}
+
+func (c *Client) store(key string, v any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cache == nil {
+		c.cache = make(map[string]any)
+	}
+	c.cache[key] = v
+}
+
+// wait blocks until Interval has passed since the last request.
+func (c *Client) wait(ctx context.Context) error {
+	c.limitMu.Lock()
+	defer c.limitMu.Unlock()
+	if d := time.Until(c.last.Add(c.Interval)); d > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(d):
+		}
+	}
+	c.last = time.Now()
+	return nil
+}
+
+// get requests endpoint as JSON, decoding the response into out.
+func (c *Client) get(ctx context.Context, endpoint string, q url.Values, out any) error {
+	if q == nil {
+		q = url.Values{}
+	}
+	q.Set("fmt", "json")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(c.BaseURL, "/")+endpoint+"?"+q.Encode(), nil)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "application/json")
+	if err := c.wait(ctx); err != nil {
+		return err
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("request failed with status %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package musicbrainz
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestClient_Album(t *testing.T) {
+	calls := 0
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Query().Get("fmt") != "json" || !strings.HasPrefix(r.Header.Get("User-Agent"), "slskrr") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch r.URL.Path {
+		case "/release-group/rg1":
+			w.Write([]byte(`{"title":"Album","first-release-date":"1997-05-21","artist-credit":[{"name":"A","joinphrase":" & "},{"name":"B","joinphrase":""}]}`))
+		case "/release":
+			if r.URL.Query().Get("release-group") != "rg1" {
+				t.Errorf("expected the group's releases browsed, got %s", r.URL.RawQuery)
+			}
+			w.Write([]byte(`{"releases":[{"media":[{"track-count":12}]},{"media":[{"track-count":10},{"track-count":8}]},{"media":[{"track-count":12}]}]}`))
+		case "/artist/ar1":
+			w.Write([]byte(`{"name":"Artist"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer mock.Close()
+
+	c := NewClient()
+	c.BaseURL = mock.URL
+	c.Interval = 0
+
+	a, err := c.Album(context.Background(), "rg1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if a.Artist != "A & B" || a.Title != "Album" || a.Year != "1997" {
+		t.Errorf("unexpected album: %+v", a)
+	}
+	if !slices.Equal(a.TrackCounts, []int{12, 18}) || !slices.Equal(a.DiscTracks, []int{8, 10}) {
+		t.Errorf("unexpected track counts %v and %v", a.TrackCounts, a.DiscTracks)
+	}
+	if !a.Complete(12) || !a.Complete(10) || a.Complete(11) {
+		t.Error("expected 12 tracks or a 10-track disc to be complete, and 11 not")
+	}
+	if _, err := c.Album(context.Background(), "rg1"); err != nil || calls != 2 {
+		t.Errorf("expected the lookup cached, got %d calls (%v)", calls, err)
+	}
+
+	if name, err := c.Artist(context.Background(), "ar1"); err != nil || name != "Artist" {
+		t.Errorf("unexpected artist %q (%v)", name, err)
+	}
+	if _, err := c.Album(context.Background(), "missing"); err == nil {
+		t.Error("expected an error for an unknown album")
+	}
+	if !(&Album{}).Complete(3) {
+		t.Error("expected any folder complete without track counts")
+	}
+}
@@ -0,0 +1,254 @@
+// Package naming synthesizes release titles the *arr apps can parse from
+// the paths Soulseek peers share. A file named "01 - Track.flac" or
+// "movie.mkv" says little on its own; the folders above it usually name
+// the release, and the peer's file attributes hint at its quality.
+package naming
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/nerney/slskrr/postprocess"
+)
+
+// Hints are what a search knows about a release beyond its path.
+type Hints struct {
+	Artist, Album string // the album a music search asked for
+	BitRate       int    // kbps, as the peer reports it
+	BitDepth      int
+}
+
+var (
+	// titleYear splits "Movie Title (2024) ..." into the title and year,
+	// the last year-like number being the year.
+	titleYear = regexp.MustCompile(`^(.+)[\s.(\[_-]+((?:19|20)\d{2})(?:[\s.)\]_-]|$)`)
+	// episodeTag matches S01E05 and 1x05.
+	episodeTag = regexp.MustCompile(`(?i)\bS(\d{1,2})[ ._-]?E(\d{1,3})|\b(\d{1,2})x(\d{2,3})\b`)
+	// episodeOnly matches an episode number without its season, as in
+	// "Season 1/Episode 05.mkv".
+	episodeOnly = regexp.MustCompile(`(?i)\b(?:e|ep|episode)[\s._-]*(\d{1,3})\b`)
+	// seasonFolder matches folders like "Season 1" or "S01".
+	seasonFolder = regexp.MustCompile(`(?i)^(?:season|series|s)[\s._-]*(\d{1,2})$`)
+	// seasonSuffix cuts a season off a show folder like "Show S01".
+	seasonSuffix = regexp.MustCompile(`(?i)[\s._-]+(?:S\d{1,2}\b|season[\s._-]*\d).*$`)
+	resolution   = regexp.MustCompile(`(?i)\b(2160p|1080p|720p|576p|480p|4k|uhd)\b`)
+	source       = regexp.MustCompile(`(?i)\b(blu-?ray|bdrip|brrip|web-?dl|webrip|hdtv|dvdrip|remux)\b`)
+	releaseYear  = regexp.MustCompile(`^(?:19|20)\d{2}$`)
+	// albumYear finds "(2020)" or "[2020]" in an album folder.
+	albumYear = regexp.MustCompile(`\s*[(\[]((?:19|20)\d{2})[)\]]`)
+)
+
+var sources = map[string]string{
+	"bluray": "BluRay", "blu-ray": "BluRay", "bdrip": "BDRip", "brrip": "BRRip",
+	"web-dl": "WEB-DL", "webdl": "WEB-DL", "webrip": "WEBRip", "hdtv": "HDTV",
+	"dvdrip": "DVDRip", "remux": "Remux",
+}
+
+// Movie titles a movie file like "Movie.Title.2024.1080p.BluRay", taking
+// the title and year from the file name or the nearest folder naming them.
+// ok is false when no year is found.
+func Movie(remote string) (title string, ok bool) {
+	names := candidates(remote)
+	for _, name := range names {
+		if m := titleYear.FindStringSubmatch(name); m != nil {
+			return dotted(m[1]) + "." + m[2] + quality(names), true
+		}
+	}
+	return "", false
+}
+
+// Episode titles an episode file like "Show.Name.S01E05.1080p", taking the
+// show from the file name or the folders above it. ok is false when no
+// episode number is found.
+func Episode(remote string) (title string, ok bool) {
+	names := candidates(remote)
+	file, dirs := names[0], names[1:]
+
+	var season, episode int
+	var show string
+	if loc := episodeTag.FindStringSubmatchIndex(file); loc != nil {
+		g := 2 // S01E05, else 1x05
+		if loc[g] < 0 {
+			g = 6
+		}
+		season, _ = strconv.Atoi(file[loc[g]:loc[g+1]])
+		episode, _ = strconv.Atoi(file[loc[g+2]:loc[g+3]])
+		show = file[:loc[0]]
+	} else if m := episodeOnly.FindStringSubmatch(file); m != nil {
+		found := false
+		for _, dir := range dirs {
+			if s := seasonFolder.FindStringSubmatch(dir); s != nil {
+				season, _ = strconv.Atoi(s[1])
+				found = true
+				break
+			}
+		}
+		if !found {
+			return "", false
+		}
+		episode, _ = strconv.Atoi(m[1])
+	} else {
+		return "", false
+	}
+
+	show = strings.Trim(show, " ._-")
+	if show == "" {
+		show = showFolder(dirs)
+	}
+	if show == "" {
+		return "", false
+	}
+	return fmt.Sprintf("%s.S%02dE%02d%s", dotted(show), season, episode, quality(names)), true
+}
+
+// Season titles a season pack from one of its episodes, like
+// "Show.Name.S01.1080p". ok is false when the show can't be named.
+func Season(remote string, season int) (title string, ok bool) {
+	names := candidates(remote)
+	show := ""
+	if loc := episodeTag.FindStringIndex(names[0]); loc != nil {
+		show = strings.Trim(names[0][:loc[0]], " ._-")
+	}
+	if show == "" {
+		show = showFolder(names[1:])
+	}
+	if show == "" {
+		return "", false
+	}
+	return fmt.Sprintf("%s.S%02d%s", dotted(show), season, quality(names)), true
+}
+
+// Album titles an album from one of its tracks, like
+// "Artist - Album (2020) [FLAC]". Folders named "Artist - Album (Year)",
+// or "Artist/Album (Year)", are understood; hints fill in or override the
+// artist and album. ok is false when no artist is known.
+func Album(track string, h Hints) (title string, ok bool) {
+	names := candidates(track)
+	var dirs []string
+	for _, dir := range names[1:] {
+		if !postprocess.IsDiscFolder(dir) && !postprocess.IsFormatFolder(dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	var artist, album, year string
+	if len(dirs) > 0 {
+		album = dirs[0]
+		a, b, found := strings.Cut(album, " - ")
+		switch {
+		case found && releaseYear.MatchString(a): // "2020 - Album" under the artist
+			year, album = a, b
+			if len(dirs) > 1 {
+				artist = dirs[1]
+			}
+		case found:
+			artist, album = a, b
+		case len(dirs) > 1:
+			artist = dirs[1]
+		}
+		if m := albumYear.FindStringSubmatch(album); m != nil {
+			year = m[1]
+			album = albumYear.ReplaceAllString(album, "")
+		}
+	}
+	if h.Artist != "" {
+		artist = h.Artist
+	}
+	if h.Album != "" {
+		album = h.Album
+	}
+	artist, album = strings.TrimSpace(artist), strings.TrimSpace(album)
+	if artist == "" || album == "" {
+		return "", false
+	}
+
+	title = artist + " - " + album
+	if year != "" {
+		title += " (" + year + ")"
+	}
+	if format := audioFormat(track, h); format != "" {
+		title += " [" + format + "]"
+	}
+	return title, true
+}
+
+// audioFormat names a track's format the way release titles do, e.g.
+// "FLAC 24bit" or "MP3-320".
+func audioFormat(name string, h Hints) string {
+	ext := strings.ToUpper(strings.TrimPrefix(path.Ext(name), "."))
+	switch {
+	case ext == "":
+		return ""
+	case ext == "FLAC" && h.BitDepth >= 24:
+		return fmt.Sprintf("FLAC %dbit", h.BitDepth)
+	case ext == "MP3" && h.BitRate > 0:
+		return fmt.Sprintf("MP3-%d", h.BitRate)
+	}
+	return ext
+}
+
+// candidates returns the file name without its extension, then its
+// folders nearest first.
+func candidates(remote string) []string {
+	parts := strings.FieldsFunc(remote, func(r rune) bool { return r == '\\' || r == '/' })
+	if len(parts) == 0 {
+		return []string{""}
+	}
+	file := parts[len(parts)-1]
+	names := []string{strings.TrimSuffix(file, path.Ext(file))}
+	for i := len(parts) - 2; i >= 0; i-- {
+		names = append(names, strings.TrimSpace(parts[i]))
+	}
+	return names
+}
+
+// showFolder returns the nearest folder naming a show, skipping season
+// and disc folders.
+func showFolder(dirs []string) string {
+	for _, dir := range dirs {
+		if dir == "" || seasonFolder.MatchString(dir) || postprocess.IsDiscFolder(dir) {
+			continue
+		}
+		return strings.Trim(seasonSuffix.ReplaceAllString(dir, ""), " ._-")
+	}
+	return ""
+}
+
+// quality returns the resolution and source the names mention, nearest
+// first, as a title suffix like ".1080p.BluRay".
+func quality(names []string) string {
+	var res, src string
+	for _, name := range names {
+		name = strings.ReplaceAll(name, "_", " ")
+		if m := resolution.FindString(name); res == "" && m != "" {
+			res = strings.ToLower(m)
+			if res == "4k" || res == "uhd" {
+				res = "2160p"
+			}
+		}
+		if m := source.FindString(name); src == "" && m != "" {
+			src = sources[strings.ToLower(m)]
+		}
+	}
+	var s string
+	for _, part := range []string{res, src} {
+		if part != "" {
+			s += "." + part
+		}
+	}
+	return s
+}
+
+// dotted joins the words of s with dots, as scene release names do,
+// dropping apostrophes and other punctuation.
+func dotted(s string) string {
+	s = strings.NewReplacer("'", "", "’", "").Replace(s)
+	words := strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '&'
+	})
+	return strings.Join(words, ".")
+}
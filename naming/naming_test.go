@@ -0,0 +1,63 @@
+package naming
+
+import "testing"
+
+func TestMovie(t *testing.T) {
+	tests := map[string]string{
+		`Movies\Movie Title (2024)\movie.mkv`:                 "Movie.Title.2024",
+		`Movies\Movie.Title.2024.1080p.BluRay.x264\movie.mkv`: "Movie.Title.2024.1080p.BluRay",
+		`Movie Title 2024 720p WEB-DL.mp4`:                    "Movie.Title.2024.720p.WEB-DL",
+		`Films\Blade Runner 2049 (2017) 4K\film.mkv`:          "Blade.Runner.2049.2017.2160p",
+		`Films\Schindler's List (1993)\CD1\part1.avi`:         "Schindlers.List.1993",
+	}
+	for remote, want := range tests {
+		if got, ok := Movie(remote); !ok || got != want {
+			t.Errorf("Movie(%q) = %q, %v; want %q", remote, got, ok, want)
+		}
+	}
+	if got, ok := Movie(`Movies\movie.mkv`); ok {
+		t.Errorf("expected no title without a year, got %q", got)
+	}
+}
+
+func TestEpisode(t *testing.T) {
+	tests := map[string]string{
+		`TV\Show\Season 1\Show.S01E05.1080p.mkv`:        "Show.S01E05.1080p",
+		`TV\The Show\Season 02\05 - Episode 5.mkv`:      "The.Show.S02E05",
+		`TV\The Show S03 720p\the show 3x07.mkv`:        "the.show.S03E07.720p",
+		`TV\Some Show (2019)\Season 1\S01E02 Pilot.mkv`: "Some.Show.2019.S01E02",
+	}
+	for remote, want := range tests {
+		if got, ok := Episode(remote); !ok || got != want {
+			t.Errorf("Episode(%q) = %q, %v; want %q", remote, got, ok, want)
+		}
+	}
+	if got, ok := Episode(`Movies\Movie (2020)\movie.mkv`); ok {
+		t.Errorf("expected no title without an episode, got %q", got)
+	}
+	if got, ok := Season(`TV\Show\Season 1\Show.S01E05.1080p.mkv`, 1); !ok || got != "Show.S01.1080p" {
+		t.Errorf("Season = %q, %v", got, ok)
+	}
+}
+
+func TestAlbum(t *testing.T) {
+	tests := []struct {
+		track string
+		hints Hints
+		want  string
+	}{
+		{`Music\Artist - Album (2020)\01 - Track.flac`, Hints{}, "Artist - Album (2020) [FLAC]"},
+		{`Music\Artist\Album [2020]\CD1\01 - Track.flac`, Hints{BitDepth: 24}, "Artist - Album (2020) [FLAC 24bit]"},
+		{`Music\Artist\2020 - Album\FLAC\01.flac`, Hints{}, "Artist - Album (2020) [FLAC]"},
+		{`Album\01 - Track.mp3`, Hints{Artist: "Artist", BitRate: 320}, "Artist - Album [MP3-320]"},
+		{`Music\Artist - Albm\01.flac`, Hints{Album: "Album"}, "Artist - Album [FLAC]"},
+	}
+	for _, tc := range tests {
+		if got, ok := Album(tc.track, tc.hints); !ok || got != tc.want {
+			t.Errorf("Album(%q) = %q, %v; want %q", tc.track, got, ok, tc.want)
+		}
+	}
+	if got, ok := Album(`01 - Track.flac`, Hints{}); ok {
+		t.Errorf("expected no title without an artist, got %q", got)
+	}
+}
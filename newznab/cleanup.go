@@ -0,0 +1,84 @@
+package newznab
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// QueryRule is a cleanup applied to an app's query before it is searched.
+// Sonarr and Radarr send titles as their metadata spells them, and the
+// extra words rarely appear in Soulseek file names.
+type QueryRule string
+
+const (
+	RuleRegion QueryRule = "region" // drop a parenthesized country code such as (US)
+	RuleAKA    QueryRule = "aka"    // search "Title aka Other Title" as each title in turn
+	RuleColon  QueryRule = "colon"  // replace colons, which Soulseek keeps as part of a word, with spaces
+)
+
+// DefaultQueryRules are the cleanups applied unless configured otherwise.
+var DefaultQueryRules = []QueryRule{RuleRegion, RuleAKA, RuleColon}
+
+var (
+	regionTag = regexp.MustCompile(`\s*\([A-Z]{2}\)`)
+	akaSep    = regexp.MustCompile(`(?i)\s+a\.?k\.?a\.?\s+`)
+)
+
+// ParseQueryRules parses a comma-separated list of rules; "none" disables
+// cleanup.
+func ParseQueryRules(s string) ([]QueryRule, error) {
+	if strings.EqualFold(strings.TrimSpace(s), "none") {
+		return nil, nil
+	}
+	var rules []QueryRule
+	for _, name := range strings.Split(s, ",") {
+		switch r := QueryRule(strings.ToLower(strings.TrimSpace(name))); r {
+		case "":
+		case RuleRegion, RuleAKA, RuleColon:
+			if !slices.Contains(rules, r) {
+				rules = append(rules, r)
+			}
+		default:
+			return nil, fmt.Errorf("unknown rule %q: want region, aka, colon or none", name)
+		}
+	}
+	return rules, nil
+}
+
+// cleanQuery applies h.QueryCleanup to q, returning the queries to search:
+// the cleaned query first, then any alternate titles it named. A trailing
+// year stays on every query so the year fallback still applies.
+func (h *Handler) cleanQuery(q string) []string {
+	if len(h.QueryCleanup) == 0 {
+		return []string{q}
+	}
+	year := ""
+	if loc := yearSuffix.FindStringIndex(q); loc != nil {
+		q, year = q[:loc[0]], q[loc[0]:]
+	}
+
+	titles := []string{q}
+	if slices.Contains(h.QueryCleanup, RuleRegion) {
+		titles[0] = regionTag.ReplaceAllString(titles[0], "")
+	}
+	if slices.Contains(h.QueryCleanup, RuleAKA) {
+		titles = akaSep.Split(titles[0], -1)
+	}
+
+	var queries []string
+	for _, t := range titles {
+		if slices.Contains(h.QueryCleanup, RuleColon) {
+			t = strings.ReplaceAll(t, ":", " ")
+		}
+		t = strings.Join(strings.Fields(t), " ")
+		if t != "" && !slices.Contains(queries, t+year) {
+			queries = append(queries, t+year)
+		}
+	}
+	if len(queries) == 0 {
+		return []string{q + year}
+	}
+	return queries
+}
@@ -0,0 +1,140 @@
+package newznab
+
+import (
+	"cmp"
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nerney/slskrr/slskd"
+	"github.com/nerney/slskrr/textnorm"
+)
+
+// recentSearches remembers the responses to recent queries, so a query
+// repeated within the cooldown is answered without searching Soulseek again.
+type recentSearches struct {
+	mu      sync.Mutex
+	entries map[string]*recentSearch
+}
+
+type recentSearch struct {
+	query     string
+	responses []slskd.SearchResponse
+	at        time.Time
+	hits      int // times reused, halved on every refresh round
+}
+
+// normalizeQuery folds case, whitespace and Unicode composition, so
+// "The  Matrix" and "the matrix" count as the same query.
+func normalizeQuery(query string) string {
+	return strings.Join(strings.Fields(textnorm.Fold(query)), " ")
+}
+
+// recentKey is the cache key of query. A search that stopped early holds
+// only the results its filter wanted, so it is kept apart under a scope
+// naming that filter; a complete search has no scope and suits any filter.
+func recentKey(query, scope string) string {
+	if scope == "" {
+		return normalizeQuery(query)
+	}
+	return normalizeQuery(query) + "\x00" + scope
+}
+
+// get returns the responses to query if it was searched within cooldown,
+// either completely or stopping early for the same scope.
+func (r *recentSearches) get(query, scope string, cooldown time.Duration) ([]slskd.SearchResponse, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, key := range []string{recentKey(query, ""), recentKey(query, scope)} {
+		if e, ok := r.entries[key]; ok && time.Since(e.at) < cooldown {
+			e.hits++
+			return e.responses, true
+		}
+	}
+	return nil, false
+}
+
+// put records the responses to query searched for scope, dropping entries
+// older than cooldown.
+func (r *recentSearches) put(query, scope string, responses []slskd.SearchResponse, cooldown time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.entries == nil {
+		r.entries = make(map[string]*recentSearch)
+	}
+	for k, e := range r.entries {
+		if time.Since(e.at) >= cooldown {
+			delete(r.entries, k)
+		}
+	}
+	key := recentKey(query, scope)
+	if e, ok := r.entries[key]; ok {
+		e.responses, e.at = responses, time.Now()
+		return
+	}
+	r.entries[key] = &recentSearch{query: query, responses: responses, at: time.Now()}
+}
+
+// hot returns up to n queries reused since the last round, most reused
+// first, and halves every count so queries nobody repeats cool off.
+func (r *recentSearches) hot(n int) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var hot []*recentSearch
+	for _, e := range r.entries {
+		if e.hits > 0 {
+			hot = append(hot, e)
+		}
+	}
+	slices.SortFunc(hot, func(a, b *recentSearch) int { return cmp.Compare(b.hits, a.hits) })
+	// A query reused under several scopes is refreshed once, completely.
+	var queries []string
+	seen := make(map[string]bool)
+	for _, e := range hot {
+		if len(queries) == n {
+			break
+		}
+		if key := normalizeQuery(e.query); !seen[key] {
+			seen[key] = true
+			queries = append(queries, e.query)
+		}
+	}
+	for _, e := range r.entries {
+		e.hits /= 2
+	}
+	return queries
+}
+
+// RefreshHotSearches re-runs the HotSearches most reused queries every half
+// QueryCooldown until ctx is cancelled, so repeats of them are answered from
+// results that are never older than that.
+func (h *Handler) RefreshHotSearches(ctx context.Context) {
+	if h.HotSearches <= 0 || h.QueryCooldown <= 0 {
+		return
+	}
+	ticker := time.NewTicker(h.QueryCooldown / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, query := range h.recent.hot(h.HotSearches) {
+				responses, err := h.SlskdClient.SearchAndWait(ctx, query, h.SearchTimeout)
+				if err != nil {
+					if ctx.Err() != nil {
+						return
+					}
+					slog.Warn("hot search refresh failed", "query", query, "error", err)
+					continue
+				}
+				h.recent.put(query, "", responses, h.QueryCooldown)
+				slog.Debug("refreshed hot search", "query", query, "responses", len(responses))
+			}
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package newznab
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRecentSearches_Hot(t *testing.T) {
+	var r recentSearches
+	for _, q := range []string{"Album One", "Album Two", "Album Three"} {
+		r.put(q, "", nil, time.Hour)
+	}
+	for range 3 {
+		r.get("album two", "", time.Hour)
+	}
+	r.get("ALBUM  ONE", "", time.Hour)
+
+	if got := r.hot(5); !slices.Equal(got, []string{"Album Two", "Album One"}) {
+		t.Errorf("expected reused queries by hits, got %v", got)
+	}
+	// Counts halve each round: 3 → 1 → 0, 1 → 0.
+	if got := r.hot(5); !slices.Equal(got, []string{"Album Two"}) {
+		t.Errorf("expected only the hottest query left, got %v", got)
+	}
+	if got := r.hot(5); len(got) != 0 {
+		t.Errorf("expected every query cooled off, got %v", got)
+	}
+}
+
+func TestRecentSearches_Expiry(t *testing.T) {
+	var r recentSearches
+	r.put("old", "", nil, time.Hour)
+	r.entries["old"].at = time.Now().Add(-2 * time.Hour)
+	if _, ok := r.get("old", "", time.Hour); ok {
+		t.Error("expected an expired entry to miss")
+	}
+	r.put("new", "", nil, time.Hour)
+	if _, ok := r.entries["old"]; ok {
+		t.Error("expected expired entries pruned on put")
+	}
+}
+
+func TestRecentSearches_Scope(t *testing.T) {
+	var r recentSearches
+	r.put("Some Title", "movie", nil, time.Hour)
+	if _, ok := r.get("some title", "music", time.Hour); ok {
+		t.Error("expected a search stopped early for films to miss for music")
+	}
+	if _, ok := r.get("some title", "movie", time.Hour); !ok {
+		t.Error("expected a hit in the same scope")
+	}
+	r.put("Some Title", "", nil, time.Hour)
+	if _, ok := r.get("some title", "music", time.Hour); !ok {
+		t.Error("expected a complete search to suit any scope")
+	}
+	if got := r.hot(5); !slices.Equal(got, []string{"Some Title"}) {
+		t.Errorf("expected a query hot in two scopes refreshed once, got %v", got)
+	}
+}
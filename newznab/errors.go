@@ -0,0 +1,98 @@
+package newznab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/nerney/slskrr/slskd"
+)
+
+// Error codes from the newznab API specification. Prowlarr and the *arr
+// apps act on the code: 1xx is reported as a bad API key, 5xx as a limit
+// to back off from, and the rest shown with the description.
+const (
+	CodeIncorrectCredentials   = 100
+	CodeAccountSuspended       = 101
+	CodeInsufficientPrivileges = 102
+	CodeMissingParameter       = 200
+	CodeIncorrectParameter     = 201
+	CodeNoSuchFunction         = 202
+	CodeFunctionUnavailable    = 203
+	CodeNoSuchItem             = 300
+	CodeItemExists             = 310
+	CodeRequestLimit           = 500
+	CodeDownloadLimit          = 501
+	CodeUnknown                = 900
+	CodeAPIDisabled            = 910
+)
+
+// Error is a newznab API error, written to clients as an <error> document.
+type Error struct {
+	Code        int
+	Description string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("newznab error %d: %s", e.Code, e.Description)
+}
+
+var (
+	errCredentials   = &Error{CodeIncorrectCredentials, "Incorrect user credentials"}
+	errRequestLimit  = &Error{CodeRequestLimit, "Request limit reached"}
+	errDownloadLimit = &Error{CodeDownloadLimit, "Download limit reached"}
+	errNoSuchItem    = &Error{CodeNoSuchItem, "No such item"}
+)
+
+func missingParameter(name string) *Error {
+	return &Error{CodeMissingParameter, "Missing parameter (" + name + ")"}
+}
+
+func incorrectParameter(name string) *Error {
+	return &Error{CodeIncorrectParameter, "Incorrect parameter (" + name + ")"}
+}
+
+func noSuchFunction(name string) *Error {
+	return &Error{CodeNoSuchFunction, "No such function (" + name + ")"}
+}
+
+func functionUnavailable(name string) *Error {
+	return &Error{CodeFunctionUnavailable, "Function not available (" + name + ")"}
+}
+
+// unavailableFunctions are functions in the newznab specification that
+// slskrr doesn't implement, as opposed to unknown ones.
+var unavailableFunctions = map[string]bool{
+	"register":     true,
+	"details":      true,
+	"getnfo":       true,
+	"comments":     true,
+	"comments-add": true,
+	"cart-add":     true,
+	"cart-del":     true,
+	"user":         true,
+}
+
+// searchError maps a failed slskd search to an error that says why.
+func searchError(err error) *Error {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, slskd.ErrSearchQueueTimeout):
+		return &Error{CodeRequestLimit, "Too many searches queued, try again later"}
+	case errors.Is(err, context.DeadlineExceeded):
+		return &Error{CodeUnknown, "slskd search timed out"}
+	case errors.As(err, &netErr):
+		return &Error{CodeUnknown, "slskd is unreachable"}
+	default:
+		return &Error{CodeUnknown, "slskd search failed"}
+	}
+}
+
+func writeError(w http.ResponseWriter, err *Error) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusOK) // Newznab errors are returned as 200 with error XML
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<error code="%d" description="%s" />`, err.Code, xmlEscape(err.Description))
+}
@@ -1,22 +1,43 @@
 package newznab
 
 import (
-	"crypto/subtle"
+	"cmp"
+	"context"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"iter"
 	"log/slog"
+	"mime"
 	"net/http"
+	"net/url"
 	"path"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"text/template"
 	"time"
 
+	"github.com/nerney/slskrr/auth"
+	"github.com/nerney/slskrr/blocklist"
+	"github.com/nerney/slskrr/middleware"
+	"github.com/nerney/slskrr/musicbrainz"
+	"github.com/nerney/slskrr/naming"
+	"github.com/nerney/slskrr/postprocess"
+	"github.com/nerney/slskrr/searchlog"
 	"github.com/nerney/slskrr/slskd"
+	"github.com/nerney/slskrr/textnorm"
+	"github.com/nerney/slskrr/tmdb"
 )
 
 var yearSuffix = regexp.MustCompile(`\s+\(?\d{4}\)?$`)
 
+// episodeTag finds the S01E05 in an episode's filename.
+var episodeTag = regexp.MustCompile(`(?i)\bS(\d{1,2})[ ._-]?E(\d{1,3})`)
+
 // videoExtensions are file extensions we consider relevant for Movies/TV.
 var videoExtensions = map[string]bool{
 	".mkv":  true,
@@ -42,6 +63,35 @@ var audioExtensions = map[string]bool{
 	".alac": true,
 }
 
+// losslessExtensions are the audio formats ranked above lossy ones when a
+// peer's files are capped.
+var losslessExtensions = map[string]bool{
+	".flac": true,
+	".wav":  true,
+	".ape":  true,
+	".alac": true,
+}
+
+// IsVideo reports whether a peer's file is a movie or episode by its
+// extension.
+func IsVideo(filename string) bool {
+	return videoExtensions[strings.ToLower(path.Ext(strings.ReplaceAll(filename, "\\", "/")))]
+}
+
+// IsAudio reports whether a peer's file is music by its extension.
+func IsAudio(filename string) bool {
+	return audioExtensions[strings.ToLower(path.Ext(strings.ReplaceAll(filename, "\\", "/")))]
+}
+
+// adultExtensions are video formats common for adult content, accepted on
+// top of videoExtensions in adult searches.
+var adultExtensions = map[string]bool{
+	".mov":  true,
+	".flv":  true,
+	".mpg":  true,
+	".mpeg": true,
+}
+
 // audiobookExtensions are file extensions specific to audiobooks.
 var audiobookExtensions = map[string]bool{
 	".m4b": true,
@@ -49,21 +99,93 @@ var audiobookExtensions = map[string]bool{
 	".aax": true,
 }
 
+// subtitleExtensions are the subtitle formats offered along with the video
+// they're named after.
+var subtitleExtensions = map[string]bool{
+	".srt": true,
+	".sub": true,
+	".idx": true,
+	".ass": true,
+	".ssa": true,
+}
+
+// ebookExtensions are the formats offered in ebook searches, which
+// accept nothing else.
+var ebookExtensions = map[string]bool{
+	".epub": true,
+	".mobi": true,
+	".azw3": true,
+	".pdf":  true,
+	".cbz":  true,
+}
+
+// maxQueryLength bounds the search text forwarded to slskd; Soulseek queries
+// are short, so anything longer is a client bug or abuse.
+const maxQueryLength = 256
+
 // minVideoFileSize is the minimum file size (50MB) to filter out samples/trailers.
 const minVideoFileSize = 50 * 1024 * 1024
 
+// minAdultFileSize is the minimum video size (20MB) in adult searches,
+// where short scenes are common.
+const minAdultFileSize = 20 * 1024 * 1024
+
+// maxResults is the default for the most results returned per request.
+const maxResults = 100
+
 // minAudioFileSize is the minimum file size (1MB) to filter out tiny/corrupt files.
 const minAudioFileSize = 1 * 1024 * 1024
 
+// minEbookFileSize is the minimum ebook size (50KB); smaller files are
+// excerpts or broken conversions.
+const minEbookFileSize = 50 * 1024
+
+// minComicFileSize is the minimum comic archive size (1MB), a few pages of
+// images.
+const minComicFileSize = 1 * 1024 * 1024
+
+// TokenVersion is the FileToken version Encode writes. Version 1 tokens,
+// which predate the field, name one file or a Folder; version 2 adds Files.
+const TokenVersion = 2
+
+// maxTokenFiles bounds the files a token lists, keeping its URL short
+// enough for the apps. Larger releases get a Folder token instead.
+const maxTokenFiles = 50
+
 // FileToken encodes the slskd file info needed to queue a download later.
+// Artist and Album carry the album a music search asked for.
+//
+// A token listing Files is a multi-file release (an album, a season pack,
+// or a movie and its subtitles), grabbed as one download: Filename is the
+// peer's directory holding the files and Size their total. A Folder token
+// is a release too big to list, and grabbing it queues every audio file
+// in Filename, or every video file of a season pack.
 type FileToken struct {
-	Username string `json:"u"`
-	Filename string `json:"f"`
-	Size     int64  `json:"s"`
+	Version  int         `json:"v,omitempty"`
+	Username string      `json:"u"`
+	Filename string      `json:"f"`
+	Size     int64       `json:"s"`
+	Artist   string      `json:"a,omitempty"`
+	Album    string      `json:"b,omitempty"`
+	BitRate  int         `json:"r,omitempty"`
+	Folder   bool        `json:"d,omitempty"`
+	Files    []TokenFile `json:"m,omitempty"`
+}
+
+// TokenFile is a file of a multi-file token, named by what follows its
+// directory, separator included, so joining them gives the peer's path
+// whichever separator it uses.
+type TokenFile struct {
+	Name string `json:"n"`
+	Size int64  `json:"s"`
 }
 
 func EncodeToken(username, filename string, size int64) string {
-	t := FileToken{Username: username, Filename: filename, Size: size}
+	return FileToken{Username: username, Filename: filename, Size: size}.Encode()
+}
+
+func (t FileToken) Encode() string {
+	t.Version = TokenVersion
 	b, _ := json.Marshal(t)
 	return base64.URLEncoding.EncodeToString(b)
 }
@@ -77,21 +199,167 @@ func DecodeToken(token string) (*FileToken, error) {
 	if err := json.Unmarshal(b, &t); err != nil {
 		return nil, fmt.Errorf("unmarshal token: %w", err)
 	}
+	if t.Version > TokenVersion {
+		return nil, fmt.Errorf("unsupported token version %d", t.Version)
+	}
 	return &t, nil
 }
 
+// Downloads returns the files the token names, as slskd download
+// requests: those it lists, or the one it names. A Folder token's files
+// are only known by listing the peer's directory.
+func (t *FileToken) Downloads() []slskd.DownloadRequest {
+	if len(t.Files) == 0 {
+		return []slskd.DownloadRequest{{Filename: t.Filename, Size: t.Size}}
+	}
+	files := make([]slskd.DownloadRequest, len(t.Files))
+	for i, f := range t.Files {
+		files[i] = slskd.DownloadRequest{Filename: t.Filename + f.Name, Size: f.Size}
+	}
+	return files
+}
+
+// releaseToken returns the token of the release in dir made of files,
+// listing them unless there are too many.
+func releaseToken(t FileToken, dir string, files []*slskd.SlskdFile) FileToken {
+	t.Filename = dir
+	t.Size = 0
+	for _, f := range files {
+		t.Size += f.Size
+	}
+	if len(files) > maxTokenFiles {
+		t.Folder = true
+		return t
+	}
+	for _, f := range files {
+		t.Files = append(t.Files, TokenFile{Name: strings.TrimPrefix(f.Filename, dir), Size: f.Size})
+	}
+	return t
+}
+
 // Handler serves the Newznab API facade.
 type Handler struct {
-	SlskdClient   *slskd.Client
-	APIKey        string
-	SearchTimeout time.Duration
-	BaseURL       string // e.g. "http://localhost:6969" for constructing download URLs
+	SlskdClient    *slskd.Client
+	Keys           *auth.Keyring
+	SearchTimeout  time.Duration
+	MaxTimeout     time.Duration // most a request's timeout parameter can ask for; 0 ignores the parameter
+	BaseURL        string        // e.g. "http://localhost:6969" for constructing download URLs
+	ExternalURL    string        // when set, download URLs always use it, ignoring forwarded headers
+	Limiter        *middleware.RateLimiter
+	Blocklist      *blocklist.Blocklist // peers left out of results
+	SlskdBlacklist bool                 // also leave out the members of slskd's blacklisted group
+	MaxPeerFiles   int                  // best wanted files considered per peer response; 0 is unlimited
+	MaxFiles       int                  // files considered per search across all peers; 0 is unlimited
+	ResultLimit    int                  // most results per page, advertised in caps; 0 means maxResults
+	QueryCooldown  time.Duration        // repeats of a query within this are served from the last search; 0 disables
+	EarlyResults   int                  // results from peers scoring EarlyScore that end a search early; 0 waits out the timeout
+	EarlyScore     int                  // peer score (see slskd.SearchResponse.PeerScore) counted towards EarlyResults
+	RelaxEmpty     bool                 // when every file is filtered out, retry without the size floors
+	ReleaseNames   bool                 // title results with synthesized release names rather than file names
+	HotSearches    int                  // most reused queries kept fresh by RefreshHotSearches
+	QueryCleanup   []QueryRule          // cleanups applied to q before searching; nil searches it as sent
+
+	// TestResponse is what t=search without a query returns; empty means
+	// TestItem. TestResponses overrides it by API key label or search
+	// function, which otherwise return an empty feed.
+	TestResponse  TestResponse
+	TestResponses map[string]TestResponse
+
+	// TMDB, when set, resolves the ids of Radarr's movie searches, which
+	// caps then advertises, so the original title of a foreign film is
+	// searched alongside the translated one.
+	TMDB *tmdb.Client
+
+	// MusicBrainz, when set, resolves the artistmbid and albummbid of
+	// music searches, which caps then advertises, to canonical names, and
+	// drops album folders that don't hold a whole release.
+	MusicBrainz *musicbrainz.Client
+
+	// Adult adds the 6000 (XXX) categories to caps for Whisparr, and serves
+	// searches asking for them with adult-specific video rules.
+	Adult bool
+
+	// Searches, when set, records each search's slskd searches, raw
+	// responses and results, to be inspected through the admin API.
+	Searches *searchlog.Log
+
+	recent recentSearches
+
+	capsOnce sync.Once
+	caps     capsDocument
+}
+
+// capsDocument is the caps response, rendered once from the handler's
+// configuration, which doesn't change while it runs.
+type capsDocument struct {
+	body     string
+	etag     string
+	modified time.Time
+}
+
+// searchModes lists each search function's caps element and the parameters
+// handleSearch reads for it.
+var searchModes = []struct{ Element, Params string }{
+	{"search", "q"},
+	{"tv-search", "q,season,ep"},
+	{"movie-search", "q,year"},
+	{"music-search", "q,artist,album"},
+	{"book-search", "q,author,title"},
+}
+
+// searchModes returns searchModes, with the movie and music id parameters
+// added when TMDB and MusicBrainz can resolve them.
+func (h *Handler) searchModes() []struct{ Element, Params string } {
+	modes := slices.Clone(searchModes)
+	for i := range modes {
+		switch {
+		case modes[i].Element == "movie-search" && h.TMDB != nil:
+			modes[i].Params += ",imdbid,tmdbid"
+		case modes[i].Element == "music-search" && h.MusicBrainz != nil:
+			modes[i].Params += ",artistmbid,albummbid"
+		}
+	}
+	return modes
+}
+
+// resultLimit returns the most results a page can hold: ResultLimit, and
+// never more than the files a search considers.
+func (h *Handler) resultLimit() int {
+	limit := cmp.Or(h.ResultLimit, maxResults)
+	if h.MaxFiles > 0 {
+		limit = min(limit, h.MaxFiles)
+	}
+	return limit
+}
+
+// externalURL returns the base for download links: the configured
+// ExternalURL, else the URL the client reached us through when behind a
+// reverse proxy.
+func (h *Handler) externalURL(r *http.Request) string {
+	if h.ExternalURL != "" {
+		return h.ExternalURL
+	}
+	return middleware.ExternalURL(r, h.BaseURL)
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query()
 	action := q.Get("t")
 
+	// caps is static and fetched often by Prowlarr, so it isn't rate limited.
+	if action != "caps" {
+		if ok, wait := h.Limiter.Allow(middleware.ClientKey(r)); !ok {
+			slog.WarnContext(r.Context(), "newznab rate limit exceeded", "remote", r.RemoteAddr, "t", action)
+			w.Header().Set("Retry-After", middleware.RetryAfter(wait))
+			if action == "get" {
+				writeError(w, errDownloadLimit)
+			} else {
+				writeError(w, errRequestLimit)
+			}
+			return
+		}
+	}
+
 	switch action {
 	case "caps":
 		h.handleCaps(w, r)
@@ -99,57 +367,98 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		h.handleSearch(w, r, action)
 	case "get":
 		h.handleGet(w, r)
+	case "":
+		writeError(w, missingParameter("t"))
 	default:
-		writeError(w, 202, "No such function ("+action+")")
+		if unavailableFunctions[action] {
+			writeError(w, functionUnavailable(action))
+		} else {
+			writeError(w, noSuchFunction(action))
+		}
 	}
 }
 
-func (h *Handler) checkAPIKey(r *http.Request) bool {
-	if h.APIKey == "" {
-		return true
+// checkAPIKey validates the apikey param and returns the matching key's label.
+func (h *Handler) checkAPIKey(r *http.Request) (string, bool) {
+	label, ok := h.Keys.Check(r.URL.Query().Get("apikey"))
+	if !ok {
+		auth.Reject(r, auth.EventAPIKey, "newznab")
 	}
-	key := r.URL.Query().Get("apikey")
-	return subtle.ConstantTimeCompare([]byte(key), []byte(h.APIKey)) == 1
+	return label, ok
 }
 
-func (h *Handler) handleCaps(w http.ResponseWriter, _ *http.Request) {
+// handleCaps serves the caps document with validators, so clients
+// revalidating it get a 304 instead of a copy.
+func (h *Handler) handleCaps(w http.ResponseWriter, r *http.Request) {
+	h.capsOnce.Do(func() {
+		var b strings.Builder
+		limit := h.resultLimit()
+		if err := capsTemplate.Execute(&b, map[string]any{"Max": limit, "Default": limit, "Modes": h.searchModes(), "Adult": h.Adult}); err != nil {
+			panic(err) // the template and its data are fixed
+		}
+		h.caps = capsDocument{
+			body:     b.String(),
+			etag:     fmt.Sprintf(`"%x"`, sha256.Sum256([]byte(b.String()))),
+			modified: time.Now(),
+		}
+	})
 	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
-	fmt.Fprint(w, capsXML)
+	w.Header().Set("ETag", h.caps.etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, "", h.caps.modified, strings.NewReader(h.caps.body))
 }
 
 func (h *Handler) handleSearch(w http.ResponseWriter, r *http.Request, action string) {
-	if !h.checkAPIKey(r) {
-		writeError(w, 100, "Incorrect user credentials")
+	client, ok := h.checkAPIKey(r)
+	if !ok {
+		writeError(w, errCredentials)
 		return
 	}
 
 	q := r.URL.Query()
-	query := q.Get("q")
+	if err := checkSearchParams(q); err != nil {
+		writeError(w, err)
+		return
+	}
+	queries := h.cleanQuery(q.Get("q"))
+	query, alternates := queries[0], queries[1:]
 
 	// Build search query based on action type
+	var artist, album string       // passed on to the grab for tagging
+	var suffix string              // added to the query and each alternate title
+	season := -1                   // season whose episodes are offered as packs
+	var release *musicbrainz.Album // the album MusicBrainz knows the search's albummbid by
+	// Readarr's ebook profiles ask for the 7000 categories rather than 3030.
+	ebook := (action == "book" || action == "search") && ebookCategory(q.Get("cat"))
 	switch action {
 	case "tvsearch":
-		season := q.Get("season")
 		ep := q.Get("ep")
-		if query != "" && season != "" && ep != "" {
-			query = fmt.Sprintf("%s S%02sE%02s", query, zeroPad(season), zeroPad(ep))
-		} else if query != "" && season != "" {
-			query = fmt.Sprintf("%s S%02s", query, zeroPad(season))
+		if s := q.Get("season"); s != "" && ep != "" {
+			suffix = fmt.Sprintf(" S%02sE%02s", zeroPad(s), zeroPad(ep))
+		} else if s != "" {
+			suffix = fmt.Sprintf(" S%02s", zeroPad(s))
+			if n, err := strconv.Atoi(s); err == nil {
+				season = n
+			}
 		}
 	case "movie":
-		// q already contains the movie title from Radarr
+		// q already contains the movie title from Radarr; an id also gets
+		// the original title searched.
+		query, alternates = h.movieQueries(r.Context(), q, query, alternates)
 	case "music":
-		artist := q.Get("artist")
-		album := q.Get("album")
+		// MBIDs, when MusicBrainz can resolve them, give the canonical names.
+		artist, album, release = h.albumLookup(r.Context(), q, q.Get("artist"), q.Get("album"))
+		parts := []string{}
+		if artist != "" {
+			parts = append(parts, artist)
+		}
+		if album != "" {
+			parts = append(parts, album)
+		}
 		if query == "" {
-			parts := []string{}
-			if artist != "" {
-				parts = append(parts, artist)
-			}
-			if album != "" {
-				parts = append(parts, album)
-			}
 			query = strings.Join(parts, " ")
+		} else if t := strings.Join(parts, " "); release != nil && titleKey(t) != titleKey(query) {
+			alternates = append(alternates, t)
 		}
 	case "book":
 		author := q.Get("author")
@@ -164,34 +473,31 @@ func (h *Handler) handleSearch(w http.ResponseWriter, r *http.Request, action st
 			}
 			query = strings.Join(parts, " ")
 		}
-		if query != "" {
-			query += " audiobook"
+		if !ebook {
+			suffix = " audiobook"
+		}
+	}
+	if query != "" {
+		query += suffix
+		for i := range alternates {
+			alternates[i] += suffix
 		}
 	}
 
 	if query == "" {
-		if action == "search" {
-			// Prowlarr/apps send ?t=search with no q= as a connectivity test.
-			// Each app sends its own cat= filter (e.g. Radarr sends 2000s,
-			// Sonarr sends 5000s, Lidarr sends 3000s). We must return a test
-			// item whose category matches the requested categories, otherwise
-			// the app rejects the indexer with "no results in configured categories."
-			cat := firstCategory(q.Get("cat"))
-			writeSearchResponse(w, []searchItem{{
-				Title:    "slskrr-test",
-				Token:    EncodeToken("slskrr", "test/slskrr-test.mp3", 1),
-				Size:     1,
-				Category: cat,
-				Username: "slskrr",
-			}}, h.BaseURL)
-		} else {
-			// No usable query for tvsearch/movie/music/book — return empty results.
-			writeSearchResponse(w, nil, h.BaseURL)
-		}
+		// Prowlarr and the *arr apps send a search with no query as a
+		// connectivity test, and RSS syncs look the same.
+		h.writeTestResponse(w, r, client, action)
+		return
+	}
+
+	if len(query) > maxQueryLength {
+		writeError(w, &Error{CodeIncorrectParameter, "Incorrect parameter (query too long)"})
 		return
 	}
 
-	slog.Info("searching slskd", "query", query, "action", action)
+	timeout := h.searchTimeout(q)
+	slog.InfoContext(r.Context(), "searching slskd", "query", query, "action", action, "client", client, "timeout", timeout)
 
 	// Extract year from query and check if a year param was provided (Newznab standard).
 	year := q.Get("year")
@@ -207,110 +513,659 @@ func (h *Handler) handleSearch(w http.ResponseWriter, r *http.Request, action st
 		queryWithoutYear = strings.TrimSpace(strings.Replace(query, year, "", 1))
 	}
 
-	responses, err := h.SlskdClient.SearchAndWait(r.Context(), query, h.SearchTimeout)
+	// Remember the slskd searches this request runs and what it returns.
+	ctx := r.Context()
+	var logged *searchlog.Search
+	if h.Searches != nil {
+		logged = &searchlog.Search{RequestID: middleware.RequestID(ctx), Time: time.Now(), Client: client, Action: action, Query: query}
+		ctx = context.WithValue(ctx, searchLogKey{}, logged)
+	}
+	if v := q.Get("nocache"); v == "1" || v == "true" {
+		ctx = context.WithValue(ctx, noCacheKey{}, true)
+	}
+	wanted := categoryKinds(q.Get("cat"))
+	ctx = context.WithValue(ctx, filterKey{}, resultFilter{ebook: ebook, kinds: wanted})
+
+	responses, err := h.search(ctx, query, action, timeout)
 	if err != nil {
-		slog.Error("slskd search failed", "error", err)
-		writeError(w, 900, "slskd search failed")
+		slog.ErrorContext(r.Context(), "slskd search failed", "error", err)
+		h.recordSearch(ctx, logged, nil, nil, err)
+		writeError(w, searchError(err))
 		return
 	}
 
 	// If the query contained a year, run a fallback search without it to catch
 	// oddly-named Soulseek results that omit the year.
 	if year != "" && queryWithoutYear != "" && queryWithoutYear != query {
-		slog.Info("running fallback search without year", "query", queryWithoutYear)
-		fallbackResponses, err := h.SlskdClient.SearchAndWait(r.Context(), queryWithoutYear, h.SearchTimeout)
+		slog.InfoContext(r.Context(), "running fallback search without year", "query", queryWithoutYear)
+		fallbackResponses, err := h.search(ctx, queryWithoutYear, action, timeout)
 		if err != nil {
-			slog.Warn("fallback search failed, continuing with primary results", "error", err)
+			slog.WarnContext(r.Context(), "fallback search failed, continuing with primary results", "error", err)
 		} else {
-			responses = append(responses, fallbackResponses...)
+			// Clip first: responses may be shared with the cooldown cache.
+			responses = append(slices.Clip(responses), fallbackResponses...)
+		}
+	}
+
+	// Search any alternate titles the query named, e.g. "Title aka Other".
+	for _, alt := range alternates {
+		slog.InfoContext(r.Context(), "running alternate title search", "query", alt)
+		altResponses, err := h.search(ctx, alt, action, timeout)
+		if err != nil {
+			slog.WarnContext(r.Context(), "alternate title search failed, continuing with other results", "error", err)
+			continue
+		}
+		responses = append(slices.Clip(responses), altResponses...)
+	}
+
+	limit, offset := pageParams(q, h.resultLimit())
+	adult := h.Adult && adultCategory(q.Get("cat"))
+	excluded := h.slskdBlacklist(ctx)
+	filter := &resultFilter{action: action, artist: artist, album: album, season: season, names: h.ReleaseNames, release: release, adult: adult, ebook: ebook, kinds: wanted, excluded: excluded}
+	items, exhausted := h.page(responses, filter, limit, offset)
+	if len(items) == 0 && offset == 0 && filter.rejected() > 0 {
+		slog.InfoContext(r.Context(), "all search results filtered out", append([]any{"query", query}, filter.counts()...)...)
+		if h.RelaxEmpty {
+			filter = &resultFilter{action: action, artist: artist, album: album, season: season, names: h.ReleaseNames, release: release, adult: adult, ebook: ebook, kinds: wanted, excluded: excluded, relaxed: true}
+			items, exhausted = h.page(responses, filter, limit, offset)
+			slog.InfoContext(r.Context(), "retried with relaxed filters", "query", query, "results", len(items))
 		}
 	}
 
-	// Collect and filter results from both regular and locked files
-	seen := make(map[string]bool) // deduplicate by username+filename
-	var items []searchItem
+	raw := 0
 	for _, resp := range responses {
-		// Combine regular files and locked files into a single pass
-		allFiles := resp.Files
-		allFiles = append(allFiles, resp.LockedFiles...)
+		raw += len(resp.Files) + len(resp.LockedFiles)
+	}
+	searchPeerResponses.Observe(float64(len(responses)), action)
+	searchResults.Observe(float64(raw), action, "raw")
+	searchResults.Observe(float64(len(items)), action, "filtered")
+
+	total := -1
+	if exhausted {
+		total = offset + len(items)
+	}
+	slog.InfoContext(r.Context(), "search complete", "query", query, "responses", len(responses), "results", len(items), "offset", offset)
+	h.recordSearch(ctx, logged, responses, items, nil)
+	writeSearchResponse(w, formatOf(r), items, h.externalURL(r), offset, total)
+}
 
-		for _, f := range allFiles {
-			key := resp.Username + "\x00" + f.Filename
-			if seen[key] {
+// page collects up to limit results after skipping offset, reporting
+// whether the results ran out.
+func (h *Handler) page(responses []slskd.SearchResponse, filter *resultFilter, limit, offset int) (items []searchItem, exhausted bool) {
+	skipped := 0
+	for item := range h.results(responses, filter) {
+		if skipped < offset {
+			skipped++
+			continue
+		}
+		if len(items) == limit {
+			return items, false
+		}
+		items = append(items, item)
+	}
+	return items, true
+}
+
+// resultFilter describes a search the results are for, and counts the
+// files each check dropped.
+type resultFilter struct {
+	action, artist, album string
+	season                int             // in a tvsearch without ep; its episodes sharing a folder become a pack
+	names                 bool            // synthesize release names (see package naming)
+	relaxed               bool            // drop only empty files, not small ones
+	adult                 bool            // a Whisparr search: adult video rules and category
+	ebook                 bool            // a Readarr ebook search: only ebook formats
+	kinds                 kinds           // the sorts of file the search's categories ask for
+	excluded              map[string]bool // peers slskd blacklists, counted as blocked
+
+	release *musicbrainz.Album // the album a music search is for; incomplete folders of it are dropped
+
+	blocked, extension, size, capped, incomplete int
+}
+
+func (f *resultFilter) rejected() int {
+	return f.blocked + f.extension + f.size + f.capped + f.incomplete
+}
+
+// counts returns the rejections as slog key-value pairs.
+func (f *resultFilter) counts() []any {
+	return []any{"blocked", f.blocked, "extension", f.extension, "size", f.size, "capped", f.capped, "incomplete", f.incomplete}
+}
+
+// results yields the files in responses worth offering as results, once per
+// peer and file: video, audio and audiobook files above the size floor,
+// from peers that aren't blocked. Peers are checked as their files are
+// consumed, so a caller that stops early skips the rest. Only the
+// MaxPeerFiles best-scored of each peer's files (see compareFiles) and
+// MaxFiles overall are considered, so a peer sharing a huge dump can't
+// crowd out everyone else.
+func (h *Handler) results(responses []slskd.SearchResponse, filter *resultFilter) iter.Seq[searchItem] {
+	return func(yield func(searchItem) bool) {
+		seen := make(map[string]bool) // deduplicate by username+filename
+		considered := 0
+		for i := range responses {
+			resp := &responses[i]
+			if h.Blocklist.Blocked(resp.Username) || filter.excluded[resp.Username] {
+				filter.blocked += len(resp.Files) + len(resp.LockedFiles)
 				continue
 			}
-			seen[key] = true
+			var files, subtitles []*slskd.SlskdFile
+			for f := range resp.AllFiles() {
+				key := resp.Username + "\x00" + textnorm.NFC(f.Filename)
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				if filter.accept(f) {
+					files = append(files, f)
+				} else if !filter.ebook && subtitleExtensions[strings.ToLower(path.Ext(f.Filename))] {
+					subtitles = append(subtitles, f)
+				}
+			}
+			if h.MaxPeerFiles > 0 && len(files) > h.MaxPeerFiles {
+				slices.SortStableFunc(files, compareFiles)
+				filter.capped += len(files) - h.MaxPeerFiles
+				files = files[:h.MaxPeerFiles]
+			}
+			for _, item := range filter.items(resp.Username, files, subtitles) {
+				if h.MaxFiles > 0 && considered >= h.MaxFiles {
+					return
+				}
+				considered++
+				if !yield(item) {
+					return
+				}
+			}
+		}
+	}
+}
 
-			ext := strings.ToLower(path.Ext(f.Filename))
+// accept reports whether f is a video, audio or audiobook file of a kind
+// the search asks for above the size floor, or in an ebook search an
+// ebook, counting the check that dropped it otherwise.
+func (filter *resultFilter) accept(f *slskd.SlskdFile) bool {
+	ext := strings.ToLower(path.Ext(f.Filename))
+	if filter.ebook {
+		if !ebookExtensions[ext] {
+			filter.extension++
+			return false
+		}
+		floor := int64(minEbookFileSize)
+		switch {
+		case filter.relaxed:
+			floor = 1
+		case ext == ".cbz":
+			floor = minComicFileSize
+		}
+		if f.Size < floor {
+			filter.size++
+			return false
+		}
+		return true
+	}
+	isVideo := videoExtensions[ext] || (filter.adult && adultExtensions[ext])
+	if !isVideo && !audioExtensions[ext] && !audiobookExtensions[ext] || !filter.kinds.want(ext, isVideo) {
+		filter.extension++
+		return false
+	}
+	floor := int64(minAudioFileSize)
+	switch {
+	case filter.relaxed:
+		floor = 1 // anything but empty files
+	case isVideo && filter.adult:
+		floor = minAdultFileSize
+	case isVideo:
+		floor = minVideoFileSize
+	}
+	if f.Size < floor {
+		filter.size++
+		return false
+	}
+	return true
+}
 
-			isVideo := videoExtensions[ext]
-			isAudio := audioExtensions[ext]
-			isAudiobook := audiobookExtensions[ext]
-			if !isVideo && !isAudio && !isAudiobook {
-				continue
+// items turns username's accepted files into search results. In music
+// searches the audio files sharing a directory become one release, so
+// grabbing an album queues all of its tracks rather than one. Likewise in
+// a season search, episodes of the season sharing a directory become a
+// season pack; the episodes are offered on their own too. Videos come with
+// the subtitles named after them.
+func (filter *resultFilter) items(username string, files, subtitles []*slskd.SlskdFile) []searchItem {
+	folders := make(map[string][]*slskd.SlskdFile)
+	for _, f := range files {
+		if filter.grouped(f.Filename) {
+			dir := slskd.Directory(f.Filename)
+			folders[dir] = append(folders[dir], f)
+		}
+	}
+	items := make([]searchItem, 0, len(files))
+	for _, f := range files {
+		dir := slskd.Directory(f.Filename)
+		group := folders[dir]
+		switch {
+		case dir == "" || len(group) < 2 || !filter.grouped(f.Filename):
+			items = append(items, filter.item(username, f, subtitlesOf(f, files, subtitles)))
+		case filter.action == "music":
+			switch {
+			case group[0] != f: // the release goes where its first track was
+			case filter.release != nil && !filter.release.Complete(len(group)):
+				filter.incomplete += len(group)
+			default:
+				items = append(items, filter.folderItem(username, dir, group))
 			}
-			if isVideo && f.Size < minVideoFileSize {
-				continue
+		default:
+			if group[0] == f {
+				items = append(items, filter.seasonItem(username, dir, group))
 			}
-			if (isAudio || isAudiobook) && f.Size < minAudioFileSize {
+			items = append(items, filter.item(username, f, subtitlesOf(f, files, subtitles)))
+		}
+	}
+	return items
+}
+
+// grouped reports whether filename is one of the files items groups by
+// directory: audio in a music search, episodes of the season in a season
+// search.
+func (filter *resultFilter) grouped(filename string) bool {
+	switch filter.action {
+	case "music":
+		return IsAudio(filename)
+	case "tvsearch":
+		if filter.season < 0 || !IsVideo(filename) {
+			return false
+		}
+		m := episodeTag.FindStringSubmatch(path.Base(strings.ReplaceAll(filename, "\\", "/")))
+		if m == nil {
+			return false
+		}
+		n, _ := strconv.Atoi(m[1])
+		return n == filter.season
+	}
+	return false
+}
+
+// folderItem turns the audio files username shares in dir into one
+// release result.
+func (filter *resultFilter) folderItem(username, dir string, tracks []*slskd.SlskdFile) searchItem {
+	var size int64
+	bitRate := 0
+	for _, f := range tracks {
+		size += f.Size
+		bitRate = max(bitRate, f.BitRate)
+	}
+	token := releaseToken(FileToken{Username: username, Artist: filter.artist, Album: filter.album, BitRate: bitRate}, dir, tracks).Encode()
+	// Name the release after its folder, skipping folders like "FLAC".
+	name, disc := postprocess.ReleaseFolder(tracks[0].Filename)
+	if filter.names {
+		bitDepth := 0
+		for _, f := range tracks {
+			bitDepth = max(bitDepth, f.BitDepth)
+		}
+		hints := naming.Hints{Artist: filter.artist, Album: filter.album, BitRate: bitRate, BitDepth: bitDepth}
+		if title, ok := naming.Album(tracks[0].Filename, hints); ok {
+			name = title
+		}
+	}
+	if disc != "" {
+		name += " " + disc
+	}
+	return searchItem{
+		Title:    fmt.Sprintf("%s [%s]", name, formatSize(size)),
+		Token:    token,
+		Size:     size,
+		Category: "3000",
+		Username: username,
+		Filename: dir,
+	}
+}
+
+// seasonItem turns the episodes username shares in dir into one season
+// pack result. It is titled after the first episode with its episode
+// number dropped, e.g. "Show.S01.1080p", which the apps parse as a pack.
+func (filter *resultFilter) seasonItem(username, dir string, episodes []*slskd.SlskdFile) searchItem {
+	var size int64
+	for _, f := range episodes {
+		size += f.Size
+	}
+	token := releaseToken(FileToken{Username: username}, dir, episodes).Encode()
+	name := path.Base(strings.ReplaceAll(episodes[0].Filename, "\\", "/"))
+	name = strings.TrimSuffix(name, path.Ext(name))
+	name = episodeTag.ReplaceAllStringFunc(name, func(tag string) string {
+		return fmt.Sprintf("S%02d", filter.season)
+	})
+	if filter.names {
+		if title, ok := naming.Season(episodes[0].Filename, filter.season); ok {
+			name = title
+		}
+	}
+	return searchItem{
+		Title:    fmt.Sprintf("%s [%s]", name, formatSize(size)),
+		Token:    token,
+		Size:     size,
+		Category: "5000",
+		Username: username,
+		Filename: dir,
+	}
+}
+
+// subtitlesOf returns the subtitles beside video among subtitles: those
+// named after it, or all of them when it's the only video in its
+// directory among files.
+func subtitlesOf(video *slskd.SlskdFile, files, subtitles []*slskd.SlskdFile) []*slskd.SlskdFile {
+	if len(subtitles) == 0 || !IsVideo(video.Filename) {
+		return nil
+	}
+	dir := slskd.Directory(video.Filename)
+	alone := true
+	for _, f := range files {
+		if f != video && IsVideo(f.Filename) && slskd.Directory(f.Filename) == dir {
+			alone = false
+			break
+		}
+	}
+	stem := strings.ToLower(strings.TrimSuffix(video.Filename, path.Ext(video.Filename)))
+	var matched []*slskd.SlskdFile
+	for _, s := range subtitles {
+		if slskd.Directory(s.Filename) == dir && (alone || strings.HasPrefix(strings.ToLower(s.Filename), stem+".")) {
+			matched = append(matched, s)
+		}
+	}
+	return matched
+}
+
+// item turns an accepted file of username's into a search result, with
+// subtitles, if any, grabbed along with it.
+func (filter *resultFilter) item(username string, f *slskd.SlskdFile, subtitles []*slskd.SlskdFile) searchItem {
+	ext := strings.ToLower(path.Ext(f.Filename))
+	isVideo := videoExtensions[ext] || (filter.adult && adultExtensions[ext])
+	isAudio := audioExtensions[ext]
+	isAudiobook := audiobookExtensions[ext]
+
+	t := FileToken{Username: username, Filename: f.Filename, Size: f.Size, Artist: filter.artist, Album: filter.album, BitRate: f.BitRate}
+	size := f.Size
+	if len(subtitles) > 0 {
+		t = releaseToken(t, slskd.Directory(f.Filename), append([]*slskd.SlskdFile{f}, subtitles...))
+		size = t.Size
+	}
+	token := t.Encode()
+	// Convert backslashes (Windows paths from Soulseek) to forward slashes
+	basename := path.Base(strings.ReplaceAll(f.Filename, "\\", "/"))
+
+	category := "2000"
+	switch {
+	case filter.ebook && ext == ".cbz":
+		category = "7030" // Comics subcategory
+	case filter.ebook:
+		category = "7020" // EBook subcategory
+	case filter.action == "book" || filter.kinds == kinds{audiobook: true}:
+		category = "3030" // Audiobook subcategory
+	case filter.action == "music" || (isAudio && !isAudiobook):
+		category = "3000"
+	case isAudiobook:
+		category = "3030"
+	case filter.adult && isVideo:
+		category = "6000"
+	case filter.action == "tvsearch":
+		category = "5000"
+	}
+	if name, ok := filter.releaseName(f.Filename, category); ok {
+		basename = name
+	}
+	// Append human-readable file size to the title for visibility in *arr UIs
+	basename = fmt.Sprintf("%s [%s]", basename, formatSize(f.Size))
+	// Runtime is how audiobook pickers tell abridged editions apart.
+	if category == "3030" && f.Length > 0 {
+		basename = fmt.Sprintf("%s (%s)", basename, formatDuration(f.Length))
+	}
+
+	return searchItem{
+		Title:    basename,
+		Token:    token,
+		Size:     size,
+		Category: category,
+		Username: username,
+		Filename: f.Filename,
+	}
+}
+
+// releaseName synthesizes a parseable title for a movie or episode file
+// when release names are enabled.
+func (filter *resultFilter) releaseName(filename, category string) (string, bool) {
+	if !filter.names {
+		return "", false
+	}
+	switch {
+	case category == "5000":
+		return naming.Episode(filename)
+	case category == "2000" && filter.action != "movie":
+		if name, ok := naming.Episode(filename); ok {
+			return name, true
+		}
+		fallthrough
+	case category == "2000":
+		return naming.Movie(filename)
+	}
+	return "", false
+}
+
+// compareFiles orders a peer's files best first: lossless audio, then the
+// higher bit rate, then the larger file, which for video is usually the
+// better encode.
+func compareFiles(a, b *slskd.SlskdFile) int {
+	la := losslessExtensions[strings.ToLower(path.Ext(a.Filename))]
+	lb := losslessExtensions[strings.ToLower(path.Ext(b.Filename))]
+	if la != lb {
+		if la {
+			return -1
+		}
+		return 1
+	}
+	return cmp.Or(cmp.Compare(b.BitRate, a.BitRate), cmp.Compare(b.Size, a.Size))
+}
+
+// searchTimeout returns how long a search may wait for results: the
+// request's timeout parameter, in seconds or as a duration like "90s", capped
+// at MaxTimeout; or SearchTimeout when it gave none. Interactive searches can
+// ask for longer to get more thorough results.
+func (h *Handler) searchTimeout(q url.Values) time.Duration {
+	v := q.Get("timeout")
+	if v == "" || h.MaxTimeout <= 0 {
+		return h.SearchTimeout
+	}
+	d, err := time.ParseDuration(v)
+	if n, nerr := strconv.Atoi(v); nerr == nil {
+		d, err = time.Duration(n)*time.Second, nil
+	}
+	if err != nil || d <= 0 {
+		return h.SearchTimeout
+	}
+	return min(d, h.MaxTimeout)
+}
+
+// checkSearchParams rejects numeric search parameters that aren't numbers,
+// which pageParams and the category filters would otherwise ignore.
+func checkSearchParams(q url.Values) *Error {
+	for _, name := range []string{"limit", "offset"} {
+		if v := q.Get(name); v != "" {
+			if n, err := strconv.Atoi(v); err != nil || n < 0 {
+				return incorrectParameter(name)
+			}
+		}
+	}
+	if v := q.Get("cat"); v != "" {
+		for _, c := range strings.Split(v, ",") {
+			if c = strings.TrimSpace(c); c == "" {
 				continue
 			}
+			if _, err := strconv.Atoi(c); err != nil {
+				return incorrectParameter("cat")
+			}
+		}
+	}
+	return nil
+}
 
-			token := EncodeToken(resp.Username, f.Filename, f.Size)
-			// Convert backslashes (Windows paths from Soulseek) to forward slashes
-			basename := path.Base(strings.ReplaceAll(f.Filename, "\\", "/"))
-			// Append human-readable file size to the title for visibility in *arr UIs
-			basename = fmt.Sprintf("%s [%s]", basename, formatSize(f.Size))
+// pageParams returns the limit and offset requested, with the limit capped
+// at most as advertised in caps.
+func pageParams(q url.Values, most int) (limit, offset int) {
+	limit = most
+	if n, err := strconv.Atoi(q.Get("limit")); err == nil && n > 0 {
+		limit = min(n, most)
+	}
+	if n, err := strconv.Atoi(q.Get("offset")); err == nil && n > 0 {
+		offset = n
+	}
+	return limit, offset
+}
 
-			category := "2000"
-			switch {
-			case action == "book":
-				category = "3030" // Audiobook subcategory
-			case action == "music" || (isAudio && !isAudiobook):
-				category = "3000"
-			case isAudiobook:
-				category = "3030"
-			case action == "tvsearch":
-				category = "5000"
+// search runs query on slskd for up to timeout, unless the same query was
+// searched within QueryCooldown, in which case those responses are reused.
+// With EarlyResults set, only searches wanting the same results reuse them.
+// A request with nocache=1 always searches, refreshing the cache.
+// category labels the search's metrics.
+func (h *Handler) search(ctx context.Context, query, category string, timeout time.Duration) ([]slskd.SearchResponse, error) {
+	logged, _ := ctx.Value(searchLogKey{}).(*searchlog.Search)
+	// A search that may stop early is cached for the results it wanted.
+	var want resultFilter
+	scope := ""
+	if h.EarlyResults > 0 {
+		want, _ = ctx.Value(filterKey{}).(resultFilter)
+		want.action = category
+		scope = fmt.Sprint(want.action, want.ebook, want.kinds)
+	}
+	if h.QueryCooldown > 0 && ctx.Value(noCacheKey{}) != nil {
+		searchCache.Inc(category, "bypass")
+	} else if h.QueryCooldown > 0 {
+		if responses, ok := h.recent.get(query, scope, h.QueryCooldown); ok {
+			slog.InfoContext(ctx, "query in cooldown, reusing last search", "query", query)
+			searchCache.Inc(category, "hit")
+			if logged != nil {
+				logged.Slskd = append(logged.Slskd, searchlog.Query{Query: query})
 			}
+			return responses, nil
+		}
+		searchCache.Inc(category, "miss")
+	}
+	if h.EarlyResults > 0 {
+		ctx = slskd.ReturnEarly(ctx, h.EarlyResults, func(responses []slskd.SearchResponse) bool {
+			return h.enoughResults(responses, want)
+		})
+	}
+	if logged != nil {
+		ctx = slskd.OnSearchStarted(ctx, func(id, query string) {
+			logged.Slskd = append(logged.Slskd, searchlog.Query{Query: query, SearchID: id})
+		})
+	}
+	start := time.Now()
+	responses, err := h.SlskdClient.SearchAndWait(ctx, query, timeout)
+	if err != nil {
+		return nil, err
+	}
+	searchDuration.Observe(time.Since(start).Seconds(), category)
+	if h.QueryCooldown > 0 {
+		h.recent.put(query, scope, responses, h.QueryCooldown)
+	}
+	return responses, nil
+}
 
-			items = append(items, searchItem{
-				Title:    basename,
-				Token:    token,
-				Size:     f.Size,
-				Category: category,
-				Username: resp.Username,
-			})
+// enoughResults reports whether responses already hold EarlyResults
+// results wanted by a search like want from peers scoring at least
+// EarlyScore, so the search can stop.
+func (h *Handler) enoughResults(responses []slskd.SearchResponse, want resultFilter) bool {
+	var good []slskd.SearchResponse
+	for _, resp := range responses {
+		if resp.PeerScore() >= h.EarlyScore {
+			good = append(good, resp)
 		}
 	}
+	n := 0
+	for range h.results(good, &resultFilter{action: want.action, ebook: want.ebook, kinds: want.kinds}) {
+		if n++; n >= h.EarlyResults {
+			return true
+		}
+	}
+	return false
+}
 
-	slog.Info("search complete", "query", query, "responses", len(responses), "results", len(items))
-	writeSearchResponse(w, items, h.BaseURL)
+// slskdBlacklist returns the members of slskd's blacklisted group when
+// SlskdBlacklist is set. If slskd's options can't be read the results go
+// unfiltered rather than the search failing.
+func (h *Handler) slskdBlacklist(ctx context.Context) map[string]bool {
+	if !h.SlskdBlacklist {
+		return nil
+	}
+	users, err := h.SlskdClient.BlacklistedUsers(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to read slskd's blacklisted users", "error", err)
+		return nil
+	}
+	excluded := make(map[string]bool, len(users))
+	for _, u := range users {
+		excluded[u] = true
+	}
+	return excluded
+}
+
+// searchLogKey carries the *searchlog.Search a request's searches are
+// recorded in.
+type searchLogKey struct{}
+
+// noCacheKey marks a request that asked, with nocache=1, for fresh results
+// rather than the QueryCooldown cache's.
+type noCacheKey struct{}
+
+// filterKey carries a resultFilter with what a search's categories ask
+// for, so its early return counts only the results it will offer.
+type filterKey struct{}
+
+// recordSearch completes logged, when set, with the outcome of its request
+// and adds it to Searches.
+func (h *Handler) recordSearch(ctx context.Context, logged *searchlog.Search, responses []slskd.SearchResponse, items []searchItem, err error) {
+	if logged == nil {
+		return
+	}
+	logged.Responses = responses
+	logged.Results = make([]searchlog.Result, len(items))
+	for i, item := range items {
+		logged.Results[i] = searchlog.Result{Username: item.Username, Filename: item.Filename, Size: item.Size}
+	}
+	if err != nil {
+		logged.Error = err.Error()
+	}
+	if err := h.Searches.Record(logged); err != nil {
+		slog.ErrorContext(ctx, "failed to persist search log", "error", err)
+	}
 }
 
 func (h *Handler) handleGet(w http.ResponseWriter, r *http.Request) {
-	if !h.checkAPIKey(r) {
-		writeError(w, 100, "Incorrect user credentials")
+	if _, ok := h.checkAPIKey(r); !ok {
+		writeError(w, errCredentials)
 		return
 	}
 
 	id := r.URL.Query().Get("id")
 	if id == "" {
-		writeError(w, 200, "Missing parameter (id)")
+		writeError(w, missingParameter("id"))
 		return
 	}
 
 	token, err := DecodeToken(id)
 	if err != nil {
-		writeError(w, 300, "Invalid token")
+		auth.Reject(r, auth.EventToken, "newznab", "error", err)
+		writeError(w, errNoSuchItem)
+		return
+	}
+
+	if formatOf(r) == torznabFormat {
+		writeTorrent(w, token, id, h.externalURL(r))
 		return
 	}
 
 	basename := path.Base(strings.ReplaceAll(token.Filename, "\\", "/"))
 
 	w.Header().Set("Content-Type", "application/x-nzb")
-	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.nzb"`, basename))
-	fmt.Fprintf(w, nzbTemplate, token.Username, token.Filename, token.Size, basename)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": textnorm.Clean(basename) + ".nzb"}))
+	fmt.Fprintf(w, nzbTemplate, xmlEscape(token.Username), xmlEscape(token.Filename), token.Size, xmlEscape(basename), xmlEscape(id))
 }
 
 type searchItem struct {
@@ -319,19 +1174,26 @@ type searchItem struct {
 	Size     int64
 	Category string
 	Username string
+	Filename string
 }
 
-func writeSearchResponse(w http.ResponseWriter, items []searchItem, baseURL string) {
+// writeSearchResponse writes items as an RSS feed in format f. total is the
+// number of results in all pages, or -1 when unknown, in which case clients
+// page on until a short page.
+func writeSearchResponse(w http.ResponseWriter, f *apiFormat, items []searchItem, baseURL string, offset, total int) {
 	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
 	fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?>`)
 	fmt.Fprint(w, "\n")
-	fmt.Fprint(w, `<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom" xmlns:newznab="http://www.newznab.com/DTD/2010/feeds/attributes/">`)
+	fmt.Fprintf(w, `<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom" xmlns:%s="%s">`, f.ns, f.nsURL)
 	fmt.Fprint(w, "\n<channel>")
 	fmt.Fprint(w, "\n<title>slskrr</title>")
 	fmt.Fprintf(w, "\n<description>slskd Newznab facade</description>")
+	if total >= 0 {
+		fmt.Fprintf(w, "\n<%s:response offset=\"%d\" total=\"%d\" />", f.ns, offset, total)
+	}
 
 	for _, item := range items {
-		downloadURL := fmt.Sprintf("%s/api?t=get&amp;id=%s", baseURL, item.Token)
+		downloadURL := fmt.Sprintf("%s%s?t=get&amp;id=%s", baseURL, f.path, item.Token)
 		pubDate := time.Now().UTC().Format(time.RFC1123Z)
 
 		fmt.Fprint(w, "\n<item>")
@@ -339,10 +1201,16 @@ func writeSearchResponse(w http.ResponseWriter, items []searchItem, baseURL stri
 		fmt.Fprintf(w, "\n  <guid>%s</guid>", item.Token)
 		fmt.Fprintf(w, "\n  <link>%s</link>", downloadURL)
 		fmt.Fprintf(w, "\n  <pubDate>%s</pubDate>", pubDate)
-		fmt.Fprintf(w, "\n  <enclosure url=\"%s\" length=\"%d\" type=\"application/x-nzb\" />", downloadURL, item.Size)
-		fmt.Fprintf(w, "\n  <newznab:attr name=\"size\" value=\"%d\" />", item.Size)
-		fmt.Fprintf(w, "\n  <newznab:attr name=\"category\" value=\"%s\" />", item.Category)
-		fmt.Fprintf(w, "\n  <newznab:attr name=\"grabs\" value=\"0\" />")
+		fmt.Fprintf(w, "\n  <enclosure url=\"%s\" length=\"%d\" type=\"%s\" />", downloadURL, item.Size, f.enclosure)
+		fmt.Fprintf(w, "\n  <%s:attr name=\"size\" value=\"%d\" />", f.ns, item.Size)
+		fmt.Fprintf(w, "\n  <%s:attr name=\"category\" value=\"%s\" />", f.ns, item.Category)
+		fmt.Fprintf(w, "\n  <%s:attr name=\"grabs\" value=\"0\" />", f.ns)
+		if f == torznabFormat {
+			// The peer sharing the file is its one seeder; torrent
+			// clients drop results with none.
+			fmt.Fprint(w, "\n  <torznab:attr name=\"seeders\" value=\"1\" />")
+			fmt.Fprint(w, "\n  <torznab:attr name=\"peers\" value=\"1\" />")
+		}
 		fmt.Fprint(w, "\n</item>")
 	}
 
@@ -350,14 +1218,10 @@ func writeSearchResponse(w http.ResponseWriter, items []searchItem, baseURL stri
 	fmt.Fprint(w, "\n</rss>\n")
 }
 
-func writeError(w http.ResponseWriter, code int, description string) {
-	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
-	w.WriteHeader(http.StatusOK) // Newznab errors are returned as 200 with error XML
-	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
-<error code="%d" description="%s" />`, code, xmlEscape(description))
-}
-
+// xmlEscape escapes s for XML text and attributes, first repairing text
+// from peers that isn't valid UTF-8 or holds characters XML can't carry.
 func xmlEscape(s string) string {
+	s = textnorm.Clean(s)
 	s = strings.ReplaceAll(s, "&", "&amp;")
 	s = strings.ReplaceAll(s, "<", "&lt;")
 	s = strings.ReplaceAll(s, ">", "&gt;")
@@ -378,6 +1242,75 @@ func firstCategory(cats string) string {
 	return cats
 }
 
+// ebookCategory reports whether a cat= param asks for books (7000-series)
+// and not for audio, which audiobook searches ask for.
+func ebookCategory(cats string) bool {
+	ebook := false
+	for _, c := range strings.Split(cats, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(c))
+		switch {
+		case err != nil:
+		case n >= 3000 && n < 4000:
+			return false
+		case n >= 7000 && n < 8000:
+			ebook = true
+		}
+	}
+	return ebook
+}
+
+// kinds are the sorts of file a search's categories ask for. The zero value,
+// for a search naming no category that tells, asks for them all.
+type kinds struct{ video, audio, audiobook bool }
+
+// categoryKinds returns the kinds of file a cat= param asks for: video for
+// the 2000 (Movies), 5000 (TV) and 6000 (XXX) series, audiobooks for 3030
+// and music for the rest of the 3000 series.
+func categoryKinds(cats string) kinds {
+	var k kinds
+	for _, c := range strings.Split(cats, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(c))
+		switch {
+		case err != nil:
+		case n == 3030:
+			k.audiobook = true
+		case n >= 3000 && n < 4000:
+			k.audio = true
+		case n >= 2000 && n < 3000, n >= 5000 && n < 7000:
+			k.video = true
+		}
+	}
+	return k
+}
+
+// want reports whether a file with extension ext, a video file or not,
+// is of a kind k asks for. Audiobooks are also published as plain audio
+// tracks, so an audiobook search takes those too.
+func (k kinds) want(ext string, isVideo bool) bool {
+	switch {
+	case k == kinds{}:
+		return true
+	case isVideo:
+		return k.video
+	case audiobookExtensions[ext] && k.audiobook:
+		return true
+	case audioExtensions[ext]:
+		return k.audio || k.audiobook
+	}
+	return false
+}
+
+// adultCategory reports whether a cat= param asks for an adult (6000-series)
+// category.
+func adultCategory(cats string) bool {
+	for _, c := range strings.Split(cats, ",") {
+		if n, err := strconv.Atoi(strings.TrimSpace(c)); err == nil && n >= 6000 && n < 7000 {
+			return true
+		}
+	}
+	return false
+}
+
 func zeroPad(s string) string {
 	if len(s) == 1 {
 		return "0" + s
@@ -401,16 +1334,27 @@ func formatSize(bytes int64) string {
 	}
 }
 
-const capsXML = `<?xml version="1.0" encoding="UTF-8"?>
+// formatDuration returns a human-readable length for a file of seconds.
+func formatDuration(seconds int) string {
+	h, m := seconds/3600, seconds%3600/60
+	switch {
+	case h > 0:
+		return fmt.Sprintf("%dh %dm", h, m)
+	case m > 0:
+		return fmt.Sprintf("%dm", m)
+	default:
+		return fmt.Sprintf("%ds", seconds)
+	}
+}
+
+var capsTemplate = template.Must(template.New("caps").Parse(`<?xml version="1.0" encoding="UTF-8"?>
 <caps>
   <server version="1.0" title="slskrr" strapline="Soulseek via slskd" />
-  <limits max="100" default="100" />
+  <limits max="{{.Max}}" default="{{.Default}}" />
   <searching>
-    <search available="yes" supportedParams="q" />
-    <tv-search available="yes" supportedParams="q,season,ep" />
-    <movie-search available="yes" supportedParams="q,year" />
-    <music-search available="yes" supportedParams="q,artist,album" />
-    <book-search available="yes" supportedParams="q,author,title" />
+{{- range .Modes}}
+    <{{.Element}} available="yes" supportedParams="{{.Params}}" />
+{{- end}}
   </searching>
   <categories>
     <category id="2000" name="Movies">
@@ -440,20 +1384,20 @@ const capsXML = `<?xml version="1.0" encoding="UTF-8"?>
       <subcat id="5070" name="Anime" />
       <subcat id="5080" name="Documentary" />
     </category>
+    <category id="7000" name="Books">
+      <subcat id="7020" name="EBook" />
+      <subcat id="7030" name="Comics" />
+    </category>
+{{- if .Adult}}
+    <category id="6000" name="XXX">
+      <subcat id="6010" name="DVD" />
+      <subcat id="6020" name="WMV" />
+      <subcat id="6030" name="XviD" />
+      <subcat id="6040" name="x264" />
+      <subcat id="6045" name="UHD" />
+      <subcat id="6050" name="Pack" />
+      <subcat id="6070" name="Other" />
+    </category>
+{{- end}}
   </categories>
-</caps>`
-
-const nzbTemplate = `<?xml version="1.0" encoding="UTF-8"?>
-<!DOCTYPE nzb PUBLIC "-//newzBin//DTD NZB 1.1//EN" "http://www.newzbin.com/DTD/nzb/nzb-1.1.dtd">
-<nzb xmlns="http://www.newzbin.com/DTD/2003/nzb">
-  <head>
-    <meta type="username">%s</meta>
-    <meta type="filename">%s</meta>
-    <meta type="size">%d</meta>
-    <meta type="name">%s</meta>
-  </head>
-  <file poster="slskrr" date="0" subject="slskd download">
-    <groups><group>alt.binaries.slskd</group></groups>
-    <segments><segment bytes="0" number="1">placeholder@slskrr</segment></segments>
-  </file>
-</nzb>`
+</caps>`))
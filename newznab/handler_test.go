@@ -1,15 +1,28 @@
 package newznab
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"encoding/xml"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/nerney/slskrr/auth"
+	"github.com/nerney/slskrr/middleware"
+	"github.com/nerney/slskrr/musicbrainz"
+	"github.com/nerney/slskrr/searchlog"
 	"github.com/nerney/slskrr/slskd"
+	"github.com/nerney/slskrr/tmdb"
 )
 
 func TestEncodeDecodeToken(t *testing.T) {
@@ -29,6 +42,66 @@ func TestEncodeDecodeToken(t *testing.T) {
 	}
 }
 
+func TestFileToken_Album(t *testing.T) {
+	decoded, err := DecodeToken(FileToken{Username: "u", Filename: "f.flac", Size: 1, Artist: "Artist", Album: "Album"}.Encode())
+	if err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if decoded.Artist != "Artist" || decoded.Album != "Album" {
+		t.Errorf("expected album carried in the token, got %+v", decoded)
+	}
+}
+
+func TestFileToken_Versions(t *testing.T) {
+	// Version 1 tokens, from before the field, still decode.
+	legacy := base64.URLEncoding.EncodeToString([]byte(`{"u":"peer","f":"Music\\Album","s":5,"d":true}`))
+	token, err := DecodeToken(legacy)
+	if err != nil || !token.Folder || token.Version != 0 {
+		t.Errorf("expected a version 1 folder token, got %+v (%v)", token, err)
+	}
+	if token, _ := DecodeToken(EncodeToken("peer", "a.mkv", 1)); token.Version != TokenVersion {
+		t.Errorf("expected version %d, got %d", TokenVersion, token.Version)
+	}
+	future := base64.URLEncoding.EncodeToString([]byte(`{"v":99,"u":"peer","f":"a.mkv","s":1}`))
+	if _, err := DecodeToken(future); err == nil {
+		t.Error("expected an error for a token from a newer version")
+	}
+
+	var tracks []*slskd.SlskdFile
+	for i := range maxTokenFiles + 1 {
+		tracks = append(tracks, &slskd.SlskdFile{Filename: fmt.Sprintf(`Music\Box\%03d.flac`, i), Size: 10})
+	}
+	if big := releaseToken(FileToken{Username: "peer"}, `Music\Box`, tracks); !big.Folder || big.Files != nil || big.Size != 10*int64(len(tracks)) {
+		t.Errorf("expected a folder token for a release too big to list, got %+v", big)
+	}
+}
+
+func TestHandler_Results_Subtitles(t *testing.T) {
+	responses := []slskd.SearchResponse{{
+		Username: "peer",
+		Files: []slskd.SlskdFile{
+			{Filename: `Movies\Heat (1995)\Heat.1995.1080p.mkv`, Size: 900 << 20},
+			{Filename: `Movies\Heat (1995)\English.srt`, Size: 100 << 10},
+			{Filename: `TV\Show S01\Show.S01E01.mkv`, Size: 300 << 20},
+			{Filename: `TV\Show S01\Show.S01E01.en.srt`, Size: 50 << 10},
+			{Filename: `TV\Show S01\Show.S01E02.mkv`, Size: 300 << 20},
+		},
+	}}
+	items, _ := (&Handler{}).page(responses, &resultFilter{action: "search", season: -1}, 10, 0)
+	if len(items) != 3 {
+		t.Fatalf("expected the subtitles grabbed with their videos rather than listed, got %+v", items)
+	}
+	movie, _ := DecodeToken(items[0].Token)
+	if len(movie.Files) != 2 || movie.Files[1].Name != `\English.srt` || items[0].Size != 900<<20+100<<10 {
+		t.Errorf("expected the lone movie with the folder's subtitles, got %+v", movie)
+	}
+	ep1, _ := DecodeToken(items[1].Token)
+	ep2, _ := DecodeToken(items[2].Token)
+	if len(ep1.Files) != 2 || ep1.Files[1].Name != `\Show.S01E01.en.srt` || len(ep2.Files) != 0 {
+		t.Errorf("expected subtitles only with the episode they're named after, got %+v and %+v", ep1, ep2)
+	}
+}
+
 func TestDecodeToken_Invalid(t *testing.T) {
 	_, err := DecodeToken("not-valid-base64!!!")
 	if err == nil {
@@ -78,9 +151,114 @@ func TestHandler_Caps(t *testing.T) {
 	}
 }
 
+func TestHandler_Caps_Conditional(t *testing.T) {
+	h := &Handler{}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/api?t=caps", nil))
+	etag := rec.Header().Get("ETag")
+	if etag == "" || rec.Header().Get("Last-Modified") == "" {
+		t.Fatalf("expected ETag and Last-Modified, got %v", rec.Header())
+	}
+
+	req := httptest.NewRequest("GET", "/api?t=caps", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("expected 304 for a matching ETag, got %d", rec.Code)
+	}
+	if rec.Body.Len() != 0 {
+		t.Errorf("expected no body on 304, got %q", rec.Body.String())
+	}
+
+	req = httptest.NewRequest("GET", "/api?t=caps", nil)
+	req.Header.Set("If-None-Match", `"stale"`)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "<caps>") {
+		t.Errorf("expected caps for a stale ETag, got %d", rec.Code)
+	}
+}
+
+func TestHandler_Caps_Limits(t *testing.T) {
+	for _, tc := range []struct {
+		h    *Handler
+		want string
+	}{
+		{&Handler{}, `<limits max="100" default="100" />`},
+		{&Handler{ResultLimit: 250}, `<limits max="250" default="250" />`},
+		{&Handler{ResultLimit: 250, MaxFiles: 50}, `<limits max="50" default="50" />`},
+	} {
+		rec := httptest.NewRecorder()
+		tc.h.ServeHTTP(rec, httptest.NewRequest("GET", "/api?t=caps", nil))
+		if body := rec.Body.String(); !strings.Contains(body, tc.want) {
+			t.Errorf("expected %s, got %s", tc.want, body)
+		}
+	}
+}
+
+func TestHandler_Adult(t *testing.T) {
+	mockSlskd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "POST":
+			json.NewEncoder(w).Encode(slskd.SearchResult{ID: "s", State: "InProgress"})
+		case "GET":
+			json.NewEncoder(w).Encode(slskd.SearchResult{
+				ID:         "s",
+				State:      "Completed",
+				IsComplete: true,
+				Responses: []slskd.SearchResponse{{
+					Username: "peer",
+					Files: []slskd.SlskdFile{
+						{Filename: `Videos\Scene.mov`, Size: 30 * 1024 * 1024},
+						{Filename: `Videos\Scene.mp4`, Size: 30 * 1024 * 1024},
+					},
+				}},
+			})
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer mockSlskd.Close()
+
+	h := &Handler{
+		SlskdClient:   slskd.NewClient(mockSlskd.URL, "testkey"),
+		SearchTimeout: 5 * time.Second,
+		BaseURL:       "http://localhost:6969",
+	}
+	get := func(query string) string {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/api?"+query, nil))
+		return rec.Body.String()
+	}
+
+	if body := get("t=caps"); strings.Contains(body, `id="6000"`) {
+		t.Error("expected no adult categories by default")
+	}
+	if body := get("t=search&q=scene&cat=6000"); strings.Contains(body, "Scene") {
+		t.Errorf("expected small scenes filtered without Adult, got: %s", body)
+	}
+
+	h = &Handler{SlskdClient: h.SlskdClient, SearchTimeout: h.SearchTimeout, BaseURL: h.BaseURL, Adult: true}
+	if body := get("t=caps"); !strings.Contains(body, `<category id="6000" name="XXX">`) {
+		t.Errorf("expected adult categories in caps, got: %s", body)
+	}
+	body := get("t=search&q=scene&cat=6000,6040")
+	if !strings.Contains(body, "Scene.mov") || !strings.Contains(body, "Scene.mp4") {
+		t.Errorf("expected both scenes in adult results, got: %s", body)
+	}
+	if !strings.Contains(body, `<newznab:attr name="category" value="6000" />`) {
+		t.Errorf("expected adult results in category 6000, got: %s", body)
+	}
+	if body := get("t=search&q=scene&cat=2000"); strings.Contains(body, "Scene") {
+		t.Errorf("expected movie searches to keep the usual rules, got: %s", body)
+	}
+}
+
 func TestHandler_Search_NoAPIKey(t *testing.T) {
 	h := &Handler{
-		APIKey: "secret",
+		Keys: auth.NewKeyring(auth.Key{Label: "test", Value: "secret"}),
 	}
 
 	req := httptest.NewRequest("GET", "/api?t=search&q=test", nil)
@@ -165,6 +343,593 @@ func TestHandler_Search_WithMockSlskd(t *testing.T) {
 	}
 }
 
+func TestHandler_Search_LimitOffset(t *testing.T) {
+	var files []slskd.SlskdFile
+	for i := range 150 {
+		files = append(files, slskd.SlskdFile{Filename: fmt.Sprintf(`Music\Album\%03d.flac`, i), Size: 20000000})
+	}
+	mockSlskd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST":
+			json.NewEncoder(w).Encode(slskd.SearchResult{ID: "s", State: "InProgress"})
+		case r.Method == "GET":
+			json.NewEncoder(w).Encode(slskd.SearchResult{
+				ID:         "s",
+				State:      "Completed",
+				IsComplete: true,
+				Responses: []slskd.SearchResponse{
+					{Username: "peer", Files: files[:100], LockedFiles: files[100:]},
+				},
+			})
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer mockSlskd.Close()
+
+	h := &Handler{
+		SlskdClient:   slskd.NewClient(mockSlskd.URL, "testkey"),
+		SearchTimeout: 5 * time.Second,
+		BaseURL:       "http://localhost:6969",
+	}
+	search := func(params string) string {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/api?t=search&q=Album"+params, nil))
+		return rec.Body.String()
+	}
+
+	body := search("")
+	if n := strings.Count(body, "<item>"); n != maxResults {
+		t.Errorf("expected %d results by default, got %d", maxResults, n)
+	}
+	if strings.Contains(body, "<newznab:response") {
+		t.Error("total should be omitted when results were cut off")
+	}
+
+	body = search("&limit=10&offset=145")
+	if n := strings.Count(body, "<item>"); n != 5 {
+		t.Errorf("expected the last 5 results, got %d", n)
+	}
+	if !strings.Contains(body, "149.flac") || strings.Contains(body, "144.flac") {
+		t.Errorf("expected results from offset 145, got: %s", body)
+	}
+	if !strings.Contains(body, `<newznab:response offset="145" total="150" />`) {
+		t.Errorf("expected total on the last page, got: %s", body)
+	}
+}
+
+func TestHandler_Results_FileCaps(t *testing.T) {
+	files := func(user string, n int) slskd.SearchResponse {
+		resp := slskd.SearchResponse{Username: user}
+		for i := range n {
+			resp.Files = append(resp.Files, slskd.SlskdFile{Filename: fmt.Sprintf(`Music\%s\%02d.flac`, user, i), Size: 20000000})
+		}
+		return resp
+	}
+	responses := []slskd.SearchResponse{files("hoarder", 50), files("alice", 3), files("bob", 3)}
+
+	count := func(h *Handler) map[string]int {
+		got := map[string]int{}
+		for item := range h.results(responses, &resultFilter{action: "search"}) {
+			got[item.Username]++
+		}
+		return got
+	}
+
+	got := count(&Handler{MaxPeerFiles: 5})
+	if got["hoarder"] != 5 || got["alice"] != 3 || got["bob"] != 3 {
+		t.Errorf("expected the hoarder capped at 5, got %v", got)
+	}
+	got = count(&Handler{MaxPeerFiles: 5, MaxFiles: 7})
+	if got["hoarder"] != 5 || got["alice"] != 2 || got["bob"] != 0 {
+		t.Errorf("expected 7 files considered in total, got %v", got)
+	}
+	if got = count(&Handler{}); got["hoarder"] != 50 {
+		t.Errorf("expected no cap by default, got %v", got)
+	}
+}
+
+func TestHandler_Results_PeerCapKeepsBest(t *testing.T) {
+	responses := []slskd.SearchResponse{{Username: "peer", Files: []slskd.SlskdFile{
+		{Filename: `Music\readme.txt`, Size: 100},
+		{Filename: `Music\a.mp3`, Size: 8000000, BitRate: 128},
+		{Filename: `Music\b.mp3`, Size: 9000000, BitRate: 320},
+		{Filename: `Music\c.flac`, Size: 30000000},
+		{Filename: `Music\d.mp3`, Size: 9500000, BitRate: 320},
+	}}}
+
+	filter := &resultFilter{action: "search"}
+	var got []string
+	for item := range (&Handler{MaxPeerFiles: 3}).results(responses, filter) {
+		got = append(got, item.Title)
+	}
+	want := []string{"c.flac [28.6 MB]", "d.mp3 [9.1 MB]", "b.mp3 [8.6 MB]"}
+	if !slices.Equal(got, want) {
+		t.Errorf("expected the best files kept, got %q", got)
+	}
+	if filter.capped != 1 || filter.extension != 1 {
+		t.Errorf("expected only eligible files counted against the cap, got %v", filter.counts())
+	}
+}
+
+func TestHandler_Search_QueryCooldown(t *testing.T) {
+	var searches atomic.Int32
+	mockSlskd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "POST":
+			searches.Add(1)
+			json.NewEncoder(w).Encode(slskd.SearchResult{ID: "s", State: "InProgress"})
+		case "GET":
+			json.NewEncoder(w).Encode(slskd.SearchResult{
+				ID:         "s",
+				State:      "Completed",
+				IsComplete: true,
+				Responses: []slskd.SearchResponse{{
+					Username: "peer",
+					Files:    []slskd.SlskdFile{{Filename: `Music\Album\01.flac`, Size: 20000000}},
+				}},
+			})
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer mockSlskd.Close()
+
+	h := &Handler{
+		SlskdClient:   slskd.NewClient(mockSlskd.URL, "testkey"),
+		SearchTimeout: 5 * time.Second,
+		BaseURL:       "http://localhost:6969",
+		QueryCooldown: time.Hour,
+	}
+	hits, misses := searchCache.Value("search", "hit"), searchCache.Value("search", "miss")
+	stats := CacheStats()
+	filtered := searchResults.Count("search", "filtered")
+	for _, q := range []string{"Some+Album", "some++album"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/api?t=search&q="+q, nil))
+		if !strings.Contains(rec.Body.String(), "01.flac") {
+			t.Errorf("expected results for %q, got: %s", q, rec.Body.String())
+		}
+	}
+	if n := searches.Load(); n != 1 {
+		t.Errorf("expected one slskd search within the cooldown, got %d", n)
+	}
+	if searchCache.Value("search", "hit")-hits != 1 || searchCache.Value("search", "miss")-misses != 1 {
+		t.Error("expected one cache hit and one miss counted")
+	}
+	if got := CacheStats(); got["hit"]-stats["hit"] != 1 || got["miss"]-stats["miss"] != 1 {
+		t.Errorf("expected the hit and the miss in the cache stats, got %v", got)
+	}
+	if n := searchResults.Count("search", "filtered") - filtered; n != 2 {
+		t.Errorf("expected both searches' result counts observed, got %d", n)
+	}
+
+	// The cache is shared across categories, and nocache skips it.
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/api?t=music&q=some+album", nil))
+	if n := searches.Load(); n != 1 {
+		t.Errorf("expected a music search for the same query answered from the cache, got %d searches", n)
+	}
+	bypassed := searchCache.Value("search", "bypass")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/api?t=search&q=some+album&nocache=1", nil))
+	if n := searches.Load(); n != 2 {
+		t.Errorf("expected nocache to search again, got %d searches", n)
+	}
+	if searchCache.Value("search", "bypass")-bypassed != 1 {
+		t.Error("expected the bypass counted")
+	}
+}
+
+func TestHandler_Search_QueryCooldownEarlyResults(t *testing.T) {
+	var searches atomic.Int32
+	mockSlskd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "POST":
+			searches.Add(1)
+			json.NewEncoder(w).Encode(slskd.SearchResult{ID: "s", State: "InProgress"})
+		case "GET":
+			json.NewEncoder(w).Encode(slskd.SearchResult{
+				ID:         "s",
+				State:      "Completed",
+				IsComplete: true,
+				Responses: []slskd.SearchResponse{{
+					Username:          "peer",
+					HasFreeUploadSlot: true,
+					Files: []slskd.SlskdFile{
+						{Filename: `Movies\Some.Title.2024.mkv`, Size: 2000000000},
+						{Filename: `Music\Some Title\01.flac`, Size: 20000000},
+					},
+				}},
+			})
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer mockSlskd.Close()
+
+	h := &Handler{
+		SlskdClient:   slskd.NewClient(mockSlskd.URL, "testkey"),
+		SearchTimeout: 5 * time.Second,
+		BaseURL:       "http://localhost:6969",
+		QueryCooldown: time.Hour,
+		EarlyResults:  1,
+	}
+	// A search that may stop early once it has a film holds nothing a
+	// music search for the same query can rely on.
+	for i, search := range []string{"movie", "music", "movie"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/api?t="+search+"&q=some+title", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: unexpected status %d", search, rec.Code)
+		}
+		if want := int32(min(i+1, 2)); searches.Load() != want {
+			t.Errorf("after the %s search: expected %d slskd searches, got %d", search, want, searches.Load())
+		}
+	}
+}
+
+func TestHandler_Results_Relaxed(t *testing.T) {
+	responses := []slskd.SearchResponse{{
+		Username: "peer",
+		Files: []slskd.SlskdFile{
+			{Filename: `Movies\Short.Film.mkv`, Size: 10000000},
+			{Filename: `Movies\Short.Film.nfo`, Size: 1000},
+			{Filename: `Movies\Empty.mkv`, Size: 0},
+		},
+	}}
+	h := &Handler{}
+
+	filter := &resultFilter{action: "movie"}
+	if items, _ := h.page(responses, filter, 10, 0); len(items) != 0 {
+		t.Fatalf("expected the small film filtered out, got %+v", items)
+	}
+	if filter.size != 2 || filter.extension != 1 {
+		t.Errorf("expected 2 size and 1 extension rejections, got %+v", filter)
+	}
+
+	items, _ := h.page(responses, &resultFilter{action: "movie", relaxed: true}, 10, 0)
+	if len(items) != 1 || !strings.Contains(items[0].Title, "Short.Film.mkv") {
+		t.Errorf("expected only the small film when relaxed, got %+v", items)
+	}
+}
+
+func TestHandler_Search_SlskdBlacklist(t *testing.T) {
+	mockSlskd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/v0/options":
+			w.Write([]byte(`{"groups":{"blacklisted":{"members":["baduser"]}}}`))
+		case r.Method == "POST":
+			json.NewEncoder(w).Encode(slskd.SearchResult{ID: "s1"})
+		case r.Method == "GET":
+			json.NewEncoder(w).Encode(slskd.SearchResult{
+				ID:         "s1",
+				IsComplete: true,
+				Responses: []slskd.SearchResponse{
+					{Username: "baduser", Files: []slskd.SlskdFile{{Filename: `Movies\Bad.Movie.2024.mkv`, Size: 2000000000}}},
+					{Username: "gooduser", Files: []slskd.SlskdFile{{Filename: `Movies\Good.Movie.2024.mkv`, Size: 2000000000}}},
+				},
+			})
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer mockSlskd.Close()
+
+	for _, enabled := range []bool{true, false} {
+		h := &Handler{
+			SlskdClient:    slskd.NewClient(mockSlskd.URL, "testkey"),
+			SearchTimeout:  5 * time.Second,
+			SlskdBlacklist: enabled,
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/api?t=search&q=Movie", nil))
+
+		body := rec.Body.String()
+		if !strings.Contains(body, "Good.Movie") {
+			t.Errorf("enabled=%v: expected gooduser's file, got: %s", enabled, body)
+		}
+		if strings.Contains(body, "Bad.Movie") == enabled {
+			t.Errorf("enabled=%v: unexpected presence of baduser's file in: %s", enabled, body)
+		}
+	}
+}
+
+func TestHandler_EnoughResults(t *testing.T) {
+	responses := []slskd.SearchResponse{
+		{Username: "slow", QueueLength: 50, Files: []slskd.SlskdFile{
+			{Filename: `Movies\Movie.2024.1080p.mkv`, Size: 2000000000},
+			{Filename: `Movies\Movie.2024.720p.mkv`, Size: 1000000000},
+		}},
+		{Username: "free", HasFreeUploadSlot: true, Files: []slskd.SlskdFile{
+			{Filename: `Movies\Movie.2024.2160p.mkv`, Size: 8000000000},
+			{Filename: `Movies\Movie.2024.nfo`, Size: 1000},
+		}},
+	}
+
+	h := &Handler{EarlyResults: 2, EarlyScore: 500}
+	if h.enoughResults(responses, resultFilter{action: "movie"}) {
+		t.Error("expected the slow peer's files and the nfo not to count")
+	}
+	h.EarlyResults = 1
+	if !h.enoughResults(responses, resultFilter{action: "movie"}) {
+		t.Error("expected the free peer's film to be enough")
+	}
+	h.EarlyResults, h.EarlyScore = 3, -100
+	if !h.enoughResults(responses, resultFilter{action: "movie"}) {
+		t.Error("expected every film to count with a low score")
+	}
+}
+
+func TestHandler_Results_MusicFolders(t *testing.T) {
+	responses := []slskd.SearchResponse{{
+		Username: "peer",
+		Files: []slskd.SlskdFile{
+			{Filename: `Music\Artist - Album\FLAC\01 - One.flac`, Size: 30000000, BitRate: 900},
+			{Filename: `Music\Artist - Album\FLAC\02 - Two.flac`, Size: 20000000, BitRate: 1000},
+			{Filename: `Music\Singles\Single.mp3`, Size: 5000000},
+		},
+	}}
+	h := &Handler{}
+
+	items, _ := h.page(responses, &resultFilter{action: "music"}, 10, 0)
+	if len(items) != 2 {
+		t.Fatalf("expected the album and the single, got %+v", items)
+	}
+	album := items[0]
+	if album.Title != "Artist - Album [47.7 MB]" || album.Size != 50000000 || album.Filename != `Music\Artist - Album\FLAC` {
+		t.Errorf("unexpected album item: %+v", album)
+	}
+	token, err := DecodeToken(album.Token)
+	if err != nil || token.Filename != `Music\Artist - Album\FLAC` || token.BitRate != 1000 || len(token.Files) != 2 {
+		t.Errorf("expected a token listing the album's tracks, got %+v (%v)", token, err)
+	}
+	if files := token.Downloads(); files[0].Filename != `Music\Artist - Album\FLAC\01 - One.flac` || files[0].Size != 30000000 {
+		t.Errorf("unexpected album files %+v", files)
+	}
+	if token, _ := DecodeToken(items[1].Token); len(token.Files) > 0 || !strings.Contains(items[1].Title, "Single.mp3") {
+		t.Errorf("expected a lone track to stay a file, got %+v", items[1])
+	}
+
+	// Other searches list the tracks.
+	if items, _ := h.page(responses, &resultFilter{action: "search"}, 10, 0); len(items) != 3 {
+		t.Errorf("expected every track in a general search, got %+v", items)
+	}
+}
+
+func TestHandler_Results_SeasonPacks(t *testing.T) {
+	responses := []slskd.SearchResponse{{
+		Username: "peer",
+		Files: []slskd.SlskdFile{
+			{Filename: `TV\Show\Season 1\Show.S01E01.1080p.mkv`, Size: 600 << 20},
+			{Filename: `TV\Show\Season 1\Show.S01E02.1080p.mkv`, Size: 400 << 20},
+			{Filename: `TV\Show\Season 2\Show.S02E01.1080p.mkv`, Size: 500 << 20},
+			{Filename: `TV\Show\Season 2\Show.S02E02.1080p.mkv`, Size: 500 << 20},
+		},
+	}}
+	h := &Handler{}
+
+	items, _ := h.page(responses, &resultFilter{action: "tvsearch", season: 1}, 10, 0)
+	if len(items) != 5 {
+		t.Fatalf("expected the pack and each episode, got %+v", items)
+	}
+	pack := items[0]
+	if pack.Title != "Show.S01.1080p [1000.0 MB]" || pack.Size != 1000<<20 || pack.Category != "5000" {
+		t.Errorf("unexpected season pack: %+v", pack)
+	}
+	token, err := DecodeToken(pack.Token)
+	if err != nil || token.Filename != `TV\Show\Season 1` || len(token.Files) != 2 || token.Size != 1000<<20 {
+		t.Errorf("expected a token listing the season's episodes, got %+v (%v)", token, err)
+	}
+	for _, item := range items[1:] {
+		if token, _ := DecodeToken(item.Token); len(token.Files) > 0 {
+			t.Errorf("expected only one pack, got %+v", item)
+		}
+	}
+
+	// Episode searches list only the files.
+	if items, _ := h.page(responses, &resultFilter{action: "tvsearch", season: -1}, 10, 0); len(items) != 4 {
+		t.Errorf("expected no packs without a season search, got %+v", items)
+	}
+}
+
+func TestHandler_Results_ReleaseNames(t *testing.T) {
+	responses := []slskd.SearchResponse{{
+		Username: "peer",
+		Files: []slskd.SlskdFile{
+			{Filename: `Movies\Cool Movie (2024) 1080p\movie.mkv`, Size: 600 << 20},
+			{Filename: `TV\Show\Season 1\05 - Episode 5.mkv`, Size: 300 << 20},
+			{Filename: `Movies\untitled.mkv`, Size: 100 << 20},
+		},
+	}}
+	h := &Handler{}
+
+	items, _ := h.page(responses, &resultFilter{action: "search", names: true}, 10, 0)
+	var titles []string
+	for _, item := range items {
+		titles = append(titles, item.Title)
+	}
+	want := []string{"Cool.Movie.2024.1080p [600.0 MB]", "Show.S01E05 [300.0 MB]", "untitled.mkv [100.0 MB]"}
+	if !slices.Equal(titles, want) {
+		t.Errorf("got titles %q, want %q", titles, want)
+	}
+	if token, _ := DecodeToken(items[0].Token); token.Filename != `Movies\Cool Movie (2024) 1080p\movie.mkv` {
+		t.Errorf("expected the original path in the token, got %q", token.Filename)
+	}
+
+	music := []slskd.SearchResponse{{
+		Username: "peer",
+		Files: []slskd.SlskdFile{
+			{Filename: `Music\Album (2020)\01 - One.flac`, Size: 30000000},
+			{Filename: `Music\Album (2020)\02 - Two.flac`, Size: 20000000},
+		},
+	}}
+	items, _ = h.page(music, &resultFilter{action: "music", artist: "Artist", names: true}, 10, 0)
+	if len(items) != 1 || items[0].Title != "Artist - Album (2020) [FLAC] [47.7 MB]" {
+		t.Errorf("expected a synthesized album title, got %+v", items)
+	}
+}
+
+func TestHandler_Results_AudiobookDuration(t *testing.T) {
+	responses := []slskd.SearchResponse{{
+		Username: "peer",
+		Files: []slskd.SlskdFile{
+			{Filename: `Books\Dune (Unabridged).m4b`, Size: 600 << 20, Length: 21*3600 + 2*60},
+			{Filename: `Books\Dune (Abridged).m4b`, Size: 200 << 20, Length: 6*3600 + 23*60},
+			{Filename: `Books\Dune.m4b`, Size: 100 << 20},
+		},
+	}}
+	h := &Handler{}
+
+	items, _ := h.page(responses, &resultFilter{action: "book"}, 10, 0)
+	want := []string{
+		"Dune (Unabridged).m4b [600.0 MB] (21h 2m)",
+		"Dune (Abridged).m4b [200.0 MB] (6h 23m)",
+		"Dune.m4b [100.0 MB]",
+	}
+	var titles []string
+	for _, item := range items {
+		titles = append(titles, item.Title)
+	}
+	if !slices.Equal(titles, want) {
+		t.Errorf("got titles %q, want %q", titles, want)
+	}
+
+	if got := formatDuration(45*60 + 30); got != "45m" {
+		t.Errorf("formatDuration = %q, want 45m", got)
+	}
+}
+
+func TestHandler_Search_CategoryKinds(t *testing.T) {
+	mockSlskd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "POST":
+			json.NewEncoder(w).Encode(slskd.SearchResult{ID: "s", State: "InProgress"})
+		case "GET":
+			json.NewEncoder(w).Encode(slskd.SearchResult{
+				ID:         "s",
+				State:      "Completed",
+				IsComplete: true,
+				Responses: []slskd.SearchResponse{{
+					Username: "peer",
+					Files: []slskd.SlskdFile{
+						{Filename: `Movies\Dune.2021.1080p.mkv`, Size: 4 << 30},
+						{Filename: `Music\Dune OST\01 - Dream of Arrakis.flac`, Size: 30 << 20},
+						{Filename: `Books\Dune (Unabridged).m4b`, Size: 600 << 20},
+					},
+				}},
+			})
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer mockSlskd.Close()
+
+	h := &Handler{
+		SlskdClient:   slskd.NewClient(mockSlskd.URL, "testkey"),
+		SearchTimeout: 5 * time.Second,
+		BaseURL:       "http://localhost:6969",
+	}
+	for cats, want := range map[string][]string{
+		"2000":      {".mkv"},
+		"5000,5040": {".mkv"},
+		"3000":      {".flac"},
+		"3030":      {".m4b", ".flac"},
+		"2000,3000": {".mkv", ".flac"},
+		"":          {".mkv", ".flac", ".m4b"},
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/api?t=search&q=dune&cat="+cats, nil))
+		body := rec.Body.String()
+		for _, ext := range []string{".mkv", ".flac", ".m4b"} {
+			if got := strings.Contains(body, ext+" ["); got != slices.Contains(want, ext) {
+				t.Errorf("cat=%s: expected %s offered %v, got: %s", cats, ext, !got, body)
+			}
+		}
+	}
+}
+
+func TestHandler_BookSearch_Ebook(t *testing.T) {
+	var query string
+	mockSlskd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "POST":
+			var req slskd.SearchRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			query = req.SearchText
+			json.NewEncoder(w).Encode(slskd.SearchResult{ID: "s", State: "InProgress"})
+		case "GET":
+			json.NewEncoder(w).Encode(slskd.SearchResult{
+				ID:         "s",
+				State:      "Completed",
+				IsComplete: true,
+				Responses: []slskd.SearchResponse{{
+					Username: "peer",
+					Files: []slskd.SlskdFile{
+						{Filename: `Books\Frank Herbert - Dune.epub`, Size: 800 << 10},
+						{Filename: `Books\Frank Herbert - Dune (sample).epub`, Size: 10 << 10},
+						{Filename: `Books\Dune (Unabridged).m4b`, Size: 600 << 20},
+						{Filename: `Comics\Dune 01.cbz`, Size: 40 << 20},
+					},
+				}},
+			})
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer mockSlskd.Close()
+
+	h := &Handler{
+		SlskdClient:   slskd.NewClient(mockSlskd.URL, "testkey"),
+		SearchTimeout: 5 * time.Second,
+		BaseURL:       "http://localhost:6969",
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/api?t=book&author=Frank+Herbert&title=Dune&cat=7000,7020", nil))
+	body := rec.Body.String()
+
+	if query != "Frank Herbert Dune" {
+		t.Errorf("expected the ebook search without the audiobook suffix, got %q", query)
+	}
+	if !strings.Contains(body, "Frank Herbert - Dune.epub") || !strings.Contains(body, `<newznab:attr name="category" value="7020" />`) {
+		t.Errorf("expected the epub in category 7020, got: %s", body)
+	}
+	if !strings.Contains(body, `<newznab:attr name="category" value="7030" />`) {
+		t.Errorf("expected the comic in category 7030, got: %s", body)
+	}
+	if strings.Contains(body, "sample") || strings.Contains(body, ".m4b") {
+		t.Errorf("expected the tiny epub and the audiobook dropped, got: %s", body)
+	}
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api?t=book&author=Frank+Herbert&title=Dune&cat=3030", nil))
+	if query != "Frank Herbert Dune audiobook" {
+		t.Errorf("expected audiobook searches unchanged, got %q", query)
+	}
+
+	for cats, want := range map[string]bool{"7020": true, "7000,7020": true, "3030,7020": false, "": false, "2000": false} {
+		if ebookCategory(cats) != want {
+			t.Errorf("ebookCategory(%q) = %v, want %v", cats, !want, want)
+		}
+	}
+}
+
+func TestWriteSearchResponse_NonUTF8(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writeSearchResponse(rec, newznabFormat, []searchItem{{Title: "Beyonc\xe9 \x01- Halo.flac", Token: "t", Size: 1, Category: "3000"}}, "http://x", 0, 1)
+
+	var feed struct {
+		Items []struct {
+			Title string `xml:"title"`
+		} `xml:"channel>item"`
+	}
+	if err := xml.Unmarshal(rec.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("feed should be valid XML: %v", err)
+	}
+	if len(feed.Items) != 1 || feed.Items[0].Title != "Beyoncé - Halo.flac" {
+		t.Errorf("expected a repaired title, got %+v", feed.Items)
+	}
+}
+
 func TestHandler_TVSearch_QueryConstruction(t *testing.T) {
 	var receivedQuery string
 	mockSlskd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -204,6 +969,236 @@ func TestHandler_TVSearch_QueryConstruction(t *testing.T) {
 	}
 }
 
+func TestHandler_TVSearch_QueryCleanup(t *testing.T) {
+	var mu sync.Mutex
+	var queries []string
+	mockSlskd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && strings.HasPrefix(r.URL.Path, "/api/v0/searches"):
+			var req slskd.SearchRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			mu.Lock()
+			queries = append(queries, req.SearchText)
+			mu.Unlock()
+			json.NewEncoder(w).Encode(slskd.SearchResult{ID: "s1", State: "InProgress"})
+		case r.Method == "GET" && strings.HasSuffix(r.URL.Path, "/s1"):
+			json.NewEncoder(w).Encode(slskd.SearchResult{ID: "s1", State: "Completed, TimedOut", IsComplete: true})
+		case r.Method == "DELETE":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer mockSlskd.Close()
+
+	h := &Handler{
+		SlskdClient:   slskd.NewClient(mockSlskd.URL, "testkey"),
+		SearchTimeout: 5 * time.Second,
+		BaseURL:       "http://localhost:6969",
+		QueryCleanup:  DefaultQueryRules,
+	}
+
+	req := httptest.NewRequest("GET", "/api?t=tvsearch&q="+url.QueryEscape("The Office (US) aka Office: An American Workplace")+"&season=2&ep=1", nil)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	want := []string{"The Office S02E01", "Office An American Workplace S02E01"}
+	if !slices.Equal(queries, want) {
+		t.Errorf("expected %q, got %q", want, queries)
+	}
+}
+
+func TestHandler_MovieSearch_OriginalTitle(t *testing.T) {
+	var mu sync.Mutex
+	var queries []string
+	mockSlskd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && strings.HasPrefix(r.URL.Path, "/api/v0/searches"):
+			var req slskd.SearchRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			mu.Lock()
+			queries = append(queries, req.SearchText)
+			mu.Unlock()
+			json.NewEncoder(w).Encode(slskd.SearchResult{ID: "s1", State: "InProgress"})
+		case r.Method == "GET" && strings.HasSuffix(r.URL.Path, "/s1"):
+			json.NewEncoder(w).Encode(slskd.SearchResult{ID: "s1", State: "Completed, TimedOut", IsComplete: true})
+		case r.Method == "DELETE":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer mockSlskd.Close()
+	mockTMDB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		movie := `{"title":"Amélie","original_title":"Le Fabuleux Destin d'Amélie Poulain","release_date":"2001-04-25"}`
+		if strings.HasPrefix(r.URL.Path, "/find/") {
+			movie = `{"movie_results":[` + movie + `]}`
+		}
+		w.Write([]byte(movie))
+	}))
+	defer mockTMDB.Close()
+
+	h := &Handler{
+		SlskdClient:   slskd.NewClient(mockSlskd.URL, "testkey"),
+		SearchTimeout: 5 * time.Second,
+		BaseURL:       "http://localhost:6969",
+		TMDB:          tmdb.NewClient("key"),
+	}
+	h.TMDB.BaseURL = mockTMDB.URL
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/api?t=caps", nil))
+	if !strings.Contains(rec.Body.String(), `supportedParams="q,year,imdbid,tmdbid"`) {
+		t.Errorf("expected movie id params in caps, got %s", rec.Body.String())
+	}
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api?t=movie&q=Am%C3%A9lie&year=2001&tmdbid=194", nil))
+	want := []string{"Amélie", "Le Fabuleux Destin d'Amélie Poulain 2001"}
+	if !slices.Equal(queries, want) {
+		t.Errorf("expected %q, got %q", want, queries)
+	}
+
+	queries = nil
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api?t=movie&imdbid=tt0211915", nil))
+	want = []string{"Amélie 2001", "Amélie", "Le Fabuleux Destin d'Amélie Poulain 2001"}
+	if !slices.Equal(queries, want) {
+		t.Errorf("expected %q, got %q", want, queries)
+	}
+}
+
+func TestHandler_MusicSearch_MusicBrainz(t *testing.T) {
+	var mu sync.Mutex
+	var queries []string
+	mockSlskd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && strings.HasPrefix(r.URL.Path, "/api/v0/searches"):
+			var req slskd.SearchRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			mu.Lock()
+			queries = append(queries, req.SearchText)
+			mu.Unlock()
+			json.NewEncoder(w).Encode(slskd.SearchResult{ID: "s1", State: "InProgress"})
+		case r.Method == "GET" && strings.HasSuffix(r.URL.Path, "/s1"):
+			json.NewEncoder(w).Encode(slskd.SearchResult{ID: "s1", State: "Completed, TimedOut", IsComplete: true})
+		case r.Method == "DELETE":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer mockSlskd.Close()
+	mockMB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/release-group/rg1":
+			w.Write([]byte(`{"title":"OK Computer","first-release-date":"1997-05-21","artist-credit":[{"name":"Radiohead"}]}`))
+		case "/release":
+			w.Write([]byte(`{"releases":[{"media":[{"track-count":12}]}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer mockMB.Close()
+
+	h := &Handler{
+		SlskdClient:   slskd.NewClient(mockSlskd.URL, "testkey"),
+		SearchTimeout: 5 * time.Second,
+		BaseURL:       "http://localhost:6969",
+		MusicBrainz:   musicbrainz.NewClient(),
+	}
+	h.MusicBrainz.BaseURL = mockMB.URL
+	h.MusicBrainz.Interval = 0
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/api?t=caps", nil))
+	if !strings.Contains(rec.Body.String(), `supportedParams="q,artist,album,artistmbid,albummbid"`) {
+		t.Errorf("expected music id params in caps, got %s", rec.Body.String())
+	}
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api?t=music&artist=radiohead&album=ok+computer+(remastered)&albummbid=rg1", nil))
+	if want := []string{"Radiohead OK Computer"}; !slices.Equal(queries, want) {
+		t.Errorf("expected %q, got %q", want, queries)
+	}
+
+	// Only folders holding a whole release are offered.
+	folder := func(dir string, n int) []slskd.SlskdFile {
+		var files []slskd.SlskdFile
+		for i := range n {
+			files = append(files, slskd.SlskdFile{Filename: fmt.Sprintf(`%s\%02d.flac`, dir, i+1), Size: 20 << 20})
+		}
+		return files
+	}
+	release, _ := h.MusicBrainz.Album(context.Background(), "rg1")
+	responses := []slskd.SearchResponse{
+		{Username: "whole", Files: folder(`Radiohead\OK Computer`, 12)},
+		{Username: "partial", Files: folder(`Radiohead\OK Computer`, 5)},
+	}
+	filter := &resultFilter{action: "music", release: release}
+	items, _ := h.page(responses, filter, 10, 0)
+	if len(items) != 1 || items[0].Username != "whole" || filter.incomplete != 5 {
+		t.Errorf("expected only the whole album, got %+v (%d incomplete)", items, filter.incomplete)
+	}
+}
+
+func TestHandler_SearchTimeout(t *testing.T) {
+	h := &Handler{SearchTimeout: 30 * time.Second, MaxTimeout: 2 * time.Minute}
+	tests := []struct {
+		param string
+		want  time.Duration
+	}{
+		{"", 30 * time.Second},
+		{"90", 90 * time.Second},
+		{"45s", 45 * time.Second},
+		{"10", 10 * time.Second},
+		{"1h", 2 * time.Minute},
+		{"-5", 30 * time.Second},
+		{"soon", 30 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := h.searchTimeout(url.Values{"timeout": {tt.param}}); got != tt.want {
+			t.Errorf("timeout=%q: got %v, want %v", tt.param, got, tt.want)
+		}
+	}
+
+	h.MaxTimeout = 0
+	if got := h.searchTimeout(url.Values{"timeout": {"90"}}); got != 30*time.Second {
+		t.Errorf("expected the parameter ignored without MaxTimeout, got %v", got)
+	}
+}
+
+func TestHandler_CleanQuery(t *testing.T) {
+	h := &Handler{QueryCleanup: DefaultQueryRules}
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"Shameless (US)", []string{"Shameless"}},
+		{"Mission: Impossible (1996)", []string{"Mission Impossible (1996)"}},
+		{"Le Samourai a.k.a. The Samurai 1967", []string{"Le Samourai 1967", "The Samurai 1967"}},
+		{"(US)", []string{"(US)"}},
+	}
+	for _, tt := range tests {
+		if got := h.cleanQuery(tt.in); !slices.Equal(got, tt.want) {
+			t.Errorf("cleanQuery(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	h.QueryCleanup = []QueryRule{RuleColon}
+	if got := h.cleanQuery("Shameless (US): Uncut aka Other"); !slices.Equal(got, []string{"Shameless (US) Uncut aka Other"}) {
+		t.Errorf("expected only colons replaced, got %q", got)
+	}
+}
+
+func TestParseQueryRules(t *testing.T) {
+	if rules, err := ParseQueryRules("aka, Colon,aka"); err != nil || !slices.Equal(rules, []QueryRule{RuleAKA, RuleColon}) {
+		t.Errorf("unexpected rules: %v %v", rules, err)
+	}
+	if rules, err := ParseQueryRules("none"); err != nil || rules != nil {
+		t.Errorf("expected no rules, got %v %v", rules, err)
+	}
+	if _, err := ParseQueryRules("region,bogus"); err == nil {
+		t.Error("expected error for unknown rule")
+	}
+}
+
 func TestHandler_Get(t *testing.T) {
 	h := &Handler{
 		BaseURL: "http://localhost:6969",
@@ -271,3 +1266,219 @@ func TestHandler_EmptySearch(t *testing.T) {
 		t.Error("expected mock item to contain slskrr-test title")
 	}
 }
+
+func TestHandler_EmptySearch_TestResponses(t *testing.T) {
+	h := &Handler{
+		BaseURL:       "http://localhost:6969",
+		Keys:          auth.NewKeyring(auth.Key{Label: "radarr", Value: "rkey"}, auth.Key{Label: "lidarr", Value: "lkey"}),
+		QueryCooldown: time.Hour,
+		TestResponses: map[string]TestResponse{"lidarr": TestEmpty, "music": TestItem, "radarr": TestRecent},
+	}
+
+	get := func(query string) string {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/api?"+query, nil))
+		return rec.Body.String()
+	}
+	if body := get("t=search&apikey=lkey"); strings.Contains(body, "<item>") {
+		t.Errorf("expected an empty feed for lidarr, got: %s", body)
+	}
+	if body := get("t=music&apikey=rkey&cat=3000"); !strings.Contains(body, "slskrr-test") {
+		t.Errorf("expected the radarr override to win over the function's, got: %s", body)
+	}
+	if body := get("t=music&apikey=lkey"); strings.Contains(body, "<item>") {
+		t.Errorf("expected the lidarr override to win over the function's, got: %s", body)
+	}
+
+	// recent falls back to the test item until a search is cached.
+	if body := get("t=search&apikey=rkey"); !strings.Contains(body, "slskrr-test") {
+		t.Errorf("expected the test item with nothing cached, got: %s", body)
+	}
+	h.recent.put("some album", "", []slskd.SearchResponse{{
+		Username: "peer",
+		Files:    []slskd.SlskdFile{{Filename: `Music\Album\01.flac`, Size: 20000000}},
+	}}, h.QueryCooldown)
+	if body := get("t=search&apikey=rkey"); !strings.Contains(body, "01.flac") || strings.Contains(body, "slskrr-test") {
+		t.Errorf("expected recently cached results, got: %s", body)
+	}
+}
+
+func TestParseTestResponses(t *testing.T) {
+	modes, err := ParseTestResponses("Sonarr:empty, tvsearch:RECENT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if modes["sonarr"] != TestEmpty || modes["tvsearch"] != TestRecent {
+		t.Errorf("unexpected modes: %v", modes)
+	}
+	for _, bad := range []string{"sonarr", ":empty", "sonarr:maybe"} {
+		if _, err := ParseTestResponses(bad); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
+
+func TestHandler_EmptySearch_ForwardedHeaders(t *testing.T) {
+	h := &Handler{
+		BaseURL: "http://localhost:6969",
+	}
+
+	req := httptest.NewRequest("GET", "/api?t=search&q=", nil)
+	req.Header.Set("X-Forwarded-Proto", "https")
+	req.Header.Set("X-Forwarded-Host", "media.example.com")
+	req.Header.Set("X-Forwarded-Prefix", "/slskrr")
+	rec := httptest.NewRecorder()
+	middleware.TrustedProxies([]netip.Prefix{netip.MustParsePrefix("192.0.2.1/32")}, h).ServeHTTP(rec, req)
+
+	body := rec.Body.String()
+	if !strings.Contains(body, "https://media.example.com/slskrr/api?t=get") {
+		t.Errorf("expected download link built from forwarded headers, got: %s", body)
+	}
+}
+
+func TestHandler_EmptySearch_ExternalURL(t *testing.T) {
+	h := &Handler{
+		BaseURL:     "http://localhost:6969",
+		ExternalURL: "http://slskrr:6969/indexer",
+	}
+
+	req := httptest.NewRequest("GET", "/api?t=search&q=", nil)
+	req.Header.Set("X-Forwarded-Host", "media.example.com")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	body := rec.Body.String()
+	if !strings.Contains(body, "http://slskrr:6969/indexer/api?t=get") {
+		t.Errorf("expected download link built from ExternalURL, got: %s", body)
+	}
+}
+
+func TestHandler_RateLimited(t *testing.T) {
+	h := &Handler{
+		BaseURL: "http://localhost:6969",
+		Limiter: middleware.NewRateLimiter(60, 1),
+	}
+
+	req := httptest.NewRequest("GET", "/api?t=search&q=", nil)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if !strings.Contains(rec.Body.String(), `code="500"`) {
+		t.Errorf("expected request limit error, got: %s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/api?t=get&id=x", nil))
+	if !strings.Contains(rec.Body.String(), `code="501"`) {
+		t.Errorf("expected download limit error, got: %s", rec.Body.String())
+	}
+
+	// caps is exempt
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/api?t=caps", nil))
+	if !strings.Contains(rec.Body.String(), "<caps>") {
+		t.Error("expected caps to bypass rate limiting")
+	}
+}
+
+func TestHandler_Search_Log(t *testing.T) {
+	mockSlskd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST":
+			json.NewEncoder(w).Encode(slskd.SearchResult{ID: "s1", State: "InProgress"})
+		case r.Method == "GET":
+			json.NewEncoder(w).Encode(slskd.SearchResult{ID: "s1", IsComplete: true, Responses: []slskd.SearchResponse{{
+				Username: "peer",
+				Files:    []slskd.SlskdFile{{Filename: `Movies\Heat (1995)\Heat.mkv`, Size: 700 << 20}},
+			}}})
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer mockSlskd.Close()
+
+	h := &Handler{
+		SlskdClient:   slskd.NewClient(mockSlskd.URL, "testkey"),
+		SearchTimeout: 5 * time.Second,
+		BaseURL:       "http://localhost:6969",
+		QueryCooldown: time.Minute,
+		Searches:      searchlog.New(10),
+	}
+	for _, id := range []string{"req-1", "req-2"} {
+		req := httptest.NewRequest("GET", "/api?t=movie&q=Heat", nil)
+		h.ServeHTTP(httptest.NewRecorder(), req.WithContext(middleware.WithRequestID(req.Context(), id)))
+	}
+
+	s, err := h.Searches.Get("req-1")
+	if err != nil {
+		t.Fatalf("expected the search logged: %v", err)
+	}
+	if len(s.Slskd) != 1 || s.Slskd[0].SearchID != "s1" || s.Slskd[0].Query != "Heat" {
+		t.Errorf("unexpected slskd searches: %+v", s.Slskd)
+	}
+	if len(s.Responses) != 1 || len(s.Results) != 1 || s.Results[0].Filename != `Movies\Heat (1995)\Heat.mkv` {
+		t.Errorf("unexpected responses %+v and results %+v", s.Responses, s.Results)
+	}
+	if s, _ := h.Searches.Get("req-2"); len(s.Slskd) != 1 || s.Slskd[0].SearchID != "" {
+		t.Errorf("expected the repeat served from the cooldown cache, got %+v", s.Slskd)
+	}
+}
+
+func TestHandler_Errors(t *testing.T) {
+	h := &Handler{
+		BaseURL: "http://localhost:6969",
+		Keys:    auth.NewKeyring(auth.Key{Label: "default", Value: "testapikey"}),
+	}
+	tests := []struct {
+		query string
+		code  string
+		desc  string
+	}{
+		{"", "200", "Missing parameter (t)"},
+		{"t=bogus", "202", "No such function (bogus)"},
+		{"t=details&id=1", "203", "Function not available (details)"},
+		{"t=search&q=x&apikey=wrong", "100", "Incorrect user credentials"},
+		{"t=search&q=x&apikey=testapikey&limit=ten", "201", "Incorrect parameter (limit)"},
+		{"t=search&q=x&apikey=testapikey&offset=-1", "201", "Incorrect parameter (offset)"},
+		{"t=movie&q=x&apikey=testapikey&cat=2000,movies", "201", "Incorrect parameter (cat)"},
+		{"t=get&apikey=testapikey", "200", "Missing parameter (id)"},
+		{"t=get&apikey=testapikey&id=garbage", "300", "No such item"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/api?"+tt.query, nil))
+		want := fmt.Sprintf(`<error code="%s" description="%s" />`, tt.code, tt.desc)
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("%s: expected %s, got %s", tt.query, want, rec.Body.String())
+		}
+	}
+}
+
+func TestHandler_Search_Unreachable(t *testing.T) {
+	mock := httptest.NewServer(http.NotFoundHandler())
+	mock.Close()
+	h := &Handler{
+		SlskdClient:   slskd.NewClient(mock.URL, "key"),
+		BaseURL:       "http://localhost:6969",
+		SearchTimeout: time.Second,
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/api?t=search&q=x", nil))
+	if !strings.Contains(rec.Body.String(), `<error code="900" description="slskd is unreachable" />`) {
+		t.Errorf("expected an unreachable error, got %s", rec.Body.String())
+	}
+}
+
+func TestHandler_Search_QueryTooLong(t *testing.T) {
+	h := &Handler{BaseURL: "http://localhost:6969"}
+
+	req := httptest.NewRequest("GET", "/api?t=search&q="+strings.Repeat("a", 300), nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if !strings.Contains(rec.Body.String(), `code="201"`) {
+		t.Errorf("expected incorrect parameter error, got: %s", rec.Body.String())
+	}
+}
@@ -0,0 +1,37 @@
+package newznab
+
+import "github.com/nerney/slskrr/metrics"
+
+// Search metrics, labeled by the search function (search, tvsearch, movie,
+// music or book) as category, for tuning the result filters.
+var (
+	searchDuration = metrics.NewHistogram("slskrr_search_duration_seconds",
+		"Time taken by Soulseek searches, not counting queries answered from the cooldown cache.",
+		metrics.ExponentialBuckets(0.5, 2, 8), "category")
+	searchPeerResponses = metrics.NewHistogram("slskrr_search_peer_responses",
+		"Peers that responded to a search.",
+		metrics.ExponentialBuckets(1, 2, 10), "category")
+	searchResults = metrics.NewHistogram("slskrr_search_results",
+		"Files per search: every file peers offered (stage=raw) and the results returned after filtering (stage=filtered).",
+		metrics.ExponentialBuckets(1, 4, 8), "category", "stage")
+	searchCache = metrics.NewCounter("slskrr_search_cache_total",
+		"Queries answered from the cooldown cache (result=hit), by searching Soulseek (result=miss) or by searching because the request asked to skip the cache (result=bypass).",
+		"category", "result")
+)
+
+// searchFunctions are the search functions the metrics are labeled by.
+var searchFunctions = []string{"search", "tvsearch", "movie", "music", "book"}
+
+// CacheStats returns how many queries were answered from the cooldown cache
+// (hit), by searching Soulseek (miss) or by skipping the cache (bypass),
+// over every search function.
+func CacheStats() map[string]int {
+	stats := make(map[string]int)
+	for _, result := range []string{"hit", "miss", "bypass"} {
+		stats[result] = 0
+		for _, function := range searchFunctions {
+			stats[result] += int(searchCache.Value(function, result))
+		}
+	}
+	return stats
+}
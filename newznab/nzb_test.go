@@ -0,0 +1,48 @@
+package newznab
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseNZB(t *testing.T) {
+	h := &Handler{BaseURL: "http://localhost:6969"}
+	want := FileToken{Username: "peer", Filename: `Music\Artist - Album`, Size: 50, Artist: "Artist", Files: []TokenFile{
+		{Name: `\01 - One.flac`, Size: 30},
+		{Name: `\02 - Two.flac`, Size: 20},
+	}}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/api?t=get&id="+want.Encode(), nil))
+
+	got, err := ParseNZB(rec.Body)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Username != want.Username || got.Filename != want.Filename || got.Artist != want.Artist || len(got.Files) != 2 || got.Files[1] != want.Files[1] {
+		t.Errorf("expected the served token back, got %+v", got)
+	}
+
+	// NZBs served before the token meta only name the one file.
+	legacy := `<?xml version="1.0" encoding="UTF-8"?>
+<nzb xmlns="http://www.newzbin.com/DTD/2003/nzb">
+  <head>
+    <meta type="username">peer</meta>
+    <meta type="filename">Movies\Heat &amp; Dust.mkv</meta>
+    <meta type="size">1000</meta>
+  </head>
+</nzb>`
+	got, err = ParseNZB(strings.NewReader(legacy))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Username != "peer" || got.Filename != `Movies\Heat & Dust.mkv` || got.Size != 1000 {
+		t.Errorf("expected the legacy NZB's file, got %+v", got)
+	}
+
+	for _, bad := range []string{"not xml", `<nzb><head><meta type="name">x</meta></head></nzb>`} {
+		if _, err := ParseNZB(strings.NewReader(bad)); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package newznab
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/nerney/slskrr/slskd"
+)
+
+// TestResponse is what a search without a query returns. Apps send one to
+// test the indexer; some insist on finding an item in their categories,
+// others are confused by the synthetic one.
+type TestResponse string
+
+const (
+	TestItem   TestResponse = "item"   // a single slskrr-test item in the requested category
+	TestEmpty  TestResponse = "empty"  // an empty feed
+	TestRecent TestResponse = "recent" // results of recently cached searches, or the test item when there are none
+)
+
+// ParseTestResponses parses "<client or function>:<mode>,..." overrides,
+// where client is an API key label and function a search function such as
+// tvsearch.
+func ParseTestResponses(s string) (map[string]TestResponse, error) {
+	modes := make(map[string]TestResponse)
+	for _, entry := range strings.Split(s, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		name, mode, ok := strings.Cut(entry, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid entry %q: want <client or function>:<mode>", entry)
+		}
+		m, err := ParseTestResponse(mode)
+		if err != nil {
+			return nil, err
+		}
+		modes[strings.ToLower(name)] = m
+	}
+	return modes, nil
+}
+
+// ParseTestResponse parses a test response mode.
+func ParseTestResponse(s string) (TestResponse, error) {
+	switch m := TestResponse(strings.ToLower(strings.TrimSpace(s))); m {
+	case TestItem, TestEmpty, TestRecent:
+		return m, nil
+	}
+	return "", fmt.Errorf("invalid test response %q: want item, empty or recent", s)
+}
+
+// testResponse picks the mode for client's query-less search with action:
+// the client's override, then the function's, then TestResponse for plain
+// searches. Other functions return an empty feed by default.
+func (h *Handler) testResponse(client, action string) TestResponse {
+	if m, ok := h.TestResponses[strings.ToLower(client)]; ok && client != "" {
+		return m
+	}
+	if m, ok := h.TestResponses[action]; ok {
+		return m
+	}
+	if action == "search" {
+		return cmp.Or(h.TestResponse, TestItem)
+	}
+	return TestEmpty
+}
+
+// writeTestResponse answers a search without a query.
+func (h *Handler) writeTestResponse(w http.ResponseWriter, r *http.Request, client, action string) {
+	q := r.URL.Query()
+	switch h.testResponse(client, action) {
+	case TestEmpty:
+		writeSearchResponse(w, formatOf(r), nil, h.externalURL(r), 0, 0)
+		return
+	case TestRecent:
+		responses := h.recent.latest(h.QueryCooldown)
+		if len(responses) > 0 {
+			limit, offset := pageParams(q, h.resultLimit())
+			items, _ := h.page(responses, &resultFilter{action: action}, limit, offset)
+			if len(items) > 0 {
+				writeSearchResponse(w, formatOf(r), items, h.externalURL(r), offset, -1)
+				return
+			}
+		}
+	}
+	// Each app sends its own cat= filter (e.g. Radarr sends 2000s, Sonarr
+	// sends 5000s, Lidarr sends 3000s). The test item's category must match
+	// one of them, otherwise the app rejects the indexer with "no results in
+	// configured categories."
+	writeSearchResponse(w, formatOf(r), []searchItem{{
+		Title:    "slskrr-test",
+		Token:    EncodeToken("slskrr", "test/slskrr-test.mp3", 1),
+		Size:     1,
+		Category: firstCategory(q.Get("cat")),
+		Username: "slskrr",
+	}}, h.externalURL(r), 0, 1)
+}
+
+// latest returns the responses of the searches made within cooldown, most
+// recent search first.
+func (r *recentSearches) latest(cooldown time.Duration) []slskd.SearchResponse {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var fresh []*recentSearch
+	for _, e := range r.entries {
+		if time.Since(e.at) < cooldown {
+			fresh = append(fresh, e)
+		}
+	}
+	slices.SortFunc(fresh, func(a, b *recentSearch) int { return b.at.Compare(a.at) })
+	var responses []slskd.SearchResponse
+	for _, e := range fresh {
+		responses = append(responses, e.responses...)
+	}
+	return responses
+}
@@ -0,0 +1,79 @@
+package newznab
+
+import (
+	"cmp"
+	"context"
+	"log/slog"
+	"net/url"
+	"slices"
+
+	"github.com/nerney/slskrr/musicbrainz"
+)
+
+// movieQueries adds the titles TMDB knows the movie with q's id by to query
+// and alternates, so a foreign film is also searched by the original title
+// its Soulseek shares are usually named with. Titles that differ from one
+// already searched only in case, spacing or year are left out.
+func (h *Handler) movieQueries(ctx context.Context, q url.Values, query string, alternates []string) (string, []string) {
+	if h.TMDB == nil || (q.Get("tmdbid") == "" && q.Get("imdbid") == "") {
+		return query, alternates
+	}
+	m, err := h.TMDB.Movie(ctx, q.Get("tmdbid"), q.Get("imdbid"))
+	if err != nil {
+		slog.WarnContext(ctx, "movie lookup failed, searching the query only", "error", err)
+		return query, alternates
+	}
+
+	year := cmp.Or(q.Get("year"), m.Year())
+	searched := func(t string) bool {
+		key := titleKey(t)
+		return titleKey(query) == key || slices.ContainsFunc(alternates, func(a string) bool { return titleKey(a) == key })
+	}
+	for _, title := range []string{m.Title, m.OriginalTitle} {
+		for _, t := range h.cleanQuery(title) {
+			if t == "" || searched(t) {
+				continue
+			}
+			if year != "" && !yearSuffix.MatchString(t) {
+				t += " " + year
+			}
+			if query == "" {
+				query = t
+			} else {
+				alternates = append(alternates, t)
+			}
+		}
+	}
+	return query, alternates
+}
+
+// titleKey normalizes a query for comparison, ignoring a trailing year.
+func titleKey(query string) string {
+	return normalizeQuery(yearSuffix.ReplaceAllString(query, ""))
+}
+
+// albumLookup resolves a music search's artistmbid and albummbid through
+// MusicBrainz, returning the canonical artist and album in place of the
+// ones given, and the album when it was found.
+func (h *Handler) albumLookup(ctx context.Context, q url.Values, artist, album string) (string, string, *musicbrainz.Album) {
+	if h.MusicBrainz == nil {
+		return artist, album, nil
+	}
+	if id := q.Get("artistmbid"); id != "" {
+		name, err := h.MusicBrainz.Artist(ctx, id)
+		if err != nil {
+			slog.WarnContext(ctx, "artist lookup failed, searching the names given", "error", err)
+		}
+		artist = cmp.Or(name, artist)
+	}
+	id := q.Get("albummbid")
+	if id == "" {
+		return artist, album, nil
+	}
+	a, err := h.MusicBrainz.Album(ctx, id)
+	if err != nil {
+		slog.WarnContext(ctx, "album lookup failed, searching the names given", "error", err)
+		return artist, album, nil
+	}
+	return cmp.Or(a.Artist, artist), cmp.Or(a.Title, album), a
+}
@@ -0,0 +1,99 @@
+package newznab
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/nerney/slskrr/textnorm"
+)
+
+// apiFormat is how a request's results are written: as a Newznab indexer,
+// or as a Torznab one for setups that only pair torrent indexers with a
+// download client.
+type apiFormat struct {
+	path      string // API path download links point at
+	ns        string // namespace prefix of the result attributes
+	nsURL     string
+	enclosure string // MIME type of downloads
+}
+
+var (
+	newznabFormat = &apiFormat{"/api", "newznab", "http://www.newznab.com/DTD/2010/feeds/attributes/", "application/x-nzb"}
+	torznabFormat = &apiFormat{"/torznab/api", "torznab", "http://torznab.com/schemas/2015/feed", "application/x-bittorrent"}
+)
+
+type torznabKey struct{}
+
+// Torznab returns h serving the Torznab API: the same searches, with
+// torznab attributes and a placeholder .torrent for each download.
+func (h *Handler) Torznab() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), torznabKey{}, true)))
+	})
+}
+
+// formatOf returns the format r asked for by its endpoint.
+func formatOf(r *http.Request) *apiFormat {
+	if torznab, _ := r.Context().Value(torznabKey{}).(bool); torznab {
+		return torznabFormat
+	}
+	return newznabFormat
+}
+
+// writeTorrent writes a placeholder .torrent for token. Nothing can be
+// fetched over BitTorrent: the info dictionary carries the token and the
+// comment the NZB link, for a client that hands the grab back to slskrr.
+// Piece hashes are zero, sized so the torrent stays small.
+func writeTorrent(w http.ResponseWriter, token *FileToken, id, baseURL string) {
+	basename := textnorm.Clean(path.Base(strings.ReplaceAll(token.Filename, "\\", "/")))
+
+	pieceLength := int64(256 * 1024)
+	for token.Size/pieceLength > 2000 {
+		pieceLength *= 2
+	}
+	pieces := max(1, (token.Size+pieceLength-1)/pieceLength)
+
+	var info bytes.Buffer
+	info.WriteString("d")
+	bencodeString(&info, "length")
+	fmt.Fprintf(&info, "i%de", token.Size)
+	bencodeString(&info, "name")
+	bencodeString(&info, basename)
+	bencodeString(&info, "piece length")
+	fmt.Fprintf(&info, "i%de", pieceLength)
+	bencodeString(&info, "pieces")
+	bencodeString(&info, string(make([]byte, 20*pieces)))
+	bencodeString(&info, "private")
+	info.WriteString("i1e")
+	bencodeString(&info, "slskrr")
+	bencodeString(&info, id)
+	info.WriteString("e")
+
+	var b bytes.Buffer
+	b.WriteString("d")
+	bencodeString(&b, "announce")
+	bencodeString(&b, baseURL+torznabFormat.path)
+	bencodeString(&b, "comment")
+	bencodeString(&b, baseURL+newznabFormat.path+"?t=get&id="+id)
+	bencodeString(&b, "created by")
+	bencodeString(&b, "slskrr")
+	bencodeString(&b, "info")
+	b.Write(info.Bytes())
+	b.WriteString("e")
+
+	w.Header().Set("Content-Type", torznabFormat.enclosure)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": basename + ".torrent"}))
+	w.Write(b.Bytes())
+}
+
+func bencodeString(b *bytes.Buffer, s string) {
+	b.WriteString(strconv.Itoa(len(s)))
+	b.WriteByte(':')
+	b.WriteString(s)
+}
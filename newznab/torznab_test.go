@@ -0,0 +1,72 @@
+package newznab
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler_Torznab_Search(t *testing.T) {
+	h := &Handler{BaseURL: "http://localhost:6969"}
+
+	rec := httptest.NewRecorder()
+	h.Torznab().ServeHTTP(rec, httptest.NewRequest("GET", "/torznab/api?t=search&cat=2000", nil))
+
+	body := rec.Body.String()
+	for _, want := range []string{
+		`xmlns:torznab="http://torznab.com/schemas/2015/feed"`,
+		`<link>http://localhost:6969/torznab/api?t=get&amp;id=`,
+		`type="application/x-bittorrent"`,
+		`<torznab:attr name="size" value="1" />`,
+		`<torznab:attr name="seeders" value="1" />`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %s in feed, got: %s", want, body)
+		}
+	}
+	if strings.Contains(body, "newznab:") {
+		t.Errorf("expected no newznab attributes, got: %s", body)
+	}
+
+	// The Newznab API is unchanged.
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/api?t=search&cat=2000", nil))
+	if body := rec.Body.String(); !strings.Contains(body, `type="application/x-nzb"`) || strings.Contains(body, "torznab") {
+		t.Errorf("expected a Newznab feed, got: %s", body)
+	}
+}
+
+func TestHandler_Torznab_Get(t *testing.T) {
+	h := &Handler{BaseURL: "http://localhost:6969"}
+	token := EncodeToken("testuser", `Movies\movie.mkv`, 600*1024*1024)
+
+	rec := httptest.NewRecorder()
+	h.Torznab().ServeHTTP(rec, httptest.NewRequest("GET", "/torznab/api?t=get&id="+token, nil))
+
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/x-bittorrent" {
+		t.Fatalf("expected a torrent, got %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if disp := rec.Header().Get("Content-Disposition"); !strings.Contains(disp, "movie.mkv.torrent") {
+		t.Errorf("expected movie.mkv.torrent in disposition, got %s", disp)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		"d8:announce33:http://localhost:6969/torznab/api",
+		"7:comment",
+		"http://localhost:6969/api?t=get&id=" + token,
+		"4:infod6:lengthi629145600e4:name9:movie.mkv12:piece lengthi524288e6:pieces24000:",
+		"7:privatei1e6:slskrr",
+		token + "ee",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in torrent", want)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	h.Torznab().ServeHTTP(rec, httptest.NewRequest("GET", "/torznab/api?t=get&id=bad!", nil))
+	if !strings.Contains(rec.Body.String(), `code="300"`) {
+		t.Errorf("expected a no such item error, got: %s", rec.Body.String())
+	}
+}
@@ -0,0 +1,45 @@
+package notify
+
+import (
+	"context"
+	"net/http"
+)
+
+var appriseTypes = map[Event]string{
+	EventGrab:     "info",
+	EventComplete: "success",
+	EventFailure:  "failure",
+	EventRetry:    "warning",
+
+	EventUnreachable: "failure",
+	EventRecovered:   "success",
+}
+
+// Apprise sends messages through an Apprise API server, which fans them out
+// to any of the services it supports.
+type Apprise struct {
+	// URL is the server's notify endpoint: ".../notify/{key}" for a saved
+	// configuration, or ".../notify" together with Services.
+	URL        string
+	Services   string // Apprise service URLs for stateless notifications; optional
+	Tag        string // only notify services with this tag; optional
+	HTTPClient *http.Client
+}
+
+type apprisePayload struct {
+	URLs  string `json:"urls,omitempty"`
+	Tag   string `json:"tag,omitempty"`
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	Type  string `json:"type"`
+}
+
+func (a *Apprise) Notify(ctx context.Context, msg Message) error {
+	return postJSON(ctx, a.HTTPClient, a.URL, apprisePayload{
+		URLs:  a.Services,
+		Tag:   a.Tag,
+		Title: msg.Title(),
+		Body:  msg.Text(),
+		Type:  appriseTypes[msg.Event],
+	})
+}
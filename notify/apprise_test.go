@@ -0,0 +1,31 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestApprise_Notify(t *testing.T) {
+	var got apprisePayload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/notify/slskrr" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer srv.Close()
+
+	a := &Apprise{URL: srv.URL + "/notify/slskrr", Tag: "media"}
+	if err := a.Notify(context.Background(), failedMsg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Title != "Download failed" || got.Type != "failure" || got.Tag != "media" {
+		t.Errorf("unexpected payload: %+v", got)
+	}
+	if got.URLs != "" {
+		t.Errorf("expected no stateless urls, got %q", got.URLs)
+	}
+}
@@ -0,0 +1,110 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Embed colors per event, as Discord's decimal RGB.
+var discordColors = map[Event]int{
+	EventGrab:     0x3498db, // blue
+	EventComplete: 0x2ecc71, // green
+	EventFailure:  0xe74c3c, // red
+	EventRetry:    0xf39c12, // orange
+
+	EventUnreachable: 0xe74c3c, // red
+	EventRecovered:   0x2ecc71, // green
+}
+
+// Discord posts messages to a Discord channel webhook as rich embeds.
+type Discord struct {
+	WebhookURL string
+	Username   string // overrides the webhook's display name when set
+	HTTPClient *http.Client
+}
+
+type discordPayload struct {
+	Username string         `json:"username,omitempty"`
+	Embeds   []discordEmbed `json:"embeds"`
+}
+
+type discordEmbed struct {
+	Title       string         `json:"title"`
+	Description string         `json:"description"`
+	Color       int            `json:"color"`
+	Fields      []discordField `json:"fields"`
+	Footer      *discordFooter `json:"footer,omitempty"`
+	Timestamp   string         `json:"timestamp"`
+}
+
+type discordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+type discordFooter struct {
+	Text string `json:"text"`
+}
+
+func (d *Discord) Notify(ctx context.Context, msg Message) error {
+	return postJSON(ctx, d.HTTPClient, d.WebhookURL, d.payload(msg))
+}
+
+func (d *Discord) payload(msg Message) discordPayload {
+	if msg.Service != nil {
+		return discordPayload{
+			Username: d.Username,
+			Embeds: []discordEmbed{{
+				Title:       msg.Title(),
+				Description: msg.Text(),
+				Color:       discordColors[msg.Event],
+				Fields:      []discordField{},
+				Timestamp:   msg.Time.UTC().Format(time.RFC3339),
+			}},
+		}
+	}
+	dl := msg.Download
+	fields := []discordField{
+		{Name: "Category", Value: orDash(dl.Category), Inline: true},
+		{Name: "Size", Value: FormatSize(dl.Size), Inline: true},
+		{Name: "Peer", Value: orDash(dl.Username), Inline: true},
+	}
+	switch msg.Event {
+	case EventComplete:
+		fields = append(fields, discordField{Name: "Took", Value: FormatDuration(dl.Duration), Inline: true})
+	case EventFailure, EventRetry:
+		fields = append(fields,
+			discordField{Name: "Progress", Value: fmt.Sprintf("%.0f%%", dl.Progress), Inline: true},
+			discordField{Name: "Attempts", Value: fmt.Sprintf("%d", dl.Retries+1), Inline: true},
+		)
+		if dl.Error != "" {
+			fields = append(fields, discordField{Name: "Reason", Value: dl.Error})
+		}
+	}
+
+	description := dl.Title
+	if msg.text != "" {
+		description = msg.text
+	}
+	return discordPayload{
+		Username: d.Username,
+		Embeds: []discordEmbed{{
+			Title:       msg.Title(),
+			Description: description,
+			Color:       discordColors[msg.Event],
+			Fields:      fields,
+			Footer:      &discordFooter{Text: dl.ID},
+			Timestamp:   msg.Time.UTC().Format(time.RFC3339),
+		}},
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "—"
+	}
+	return s
+}
@@ -0,0 +1,72 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDiscord_FailureEmbed(t *testing.T) {
+	var got discordPayload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	d := &Discord{WebhookURL: srv.URL, Username: "slskrr"}
+	err := d.Notify(context.Background(), Message{
+		Event: EventFailure,
+		Time:  time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC),
+		Download: Download{
+			ID:       "SABnzbd_nzo_1",
+			Title:    "Cool.Movie.2024.mkv",
+			Size:     3 << 30,
+			Category: "radarr",
+			Progress: 42,
+			Retries:  3,
+			Error:    "Completed, Errored",
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got.Username != "slskrr" || len(got.Embeds) != 1 {
+		t.Fatalf("unexpected payload: %+v", got)
+	}
+	embed := got.Embeds[0]
+	if embed.Title != "Download failed" || embed.Description != "Cool.Movie.2024.mkv" {
+		t.Errorf("unexpected embed: %+v", embed)
+	}
+	if embed.Color != discordColors[EventFailure] {
+		t.Errorf("expected failure color, got %x", embed.Color)
+	}
+	fields := map[string]string{}
+	for _, f := range embed.Fields {
+		fields[f.Name] = f.Value
+	}
+	if fields["Reason"] != "Completed, Errored" || fields["Progress"] != "42%" || fields["Size"] != "3.0 GiB" {
+		t.Errorf("unexpected fields: %v", fields)
+	}
+	if embed.Timestamp != "2026-01-02T15:04:05Z" {
+		t.Errorf("unexpected timestamp %q", embed.Timestamp)
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	tests := map[int64]string{
+		512:        "512 B",
+		2048:       "2.0 KiB",
+		5 << 20:    "5.0 MiB",
+		1536 << 20: "1.5 GiB",
+	}
+	for in, want := range tests {
+		if got := FormatSize(in); got != want {
+			t.Errorf("FormatSize(%d) = %q, want %q", in, got, want)
+		}
+	}
+}
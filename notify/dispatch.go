@@ -0,0 +1,235 @@
+package notify
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// deliveryTimeout bounds a single delivery attempt to one notifier.
+	deliveryTimeout = 10 * time.Second
+	// queueSize bounds each notifier's backlog; messages beyond it are
+	// dead-lettered rather than growing memory while a target is down.
+	queueSize  = 100
+	maxBackoff = 5 * time.Minute
+)
+
+// Dispatcher fans messages out to the notifiers subscribed to each event.
+// Each notifier has its own queue and worker, so a slow or failing target
+// doesn't delay the others. Failed deliveries are retried with exponential
+// backoff; messages that exhaust their attempts are logged as dead letters.
+// A nil dispatcher drops everything.
+type Dispatcher struct {
+	Templates   *Templates    // customizes Title and Text; optional
+	MaxAttempts int           // delivery attempts per message; default 5
+	Backoff     time.Duration // wait before the first retry, doubling after each; default 5s
+
+	mu      sync.Mutex
+	targets []*target
+	closed  bool           // set by Close; later sends are dead-lettered
+	pending sync.WaitGroup // messages queued or being delivered, added to under mu
+	stop    chan struct{}  // closed by Close to abandon retries
+	once    sync.Once
+	closing sync.Once
+
+	delivered atomic.Int64
+	retried   atomic.Int64
+	dead      atomic.Int64
+}
+
+type target struct {
+	name     string
+	notifier Notifier
+	events   map[Event]bool
+	queue    chan Message
+}
+
+// Stats counts deliveries since startup.
+type Stats struct {
+	Delivered int64 `json:"delivered"`
+	Retried   int64 `json:"retried"`
+	Dead      int64 `json:"dead"`
+}
+
+// Add subscribes n, identified in logs by name, to events and starts its
+// delivery worker.
+func (d *Dispatcher) Add(name string, n Notifier, events []Event) {
+	set := make(map[Event]bool, len(events))
+	for _, ev := range events {
+		set[ev] = true
+	}
+	t := &target{name: name, notifier: n, events: set, queue: make(chan Message, queueSize)}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.init()
+	d.targets = append(d.targets, t)
+	go d.work(t)
+}
+
+func (d *Dispatcher) init() {
+	d.once.Do(func() {
+		d.stop = make(chan struct{})
+		if d.MaxAttempts <= 0 {
+			d.MaxAttempts = 5
+		}
+		if d.Backoff <= 0 {
+			d.Backoff = 5 * time.Second
+		}
+	})
+}
+
+// Len returns the number of registered notifiers.
+func (d *Dispatcher) Len() int {
+	if d == nil {
+		return 0
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.targets)
+}
+
+// Stats returns delivery counters.
+func (d *Dispatcher) Stats() Stats {
+	if d == nil {
+		return Stats{}
+	}
+	return Stats{
+		Delivered: d.delivered.Load(),
+		Retried:   d.retried.Load(),
+		Dead:      d.dead.Load(),
+	}
+}
+
+// Send queues msg for every notifier subscribed to its event. It never
+// blocks; failures are retried and logged in the background. Once Close
+// has begun, messages are dead-lettered instead.
+func (d *Dispatcher) Send(msg Message) {
+	if d == nil {
+		return
+	}
+	if err := d.Templates.Render(&msg); err != nil {
+		slog.Warn("failed to render notification template, using default", "event", msg.Event, "error", err)
+	}
+
+	// Queueing under mu keeps pending from growing after Close waits on it.
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, t := range d.targets {
+		if !t.events[msg.Event] {
+			continue
+		}
+		if d.closed {
+			d.deadLetter(t, msg, 0, "shutting down")
+			continue
+		}
+		d.pending.Add(1)
+		select {
+		case t.queue <- msg:
+		default:
+			d.deadLetter(t, msg, 0, "queue full")
+			d.pending.Done()
+		}
+	}
+}
+
+// work delivers t's queued messages in order until the process exits.
+func (d *Dispatcher) work(t *target) {
+	for msg := range t.queue {
+		select {
+		case <-d.stop:
+			d.deadLetter(t, msg, 0, "shutting down")
+		default:
+			d.deliver(t, msg)
+		}
+		d.pending.Done()
+	}
+}
+
+// deliver attempts msg up to MaxAttempts times, backing off between
+// attempts, and dead-letters it if every attempt fails.
+func (d *Dispatcher) deliver(t *target, msg Message) {
+	backoff := d.Backoff
+	var err error
+	for attempt := 1; attempt <= d.MaxAttempts; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), deliveryTimeout)
+		err = t.notifier.Notify(ctx, msg)
+		cancel()
+		if err == nil {
+			d.delivered.Add(1)
+			return
+		}
+		if attempt == d.MaxAttempts {
+			break
+		}
+
+		slog.Warn("notification failed, will retry",
+			"notifier", t.name,
+			"event", msg.Event,
+			"id", msg.Download.ID,
+			"attempt", attempt,
+			"retryIn", backoff,
+			"error", err,
+		)
+		d.retried.Add(1)
+		select {
+		case <-d.stop:
+			d.deadLetter(t, msg, attempt, "shutting down: "+err.Error())
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+	d.deadLetter(t, msg, d.MaxAttempts, err.Error())
+}
+
+// deadLetter records a message that will never be delivered. The log line
+// carries enough of the message to act on it by hand.
+func (d *Dispatcher) deadLetter(t *target, msg Message, attempts int, reason string) {
+	d.dead.Add(1)
+	slog.Error("notification dropped",
+		"notifier", t.name,
+		"event", msg.Event,
+		"id", msg.Download.ID,
+		"title", msg.Download.Title,
+		"attempts", attempts,
+		"reason", reason,
+	)
+}
+
+// Wait blocks until every queued message has been delivered or
+// dead-lettered, including retries.
+func (d *Dispatcher) Wait() {
+	if d == nil {
+		return
+	}
+	d.pending.Wait()
+}
+
+// Close waits for queued messages like Wait, but once ctx is done it
+// abandons pending retries, dead-lettering those messages, so shutdown
+// isn't held up by an unreachable target.
+func (d *Dispatcher) Close(ctx context.Context) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	d.init()
+	d.closed = true
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		d.closing.Do(func() { close(d.stop) })
+		<-done
+	}
+}
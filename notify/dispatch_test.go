@@ -0,0 +1,146 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder records delivered messages, failing the first failN attempts.
+type recorder struct {
+	mu       sync.Mutex
+	msgs     []Message
+	attempts int
+	failN    int
+	err      error
+}
+
+func (r *recorder) Notify(_ context.Context, msg Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.attempts++
+	if r.err != nil {
+		return r.err
+	}
+	if r.attempts <= r.failN {
+		return errors.New("temporarily unavailable")
+	}
+	r.msgs = append(r.msgs, msg)
+	return nil
+}
+
+func TestDispatcher_FiltersEvents(t *testing.T) {
+	all, failures := &recorder{}, &recorder{}
+	d := &Dispatcher{}
+	d.Add("all", all, AllEvents)
+	d.Add("failures", failures, []Event{EventFailure})
+
+	d.Send(Message{Event: EventGrab})
+	d.Send(Message{Event: EventFailure})
+	d.Wait()
+
+	if len(all.msgs) != 2 {
+		t.Errorf("expected 2 messages for all-events notifier, got %d", len(all.msgs))
+	}
+	if len(failures.msgs) != 1 || failures.msgs[0].Event != EventFailure {
+		t.Errorf("expected only the failure, got %+v", failures.msgs)
+	}
+}
+
+func TestDispatcher_RetriesWithBackoff(t *testing.T) {
+	flaky := &recorder{failN: 2}
+	d := &Dispatcher{Backoff: time.Millisecond}
+	d.Add("flaky", flaky, AllEvents)
+
+	d.Send(Message{Event: EventFailure})
+	d.Wait()
+
+	if len(flaky.msgs) != 1 || flaky.attempts != 3 {
+		t.Errorf("expected delivery on the 3rd attempt, got %d messages after %d attempts", len(flaky.msgs), flaky.attempts)
+	}
+	if stats := d.Stats(); stats.Delivered != 1 || stats.Retried != 2 || stats.Dead != 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestDispatcher_DeadLetters(t *testing.T) {
+	broken := &recorder{err: errors.New("boom")}
+	d := &Dispatcher{MaxAttempts: 3, Backoff: time.Millisecond}
+	d.Add("broken", broken, AllEvents)
+
+	d.Send(Message{Event: EventGrab})
+	d.Wait()
+
+	if broken.attempts != 3 {
+		t.Errorf("expected 3 attempts, got %d", broken.attempts)
+	}
+	if stats := d.Stats(); stats.Dead != 1 || stats.Delivered != 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestDispatcher_CloseAbandonsRetries(t *testing.T) {
+	broken := &recorder{err: errors.New("boom")}
+	d := &Dispatcher{Backoff: time.Hour}
+	d.Add("broken", broken, AllEvents)
+	d.Send(Message{Event: EventGrab})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		d.Close(ctx)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not return after its context expired")
+	}
+	if d.Stats().Dead != 1 {
+		t.Errorf("expected the pending message to be dead-lettered, got %+v", d.Stats())
+	}
+}
+
+func TestDispatcher_SendAfterClose(t *testing.T) {
+	rec := &recorder{}
+	d := &Dispatcher{}
+	d.Add("rec", rec, AllEvents)
+
+	// Sends racing Close either make it into the queue or are dropped;
+	// none may be left pending once Close returns.
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 10 {
+				d.Send(Message{Event: EventGrab})
+			}
+		}()
+	}
+	d.Close(context.Background())
+	wg.Wait()
+
+	d.Send(Message{Event: EventGrab})
+	stats := d.Stats()
+	if stats.Dead == 0 {
+		t.Error("expected a send after Close dead-lettered")
+	}
+	if stats.Delivered+stats.Dead != 81 {
+		t.Errorf("expected every message delivered or dropped, got %+v", stats)
+	}
+}
+
+func TestDispatcher_NilIsNoop(t *testing.T) {
+	var d *Dispatcher
+	d.Send(Message{Event: EventGrab})
+	d.Wait()
+	d.Close(context.Background())
+	if d.Len() != 0 {
+		t.Error("expected nil dispatcher to have no notifiers")
+	}
+}
@@ -0,0 +1,164 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Exec runs a local command for each event. Its arguments are templates
+// rendered like message templates, e.g. {{.Title}} or {{.Error}}; the
+// message is also passed as JSON on stdin and as SLSKRR_* environment
+// variables. A non-zero exit counts as a failed delivery.
+type Exec struct {
+	Command string
+	Args    []*template.Template
+	Timeout time.Duration // zero means 1 minute
+}
+
+// ParseExec parses a command line with shell-style quoting into an Exec,
+// test-rendering its argument templates.
+func ParseExec(line string) (*Exec, error) {
+	words, err := splitCommand(line)
+	if err != nil {
+		return nil, err
+	}
+	if len(words) == 0 {
+		return nil, errors.New("empty command")
+	}
+	e := &Exec{Command: words[0]}
+	for i, w := range words[1:] {
+		tmpl, err := parseTemplate("arg"+strconv.Itoa(i+1), w)
+		if err != nil {
+			return nil, err
+		}
+		e.Args = append(e.Args, tmpl)
+	}
+	if _, err := e.args(Message{Event: EventFailure, Time: time.Now()}); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *Exec) Notify(ctx context.Context, msg Message) error {
+	args, err := e.args(msg)
+	if err != nil {
+		return err
+	}
+	timeout := e.Timeout
+	if timeout == 0 {
+		timeout = time.Minute
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	payload, err := json.Marshal(webhookPayload{Message: msg, Title: msg.Title(), Text: msg.Text()})
+	if err != nil {
+		return fmt.Errorf("marshal payload: %w", err)
+	}
+	dl := msg.Download
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, e.Command, args...)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	cmd.Env = append(os.Environ(),
+		"SLSKRR_EVENT="+string(msg.Event),
+		"SLSKRR_ID="+dl.ID,
+		"SLSKRR_TITLE="+dl.Title,
+		"SLSKRR_USERNAME="+dl.Username,
+		"SLSKRR_FILENAME="+dl.Filename,
+		"SLSKRR_CATEGORY="+dl.Category,
+		"SLSKRR_SIZE="+strconv.FormatInt(dl.Size, 10),
+		"SLSKRR_ERROR="+dl.Error,
+	)
+	if s := msg.Service; s != nil {
+		cmd.Env = append(cmd.Env, "SLSKRR_SERVICE="+s.Name, "SLSKRR_SERVICE_URL="+s.URL, "SLSKRR_ERROR="+s.Error)
+	}
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(out.String()); msg != "" {
+			return fmt.Errorf("run %s: %w: %s", e.Command, err, msg)
+		}
+		return fmt.Errorf("run %s: %w", e.Command, err)
+	}
+	return nil
+}
+
+func (e *Exec) args(msg Message) ([]string, error) {
+	data := newTemplateData(msg)
+	args := make([]string, len(e.Args))
+	for i, tmpl := range e.Args {
+		if tmpl == nil {
+			continue // an empty argument
+		}
+		var b strings.Builder
+		if err := tmpl.Execute(&b, data); err != nil {
+			return nil, fmt.Errorf("render %s template: %w", tmpl.Name(), err)
+		}
+		args[i] = b.String()
+	}
+	return args, nil
+}
+
+// splitCommand splits a command line into words, honouring single and
+// double quotes, backslash escapes outside single quotes, and spaces inside
+// {{ }} template actions.
+func splitCommand(s string) ([]string, error) {
+	var (
+		words   []string
+		word    strings.Builder
+		inWord  bool
+		quote   rune
+		escape  bool
+		actions int // open {{ }} actions
+	)
+	rs := []rune(s)
+	for i, r := range rs {
+		switch {
+		case escape:
+			word.WriteRune(r)
+			escape = false
+			continue
+		case r == '{' && i+1 < len(rs) && rs[i+1] == '{':
+			actions++
+		case r == '}' && i > 0 && rs[i-1] == '}' && actions > 0:
+			actions--
+		}
+		switch {
+		case r == '\\' && quote != '\'' && actions == 0:
+			escape, inWord = true, true
+		case quote != 0:
+			if r == quote && actions == 0 {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case (r == '"' || r == '\'') && actions == 0:
+			quote, inWord = r, true
+		case (r == ' ' || r == '\t' || r == '\n') && actions == 0:
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 || escape {
+		return nil, errors.New("unterminated quote or escape")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
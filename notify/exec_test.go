@@ -0,0 +1,74 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitCommand(t *testing.T) {
+	words, err := splitCommand(`/bin/hook "{{.Title}} done" 'a "b"' c\ d ""`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"/bin/hook", "{{.Title}} done", `a "b"`, "c d", ""}
+	if !slices.Equal(words, want) {
+		t.Errorf("expected %q, got %q", want, words)
+	}
+	if _, err := splitCommand(`hook "open`); err == nil {
+		t.Error("expected error for an unterminated quote")
+	}
+}
+
+func TestParseExec_Invalid(t *testing.T) {
+	for _, line := range []string{"", "hook {{.Nope}}", "hook {{"} {
+		if _, err := ParseExec(line); err == nil {
+			t.Errorf("expected error for %q", line)
+		}
+	}
+}
+
+func TestExec_Notify(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	script := filepath.Join(dir, "hook.sh")
+	body := "#!/bin/sh\necho \"$1|$2|$SLSKRR_EVENT|$SLSKRR_USERNAME\" > " + out + "\ncat >> " + out + "\n"
+	if err := os.WriteFile(script, []byte(body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := ParseExec(script + ` "{{.Title}}" {{.Username | upper}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := Message{Event: EventComplete, Time: time.Now(), Download: Download{ID: "nzo_1", Title: "01 Song.flac", Username: "peer"}}
+	if err := e.Notify(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := os.ReadFile(out)
+	line, payload, _ := strings.Cut(string(data), "\n")
+	if line != "01 Song.flac|PEER|complete|peer" {
+		t.Errorf("unexpected arguments and environment: %q", line)
+	}
+	var got webhookPayload
+	if err := json.Unmarshal([]byte(payload), &got); err != nil || got.Download.ID != "nzo_1" {
+		t.Errorf("expected the message as JSON on stdin, got %q (%v)", payload, err)
+	}
+}
+
+func TestExec_NotifyFailure(t *testing.T) {
+	e, err := ParseExec(`sh -c "echo boom >&2; exit 3"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = e.Notify(context.Background(), Message{Event: EventFailure})
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("expected the command's output in the error, got %v", err)
+	}
+}
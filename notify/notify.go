@@ -0,0 +1,212 @@
+// Package notify delivers download events (grab, completion, failure,
+// retry) and slskd going unreachable or recovering to external services
+// such as webhooks, retrying failed deliveries in the background.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/nerney/slskrr/store"
+)
+
+// Event is the kind of event a notification describes.
+type Event string
+
+const (
+	EventGrab     Event = "grab"
+	EventComplete Event = "complete"
+	EventFailure  Event = "failure"
+	EventRetry    Event = "retry"
+
+	// Service events, about slskd rather than a download.
+	EventUnreachable Event = "unreachable"
+	EventRecovered   Event = "recovered"
+)
+
+// AllEvents lists every event, in the order they occur.
+var AllEvents = []Event{EventGrab, EventComplete, EventFailure, EventRetry, EventUnreachable, EventRecovered}
+
+// Message is the payload sent for an event.
+type Message struct {
+	Event    Event     `json:"event"`
+	Time     time.Time `json:"time"`
+	Download Download  `json:"download"`
+	Service  *Service  `json:"service,omitempty"` // set for service events
+
+	// Set by a Dispatcher with Templates; they replace the default Title
+	// and Text.
+	title, text string
+}
+
+// Download describes the download an event refers to.
+type Download struct {
+	ID       string  `json:"id"`
+	Title    string  `json:"title"`
+	Username string  `json:"username"`
+	Filename string  `json:"filename"`
+	Size     int64   `json:"size"`
+	Category string  `json:"category"`
+	Progress float64 `json:"progress"` // percent transferred
+	Speed    int64   `json:"speed"`    // average bytes per second
+	Duration float64 `json:"duration"` // seconds since the grab
+	Retries  int     `json:"retries,omitempty"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// Service describes the service a service event refers to.
+type Service struct {
+	Name     string  `json:"name"`
+	URL      string  `json:"url"`
+	Error    string  `json:"error,omitempty"` // why it's unreachable
+	Downtime float64 `json:"downtime"`        // seconds since it was last reachable
+}
+
+// NewServiceMessage builds the message for a service event. errMsg is why
+// the service is unreachable; down is how long it has been.
+func NewServiceMessage(ev Event, name, url string, down time.Duration, errMsg string) Message {
+	return Message{
+		Event:   ev,
+		Time:    time.Now(),
+		Service: &Service{Name: name, URL: url, Error: errMsg, Downtime: down.Seconds()},
+	}
+}
+
+// NewMessage builds the message for ev about dl. errMsg is the failure
+// reason, if any.
+func NewMessage(ev Event, dl *store.Download, errMsg string) Message {
+	now := time.Now()
+	end := now
+	if !dl.CompletedAt.IsZero() {
+		end = dl.CompletedAt
+	}
+	elapsed := end.Sub(dl.AddedAt).Seconds()
+	var speed int64
+	if elapsed > 0 {
+		speed = int64(float64(dl.BytesDownloaded) / elapsed)
+	}
+	return Message{
+		Event: ev,
+		Time:  now,
+		Download: Download{
+			ID:       dl.ID,
+			Title:    path.Base(strings.ReplaceAll(dl.Filename, "\\", "/")),
+			Username: dl.Username,
+			Filename: dl.Filename,
+			Size:     dl.Size,
+			Category: dl.Category,
+			Progress: dl.Progress(),
+			Speed:    speed,
+			Duration: elapsed,
+			Retries:  dl.Retries,
+			Error:    errMsg,
+		},
+	}
+}
+
+var titles = map[Event]string{
+	EventGrab:     "Grabbed",
+	EventComplete: "Download complete",
+	EventFailure:  "Download failed",
+	EventRetry:    "Retrying download",
+}
+
+var serviceTitles = map[Event]string{
+	EventUnreachable: "%s unreachable",
+	EventRecovered:   "%s reachable again",
+}
+
+// Title is a short headline for the event, e.g. "Download failed".
+func (m Message) Title() string {
+	if m.title != "" {
+		return m.title
+	}
+	if m.Service != nil {
+		return fmt.Sprintf(serviceTitles[m.Event], m.Service.Name)
+	}
+	return titles[m.Event]
+}
+
+// Text is a plain-text summary of the download for notifiers without rich
+// formatting.
+func (m Message) Text() string {
+	if m.text != "" {
+		return m.text
+	}
+	if s := m.Service; s != nil {
+		if m.Event == EventRecovered {
+			return fmt.Sprintf("%s at %s answers again after %s", s.Name, s.URL, FormatDuration(s.Downtime))
+		}
+		return fmt.Sprintf("%s at %s hasn't answered for %s: %s", s.Name, s.URL, FormatDuration(s.Downtime), s.Error)
+	}
+	dl := m.Download
+	var b strings.Builder
+	b.WriteString(dl.Title)
+	fmt.Fprintf(&b, "\n%s from %s", FormatSize(dl.Size), dl.Username)
+	if dl.Category != "" {
+		fmt.Fprintf(&b, " (%s)", dl.Category)
+	}
+	switch m.Event {
+	case EventComplete:
+		fmt.Fprintf(&b, "\nTook %s", FormatDuration(dl.Duration))
+	case EventFailure, EventRetry:
+		fmt.Fprintf(&b, "\nStopped at %.0f%% after %d attempt(s)", dl.Progress, dl.Retries+1)
+		if dl.Error != "" {
+			fmt.Fprintf(&b, ": %s", dl.Error)
+		}
+	}
+	return b.String()
+}
+
+// Notifier delivers a message to one external service.
+type Notifier interface {
+	Notify(ctx context.Context, msg Message) error
+}
+
+// ParseEvents parses a comma-separated event list.
+func ParseEvents(s string) ([]Event, error) {
+	var events []Event
+	for _, part := range strings.Split(s, ",") {
+		part = strings.ToLower(strings.TrimSpace(part))
+		if part == "" {
+			continue
+		}
+		ev := Event(part)
+		if !validEvent(ev) {
+			return nil, fmt.Errorf("unknown event %q", part)
+		}
+		events = append(events, ev)
+	}
+	return events, nil
+}
+
+func validEvent(ev Event) bool {
+	for _, e := range AllEvents {
+		if e == ev {
+			return true
+		}
+	}
+	return false
+}
+
+// FormatSize renders a byte count in binary units, e.g. "1.4 GiB".
+func FormatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// FormatDuration renders seconds as a short duration, e.g. "5m12s".
+func FormatDuration(secs float64) string {
+	return time.Duration(secs * float64(time.Second)).Round(time.Second).String()
+}
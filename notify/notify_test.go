@@ -0,0 +1,110 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nerney/slskrr/store"
+)
+
+func TestNewMessage(t *testing.T) {
+	added := time.Now().Add(-90 * time.Second)
+	dl := &store.Download{
+		ID:          "SABnzbd_nzo_1",
+		Username:    "peer",
+		Filename:    `Music\Artist\Album\01 - Track.flac`,
+		Size:        1234,
+		Category:    "lidarr",
+		AddedAt:     added,
+		CompletedAt: added.Add(60 * time.Second),
+	}
+
+	msg := NewMessage(EventComplete, dl, "")
+	if msg.Download.Title != "01 - Track.flac" {
+		t.Errorf("unexpected title %q", msg.Download.Title)
+	}
+	if msg.Download.Duration != 60 {
+		t.Errorf("expected duration 60s, got %v", msg.Download.Duration)
+	}
+	if msg.Download.Category != "lidarr" || msg.Download.Username != "peer" {
+		t.Errorf("unexpected download fields: %+v", msg.Download)
+	}
+}
+
+func TestNewServiceMessage(t *testing.T) {
+	msg := NewServiceMessage(EventUnreachable, "slskd", "http://slskd:5030", 90*time.Second, "connection refused")
+	if msg.Title() != "slskd unreachable" {
+		t.Errorf("unexpected title %q", msg.Title())
+	}
+	if want := "slskd at http://slskd:5030 hasn't answered for 1m30s: connection refused"; msg.Text() != want {
+		t.Errorf("expected text %q, got %q", want, msg.Text())
+	}
+	msg = NewServiceMessage(EventRecovered, "slskd", "http://slskd:5030", 5*time.Minute, "")
+	if msg.Title() != "slskd reachable again" || msg.Text() != "slskd at http://slskd:5030 answers again after 5m0s" {
+		t.Errorf("unexpected recovery message %q: %q", msg.Title(), msg.Text())
+	}
+
+	tmpl, err := ParseTemplates("{{.Service.Name}} {{.Event}}", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tmpl.Render(&msg); err != nil || msg.Title() != "slskd recovered" {
+		t.Errorf("expected the service in templates, got %q (%v)", msg.Title(), err)
+	}
+}
+
+func TestWebhook_PostsJSON(t *testing.T) {
+	var got Message
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected content type %q", r.Header.Get("Content-Type"))
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	wh := &Webhook{URL: srv.URL}
+	err := wh.Notify(context.Background(), Message{
+		Event:    EventFailure,
+		Download: Download{Title: "movie.mkv", Error: "Completed, Errored"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Event != EventFailure || got.Download.Error != "Completed, Errored" {
+		t.Errorf("unexpected payload: %+v", got)
+	}
+}
+
+func TestWebhook_ErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	wh := &Webhook{URL: srv.URL}
+	if err := wh.Notify(context.Background(), Message{Event: EventGrab}); err == nil {
+		t.Fatal("expected error for 502 response")
+	}
+}
+
+func TestParseEvents(t *testing.T) {
+	events, err := ParseEvents("")
+	if err != nil || len(events) != 0 {
+		t.Errorf("expected no events for empty list, got %v %v", events, err)
+	}
+
+	events, err = ParseEvents(" Complete, failure ")
+	if err != nil || len(events) != 2 || events[0] != EventComplete {
+		t.Errorf("unexpected events %v %v", events, err)
+	}
+
+	if _, err := ParseEvents("grab,explode"); err == nil {
+		t.Error("expected error for unknown event")
+	}
+}
@@ -0,0 +1,41 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var ntfyTags = map[Event]string{
+	EventGrab:     "inbox_tray",
+	EventComplete: "white_check_mark",
+	EventFailure:  "x",
+	EventRetry:    "repeat",
+
+	EventUnreachable: "warning",
+	EventRecovered:   "white_check_mark",
+}
+
+// Ntfy publishes messages to an ntfy topic.
+type Ntfy struct {
+	TopicURL   string // e.g. "https://ntfy.sh/my-slskrr"
+	Token      string // access token for protected topics; optional
+	HTTPClient *http.Client
+}
+
+func (n *Ntfy) Notify(ctx context.Context, msg Message) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.TopicURL, strings.NewReader(msg.Text()))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Title", msg.Title())
+	req.Header.Set("Tags", ntfyTags[msg.Event])
+	if msg.Event == EventFailure || msg.Event == EventUnreachable {
+		req.Header.Set("Priority", "high")
+	}
+	if n.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.Token)
+	}
+	return do(n.HTTPClient, req)
+}
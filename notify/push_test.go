@@ -0,0 +1,76 @@
+package notify
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var failedMsg = Message{
+	Event: EventFailure,
+	Download: Download{
+		Title:    "01 - Track.flac",
+		Username: "peer",
+		Size:     30 << 20,
+		Category: "lidarr",
+		Progress: 12,
+		Error:    "Completed, TimedOut",
+	},
+}
+
+func TestMessage_Text(t *testing.T) {
+	want := "01 - Track.flac\n30.0 MiB from peer (lidarr)\nStopped at 12% after 1 attempt(s): Completed, TimedOut"
+	if got := failedMsg.Text(); got != want {
+		t.Errorf("Text() = %q, want %q", got, want)
+	}
+}
+
+func TestNtfy_Publishes(t *testing.T) {
+	var body string
+	var header http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		body, header = string(b), r.Header
+	}))
+	defer srv.Close()
+
+	n := &Ntfy{TopicURL: srv.URL + "/slskrr", Token: "tk_secret"}
+	if err := n.Notify(context.Background(), failedMsg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(body, "01 - Track.flac") {
+		t.Errorf("unexpected body %q", body)
+	}
+	if header.Get("Title") != "Download failed" || header.Get("Priority") != "high" || header.Get("Tags") != "x" {
+		t.Errorf("unexpected headers: %v", header)
+	}
+	if header.Get("Authorization") != "Bearer tk_secret" {
+		t.Errorf("expected bearer token, got %q", header.Get("Authorization"))
+	}
+}
+
+func TestPushover_SendsForm(t *testing.T) {
+	var form map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = map[string]string{}
+		for k := range r.PostForm {
+			form[k] = r.PostForm.Get(k)
+		}
+	}))
+	defer srv.Close()
+
+	p := &Pushover{Token: "app", User: "usr", URL: srv.URL}
+	if err := p.Notify(context.Background(), failedMsg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if form["token"] != "app" || form["user"] != "usr" || form["title"] != "Download failed" || form["priority"] != "1" {
+		t.Errorf("unexpected form: %v", form)
+	}
+	if !strings.Contains(form["message"], "Completed, TimedOut") {
+		t.Errorf("expected failure reason in message, got %q", form["message"])
+	}
+}
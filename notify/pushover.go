@@ -0,0 +1,42 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const pushoverURL = "https://api.pushover.net/1/messages.json"
+
+// Pushover sends messages through the Pushover API.
+type Pushover struct {
+	Token      string // application API token
+	User       string // user or group key
+	URL        string // overrides the API endpoint; for tests
+	HTTPClient *http.Client
+}
+
+func (p *Pushover) Notify(ctx context.Context, msg Message) error {
+	form := url.Values{
+		"token":   {p.Token},
+		"user":    {p.User},
+		"title":   {msg.Title()},
+		"message": {msg.Text()},
+	}
+	if msg.Event == EventFailure || msg.Event == EventUnreachable {
+		form.Set("priority", "1")
+	}
+
+	endpoint := p.URL
+	if endpoint == "" {
+		endpoint = pushoverURL
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return do(p.HTTPClient, req)
+}
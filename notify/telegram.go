@@ -0,0 +1,197 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const telegramAPI = "https://api.telegram.org"
+
+// Actions are the operations Telegram's inline buttons can trigger.
+type Actions interface {
+	Retry(ctx context.Context, id string) error
+	Cancel(ctx context.Context, id string) error
+}
+
+// Telegram sends messages to a chat through a Telegram bot. With Actions
+// set, failure messages carry Retry and Cancel buttons, which Run handles.
+type Telegram struct {
+	Token      string
+	ChatID     string
+	Actions    Actions
+	APIURL     string // overrides the Bot API base URL; for tests
+	HTTPClient *http.Client
+
+	offset int64 // next update ID to fetch
+}
+
+type telegramButton struct {
+	Text         string `json:"text"`
+	CallbackData string `json:"callback_data"`
+}
+
+type telegramMarkup struct {
+	InlineKeyboard [][]telegramButton `json:"inline_keyboard"`
+}
+
+type telegramSend struct {
+	ChatID      string          `json:"chat_id"`
+	Text        string          `json:"text"`
+	ReplyMarkup *telegramMarkup `json:"reply_markup,omitempty"`
+}
+
+type telegramUpdate struct {
+	UpdateID      int64 `json:"update_id"`
+	CallbackQuery *struct {
+		ID      string `json:"id"`
+		Data    string `json:"data"`
+		Message *struct {
+			Chat struct {
+				ID int64 `json:"id"`
+			} `json:"chat"`
+		} `json:"message"`
+	} `json:"callback_query"`
+}
+
+func (t *Telegram) Notify(ctx context.Context, msg Message) error {
+	send := telegramSend{
+		ChatID: t.ChatID,
+		Text:   msg.Title() + "\n" + msg.Text(),
+	}
+	if msg.Event == EventFailure && t.Actions != nil {
+		send.ReplyMarkup = &telegramMarkup{InlineKeyboard: [][]telegramButton{{
+			{Text: "Retry", CallbackData: "retry:" + msg.Download.ID},
+			{Text: "Cancel", CallbackData: "cancel:" + msg.Download.ID},
+		}}}
+	}
+	return t.call(ctx, "sendMessage", send, nil)
+}
+
+// Run long-polls the bot for button presses until ctx is cancelled. It is
+// only needed when Actions is set.
+func (t *Telegram) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		if err := t.poll(ctx); err != nil && ctx.Err() == nil {
+			slog.Warn("telegram poll failed", "error", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(10 * time.Second):
+			}
+		}
+	}
+}
+
+func (t *Telegram) poll(ctx context.Context) error {
+	var updates []telegramUpdate
+	err := t.call(ctx, "getUpdates", map[string]any{
+		"offset":          t.offset,
+		"timeout":         30,
+		"allowed_updates": []string{"callback_query"},
+	}, &updates)
+	if err != nil {
+		return err
+	}
+	for _, u := range updates {
+		t.offset = u.UpdateID + 1
+		if u.CallbackQuery != nil {
+			t.handleCallback(ctx, u)
+		}
+	}
+	return nil
+}
+
+func (t *Telegram) handleCallback(ctx context.Context, u telegramUpdate) {
+	cq := u.CallbackQuery
+	// Only act on buttons in the configured chat; anyone can message a bot.
+	if cq.Message == nil || strconv.FormatInt(cq.Message.Chat.ID, 10) != t.ChatID {
+		return
+	}
+
+	action, id, _ := strings.Cut(cq.Data, ":")
+	var err error
+	switch action {
+	case "retry":
+		err = t.Actions.Retry(ctx, id)
+	case "cancel":
+		err = t.Actions.Cancel(ctx, id)
+	default:
+		return
+	}
+
+	reply := "Retrying download"
+	if action == "cancel" {
+		reply = "Download cancelled"
+	}
+	if err != nil {
+		slog.Warn("telegram action failed", "action", action, "id", id, "error", err)
+		reply = "Failed: " + err.Error()
+	} else {
+		slog.Info("telegram action", "action", action, "id", id)
+	}
+	if err := t.call(ctx, "answerCallbackQuery", map[string]any{
+		"callback_query_id": cq.ID,
+		"text":              reply,
+	}, nil); err != nil {
+		slog.Warn("failed to answer telegram callback", "error", err)
+	}
+}
+
+// call invokes a Bot API method, decoding its result into out when non-nil.
+func (t *Telegram) call(ctx context.Context, method string, params, out any) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("marshal %s: %w", method, err)
+	}
+	base := t.APIURL
+	if base == "" {
+		base = telegramAPI
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, base+"/bot"+t.Token+"/"+method, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create %s request: %w", method, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := t.HTTPClient
+	if client == nil {
+		// Longer than the getUpdates long-poll timeout.
+		client = &http.Client{Timeout: 40 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		// The request URL embeds the bot token; keep it out of logs.
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return fmt.Errorf("execute %s request: %w", method, err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		OK          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("decode %s response (status %d): %w", method, resp.StatusCode, err)
+	}
+	if !result.OK {
+		return fmt.Errorf("%s failed: %s", method, result.Description)
+	}
+	if out != nil {
+		if err := json.Unmarshal(result.Result, out); err != nil {
+			return fmt.Errorf("decode %s result: %w", method, err)
+		}
+	}
+	return nil
+}
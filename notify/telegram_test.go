@@ -0,0 +1,103 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type fakeActions struct {
+	retried, cancelled []string
+}
+
+func (f *fakeActions) Retry(_ context.Context, id string) error {
+	f.retried = append(f.retried, id)
+	return nil
+}
+
+func (f *fakeActions) Cancel(_ context.Context, id string) error {
+	f.cancelled = append(f.cancelled, id)
+	return errors.New("transfer gone")
+}
+
+func TestTelegram_FailureButtons(t *testing.T) {
+	var got telegramSend
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/botTOKEN/sendMessage" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"ok":true,"result":{}}`))
+	}))
+	defer srv.Close()
+
+	tg := &Telegram{Token: "TOKEN", ChatID: "42", APIURL: srv.URL, Actions: &fakeActions{}}
+	msg := failedMsg
+	msg.Download.ID = "SABnzbd_nzo_1"
+	if err := tg.Notify(context.Background(), msg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.ChatID != "42" || !strings.HasPrefix(got.Text, "Download failed\n") {
+		t.Errorf("unexpected message: %+v", got)
+	}
+	if got.ReplyMarkup == nil || got.ReplyMarkup.InlineKeyboard[0][0].CallbackData != "retry:SABnzbd_nzo_1" {
+		t.Errorf("expected retry button, got %+v", got.ReplyMarkup)
+	}
+}
+
+func TestTelegram_APIError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"ok":false,"description":"Bad Request: chat not found"}`))
+	}))
+	defer srv.Close()
+
+	tg := &Telegram{Token: "TOKEN", ChatID: "42", APIURL: srv.URL}
+	err := tg.Notify(context.Background(), Message{Event: EventComplete})
+	if err == nil || !strings.Contains(err.Error(), "chat not found") {
+		t.Fatalf("expected API error, got %v", err)
+	}
+}
+
+func TestTelegram_HandlesCallbacks(t *testing.T) {
+	var answers []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/getUpdates"):
+			w.Write([]byte(`{"ok":true,"result":[
+				{"update_id":7,"callback_query":{"id":"a","data":"retry:nzo_1","message":{"chat":{"id":42}}}},
+				{"update_id":8,"callback_query":{"id":"b","data":"cancel:nzo_2","message":{"chat":{"id":42}}}},
+				{"update_id":9,"callback_query":{"id":"c","data":"retry:nzo_3","message":{"chat":{"id":666}}}}
+			]}`))
+		case strings.HasSuffix(r.URL.Path, "/answerCallbackQuery"):
+			var body map[string]string
+			json.NewDecoder(r.Body).Decode(&body)
+			answers = append(answers, body["text"])
+			w.Write([]byte(`{"ok":true,"result":true}`))
+		}
+	}))
+	defer srv.Close()
+
+	actions := &fakeActions{}
+	tg := &Telegram{Token: "TOKEN", ChatID: "42", APIURL: srv.URL, Actions: actions}
+	if err := tg.poll(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(actions.retried) != 1 || actions.retried[0] != "nzo_1" {
+		t.Errorf("expected only nzo_1 retried, got %v", actions.retried)
+	}
+	if len(actions.cancelled) != 1 || actions.cancelled[0] != "nzo_2" {
+		t.Errorf("expected nzo_2 cancelled, got %v", actions.cancelled)
+	}
+	if len(answers) != 2 || answers[1] != "Failed: transfer gone" {
+		t.Errorf("unexpected callback answers: %v", answers)
+	}
+	if tg.offset != 10 {
+		t.Errorf("expected offset 10, got %d", tg.offset)
+	}
+}
@@ -0,0 +1,104 @@
+package notify
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Templates renders custom notification titles and bodies. Either template
+// may be nil to keep the default. A nil *Templates renders nothing.
+type Templates struct {
+	Title *template.Template
+	Body  *template.Template
+}
+
+// templateData is what templates see: the download's fields at the top
+// level alongside the event, e.g. {{.Event}} {{.Title}} {{.Error}}, and
+// for service events the service, e.g. {{.Service.Name}}.
+type templateData struct {
+	Download
+	Event   Event
+	Time    time.Time
+	Service Service
+}
+
+func newTemplateData(msg Message) templateData {
+	data := templateData{Download: msg.Download, Event: msg.Event, Time: msg.Time}
+	if msg.Service != nil {
+		data.Service = *msg.Service
+	}
+	return data
+}
+
+var templateFuncs = template.FuncMap{
+	"size":     FormatSize,
+	"duration": FormatDuration,
+	"upper":    strings.ToUpper,
+	"lower":    strings.ToLower,
+}
+
+// ParseTemplates parses the title and body templates, skipping empty ones.
+// Both are test-rendered so references to unknown fields fail here rather
+// than at send time. It returns nil when both are empty.
+func ParseTemplates(title, body string) (*Templates, error) {
+	if title == "" && body == "" {
+		return nil, nil
+	}
+	t := &Templates{}
+	var err error
+	if t.Title, err = parseTemplate("title", title); err != nil {
+		return nil, err
+	}
+	if t.Body, err = parseTemplate("body", body); err != nil {
+		return nil, err
+	}
+
+	sample := Message{Event: EventFailure, Time: time.Now()}
+	if err := t.Render(&sample); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func parseTemplate(name, text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	tmpl, err := template.New(name).Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s template: %w", name, err)
+	}
+	return tmpl, nil
+}
+
+// Render sets msg's title and text from the templates. On error msg is left
+// with its defaults.
+func (t *Templates) Render(msg *Message) error {
+	if t == nil {
+		return nil
+	}
+	data := newTemplateData(*msg)
+	title, err := execute(t.Title, data)
+	if err != nil {
+		return err
+	}
+	text, err := execute(t.Body, data)
+	if err != nil {
+		return err
+	}
+	msg.title, msg.text = title, text
+	return nil
+}
+
+func execute(tmpl *template.Template, data templateData) (string, error) {
+	if tmpl == nil {
+		return "", nil
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("render %s template: %w", tmpl.Name(), err)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
@@ -0,0 +1,64 @@
+package notify
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTemplates_Render(t *testing.T) {
+	tmpl, err := ParseTemplates(
+		`[{{upper (print .Event)}}] {{.Category}}`,
+		`{{.Title}} from {{.Username}} at {{size .Speed}}/s{{if .Error}}: {{.Error}}{{end}}`,
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	msg := failedMsg
+	msg.Download.Speed = 2 << 20
+	if err := tmpl.Render(&msg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if msg.Title() != "[FAILURE] lidarr" {
+		t.Errorf("unexpected title %q", msg.Title())
+	}
+	if msg.Text() != "01 - Track.flac from peer at 2.0 MiB/s: Completed, TimedOut" {
+		t.Errorf("unexpected text %q", msg.Text())
+	}
+}
+
+func TestTemplates_BodyOnlyKeepsDefaultTitle(t *testing.T) {
+	tmpl, err := ParseTemplates("", "{{.Title}}")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	msg := failedMsg
+	tmpl.Render(&msg)
+	if msg.Title() != "Download failed" || msg.Text() != "01 - Track.flac" {
+		t.Errorf("unexpected rendering: %q / %q", msg.Title(), msg.Text())
+	}
+}
+
+func TestParseTemplates_Errors(t *testing.T) {
+	if tmpl, err := ParseTemplates("", ""); tmpl != nil || err != nil {
+		t.Errorf("expected nil templates when unset, got %v %v", tmpl, err)
+	}
+	if _, err := ParseTemplates("{{.Title", ""); err == nil {
+		t.Error("expected parse error")
+	}
+	_, err := ParseTemplates("", "{{.Artist}}")
+	if err == nil || !strings.Contains(err.Error(), "Artist") {
+		t.Errorf("expected unknown field error, got %v", err)
+	}
+}
+
+func TestDiscord_UsesTemplatedText(t *testing.T) {
+	tmpl, _ := ParseTemplates("", "custom {{.Title}}")
+	msg := failedMsg
+	tmpl.Render(&msg)
+
+	payload := (&Discord{}).payload(msg)
+	if payload.Embeds[0].Description != "custom 01 - Track.flac" {
+		t.Errorf("unexpected description %q", payload.Embeds[0].Description)
+	}
+}
@@ -0,0 +1,214 @@
+package postprocess
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	flacPadding       = 1
+	flacVorbisComment = 4
+
+	flacPaddingSize = 4096 // padding left after a rewrite, for later edits
+)
+
+// flacBlock is a FLAC metadata block.
+type flacBlock struct {
+	typ  byte
+	data []byte
+}
+
+// writeFLACTags adds tags whose field is missing or empty from the file's
+// Vorbis comment, reporting whether the file changed. The new metadata is
+// written in place when it fits in the existing padding.
+func writeFLACTags(path string, tags []tag) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	blocks, audioStart, err := readFLACMetadata(f)
+	f.Close()
+	if err != nil {
+		return false, fmt.Errorf("read flac metadata: %w", err)
+	}
+
+	var (
+		vendor   = "slskrr"
+		comments []string
+		kept     []flacBlock
+	)
+	for _, b := range blocks {
+		switch b.typ {
+		case flacVorbisComment:
+			if vendor, comments, err = parseVorbisComment(b.data); err != nil {
+				return false, fmt.Errorf("read vorbis comment: %w", err)
+			}
+		case flacPadding:
+		default:
+			kept = append(kept, b)
+		}
+	}
+
+	present := make(map[string]bool)
+	for _, c := range comments {
+		if k, v, ok := strings.Cut(c, "="); ok && strings.TrimSpace(v) != "" {
+			present[strings.ToUpper(k)] = true
+		}
+	}
+	changed := false
+	comments = removeEmptyComments(comments)
+	for _, t := range tags {
+		if !present[t.vorbis] {
+			comments = append(comments, t.vorbis+"="+t.value)
+			changed = true
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+
+	// The Vorbis comment goes right after STREAMINFO, ahead of pictures.
+	vc := flacBlock{typ: flacVorbisComment, data: encodeVorbisComment(vendor, comments)}
+	kept = append(kept[:1], append([]flacBlock{vc}, kept[1:]...)...)
+
+	size := int64(4) // "fLaC"
+	for _, b := range kept {
+		size += 4 + int64(len(b.data))
+	}
+	if room := audioStart - size - 4; room >= 0 && room < 1<<24 {
+		kept = append(kept, flacBlock{typ: flacPadding, data: make([]byte, room)})
+		return true, writeAt(path, encodeFLACMetadata(kept))
+	}
+
+	kept = append(kept, flacBlock{typ: flacPadding, data: make([]byte, flacPaddingSize)})
+	return true, replaceFile(path, func(tmp *os.File) error {
+		src, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		if _, err := tmp.Write(encodeFLACMetadata(kept)); err != nil {
+			return err
+		}
+		if _, err := src.Seek(audioStart, io.SeekStart); err != nil {
+			return err
+		}
+		_, err = io.Copy(tmp, src)
+		return err
+	})
+}
+
+// readFLACMetadata reads the metadata blocks of a FLAC stream, returning
+// them along with the offset where the audio frames start.
+func readFLACMetadata(r io.Reader) ([]flacBlock, int64, error) {
+	var magic [4]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return nil, 0, err
+	}
+	if string(magic[:]) != "fLaC" {
+		return nil, 0, errors.New("not a FLAC stream")
+	}
+
+	offset := int64(4)
+	var blocks []flacBlock
+	for {
+		var hdr [4]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return nil, 0, err
+		}
+		size := int(hdr[1])<<16 | int(hdr[2])<<8 | int(hdr[3])
+		b := flacBlock{typ: hdr[0] & 0x7f, data: make([]byte, size)}
+		if _, err := io.ReadFull(r, b.data); err != nil {
+			return nil, 0, err
+		}
+		offset += 4 + int64(size)
+		blocks = append(blocks, b)
+		if hdr[0]&0x80 != 0 {
+			break
+		}
+	}
+	if len(blocks) == 0 || blocks[0].typ != 0 {
+		return nil, 0, errors.New("missing STREAMINFO")
+	}
+	return blocks, offset, nil
+}
+
+func encodeFLACMetadata(blocks []flacBlock) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("fLaC")
+	for i, b := range blocks {
+		typ := b.typ
+		if i == len(blocks)-1 {
+			typ |= 0x80
+		}
+		n := len(b.data)
+		buf.Write([]byte{typ, byte(n >> 16), byte(n >> 8), byte(n)})
+		buf.Write(b.data)
+	}
+	return buf.Bytes()
+}
+
+func parseVorbisComment(data []byte) (string, []string, error) {
+	r := bytes.NewReader(data)
+	readString := func() (string, error) {
+		var n uint32
+		if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+			return "", err
+		}
+		if int64(n) > int64(r.Len()) {
+			return "", io.ErrUnexpectedEOF
+		}
+		b := make([]byte, n)
+		_, err := io.ReadFull(r, b)
+		return string(b), err
+	}
+
+	vendor, err := readString()
+	if err != nil {
+		return "", nil, err
+	}
+	var count uint32
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return "", nil, err
+	}
+	comments := make([]string, 0, min(count, 256))
+	for range count {
+		c, err := readString()
+		if err != nil {
+			return "", nil, err
+		}
+		comments = append(comments, c)
+	}
+	return vendor, comments, nil
+}
+
+func encodeVorbisComment(vendor string, comments []string) []byte {
+	var buf bytes.Buffer
+	writeString := func(s string) {
+		_ = binary.Write(&buf, binary.LittleEndian, uint32(len(s)))
+		buf.WriteString(s)
+	}
+	writeString(vendor)
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(len(comments)))
+	for _, c := range comments {
+		writeString(c)
+	}
+	return buf.Bytes()
+}
+
+// removeEmptyComments drops fields with no value, so they can be filled in.
+func removeEmptyComments(comments []string) []string {
+	kept := comments[:0]
+	for _, c := range comments {
+		if _, v, ok := strings.Cut(c, "="); ok && strings.TrimSpace(v) == "" {
+			continue
+		}
+		kept = append(kept, c)
+	}
+	return kept
+}
@@ -0,0 +1,526 @@
+package postprocess
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math/bits"
+)
+
+// flacStreamInfo is what verifyFLAC needs of the STREAMINFO block.
+type flacStreamInfo struct {
+	sampleRate    int
+	channels      int
+	bitsPerSample int
+	totalSamples  int64    // 0 when the encoder didn't know
+	md5           [16]byte // of the decoded audio; all zero when not computed
+}
+
+func parseStreamInfo(data []byte) (flacStreamInfo, error) {
+	if len(data) < 34 {
+		return flacStreamInfo{}, errors.New("short STREAMINFO")
+	}
+	packed := binary.BigEndian.Uint64(data[10:18])
+	info := flacStreamInfo{
+		sampleRate:    int(packed >> 44),
+		channels:      int(packed>>41&0x7) + 1,
+		bitsPerSample: int(packed>>36&0x1f) + 1,
+		totalSamples:  int64(packed & (1<<36 - 1)),
+	}
+	copy(info.md5[:], data[18:34])
+	return info, nil
+}
+
+// verifyFLAC decodes a FLAC stream, checking every frame's CRCs and, when
+// the encoder recorded them, the sample count and the MD5 of the audio in
+// STREAMINFO. Any mismatch, or the stream ending mid-frame, is reported as
+// ErrCorrupt.
+func verifyFLAC(r io.Reader) error {
+	br := bufio.NewReaderSize(r, 64<<10)
+	blocks, _, err := readFLACMetadata(br)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	info, err := parseStreamInfo(blocks[0].data)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+
+	d := &flacDecoder{bits: &bitReader{r: br}, info: info, sum: md5.New()}
+	var samples int64
+	// Stop at the recorded sample count: anything after, like an appended
+	// ID3 tag, isn't audio.
+	for frame := 0; info.totalSamples == 0 || samples < info.totalSamples; frame++ {
+		n, err := d.frame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				err = errors.New("truncated")
+			}
+			return fmt.Errorf("%w: frame %d: %v", ErrCorrupt, frame, err)
+		}
+		samples += int64(n)
+	}
+
+	if info.totalSamples > 0 && samples != info.totalSamples {
+		return fmt.Errorf("%w: %d of %d samples", ErrCorrupt, samples, info.totalSamples)
+	}
+	if info.md5 != [16]byte{} && !bytes.Equal(d.sum.Sum(nil), info.md5[:]) {
+		return fmt.Errorf("%w: audio MD5 mismatch", ErrCorrupt)
+	}
+	return nil
+}
+
+// flacDecoder decodes the frames of a stream, feeding the samples to sum
+// the way the STREAMINFO MD5 is computed.
+type flacDecoder struct {
+	bits    *bitReader
+	info    flacStreamInfo
+	sum     hash.Hash
+	samples [][]int64 // per channel, reused across frames
+	out     []byte
+}
+
+// frame decodes the next frame, returning its block size, or io.EOF at the
+// clean end of the stream.
+func (d *flacDecoder) frame() (int, error) {
+	b := d.bits
+	b.crc8, b.crc16 = 0, 0
+	sync, err := b.read(15)
+	if err != nil {
+		if err == io.ErrUnexpectedEOF && b.consumed == 0 {
+			return 0, io.EOF
+		}
+		return 0, err
+	}
+	if sync != 0x7ffc {
+		return 0, errors.New("lost frame sync")
+	}
+	if _, err := b.read(1); err != nil { // blocking strategy
+		return 0, err
+	}
+	hdr, err := b.read(16)
+	if err != nil {
+		return 0, err
+	}
+	sizeCode, rateCode := hdr>>12, hdr>>8&0xf
+	assignment, depthCode := int(hdr>>4&0xf), hdr>>1&0x7
+	if err := b.skipUTF8(); err != nil {
+		return 0, err
+	}
+
+	var blockSize int
+	switch {
+	case sizeCode == 0:
+		return 0, errors.New("reserved block size")
+	case sizeCode == 1:
+		blockSize = 192
+	case sizeCode <= 5:
+		blockSize = 576 << (sizeCode - 2)
+	case sizeCode == 6:
+		v, err := b.read(8)
+		if err != nil {
+			return 0, err
+		}
+		blockSize = int(v) + 1
+	case sizeCode == 7:
+		v, err := b.read(16)
+		if err != nil {
+			return 0, err
+		}
+		blockSize = int(v) + 1
+	default:
+		blockSize = 256 << (sizeCode - 8)
+	}
+	switch rateCode {
+	case 12:
+		_, err = b.read(8)
+	case 13, 14:
+		_, err = b.read(16)
+	case 15:
+		err = errors.New("invalid sample rate")
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	depth := d.info.bitsPerSample
+	if depthCode != 0 {
+		depth = []int{0, 8, 12, 0, 16, 20, 24, 32}[depthCode]
+		if depth == 0 {
+			return 0, errors.New("reserved sample size")
+		}
+	}
+	channels := assignment + 1
+	if assignment >= 8 {
+		if assignment > 10 {
+			return 0, errors.New("reserved channel assignment")
+		}
+		channels = 2
+	}
+	if channels != d.info.channels {
+		return 0, fmt.Errorf("%d channels, STREAMINFO says %d", channels, d.info.channels)
+	}
+
+	crc := b.crc8
+	if v, err := b.read(8); err != nil {
+		return 0, err
+	} else if byte(v) != crc {
+		return 0, errors.New("header CRC mismatch")
+	}
+
+	for len(d.samples) < channels {
+		d.samples = append(d.samples, nil)
+	}
+	for ch := range channels {
+		// The side channel of a stereo pair carries one more bit.
+		bps := depth
+		if (assignment == 8 || assignment == 10) && ch == 1 || assignment == 9 && ch == 0 {
+			bps++
+		}
+		if cap(d.samples[ch]) < blockSize {
+			d.samples[ch] = make([]int64, blockSize)
+		}
+		d.samples[ch] = d.samples[ch][:blockSize]
+		if err := d.subframe(d.samples[ch], bps); err != nil {
+			return 0, fmt.Errorf("channel %d: %w", ch, err)
+		}
+	}
+
+	b.align()
+	crc16 := b.crc16
+	if v, err := b.read(16); err != nil {
+		return 0, err
+	} else if uint16(v) != crc16 {
+		return 0, errors.New("frame CRC mismatch")
+	}
+	b.consumed = 0
+
+	decorrelate(assignment, d.samples)
+	d.hash(d.samples[:channels], depth)
+	return blockSize, nil
+}
+
+// subframe decodes one channel's subframe into out.
+func (d *flacDecoder) subframe(out []int64, bps int) error {
+	b := d.bits
+	hdr, err := b.read(8)
+	if err != nil {
+		return err
+	}
+	if hdr&0x80 != 0 {
+		return errors.New("bad subframe padding")
+	}
+	wasted := 0
+	if hdr&1 != 0 {
+		n, err := b.unary()
+		if err != nil {
+			return err
+		}
+		wasted = n + 1
+		bps -= wasted
+		if bps <= 0 {
+			return errors.New("too many wasted bits")
+		}
+	}
+
+	switch typ := hdr >> 1 & 0x3f; {
+	case typ == 0:
+		v, err := b.signed(bps)
+		if err != nil {
+			return err
+		}
+		for i := range out {
+			out[i] = v
+		}
+	case typ == 1:
+		for i := range out {
+			if out[i], err = b.signed(bps); err != nil {
+				return err
+			}
+		}
+	case typ >= 8 && typ <= 12:
+		order := int(typ - 8)
+		if err := d.warmup(out, bps, order); err != nil {
+			return err
+		}
+		if err := d.residual(out, order); err != nil {
+			return err
+		}
+		predict(out, order, fixedCoefficients[order], 0)
+	case typ >= 32:
+		order := int(typ-32) + 1
+		if err := d.warmup(out, bps, order); err != nil {
+			return err
+		}
+		precision, err := b.read(4)
+		if err != nil {
+			return err
+		}
+		if precision == 15 {
+			return errors.New("invalid LPC precision")
+		}
+		shift, err := b.signed(5)
+		if err != nil {
+			return err
+		}
+		if shift < 0 {
+			return errors.New("negative LPC shift")
+		}
+		coefs := make([]int64, order)
+		for i := range coefs {
+			if coefs[i], err = b.signed(int(precision) + 1); err != nil {
+				return err
+			}
+		}
+		if err := d.residual(out, order); err != nil {
+			return err
+		}
+		predict(out, order, coefs, uint(shift))
+	default:
+		return fmt.Errorf("reserved subframe type %d", typ)
+	}
+
+	if wasted > 0 {
+		for i := range out {
+			out[i] <<= wasted
+		}
+	}
+	return nil
+}
+
+// fixedCoefficients are the predictors of the fixed subframe orders.
+var fixedCoefficients = [][]int64{{}, {1}, {2, -1}, {3, -3, 1}, {4, -6, 4, -1}}
+
+// warmup reads the order unpredicted samples a predicted subframe starts
+// with.
+func (d *flacDecoder) warmup(out []int64, bps, order int) error {
+	if order > len(out) {
+		return errors.New("predictor order above block size")
+	}
+	var err error
+	for i := range order {
+		if out[i], err = d.bits.signed(bps); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// predict turns the residuals in out after its order warm-up samples into
+// samples, adding each the prediction from the samples before it.
+func predict(out []int64, order int, coefs []int64, shift uint) {
+	for i := order; i < len(out); i++ {
+		var p int64
+		for j, c := range coefs {
+			p += c * out[i-1-j]
+		}
+		out[i] += p >> shift
+	}
+}
+
+// residual reads the Rice-coded residuals following order warm-up samples.
+func (d *flacDecoder) residual(out []int64, order int) error {
+	b := d.bits
+	method, err := b.read(2)
+	if err != nil {
+		return err
+	}
+	if method > 1 {
+		return errors.New("reserved residual coding method")
+	}
+	paramBits, escape := 4, uint64(15)
+	if method == 1 {
+		paramBits, escape = 5, 31
+	}
+	partitionOrder, err := b.read(4)
+	if err != nil {
+		return err
+	}
+	partitions := 1 << partitionOrder
+	if len(out)%partitions != 0 || len(out)/partitions < order {
+		return errors.New("bad residual partition order")
+	}
+
+	i := order
+	for p := range partitions {
+		end := (p + 1) * len(out) / partitions
+		param, err := b.read(paramBits)
+		if err != nil {
+			return err
+		}
+		if param == escape {
+			n, err := b.read(5)
+			if err != nil {
+				return err
+			}
+			for ; i < end; i++ {
+				if n == 0 {
+					out[i] = 0
+				} else if out[i], err = b.signed(int(n)); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		for ; i < end; i++ {
+			q, err := b.unary()
+			if err != nil {
+				return err
+			}
+			low, err := b.read(int(param))
+			if err != nil {
+				return err
+			}
+			v := uint64(q)<<param | low
+			out[i] = int64(v>>1) ^ -int64(v&1)
+		}
+	}
+	return nil
+}
+
+// decorrelate restores left and right from a stereo frame's side channel.
+func decorrelate(assignment int, ch [][]int64) {
+	switch assignment {
+	case 8: // left, side
+		for i := range ch[0] {
+			ch[1][i] = ch[0][i] - ch[1][i]
+		}
+	case 9: // side, right
+		for i := range ch[0] {
+			ch[0][i] += ch[1][i]
+		}
+	case 10: // mid, side
+		for i := range ch[0] {
+			mid, side := ch[0][i]<<1|ch[1][i]&1, ch[1][i]
+			ch[0][i], ch[1][i] = (mid+side)>>1, (mid-side)>>1
+		}
+	}
+}
+
+// hash adds a frame's samples to the MD5: interleaved, little-endian, in
+// as many bytes as the sample size needs.
+func (d *flacDecoder) hash(ch [][]int64, depth int) {
+	width := (depth + 7) / 8
+	d.out = d.out[:0]
+	for i := range ch[0] {
+		for c := range ch {
+			v := ch[c][i]
+			for k := range width {
+				d.out = append(d.out, byte(v>>(8*k)))
+			}
+		}
+	}
+	d.sum.Write(d.out)
+}
+
+// bitReader reads a FLAC frame MSB first, keeping the CRC-8 and CRC-16 of
+// the bytes consumed.
+type bitReader struct {
+	r        io.ByteReader
+	cache    uint64 // the low n bits are unread
+	n        int
+	crc8     byte
+	crc16    uint16
+	consumed int // bytes read in the current frame
+}
+
+func (b *bitReader) fill() error {
+	c, err := b.r.ReadByte()
+	if err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	b.crc8 = crc8Table[b.crc8^c]
+	b.crc16 = b.crc16<<8 ^ crc16Table[byte(b.crc16>>8)^c]
+	b.cache = b.cache<<8 | uint64(c)
+	b.n += 8
+	b.consumed++
+	return nil
+}
+
+// read returns the next n bits, n at most 56.
+func (b *bitReader) read(n int) (uint64, error) {
+	for b.n < n {
+		if err := b.fill(); err != nil {
+			return 0, err
+		}
+	}
+	b.n -= n
+	return b.cache >> b.n & (1<<n - 1), nil
+}
+
+// signed returns the next n bits as a two's complement number.
+func (b *bitReader) signed(n int) (int64, error) {
+	v, err := b.read(n)
+	if err != nil {
+		return 0, err
+	}
+	return int64(v<<(64-n)) >> (64 - n), nil
+}
+
+// unary counts zero bits up to the next one bit, which it consumes.
+func (b *bitReader) unary() (int, error) {
+	zeros := 0
+	for {
+		if b.n == 0 {
+			if err := b.fill(); err != nil {
+				return 0, err
+			}
+		}
+		rest := b.cache << (64 - b.n)
+		if rest == 0 {
+			zeros += b.n
+			b.n = 0
+			continue
+		}
+		lead := bits.LeadingZeros64(rest)
+		b.n -= lead + 1
+		return zeros + lead, nil
+	}
+}
+
+// skipUTF8 skips a frame header's UTF-8 style coded frame or sample number.
+func (b *bitReader) skipUTF8() error {
+	first, err := b.read(8)
+	if err != nil {
+		return err
+	}
+	extra := bits.LeadingZeros8(^byte(first)) - 1
+	if first&0xc0 == 0x80 || extra > 6 {
+		return errors.New("bad frame number")
+	}
+	for range max(extra, 0) {
+		if _, err := b.read(8); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// align drops the bits left in the current byte.
+func (b *bitReader) align() {
+	b.n -= b.n % 8
+}
+
+var (
+	crc8Table  [256]byte
+	crc16Table [256]uint16
+)
+
+func init() {
+	for i := range 256 {
+		c8, c16 := byte(i), uint16(i)<<8
+		for range 8 {
+			c8 = c8<<1 ^ byte(-int8(c8>>7))&0x07
+			c16 = c16<<1 ^ uint16(-int16(c16>>15))&0x8005
+		}
+		crc8Table[i], crc16Table[i] = c8, c16
+	}
+}
@@ -0,0 +1,144 @@
+package postprocess
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	// discFolder matches per-disc folders like "CD1" or "Disc 2".
+	discFolder = regexp.MustCompile(`(?i)^(cd|disc|disk|dvd)[\s_-]*\d{1,2}$`)
+	// formatFolder matches folders named after a format rather than a release.
+	formatFolder = regexp.MustCompile(`(?i)^(flac|mp3|aac|ogg|opus|alac|wav|320|v0|v2|\d{2}[\s-]?bit)$`)
+)
+
+// IsDiscFolder reports whether name is a per-disc folder like "CD1".
+func IsDiscFolder(name string) bool {
+	return discFolder.MatchString(name)
+}
+
+// IsFormatFolder reports whether name is a folder named after a format,
+// like "FLAC" or "24bit", rather than a release.
+func IsFormatFolder(name string) bool {
+	return formatFolder.MatchString(name)
+}
+
+// Flatten moves the file to <Root>/<category>/<release>/<name>, where the
+// release is the name the client asked for or else the nearest folder in
+// the peer's path that isn't named after a disc or a format. Files from
+// per-disc folders keep that folder below the release. A file shared
+// outside any folder gets a release folder named after itself.
+//
+// With Link set the file is hard-linked there instead, so slskd keeps its
+// copy, and goes on sharing it, without using more space.
+type Flatten struct {
+	Link bool
+}
+
+func (fl *Flatten) Name() string { return "flatten" }
+
+func (fl *Flatten) Run(_ context.Context, f *File) error {
+	release, disc := ReleaseFolder(f.Filename)
+	if name := safeName(f.Name); name != "" {
+		release = name
+	}
+	dir := f.Root
+	if c := safeName(f.Category); c != "" {
+		dir = filepath.Join(dir, c)
+	}
+	dir = filepath.Join(dir, release, disc)
+	target := filepath.Join(dir, filepath.Base(f.Path))
+	if target == f.Path {
+		return nil
+	}
+
+	src, err := os.Stat(f.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		if _, err := os.Stat(target); err == nil {
+			f.Path = target // moved by an earlier, interrupted run
+			return nil
+		}
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("create folder: %w", err)
+	}
+	target, linked := freeName(target, src)
+	if linked {
+		f.Path = target // linked by an earlier, interrupted run
+		return nil
+	}
+	if fl.Link {
+		if err := os.Link(f.Path, target); err != nil {
+			return fmt.Errorf("link: %w", err)
+		}
+		f.Path = target
+		return nil
+	}
+	if err := os.Rename(f.Path, target); err != nil {
+		return fmt.Errorf("move: %w", err)
+	}
+	if old := filepath.Dir(f.Path); filepath.Clean(old) != filepath.Clean(f.Root) {
+		_ = os.Remove(old) // drop the old folder once it's empty
+	}
+	f.Path = target
+	return nil
+}
+
+// ReleaseFolder picks the release name, and the disc folder if any, from a
+// peer's remote path.
+func ReleaseFolder(remote string) (release, disc string) {
+	parts := strings.FieldsFunc(remote, func(r rune) bool { return r == '\\' || r == '/' })
+	if len(parts) == 0 {
+		return "_", ""
+	}
+	name := parts[len(parts)-1]
+	dirs := parts[:len(parts)-1]
+	for i := len(dirs) - 1; i >= 0; i-- {
+		d := safeName(dirs[i])
+		switch {
+		case d == "" || IsFormatFolder(d):
+		case IsDiscFolder(d):
+			if disc == "" && i == len(dirs)-1 {
+				disc = d
+			}
+		default:
+			return d, disc
+		}
+	}
+	if release = safeName(strings.TrimSuffix(name, filepath.Ext(name))); release == "" {
+		release = "_"
+	}
+	return release, disc
+}
+
+// safeName returns name if it can be used as a single path component.
+func safeName(name string) string {
+	name = strings.TrimSpace(name)
+	if name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return ""
+	}
+	return name
+}
+
+// freeName returns path, or path with a " (n)" suffix when it is taken. A
+// name on the way that already is src, linked there before, is returned
+// instead with linked set.
+func freeName(path string, src os.FileInfo) (name string, linked bool) {
+	ext := filepath.Ext(path)
+	stem := strings.TrimSuffix(path, ext)
+	for n := 2; ; n++ {
+		dst, err := os.Lstat(path)
+		if err != nil {
+			return path, false
+		}
+		if src != nil && os.SameFile(src, dst) {
+			return path, true
+		}
+		path = fmt.Sprintf("%s (%d)%s", stem, n, ext)
+	}
+}
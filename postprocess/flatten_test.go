@@ -0,0 +1,149 @@
+package postprocess
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReleaseFolder(t *testing.T) {
+	tests := []struct{ remote, release, disc string }{
+		{`@@share\Music\Artist\Album (2001)\01.flac`, "Album (2001)", ""},
+		{`Music\Artist\Album\FLAC\01.flac`, "Album", ""},
+		{`Music\Artist\Album\CD2\01.flac`, "Album", "CD2"},
+		{`Music\Artist\Album\Disc 1\24bit\01.flac`, "Album", ""},
+		{`Movie.2020.1080p.mkv`, "Movie.2020.1080p", ""},
+		{`Music/..\01.flac`, "Music", ""},
+	}
+	for _, tt := range tests {
+		release, disc := ReleaseFolder(tt.remote)
+		if release != tt.release || disc != tt.disc {
+			t.Errorf("ReleaseFolder(%q) = %q, %q, want %q, %q", tt.remote, release, disc, tt.release, tt.disc)
+		}
+	}
+}
+
+func TestFlatten_Run(t *testing.T) {
+	root := t.TempDir()
+	remote := `@@share\Music\Artist\Album\CD1\01.flac`
+	orig := LocalPath(root, remote)
+	os.MkdirAll(filepath.Dir(orig), 0o755)
+	os.WriteFile(orig, []byte("x"), 0o644)
+
+	f := &File{Filename: remote, Category: "lidarr", Root: root, Path: orig}
+	if err := (&Flatten{}).Run(context.Background(), f); err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.Join(root, "lidarr", "Album", "CD1", "01.flac")
+	if f.Path != want {
+		t.Errorf("expected %q, got %q", want, f.Path)
+	}
+	if _, err := os.Stat(filepath.Dir(orig)); !os.IsNotExist(err) {
+		t.Error("expected the old folder removed")
+	}
+
+	// Re-running after an interruption finds the moved file.
+	f = &File{Filename: remote, Category: "lidarr", Root: root, Path: orig}
+	if err := (&Flatten{}).Run(context.Background(), f); err != nil || f.Path != want {
+		t.Errorf("expected re-run to resolve to %q, got %q, %v", want, f.Path, err)
+	}
+}
+
+func TestFlatten_Link(t *testing.T) {
+	root := t.TempDir()
+	remote := `@@share\Music\Artist\Album\01.flac`
+	orig := LocalPath(root, remote)
+	os.MkdirAll(filepath.Dir(orig), 0o755)
+	os.WriteFile(orig, []byte("x"), 0o644)
+
+	step := &Flatten{Link: true}
+	f := &File{Filename: remote, Category: "lidarr", Root: root, Path: orig}
+	if err := step.Run(context.Background(), f); err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.Join(root, "lidarr", "Album", "01.flac")
+	if f.Path != want {
+		t.Errorf("expected %q, got %q", want, f.Path)
+	}
+	src, err := os.Stat(orig)
+	if err != nil {
+		t.Fatalf("expected slskd's copy kept: %v", err)
+	}
+	if dst, err := os.Stat(want); err != nil || !os.SameFile(src, dst) {
+		t.Errorf("expected a hard link, got %v", err)
+	}
+
+	// Re-running finds the link rather than making another.
+	f = &File{Filename: remote, Category: "lidarr", Root: root, Path: orig}
+	if err := step.Run(context.Background(), f); err != nil || f.Path != want {
+		t.Errorf("expected re-run to resolve to %q, got %q, %v", want, f.Path, err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "lidarr", "Album", "01 (2).flac")); !os.IsNotExist(err) {
+		t.Error("expected no second link")
+	}
+}
+
+func TestFlatten_Link_AfterCollision(t *testing.T) {
+	root := t.TempDir()
+	remote := `@@share\Music\Artist\Album\01.flac`
+	orig := LocalPath(root, remote)
+	os.MkdirAll(filepath.Dir(orig), 0o755)
+	os.WriteFile(orig, []byte("new"), 0o644)
+	existing := filepath.Join(root, "lidarr", "Album", "01.flac")
+	os.MkdirAll(filepath.Dir(existing), 0o755)
+	os.WriteFile(existing, []byte("old"), 0o644)
+
+	step := &Flatten{Link: true}
+	want := filepath.Join(root, "lidarr", "Album", "01 (2).flac")
+	for range 2 {
+		f := &File{Filename: remote, Category: "lidarr", Root: root, Path: orig}
+		if err := step.Run(context.Background(), f); err != nil || f.Path != want {
+			t.Fatalf("expected %q, got %q, %v", want, f.Path, err)
+		}
+	}
+	// The re-run found the deduped link rather than making another.
+	if _, err := os.Stat(filepath.Join(root, "lidarr", "Album", "01 (3).flac")); !os.IsNotExist(err) {
+		t.Error("expected no second link")
+	}
+	if data, _ := os.ReadFile(existing); string(data) != "old" {
+		t.Error("expected the existing file untouched")
+	}
+}
+
+func TestFlatten_ReleaseName(t *testing.T) {
+	root := t.TempDir()
+	remote := `@@share\TV\stuff\show.s01e01.mkv`
+	orig := LocalPath(root, remote)
+	os.MkdirAll(filepath.Dir(orig), 0o755)
+	os.WriteFile(orig, []byte("x"), 0o644)
+
+	f := &File{Filename: remote, Category: "sonarr", Name: "Show.S01E01.1080p.WEB-DL", Root: root, Path: orig}
+	if err := (&Flatten{}).Run(context.Background(), f); err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(root, "sonarr", "Show.S01E01.1080p.WEB-DL", "show.s01e01.mkv"); f.Path != want {
+		t.Errorf("expected %q, got %q", want, f.Path)
+	}
+}
+
+func TestFlatten_KeepsExistingFiles(t *testing.T) {
+	root := t.TempDir()
+	existing := filepath.Join(root, "Album", "01.flac")
+	os.MkdirAll(filepath.Dir(existing), 0o755)
+	os.WriteFile(existing, []byte("old"), 0o644)
+	orig := filepath.Join(root, "FLAC", "01.flac")
+	os.MkdirAll(filepath.Dir(orig), 0o755)
+	os.WriteFile(orig, []byte("new"), 0o644)
+
+	f := &File{Filename: `Album\FLAC\01.flac`, Category: "../..", Root: root, Path: orig}
+	if err := (&Flatten{}).Run(context.Background(), f); err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(root, "Album", "01 (2).flac"); f.Path != want {
+		t.Errorf("expected %q, got %q", want, f.Path)
+	}
+	if data, _ := os.ReadFile(existing); string(data) != "old" {
+		t.Error("expected the existing file untouched")
+	}
+}
@@ -0,0 +1,180 @@
+package postprocess
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"unicode/utf16"
+)
+
+const id3PaddingSize = 2048 // padding left after a rewrite, for later edits
+
+// id3Frame is a raw ID3v2.3 or v2.4 frame.
+type id3Frame struct {
+	id    string
+	flags [2]byte
+	data  []byte
+}
+
+// writeID3Tags adds tags whose text frame is missing or empty to the file's
+// ID3v2 tag, creating a v2.3 tag when there is none, and reports whether the
+// file changed. Tags using unsynchronisation, extended headers or footers,
+// and pre-v2.3 tags, are left alone.
+func writeID3Tags(path string, tags []tag) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	var hdr [10]byte
+	if _, err := io.ReadFull(f, hdr[:]); err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		f.Close()
+		return false, fmt.Errorf("read id3 header: %w", err)
+	}
+
+	version := byte(3)
+	var (
+		frames   []id3Frame
+		tagSize  int64 // existing tag including header; 0 when there is none
+		tagBytes []byte
+	)
+	if string(hdr[:3]) == "ID3" {
+		version = hdr[3]
+		if (version != 3 && version != 4) || hdr[5] != 0 {
+			f.Close()
+			return false, nil
+		}
+		size := syncsafe(hdr[6:10])
+		tagBytes = make([]byte, size)
+		if _, err := io.ReadFull(f, tagBytes); err != nil {
+			f.Close()
+			return false, fmt.Errorf("read id3 tag: %w", err)
+		}
+		tagSize = 10 + int64(size)
+		if frames, err = parseID3Frames(tagBytes, version); err != nil {
+			f.Close()
+			return false, fmt.Errorf("read id3 tag: %w", err)
+		}
+	}
+	f.Close()
+
+	present := make(map[string]bool)
+	kept := frames[:0]
+	for _, fr := range frames {
+		if len(fr.id) == 4 && fr.id[0] == 'T' && emptyText(fr.data) {
+			continue // drop empty text frames so they can be filled in
+		}
+		present[fr.id] = true
+		kept = append(kept, fr)
+	}
+	changed := len(kept) != len(frames)
+	for _, t := range tags {
+		if !present[t.id3] {
+			kept = append(kept, id3Frame{id: t.id3, data: encodeID3Text(t.value, version)})
+			changed = true
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+
+	body := encodeID3Frames(kept, version)
+	if tagSize > 0 && int64(len(body)) <= tagSize-10 {
+		body = append(body, make([]byte, tagSize-10-int64(len(body)))...)
+		return true, writeAt(path, encodeID3Header(version, len(body)), body)
+	}
+
+	body = append(body, make([]byte, id3PaddingSize)...)
+	return true, replaceFile(path, func(tmp *os.File) error {
+		src, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		if _, err := tmp.Write(encodeID3Header(version, len(body))); err != nil {
+			return err
+		}
+		if _, err := tmp.Write(body); err != nil {
+			return err
+		}
+		if _, err := src.Seek(tagSize, io.SeekStart); err != nil {
+			return err
+		}
+		_, err = io.Copy(tmp, src)
+		return err
+	})
+}
+
+func parseID3Frames(data []byte, version byte) ([]id3Frame, error) {
+	var frames []id3Frame
+	for len(data) >= 10 && data[0] != 0 {
+		size := int(binary.BigEndian.Uint32(data[4:8]))
+		if version == 4 {
+			size = syncsafe(data[4:8])
+		}
+		if size > len(data)-10 {
+			return nil, fmt.Errorf("frame %q overruns the tag", data[:4])
+		}
+		frames = append(frames, id3Frame{
+			id:    string(data[:4]),
+			flags: [2]byte{data[8], data[9]},
+			data:  data[10 : 10+size],
+		})
+		data = data[10+size:]
+	}
+	return frames, nil
+}
+
+func encodeID3Frames(frames []id3Frame, version byte) []byte {
+	var buf bytes.Buffer
+	for _, fr := range frames {
+		buf.WriteString(fr.id)
+		if version == 4 {
+			buf.Write(toSyncsafe(len(fr.data)))
+		} else {
+			_ = binary.Write(&buf, binary.BigEndian, uint32(len(fr.data)))
+		}
+		buf.Write(fr.flags[:])
+		buf.Write(fr.data)
+	}
+	return buf.Bytes()
+}
+
+func encodeID3Header(version byte, size int) []byte {
+	return append([]byte{'I', 'D', '3', version, 0, 0}, toSyncsafe(size)...)
+}
+
+// encodeID3Text encodes a text frame body: UTF-8 in v2.4, and UTF-16 with a
+// byte order mark in v2.3, which has no UTF-8 encoding.
+func encodeID3Text(s string, version byte) []byte {
+	if version == 4 {
+		return append([]byte{3}, s...)
+	}
+	b := []byte{1, 0xff, 0xfe}
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(u), byte(u>>8))
+	}
+	return b
+}
+
+// emptyText reports whether a text frame body holds no text.
+func emptyText(data []byte) bool {
+	if len(data) <= 1 {
+		return true
+	}
+	for _, b := range data[1:] {
+		if b != 0 && b != 0xff && b != 0xfe {
+			return false
+		}
+	}
+	return true
+}
+
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7f)<<21 | int(b[1]&0x7f)<<14 | int(b[2]&0x7f)<<7 | int(b[3]&0x7f)
+}
+
+func toSyncsafe(n int) []byte {
+	return []byte{byte(n>>21) & 0x7f, byte(n>>14) & 0x7f, byte(n>>7) & 0x7f, byte(n) & 0x7f}
+}
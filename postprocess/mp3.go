@@ -0,0 +1,206 @@
+package postprocess
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// mp3Frame is the part of an MPEG audio frame header verifyMP3 needs.
+type mp3Frame struct {
+	length     int // bytes, header included
+	sideInfo   int // bytes of Layer III side info after the header
+	layerThree bool
+}
+
+var (
+	// mp3Bitrates are in kbps, by [MPEG-1][layer - 1][index].
+	mp3Bitrates = [2][3][16]int{
+		{ // MPEG-2 and 2.5
+			{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+			{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+			{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		},
+		{ // MPEG-1
+			{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+			{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+			{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+		},
+	}
+	mp3SampleRates = [3]int{44100, 48000, 32000} // MPEG-1; halved for 2, quartered for 2.5
+)
+
+// parseMP3Header decodes a frame header, reporting false for anything that
+// isn't a valid one. Free-format frames, whose length isn't in the header,
+// count as invalid.
+func parseMP3Header(h []byte) (mp3Frame, bool) {
+	if len(h) < 4 || h[0] != 0xff || h[1]&0xe0 != 0xe0 {
+		return mp3Frame{}, false
+	}
+	version := h[1] >> 3 & 0x3 // 0: 2.5, 2: 2, 3: 1
+	layer := 4 - int(h[1]>>1&0x3)
+	bitrateIndex, rateIndex := h[2]>>4, h[2]>>2&0x3
+	if version == 1 || layer == 4 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+		return mp3Frame{}, false
+	}
+	mpeg1 := 0
+	if version == 3 {
+		mpeg1 = 1
+	}
+	bitrate := mp3Bitrates[mpeg1][layer-1][bitrateIndex] * 1000
+	rate := mp3SampleRates[rateIndex]
+	switch version {
+	case 2:
+		rate /= 2
+	case 0:
+		rate /= 4
+	}
+	padding := int(h[2] >> 1 & 0x1)
+
+	f := mp3Frame{layerThree: layer == 3}
+	switch {
+	case layer == 1:
+		f.length = (12*bitrate/rate + padding) * 4
+	case layer == 3 && mpeg1 == 0:
+		f.length = 72*bitrate/rate + padding
+	default:
+		f.length = 144*bitrate/rate + padding
+	}
+	mono := h[3]>>6 == 3
+	switch {
+	case mpeg1 == 1 && mono:
+		f.sideInfo = 17
+	case mpeg1 == 1:
+		f.sideInfo = 32
+	case mono:
+		f.sideInfo = 9
+	default:
+		f.sideInfo = 17
+	}
+	return f, f.length > 4
+}
+
+// verifyMP3 walks an MP3 file's frames, between any ID3v2 tag at the start
+// and ID3v1 or APE tags at the end, failing with ErrCorrupt when the last
+// frame runs past the audio or there are fewer frames than the Xing or
+// VBRI header of the first one counts. Stretches of garbage between frames
+// are skipped, as players do.
+func verifyMP3(file *os.File) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	start, end, err := mp3Bounds(file, info.Size())
+	if err != nil {
+		return err
+	}
+	if _, err := file.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+	r := bufio.NewReaderSize(io.LimitReader(file, end-start), 16<<10)
+
+	pos, frames, expected := start, 0, 0
+	synced := false // whether pos is where the previous frame ended
+	for pos < end {
+		h, err := r.Peek(4)
+		if err != nil {
+			break // fewer than 4 bytes left: not a frame
+		}
+		f, ok := parseMP3Header(h)
+		if ok && !synced {
+			// At the start or after junk, only a frame followed by another,
+			// or ending the audio, is taken for one, so a stray sync word
+			// isn't.
+			if next, err := r.Peek(f.length + 4); err == nil {
+				_, ok = parseMP3Header(next[f.length:])
+			} else {
+				ok = pos+int64(f.length) == end
+			}
+		}
+		if synced = ok; !ok {
+			r.Discard(1)
+			pos++
+			continue
+		}
+		if pos+int64(f.length) > end {
+			return fmt.Errorf("%w: truncated in frame %d", ErrCorrupt, frames+1)
+		}
+		if frames == 0 && f.layerThree {
+			if data, err := r.Peek(f.length); err == nil {
+				if n, ok := mp3FrameCount(data, f); ok {
+					expected = n
+					r.Discard(f.length)
+					pos += int64(f.length)
+					continue // the header frame is silent, not counted
+				}
+			}
+		}
+		r.Discard(f.length)
+		pos += int64(f.length)
+		frames++
+	}
+
+	if frames == 0 {
+		return fmt.Errorf("%w: no MPEG audio frames", ErrCorrupt)
+	}
+	// Encoders disagree on whether the count includes the header frame.
+	if expected > 0 && frames < expected-1 {
+		return fmt.Errorf("%w: %d of %d frames", ErrCorrupt, frames, expected)
+	}
+	return nil
+}
+
+// mp3FrameCount returns the frame count in a first frame's Xing, Info or
+// VBRI header, if it has one with a count.
+func mp3FrameCount(data []byte, f mp3Frame) (int, bool) {
+	if at := 4 + f.sideInfo; len(data) >= at+12 {
+		if tag := string(data[at : at+4]); tag == "Xing" || tag == "Info" {
+			if flags := binary.BigEndian.Uint32(data[at+4:]); flags&1 != 0 {
+				return int(binary.BigEndian.Uint32(data[at+8:])), true
+			}
+			return 0, false
+		}
+	}
+	if at := 4 + 32; len(data) >= at+18 && string(data[at:at+4]) == "VBRI" {
+		return int(binary.BigEndian.Uint32(data[at+14:])), true
+	}
+	return 0, false
+}
+
+// mp3Bounds returns where an MP3 file's audio starts and ends, leaving out
+// an ID3v2 tag at the start and ID3v1 and APE tags at the end.
+func mp3Bounds(file *os.File, size int64) (start, end int64, err error) {
+	var hdr [10]byte
+	if _, err := file.ReadAt(hdr[:], 0); err == nil && string(hdr[:3]) == "ID3" {
+		start = 10 + (int64(hdr[6]&0x7f)<<21 | int64(hdr[7]&0x7f)<<14 | int64(hdr[8]&0x7f)<<7 | int64(hdr[9]&0x7f))
+		if hdr[5]&0x10 != 0 {
+			start += 10 // footer
+		}
+	} else if err != nil && !errors.Is(err, io.EOF) {
+		return 0, 0, err
+	}
+
+	end = size
+	var tag [3]byte
+	if end-128 >= start {
+		if _, err := file.ReadAt(tag[:], end-128); err == nil && string(tag[:]) == "TAG" {
+			end -= 128
+		}
+	}
+	var ape [32]byte
+	if end-32 >= start {
+		if _, err := file.ReadAt(ape[:], end-32); err == nil && string(ape[:8]) == "APETAGEX" {
+			n := int64(binary.LittleEndian.Uint32(ape[12:])) // items and footer
+			if binary.LittleEndian.Uint32(ape[20:])&(1<<31) != 0 {
+				n += 32 // header
+			}
+			if end-n >= start {
+				end -= n
+			}
+		}
+	}
+	return min(start, size), end, nil
+}
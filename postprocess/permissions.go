@@ -0,0 +1,46 @@
+package postprocess
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Permissions hands the file, its extras and its folder to UID:GID and resets their
+// modes, the way linuxserver.io images apply PUID, PGID and UMASK, so the
+// *arr containers can import and clean up after it. A UID or GID of -1 is
+// left unchanged, as is a zero mode.
+type Permissions struct {
+	UID, GID int
+	FileMode os.FileMode
+	DirMode  os.FileMode
+}
+
+func (p *Permissions) Name() string { return "permissions" }
+
+func (p *Permissions) Run(_ context.Context, f *File) error {
+	for _, path := range append([]string{f.Path}, f.Extras...) {
+		if err := p.apply(path, p.FileMode); err != nil {
+			return err
+		}
+	}
+	if dir := filepath.Dir(f.Path); filepath.Clean(dir) != filepath.Clean(f.Root) {
+		return p.apply(dir, p.DirMode)
+	}
+	return nil
+}
+
+func (p *Permissions) apply(path string, mode os.FileMode) error {
+	if p.UID >= 0 || p.GID >= 0 {
+		if err := os.Lchown(path, p.UID, p.GID); err != nil {
+			return fmt.Errorf("chown: %w", err)
+		}
+	}
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			return fmt.Errorf("chmod: %w", err)
+		}
+	}
+	return nil
+}
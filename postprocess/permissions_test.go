@@ -0,0 +1,56 @@
+package postprocess
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPermissions_Run(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "Album")
+	if err := os.Mkdir(dir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "01.flac")
+	if err := os.WriteFile(path, []byte("x"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	p := &Permissions{UID: os.Getuid(), GID: os.Getgid(), FileMode: 0o664, DirMode: 0o775}
+	if err := p.Run(context.Background(), &File{Root: root, Path: path}); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]os.FileMode{path: 0o664, dir: 0o775} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.Mode().Perm(); got != want {
+			t.Errorf("%s: expected mode %o, got %o", name, want, got)
+		}
+	}
+}
+
+func TestPermissions_KeepsRootAndUnsetModes(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Chmod(root, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(root, "01.flac")
+	if err := os.WriteFile(path, []byte("x"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	p := &Permissions{UID: -1, GID: -1, DirMode: 0o777}
+	if err := p.Run(context.Background(), &File{Root: root, Path: path}); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(root); info.Mode().Perm() != 0o700 {
+		t.Errorf("expected download dir untouched, got %o", info.Mode().Perm())
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0o600 {
+		t.Errorf("expected file mode untouched, got %o", info.Mode().Perm())
+	}
+}
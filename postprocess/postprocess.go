@@ -0,0 +1,74 @@
+// Package postprocess runs a pipeline of steps over each completed
+// download before it is reported to the *arr apps, e.g. renaming files to
+// names the target filesystem accepts.
+package postprocess
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// File is a completed download on local disk.
+type File struct {
+	ID       string
+	Username string
+	Filename string // remote path, with the peer's separators
+	Size     int64
+	BitRate  int       // kbps as shared by the peer; 0 when unknown
+	AddedAt  time.Time // when the file was grabbed
+	Category string
+	Artist   string // album artist and title, when the grab was for a known album
+	Album    string
+	Name     string   // release name the client asked for, if any
+	Root     string   // the download directory; steps never rename it
+	Path     string   // local path; steps that move the file update it
+	Extras   []string // files written alongside Path, like sidecars
+}
+
+// LocalPath returns where slskd saves a remote file under downloadDir: in
+// a folder named after the file's remote parent directory.
+func LocalPath(downloadDir, remote string) string {
+	parts := strings.FieldsFunc(remote, func(r rune) bool { return r == '\\' || r == '/' })
+	if len(parts) == 0 {
+		return downloadDir
+	}
+	if len(parts) == 1 {
+		return filepath.Join(downloadDir, parts[0])
+	}
+	return filepath.Join(downloadDir, parts[len(parts)-2], parts[len(parts)-1])
+}
+
+// Step is one post-processing action. Steps should tolerate being re-run on
+// a file they already handled, since an interrupted pipeline starts over.
+type Step interface {
+	Name() string
+	Run(ctx context.Context, f *File) error
+}
+
+// Pipeline runs its steps in order. A nil or empty pipeline does nothing.
+type Pipeline struct {
+	Steps []Step
+}
+
+// Enabled reports whether the pipeline has any steps.
+func (p *Pipeline) Enabled() bool {
+	return p != nil && len(p.Steps) > 0
+}
+
+// Run runs every step on f, stopping at the first failure.
+func (p *Pipeline) Run(ctx context.Context, f *File) error {
+	if p == nil {
+		return nil
+	}
+	for _, step := range p.Steps {
+		if err := step.Run(ctx, f); err != nil {
+			return fmt.Errorf("%s: %w", step.Name(), err)
+		}
+		slog.DebugContext(ctx, "post-processing step done", "step", step.Name(), "id", f.ID, "path", f.Path)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package postprocess
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// DefaultRules replace the characters Windows, SMB and exFAT reject.
+var DefaultRules = map[rune]string{
+	'<': "_", '>': "_", ':': "_", '"': "_", '\\': "_", '|': "_", '?': "_", '*': "_",
+}
+
+// reservedNames can't be used as a file or folder name on Windows, with or
+// without an extension.
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// ParseRules parses replacement rules like `:=-,?=` into a map from
+// character to replacement; an empty replacement deletes the character.
+func ParseRules(s string) (map[rune]string, error) {
+	rules := make(map[rune]string)
+	for _, rule := range strings.Split(s, ",") {
+		if rule == "" {
+			continue
+		}
+		from, to, ok := strings.Cut(rule, "=")
+		if !ok || len([]rune(from)) != 1 {
+			return nil, fmt.Errorf("invalid rule %q: want <char>=<replacement>", rule)
+		}
+		rules[[]rune(from)[0]] = to
+	}
+	return rules, nil
+}
+
+// Sanitize renames the file and its folder so every path component is
+// valid on Windows-family filesystems: characters in Rules are replaced,
+// control characters dropped, trailing dots and spaces trimmed and reserved
+// device names suffixed with an underscore.
+type Sanitize struct {
+	Rules map[rune]string
+}
+
+func (s *Sanitize) Name() string { return "sanitize" }
+
+func (s *Sanitize) Run(_ context.Context, f *File) error {
+	dir, base := filepath.Split(f.Path)
+	dir = filepath.Clean(dir)
+	target := filepath.Join(dir, s.clean(base))
+	if dir != filepath.Clean(f.Root) {
+		parent, folder := filepath.Split(dir)
+		target = filepath.Join(parent, s.clean(folder), s.clean(base))
+	}
+	if target == f.Path {
+		return nil
+	}
+
+	if _, err := os.Stat(f.Path); errors.Is(err, os.ErrNotExist) {
+		if _, err := os.Stat(target); err == nil {
+			f.Path = target // renamed by an earlier, interrupted run
+			return nil
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return fmt.Errorf("create folder: %w", err)
+	}
+	if err := os.Rename(f.Path, target); err != nil {
+		return fmt.Errorf("rename: %w", err)
+	}
+	if filepath.Dir(target) != dir {
+		_ = os.Remove(dir) // drop the old folder once it's empty
+	}
+	f.Path = target
+	return nil
+}
+
+// clean returns name made safe under s.Rules.
+func (s *Sanitize) clean(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if repl, ok := s.Rules[r]; ok {
+			b.WriteString(repl)
+		} else if !unicode.IsControl(r) {
+			b.WriteRune(r)
+		}
+	}
+	out := strings.TrimRight(b.String(), ". ")
+	stem, _, _ := strings.Cut(out, ".")
+	if reservedNames[strings.ToUpper(strings.TrimSpace(stem))] {
+		out = stem + "_" + out[len(stem):]
+	}
+	if out == "" {
+		out = "_"
+	}
+	return out
+}
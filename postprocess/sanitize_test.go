@@ -0,0 +1,98 @@
+package postprocess
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSanitize_Clean(t *testing.T) {
+	s := &Sanitize{Rules: DefaultRules}
+	tests := map[string]string{
+		"01 What?.flac":       "01 What_.flac",
+		`Band: "Live" <1991>`: "Band_ _Live_ _1991_",
+		"trailing. ":          "trailing",
+		"tab\there.mp3":       "tabhere.mp3",
+		"con.txt":             "con_.txt",
+		"LPT1":                "LPT1_",
+		"Console.mp3":         "Console.mp3",
+		"...":                 "_",
+		"Künstler – Ñ.flac":   "Künstler – Ñ.flac",
+	}
+	for in, want := range tests {
+		if got := s.clean(in); got != want {
+			t.Errorf("clean(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules(":=-,?=,*=x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Sanitize{Rules: rules}
+	if got := s.clean("a:b?c*d"); got != "a-bcxd" {
+		t.Errorf("unexpected result with custom rules: %q", got)
+	}
+
+	for _, bad := range []string{"ab=c", ":", "=x"} {
+		if _, err := ParseRules(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestSanitize_Run(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "Album: Live?")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	orig := filepath.Join(dir, "01 Intro*.flac")
+	if err := os.WriteFile(orig, []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Sanitize{Rules: DefaultRules}
+	f := &File{Root: root, Path: orig}
+	if err := s.Run(context.Background(), f); err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.Join(root, "Album_ Live_", "01 Intro_.flac")
+	if f.Path != want {
+		t.Errorf("expected path %q, got %q", want, f.Path)
+	}
+	if _, err := os.Stat(want); err != nil {
+		t.Errorf("expected renamed file: %v", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Error("expected old folder removed")
+	}
+
+	// Re-running after an interruption finds the already renamed file.
+	f = &File{Root: root, Path: orig}
+	if err := s.Run(context.Background(), f); err != nil || f.Path != want {
+		t.Errorf("expected re-run to resolve to %q, got %q, %v", want, f.Path, err)
+	}
+}
+
+func TestSanitize_RunKeepsRoot(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "complete?")
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	orig := filepath.Join(root, "track|1.mp3")
+	if err := os.WriteFile(orig, []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	f := &File{Root: root, Path: orig}
+	if err := (&Sanitize{Rules: DefaultRules}).Run(context.Background(), f); err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(root, "track_1.mp3"); f.Path != want {
+		t.Errorf("expected %q, got %q", want, f.Path)
+	}
+}
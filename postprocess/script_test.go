@@ -0,0 +1,65 @@
+package postprocess
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeScript(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "pp.sh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseScripts(t *testing.T) {
+	scripts, err := ParseScripts("Lidarr:/scripts/music.sh, *:/scripts/any.sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scripts["lidarr"] != "/scripts/music.sh" || scripts["*"] != "/scripts/any.sh" {
+		t.Errorf("unexpected scripts %v", scripts)
+	}
+	for _, bad := range []string{"lidarr", ":/x.sh", "lidarr:"} {
+		if _, err := ParseScripts(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestScript_Run(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	script := writeScript(t, `echo "$1|$3|$5|$7|$SAB_NZO_ID|$SAB_CAT|$SLSKRR_FILE" > `+out+"\necho done\n")
+	path := filepath.Join(t.TempDir(), "Movie.mkv")
+
+	s := &Script{Scripts: map[string]string{"radarr": script}, Timeout: time.Minute}
+	f := &File{ID: "nzo_1", Category: "Radarr", Path: path}
+	if err := s.Run(context.Background(), f); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(out)
+	want := filepath.Dir(path) + "|Movie|Radarr|0|nzo_1|Radarr|" + path
+	if strings.TrimSpace(string(data)) != want {
+		t.Errorf("expected %q, got %q", want, data)
+	}
+
+	// Categories without a script, and no "*" fallback, are skipped.
+	if err := s.Run(context.Background(), &File{Category: "sonarr", Path: path}); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestScript_Failure(t *testing.T) {
+	script := writeScript(t, "echo working\necho 'Unpack failed' >&2\nexit 1\n")
+	s := &Script{Scripts: map[string]string{"*": script}}
+	err := s.Run(context.Background(), &File{Category: "radarr", Path: filepath.Join(t.TempDir(), "a.mkv")})
+	if err == nil || !strings.HasSuffix(err.Error(), ": Unpack failed") {
+		t.Errorf("expected the script's last line in the error, got %v", err)
+	}
+}
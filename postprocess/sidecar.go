@@ -0,0 +1,92 @@
+package postprocess
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Sidecar formats.
+const (
+	SidecarNFO  = "nfo"
+	SidecarJSON = "json"
+)
+
+// Sidecar writes a file recording where a download came from next to it:
+// "<name>.nfo" as plain text, or "<file>.json" for scripts.
+type Sidecar struct {
+	Format string // SidecarNFO or SidecarJSON
+}
+
+// sidecarInfo is the content of a JSON sidecar.
+type sidecarInfo struct {
+	ID        string    `json:"id"`
+	Username  string    `json:"username"`
+	Filename  string    `json:"filename"`
+	Size      int64     `json:"size"`
+	BitRate   int       `json:"bitRate,omitempty"`
+	Category  string    `json:"category,omitempty"`
+	Grabbed   time.Time `json:"grabbed"`
+	Completed time.Time `json:"completed"`
+}
+
+func (s *Sidecar) Name() string { return "sidecar" }
+
+func (s *Sidecar) Run(_ context.Context, f *File) error {
+	info := sidecarInfo{
+		ID:        f.ID,
+		Username:  f.Username,
+		Filename:  f.Filename,
+		Size:      f.Size,
+		BitRate:   f.BitRate,
+		Category:  f.Category,
+		Grabbed:   f.AddedAt.UTC(),
+		Completed: time.Now().UTC(),
+	}
+
+	var (
+		path string
+		data []byte
+	)
+	switch s.Format {
+	case SidecarJSON:
+		path = f.Path + ".json"
+		data, _ = json.MarshalIndent(info, "", "  ")
+		data = append(data, '\n')
+	default:
+		path = strings.TrimSuffix(f.Path, filepath.Ext(f.Path)) + ".nfo"
+		data = info.nfo()
+	}
+
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("write %s: %w", filepath.Base(path), err)
+	}
+	if !slices.Contains(f.Extras, path) {
+		f.Extras = append(f.Extras, path)
+	}
+	return nil
+}
+
+func (i *sidecarInfo) nfo() []byte {
+	var b strings.Builder
+	line := func(k, v string) { fmt.Fprintf(&b, "%-10s %s\n", k+":", v) }
+	line("Source", "Soulseek")
+	line("Username", i.Username)
+	line("Filename", i.Filename)
+	line("Size", fmt.Sprintf("%d bytes", i.Size))
+	if i.BitRate > 0 {
+		line("Bitrate", fmt.Sprintf("%d kbps", i.BitRate))
+	}
+	if i.Category != "" {
+		line("Category", i.Category)
+	}
+	line("Grabbed", i.Grabbed.Format(time.RFC3339))
+	line("Completed", i.Completed.Format(time.RFC3339))
+	line("ID", i.ID)
+	return []byte(b.String())
+}
@@ -0,0 +1,66 @@
+package postprocess
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSidecar_NFO(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "01 Song.flac")
+	f := &File{
+		ID:       "nzo_1",
+		Username: "peer",
+		Filename: `Music\Album\01 Song.flac`,
+		Size:     1234,
+		BitRate:  1411,
+		AddedAt:  time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		Path:     path,
+	}
+	s := &Sidecar{Format: SidecarNFO}
+	if err := s.Run(context.Background(), f); err != nil {
+		t.Fatal(err)
+	}
+	// Re-running rewrites the sidecar rather than adding another.
+	if err := s.Run(context.Background(), f); err != nil {
+		t.Fatal(err)
+	}
+
+	nfo := strings.TrimSuffix(path, ".flac") + ".nfo"
+	data, err := os.ReadFile(nfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Username:  peer", `Filename:  Music\Album\01 Song.flac`, "Bitrate:   1411 kbps", "Grabbed:   2024-05-01T12:00:00Z"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("expected %q in:\n%s", want, data)
+		}
+	}
+	if len(f.Extras) != 1 || f.Extras[0] != nfo {
+		t.Errorf("expected sidecar recorded as an extra, got %v", f.Extras)
+	}
+}
+
+func TestSidecar_JSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "movie.mkv")
+	f := &File{ID: "nzo_1", Username: "peer", Filename: `Movies\movie.mkv`, Size: 10, Category: "radarr", Path: path}
+	if err := (&Sidecar{Format: SidecarJSON}).Run(context.Background(), f); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path + ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var info sidecarInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Username != "peer" || info.Filename != `Movies\movie.mkv` || info.Category != "radarr" || info.Completed.IsZero() {
+		t.Errorf("unexpected sidecar %+v", info)
+	}
+}
@@ -0,0 +1,146 @@
+package postprocess
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Tags fills in missing artist, album, title and track number tags on FLAC
+// and MP3 files, so Lidarr can identify untagged Soulseek files. Artist and
+// album come from the album the file was grabbed for, or else from a folder
+// named "Artist - Album"; title and track from a file named "01 - Title".
+// Tags already present are never changed, and files that can't be tagged
+// are passed on as they are.
+type Tags struct{}
+
+func (t *Tags) Name() string { return "tags" }
+
+func (t *Tags) Run(ctx context.Context, f *File) error {
+	var write func(path string, tags []tag) (bool, error)
+	switch strings.ToLower(filepath.Ext(f.Path)) {
+	case ".flac":
+		write = writeFLACTags
+	case ".mp3":
+		write = writeID3Tags
+	default:
+		return nil
+	}
+
+	tags := guessTags(f)
+	if len(tags) == 0 {
+		return nil
+	}
+	changed, err := write(f.Path, tags)
+	if err != nil {
+		// Tagging is best effort; an odd file shouldn't fail the download.
+		slog.WarnContext(ctx, "failed to tag file", "id", f.ID, "path", f.Path, "error", err)
+		return nil
+	}
+	if changed {
+		slog.DebugContext(ctx, "filled in missing tags", "id", f.ID, "path", f.Path)
+	}
+	return nil
+}
+
+// tag is one value to fill in, with its field name in each format.
+type tag struct {
+	vorbis string // Vorbis comment field, e.g. ARTIST
+	id3    string // ID3v2 frame ID, e.g. TPE1
+	value  string
+}
+
+var (
+	// trackPrefix matches "01 - Title", "01. Title", "1-01 Title" and the like.
+	trackPrefix = regexp.MustCompile(`^(?:\d{1,2}[-.])?(\d{1,3})[\s.\-_)]+(.+)$`)
+	// folderSuffix matches trailing "(2001)" or "[FLAC]" groups.
+	folderSuffix = regexp.MustCompile(`(\s*[(\[][^)\]]*[)\]])+$`)
+)
+
+// guessTags works out the tags for f from its album and local path.
+func guessTags(f *File) []tag {
+	artist, album := f.Artist, f.Album
+	if dir := filepath.Dir(f.Path); album == "" && filepath.Clean(dir) != filepath.Clean(f.Root) {
+		folder := strings.TrimSpace(folderSuffix.ReplaceAllString(filepath.Base(dir), ""))
+		if a, b, ok := strings.Cut(folder, " - "); ok {
+			if artist == "" {
+				artist = strings.TrimSpace(a)
+			}
+			album = strings.TrimSpace(b)
+		} else {
+			album = folder
+		}
+	}
+
+	title := strings.TrimSuffix(filepath.Base(f.Path), filepath.Ext(f.Path))
+	var track string
+	if m := trackPrefix.FindStringSubmatch(title); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		track, title = strconv.Itoa(n), strings.TrimSpace(m[2])
+	}
+	if artist != "" {
+		title = strings.TrimPrefix(title, artist+" - ")
+	}
+
+	var tags []tag
+	for _, t := range []tag{
+		{"ARTIST", "TPE1", artist},
+		{"ALBUM", "TALB", album},
+		{"TITLE", "TIT2", title},
+		{"TRACKNUMBER", "TRCK", track},
+	} {
+		if t.value != "" {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+// replaceFile writes a new version of path through a temporary file in the
+// same folder, keeping path's mode.
+func replaceFile(path string, write func(*os.File) error) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".slskrr-*")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// writeAt overwrites the start of path with chunks, in order.
+func writeAt(path string, chunks ...[]byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	var off int64
+	for _, c := range chunks {
+		if _, err := f.WriteAt(c, off); err != nil {
+			f.Close()
+			return err
+		}
+		off += int64(len(c))
+	}
+	return f.Close()
+}
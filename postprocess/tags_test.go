@@ -0,0 +1,164 @@
+package postprocess
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestGuessTags(t *testing.T) {
+	tests := []struct {
+		file                        File
+		artist, album, title, track string
+	}{
+		{File{Root: "/dl", Path: "/dl/Artist - Album (2001) [FLAC]/01 - Song.flac"}, "Artist", "Album", "Song", "1"},
+		{File{Root: "/dl", Path: "/dl/Album/1-07. Artist - Song.mp3", Artist: "Artist"}, "Artist", "Album", "Song", "7"},
+		{File{Root: "/dl", Path: "/dl/x/Song.flac", Artist: "Lidarr Artist", Album: "Lidarr Album"}, "Lidarr Artist", "Lidarr Album", "Song", ""},
+		{File{Root: "/dl", Path: "/dl/10cc - Song.mp3"}, "", "", "10cc - Song", ""},
+	}
+	for _, tt := range tests {
+		got := map[string]string{}
+		for _, tag := range guessTags(&tt.file) {
+			got[tag.vorbis] = tag.value
+		}
+		want := map[string]string{"ARTIST": tt.artist, "ALBUM": tt.album, "TITLE": tt.title, "TRACKNUMBER": tt.track}
+		for k, v := range want {
+			if got[k] != v {
+				t.Errorf("%s: %s = %q, want %q", tt.file.Path, k, got[k], v)
+			}
+		}
+	}
+}
+
+// testFLAC returns a FLAC stream with a STREAMINFO block, the given extra
+// metadata blocks and some audio bytes.
+func testFLAC(extra ...flacBlock) []byte {
+	blocks := append([]flacBlock{{typ: 0, data: make([]byte, 34)}}, extra...)
+	return append(encodeFLACMetadata(blocks), "AUDIO"...)
+}
+
+func TestTags_FLAC(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "Artist - Album")
+	os.Mkdir(dir, 0o755)
+	path := filepath.Join(dir, "02 - Song.flac")
+	vc := flacBlock{typ: flacVorbisComment, data: encodeVorbisComment("ref", []string{"ARTIST=Someone Else", "TITLE="})}
+	if err := os.WriteFile(path, testFLAC(vc), 0o640); err != nil {
+		t.Fatal(err)
+	}
+
+	step := &Tags{}
+	if err := step.Run(context.Background(), &File{Root: filepath.Dir(dir), Path: path}); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	blocks, start, err := readFLACMetadata(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data[start:]) != "AUDIO" {
+		t.Errorf("audio frames changed: %q", data[start:])
+	}
+	if blocks[1].typ != flacVorbisComment {
+		t.Fatalf("expected vorbis comment after STREAMINFO, got type %d", blocks[1].typ)
+	}
+	vendor, comments, _ := parseVorbisComment(blocks[1].data)
+	want := []string{"ARTIST=Someone Else", "ALBUM=Album", "TITLE=Song", "TRACKNUMBER=2"}
+	if vendor != "ref" || !slices.Equal(comments, want) {
+		t.Errorf("unexpected comments %q %q", vendor, comments)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0o640 {
+		t.Errorf("expected mode kept, got %o", info.Mode().Perm())
+	}
+
+	// A second run, now with padding to spare, changes nothing.
+	before := len(data)
+	if err := step.Run(context.Background(), &File{Root: filepath.Dir(dir), Path: path}); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ = os.ReadFile(path); len(data) != before {
+		t.Errorf("expected no rewrite, size %d -> %d", before, len(data))
+	}
+}
+
+func TestWriteFLACTags_InPlace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.flac")
+	orig := testFLAC(flacBlock{typ: flacPadding, data: make([]byte, 512)})
+	if err := os.WriteFile(path, orig, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writeFLACTags(path, []tag{{vorbis: "ALBUM", value: "Album"}}); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if len(data) != len(orig) {
+		t.Errorf("expected tags written into the padding, size %d -> %d", len(orig), len(data))
+	}
+	if _, _, err := readFLACMetadata(bytes.NewReader(data)); err != nil {
+		t.Errorf("invalid metadata after in-place write: %v", err)
+	}
+}
+
+func TestTags_MP3(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "Artist - Album")
+	os.Mkdir(dir, 0o755)
+	path := filepath.Join(dir, "03 Song.mp3")
+	if err := os.WriteFile(path, []byte("\xff\xfbAUDIO"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	f := &File{Root: filepath.Dir(dir), Path: path}
+	if err := (&Tags{}).Run(context.Background(), f); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if string(data[:4]) != "ID3\x03" {
+		t.Fatalf("expected a v2.3 tag, got %q", data[:4])
+	}
+	size := syncsafe(data[6:10])
+	if string(data[10+size:]) != "\xff\xfbAUDIO" {
+		t.Errorf("audio frames changed: %q", data[10+size:])
+	}
+	frames, err := parseID3Frames(data[10:10+size], 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, fr := range frames {
+		ids = append(ids, fr.id)
+	}
+	if !slices.Equal(ids, []string{"TPE1", "TALB", "TIT2", "TRCK"}) {
+		t.Errorf("unexpected frames %v", ids)
+	}
+	if !bytes.Equal(frames[3].data, []byte{1, 0xff, 0xfe, '3', 0}) {
+		t.Errorf("unexpected TRCK frame %q", frames[3].data)
+	}
+}
+
+func TestWriteID3Tags_KeepsExisting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.mp3")
+	frames := encodeID3Frames([]id3Frame{
+		{id: "TPE1", data: encodeID3Text("Tagged", 4)},
+		{id: "TALB", data: []byte{3}},
+	}, 4)
+	body := append(frames, make([]byte, 256)...)
+	orig := append(append(encodeID3Header(4, len(body)), body...), "AUDIO"...)
+	if err := os.WriteFile(path, orig, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	changed, err := writeID3Tags(path, []tag{{id3: "TPE1", value: "Guess"}, {id3: "TALB", value: "Album"}})
+	if err != nil || !changed {
+		t.Fatalf("expected a change, got %v %v", changed, err)
+	}
+	data, _ := os.ReadFile(path)
+	if len(data) != len(orig) {
+		t.Errorf("expected tags written into the padding, size %d -> %d", len(orig), len(data))
+	}
+	got, _ := parseID3Frames(data[10:10+syncsafe(data[6:10])], 4)
+	if len(got) != 2 || string(got[0].data[1:]) != "Tagged" || got[1].id != "TALB" || string(got[1].data[1:]) != "Album" {
+		t.Errorf("unexpected frames %+v", got)
+	}
+}
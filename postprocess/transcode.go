@@ -0,0 +1,109 @@
+package postprocess
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// codecs maps each transcode target to its ffmpeg audio encoder and default
+// bitrate. An empty bitrate means the encoder is lossless.
+var codecs = map[string]struct{ encoder, bitrate string }{
+	"opus": {"libopus", "128k"},
+	"mp3":  {"libmp3lame", "320k"},
+	"m4a":  {"aac", "256k"},
+	"ogg":  {"libvorbis", "192k"},
+	"flac": {"flac", ""},
+}
+
+// ValidTarget reports whether format is a supported transcode target.
+func ValidTarget(format string) bool {
+	_, ok := codecs[format]
+	return ok
+}
+
+// Transcode converts audio files in one of the From formats to Target with
+// an external ffmpeg, replacing the original unless KeepOriginal is set. At
+// most the configured number of ffmpeg processes run at once.
+type Transcode struct {
+	FFmpeg       string   // ffmpeg binary, looked up in PATH
+	From         []string // source extensions without the dot, e.g. flac
+	Target       string   // target extension, a key of codecs
+	Bitrate      string   // e.g. 128k; empty uses the target's default
+	KeepOriginal bool
+
+	sem chan struct{}
+}
+
+// NewTranscode returns a transcode step running at most concurrency ffmpeg
+// processes at once.
+func NewTranscode(ffmpeg, target, bitrate string, from []string, concurrency int) *Transcode {
+	return &Transcode{
+		FFmpeg:  ffmpeg,
+		From:    from,
+		Target:  target,
+		Bitrate: bitrate,
+		sem:     make(chan struct{}, max(concurrency, 1)),
+	}
+}
+
+func (t *Transcode) Name() string { return "transcode" }
+
+func (t *Transcode) Run(ctx context.Context, f *File) error {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(f.Path), "."))
+	if ext == t.Target || !slices.Contains(t.From, ext) {
+		return nil
+	}
+	target := strings.TrimSuffix(f.Path, filepath.Ext(f.Path)) + "." + t.Target
+	if _, err := os.Stat(f.Path); errors.Is(err, os.ErrNotExist) {
+		if _, err := os.Stat(target); err == nil {
+			f.Path = target // transcoded by an earlier, interrupted run
+			return nil
+		}
+	}
+
+	select {
+	case t.sem <- struct{}{}:
+		defer func() { <-t.sem }()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	codec := codecs[t.Target]
+	tmp := filepath.Join(filepath.Dir(target), ".partial-"+filepath.Base(target))
+	args := []string{"-nostdin", "-hide_banner", "-loglevel", "error", "-y",
+		"-i", f.Path, "-map", "0:a", "-map_metadata", "0", "-c:a", codec.encoder}
+	if bitrate := cmp.Or(t.Bitrate, codec.bitrate); bitrate != "" {
+		args = append(args, "-b:a", bitrate)
+	}
+	args = append(args, tmp)
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, t.FFmpeg, args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		os.Remove(tmp)
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("ffmpeg: %w: %s", err, msg)
+		}
+		return fmt.Errorf("ffmpeg: %w", err)
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("rename: %w", err)
+	}
+	if !t.KeepOriginal {
+		if err := os.Remove(f.Path); err != nil {
+			return fmt.Errorf("remove original: %w", err)
+		}
+	}
+	f.Path = target
+	return nil
+}
@@ -0,0 +1,100 @@
+package postprocess
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeFFmpeg writes a script that records its arguments and copies the
+// input to the output, or fails when the input contains "corrupt".
+func fakeFFmpeg(t *testing.T) (bin, argsFile string) {
+	t.Helper()
+	dir := t.TempDir()
+	bin = filepath.Join(dir, "ffmpeg")
+	argsFile = filepath.Join(dir, "args")
+	script := `#!/bin/sh
+echo "$@" > ` + argsFile + `
+while [ $# -gt 1 ]; do
+	[ "$1" = "-i" ] && in="$2"
+	shift
+done
+if grep -q corrupt "$in"; then echo "invalid data" >&2; exit 1; fi
+cp "$in" "$1"
+`
+	if err := os.WriteFile(bin, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return bin, argsFile
+}
+
+func TestTranscode_Run(t *testing.T) {
+	bin, argsFile := fakeFFmpeg(t)
+	path := filepath.Join(t.TempDir(), "01 Song.flac")
+	os.WriteFile(path, []byte("audio"), 0o644)
+
+	step := NewTranscode(bin, "opus", "", []string{"flac"}, 1)
+	f := &File{Path: path}
+	if err := step.Run(context.Background(), f); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.TrimSuffix(path, ".flac") + ".opus"
+	if f.Path != want {
+		t.Errorf("expected path %q, got %q", want, f.Path)
+	}
+	if data, _ := os.ReadFile(want); string(data) != "audio" {
+		t.Errorf("expected transcoded file, got %q", data)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("expected original removed")
+	}
+	args, _ := os.ReadFile(argsFile)
+	if !strings.Contains(string(args), "-c:a libopus -b:a 128k") {
+		t.Errorf("unexpected ffmpeg args: %s", args)
+	}
+
+	// Re-running after an interruption finds the transcoded file.
+	f = &File{Path: path}
+	if err := step.Run(context.Background(), f); err != nil || f.Path != want {
+		t.Errorf("expected re-run to resolve to %q, got %q, %v", want, f.Path, err)
+	}
+}
+
+func TestTranscode_SkipsOtherFormats(t *testing.T) {
+	step := NewTranscode("/nonexistent/ffmpeg", "opus", "", []string{"flac"}, 1)
+	for _, name := range []string{"song.mp3", "song.opus", "movie.mkv"} {
+		f := &File{Path: filepath.Join(t.TempDir(), name)}
+		if err := step.Run(context.Background(), f); err != nil {
+			t.Errorf("%s: unexpected error %v", name, err)
+		}
+	}
+}
+
+func TestTranscode_KeepOriginalAndFailure(t *testing.T) {
+	bin, _ := fakeFFmpeg(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.flac")
+	os.WriteFile(path, []byte("audio"), 0o644)
+
+	step := NewTranscode(bin, "mp3", "256k", []string{"flac"}, 2)
+	step.KeepOriginal = true
+	f := &File{Path: path}
+	if err := step.Run(context.Background(), f); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Error("expected original kept")
+	}
+
+	bad := filepath.Join(dir, "b.flac")
+	os.WriteFile(bad, []byte("corrupt"), 0o644)
+	err := step.Run(context.Background(), &File{Path: bad})
+	if err == nil || !strings.Contains(err.Error(), "invalid data") {
+		t.Errorf("expected ffmpeg's error, got %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 3 {
+		t.Errorf("expected no partial output left behind, got %d files", len(entries))
+	}
+}
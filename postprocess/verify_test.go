@@ -0,0 +1,318 @@
+package postprocess
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// bitWriter builds a FLAC stream for the tests, MSB first.
+type bitWriter struct {
+	buf []byte
+	n   int // bits used in the last byte
+}
+
+func (w *bitWriter) write(v uint64, n int) {
+	for i := n - 1; i >= 0; i-- {
+		if w.n == 0 {
+			w.buf = append(w.buf, 0)
+		}
+		w.buf[len(w.buf)-1] |= byte(v>>i&1) << (7 - w.n)
+		w.n = (w.n + 1) % 8
+	}
+}
+
+func (w *bitWriter) signed(v int64, n int) { w.write(uint64(v)&(1<<n-1), n) }
+
+func (w *bitWriter) rice(residuals []int64, k int) {
+	w.write(0, 2) // 4-bit parameters
+	w.write(0, 4) // one partition
+	w.write(uint64(k), 4)
+	for _, r := range residuals {
+		u := uint64(r<<1 ^ r>>63)
+		for range u >> k {
+			w.write(0, 1)
+		}
+		w.write(1, 1)
+		w.write(u, k)
+	}
+}
+
+func (w *bitWriter) align() { w.n = 0 }
+
+// testCRC computes a CRC bit by bit, independently of the decoder's tables.
+func testCRC(data []byte, poly uint16, width int) uint16 {
+	var crc uint16
+	top := uint16(1) << (width - 1)
+	for _, b := range data {
+		crc ^= uint16(b) << (width - 8)
+		for range 8 {
+			if crc&top != 0 {
+				crc = crc<<1 ^ poly
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	if width == 8 {
+		return crc & 0xff
+	}
+	return crc
+}
+
+// subframeKind is how encodeTestFLAC encodes a channel of a frame.
+type subframeKind int
+
+const (
+	verbatim subframeKind = iota
+	fixed2
+	lpc2
+	constant
+	wastedBit
+)
+
+// encodeTestFLAC encodes left and right 16-bit channels in frames of 64 samples,
+// each frame in the next of the channel assignments and subframe kinds.
+func encodeTestFLAC(left, right []int64, assignments []int, kinds []subframeKind) []byte {
+	const block, bps = 64, 16
+	var out bytes.Buffer
+	out.WriteString("fLaC")
+	out.Write([]byte{0x80, 0, 0, 34})
+	info := make([]byte, 34)
+	binary.BigEndian.PutUint16(info[0:], block)
+	binary.BigEndian.PutUint16(info[2:], block)
+	binary.BigEndian.PutUint64(info[10:], 44100<<44|1<<41|(bps-1)<<36|uint64(len(left)))
+	sum := md5.New()
+	for i := range left {
+		sum.Write([]byte{byte(left[i]), byte(left[i] >> 8), byte(right[i]), byte(right[i] >> 8)})
+	}
+	copy(info[18:], sum.Sum(nil))
+	out.Write(info)
+
+	for f := 0; f*block < len(left); f++ {
+		l, r := left[f*block:(f+1)*block], right[f*block:(f+1)*block]
+		assignment, kind := assignments[f%len(assignments)], kinds[f%len(kinds)]
+		ch := [2][]int64{l, r}
+		depth := [2]int{bps, bps}
+		switch assignment {
+		case 8:
+			ch[1], depth[1] = make([]int64, block), bps+1
+			for i := range l {
+				ch[1][i] = l[i] - r[i]
+			}
+		case 9:
+			ch[0], depth[0] = make([]int64, block), bps+1
+			for i := range l {
+				ch[0][i] = l[i] - r[i]
+			}
+		case 10:
+			ch[0], ch[1], depth[1] = make([]int64, block), make([]int64, block), bps+1
+			for i := range l {
+				ch[0][i], ch[1][i] = (l[i]+r[i])>>1, l[i]-r[i]
+			}
+		}
+
+		w := &bitWriter{}
+		w.write(0x3ffe, 14)
+		w.write(0, 2)
+		w.write(7, 4) // 16-bit block size after the frame number
+		w.write(0, 4)
+		w.write(uint64(assignment), 4)
+		w.write(0, 4)
+		w.write(uint64(f), 8)
+		w.write(block-1, 16)
+		w.write(uint64(testCRC(w.buf, 0x07, 8)), 8)
+		for c := range 2 {
+			encodeSubframe(w, ch[c], depth[c], kind)
+		}
+		w.align()
+		w.write(uint64(testCRC(w.buf, 0x8005, 16)), 16)
+		out.Write(w.buf)
+	}
+	return out.Bytes()
+}
+
+func encodeSubframe(w *bitWriter, s []int64, bps int, kind subframeKind) {
+	switch kind {
+	case verbatim:
+		w.write(1<<1, 8)
+		for _, v := range s {
+			w.signed(v, bps)
+		}
+	case constant:
+		w.write(0, 8)
+		w.signed(s[0], bps)
+	case wastedBit:
+		w.write(1<<1|1, 8)
+		w.write(1, 1) // one wasted bit
+		for _, v := range s {
+			w.signed(v>>1, bps-1)
+		}
+	case fixed2, lpc2:
+		if kind == fixed2 {
+			w.write((8+2)<<1, 8)
+		} else {
+			w.write((32+1)<<1, 8)
+		}
+		w.signed(s[0], bps)
+		w.signed(s[1], bps)
+		if kind == lpc2 {
+			// 4 and -2 in 4 bits, shifted right by 1: 2*x[n-1] - x[n-2].
+			w.write(4-1, 4)
+			w.signed(1, 5)
+			w.signed(4, 4)
+			w.signed(-2, 4)
+		}
+		res := make([]int64, 0, len(s)-2)
+		for i := 2; i < len(s); i++ {
+			res = append(res, s[i]-(2*s[i-1]-s[i-2]))
+		}
+		w.rice(res, 6)
+	}
+}
+
+func testSamples(n int, f func(i int) int64) []int64 {
+	s := make([]int64, n)
+	for i := range s {
+		s[i] = f(i)
+	}
+	return s
+}
+
+func TestVerifyFLAC(t *testing.T) {
+	left := testSamples(64*6, func(i int) int64 { return int64((i*37)%2000 - 1000) })
+	right := testSamples(64*6, func(i int) int64 { return int64((i*53)%1500 - 800) })
+	assignments := []int{1, 8, 9, 10}
+	kinds := []subframeKind{verbatim, fixed2, lpc2}
+	stream := encodeTestFLAC(left, right, assignments, kinds)
+	if err := verifyFLAC(bytes.NewReader(stream)); err != nil {
+		t.Fatalf("expected a good stream to verify, got %v", err)
+	}
+
+	// Silence and even samples take the constant and wasted-bits paths.
+	even := testSamples(64*2, func(i int) int64 { return int64(i%50-25) * 2 })
+	silence := make([]int64, 64*2)
+	if err := verifyFLAC(bytes.NewReader(encodeTestFLAC(silence, even, []int{1}, []subframeKind{constant, wastedBit}))); err == nil {
+		t.Error("expected a constant subframe of a varying channel to fail the MD5")
+	}
+	if err := verifyFLAC(bytes.NewReader(encodeTestFLAC(silence, silence, []int{1, 10}, []subframeKind{constant}))); err != nil {
+		t.Errorf("expected silence to verify, got %v", err)
+	}
+	if err := verifyFLAC(bytes.NewReader(encodeTestFLAC(even, even, []int{1, 8}, []subframeKind{wastedBit}))); err != nil {
+		t.Errorf("expected wasted bits to verify, got %v", err)
+	}
+
+	for name, damage := range map[string]func([]byte) []byte{
+		"truncated": func(b []byte) []byte { return b[:len(b)-10] },
+		"bit flip": func(b []byte) []byte {
+			b[len(b)/2] ^= 0x10
+			return b
+		},
+		"wrong MD5": func(b []byte) []byte {
+			b[8+18] ^= 0xff
+			return b
+		},
+		"frames missing": func(b []byte) []byte {
+			// Claim one more frame than there is.
+			binary.BigEndian.PutUint64(b[8+10:], binary.BigEndian.Uint64(b[8+10:])+64)
+			return b
+		},
+		"not FLAC": func([]byte) []byte { return []byte("ID3 not flac at all") },
+	} {
+		err := verifyFLAC(bytes.NewReader(damage(bytes.Clone(stream))))
+		if !errors.Is(err, ErrCorrupt) {
+			t.Errorf("%s: expected ErrCorrupt, got %v", name, err)
+		}
+	}
+
+	// Encoders that don't compute the MD5 leave it zero.
+	noMD5 := bytes.Clone(stream)
+	clear(noMD5[8+18 : 8+34])
+	if err := verifyFLAC(bytes.NewReader(noMD5)); err != nil {
+		t.Errorf("expected a stream without an MD5 to verify, got %v", err)
+	}
+	// Nor does a tag appended after the audio fail it.
+	if err := verifyFLAC(bytes.NewReader(append(bytes.Clone(stream), "TAG"+string(make([]byte, 125))...))); err != nil {
+		t.Errorf("expected trailing data ignored, got %v", err)
+	}
+}
+
+// testMP3 returns an MP3 of n 128 kbps MPEG-1 Layer III frames, the first
+// a Xing header counting xing frames, between an ID3v2 and an ID3v1 tag.
+func testMP3(n, xing int) (audio []byte, tagged []byte) {
+	frame := func() []byte {
+		f := make([]byte, 417) // 144 * 128000 / 44100
+		copy(f, []byte{0xff, 0xfb, 0x90, 0x00})
+		return f
+	}
+	first := frame()
+	copy(first[4+32:], "Xing")
+	binary.BigEndian.PutUint32(first[4+32+4:], 1)
+	binary.BigEndian.PutUint32(first[4+32+8:], uint32(xing))
+	audio = first
+	for range n {
+		audio = append(audio, frame()...)
+	}
+	id3 := append([]byte("ID3\x03\x00\x00\x00\x00\x00\x14"), make([]byte, 20)...)
+	tagged = append(append(id3, audio...), append([]byte("TAG"), make([]byte, 125)...)...)
+	return audio, tagged
+}
+
+func TestVerifyMP3(t *testing.T) {
+	dir := t.TempDir()
+	check := func(name string, data []byte) error {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		return verifyMP3(file)
+	}
+
+	_, good := testMP3(10, 10)
+	if err := check("good.mp3", good); err != nil {
+		t.Errorf("expected a whole file to verify, got %v", err)
+	}
+	audio, _ := testMP3(10, 10)
+	if err := check("untagged.mp3", append([]byte("junk"), audio...)); err != nil {
+		t.Errorf("expected leading junk skipped, got %v", err)
+	}
+
+	audio, _ = testMP3(10, 0)
+	if err := check("cut.mp3", audio[:len(audio)-100]); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("expected a cut last frame to fail, got %v", err)
+	}
+	_, short := testMP3(10, 20)
+	if err := check("short.mp3", short); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("expected fewer frames than the Xing header counts to fail, got %v", err)
+	}
+	if err := check("text.mp3", bytes.Repeat([]byte("not audio "), 100)); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("expected a file without frames to fail, got %v", err)
+	}
+}
+
+func TestVerify_Run(t *testing.T) {
+	dir := t.TempDir()
+	left := testSamples(64*2, func(i int) int64 { return int64(i) })
+	stream := encodeTestFLAC(left, left, []int{1}, []subframeKind{verbatim})
+	good, bad, other := filepath.Join(dir, "good.flac"), filepath.Join(dir, "bad.flac"), filepath.Join(dir, "movie.mkv")
+	os.WriteFile(good, stream, 0o644)
+	os.WriteFile(bad, stream[:len(stream)-1], 0o644)
+	os.WriteFile(other, []byte("not checked"), 0o644)
+
+	step := &Verify{}
+	for path, corrupt := range map[string]bool{good: false, bad: true, other: false} {
+		if err := step.Run(context.Background(), &File{Path: path}); errors.Is(err, ErrCorrupt) != corrupt {
+			t.Errorf("%s: expected corrupt %v, got %v", filepath.Base(path), corrupt, err)
+		}
+	}
+}
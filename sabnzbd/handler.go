@@ -1,35 +1,139 @@
 package sabnzbd
 
 import (
+	"cmp"
 	"context"
-	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"math"
 	"net/http"
 	"net/url"
+	"os"
 	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode"
 
+	"github.com/nerney/slskrr/auth"
+	"github.com/nerney/slskrr/blocklist"
+	"github.com/nerney/slskrr/middleware"
 	"github.com/nerney/slskrr/newznab"
+	"github.com/nerney/slskrr/notify"
+	"github.com/nerney/slskrr/postprocess"
+	"github.com/nerney/slskrr/searchlog"
 	"github.com/nerney/slskrr/slskd"
+	"github.com/nerney/slskrr/stats"
 	"github.com/nerney/slskrr/store"
+	"github.com/nerney/slskrr/textnorm"
 )
 
+// DefaultVersion is the SABnzbd version reported when none is configured.
+const DefaultVersion = "4.0.0"
+
+// DefaultCategories are the categories offered, besides "Default", when
+// none are configured: the names each *arr app suggests for itself.
+var DefaultCategories = []string{"radarr", "sonarr-tv", "tv-sonarr", "sonarr", "lidarr", "readarr"}
+
 // Handler serves the SABnzbd API facade.
 type Handler struct {
 	SlskdClient *slskd.Client
 	Store       *store.Store
-	APIKey      string
+	Keys        *auth.Keyring
 	DownloadDir string
+	Limiter     *middleware.RateLimiter
+	Notifier    *notify.Dispatcher
+	Stats       *stats.Tally
+	Searches    *searchlog.Log // grabs are attributed to the search that returned them
+
+	// SlskdBlacklist refuses grabs from the members of slskd's
+	// blacklisted group.
+	SlskdBlacklist bool
+
+	// DiscoverDownloadDir lets RefreshOptions replace DownloadDir with
+	// slskd's configured download directory.
+	DiscoverDownloadDir bool
+
+	// PostProcess runs over each completed file before it is reported as
+	// completed. It needs DownloadDir to be where slskd saves files.
+	PostProcess *postprocess.Pipeline
+
+	// Companions are patterns of files, like cue sheets and cover art,
+	// queued from the same remote directory as a grabbed audio file. They
+	// download beside it but aren't tracked as jobs. Nil disables them.
+	Companions []string
+
+	// Version is the SABnzbd version reported to clients; empty means
+	// DefaultVersion. Categories are offered by get_cats and get_config
+	// besides "Default"; nil means DefaultCategories.
+	Version    string
+	Categories []string
+
+	// BandwidthMax is the bandwidth, in bytes/s, that a speedlimit given as
+	// a percentage is a share of, like SABnzbd's bandwidth_max. Zero means
+	// only absolute limits are accepted.
+	BandwidthMax int64
+
+	// AlternateSearch is how long the search for another peer sharing a
+	// failed file, by name and size, may run before its retry. Zero
+	// retries from the same peer. Peers in Blocklist aren't picked.
+	AlternateSearch time.Duration
+	Blocklist       *blocklist.Blocklist
+
+	// MaxAge is how long a download attempt may stay queued or
+	// downloading before it is failed, and retried while retries remain.
+	// Zero disables the limit.
+	MaxAge time.Duration
+
+	// SyncInterval is how often SyncDownloads polls slskd's transfers;
+	// zero means 5s. Grabs, retries and resumes wake the loop, to pick
+	// their transfers up without waiting for the next tick.
+	SyncInterval time.Duration
+
+	// TransferHub, when set, is slskd's transfer hub. Its events wake
+	// SyncDownloads, which polls only every hubPollInterval while it is
+	// connected, and every SyncInterval again when it drops.
+	TransferHub *slskd.Hub
+
+	speedLimit  atomic.Int64           // bytes/s set through mode=config; 0 is unlimited
+	lastSync    atomic.Int64           // unix nanos of the last completed sync iteration
+	hubWake     atomic.Bool            // a sync is due for events from TransferHub
+	draining    atomic.Bool            // set on shutdown; new grabs are refused
+	paused      atomic.Bool            // queue paused; transfers are held back from slskd
+	downloadDir atomic.Pointer[string] // set by RefreshOptions; overrides DownloadDir
+	syncMu      sync.Mutex             // serializes sync iterations
+	wake        chan struct{}          // requests an immediate sync iteration
+	wakeOnce    sync.Once              // creates wake on first use
+	processing  sync.Map               // IDs with a post-processing run in flight
+	rerouting   sync.Map               // IDs being retried once an alternate peer is looked for
+	companions  sync.Map               // username+filename of companion files already queued
+}
+
+// Drain stops the handler from accepting new grabs. Status queries keep
+// working so clients see a consistent queue until the server stops.
+func (h *Handler) Drain() {
+	h.draining.Store(true)
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query()
 	mode := q.Get("mode")
 
+	if ok, wait := h.Limiter.Allow(middleware.ClientKey(r)); !ok {
+		slog.WarnContext(r.Context(), "sabnzbd rate limit exceeded", "remote", r.RemoteAddr, "mode", mode)
+		w.Header().Set("Retry-After", middleware.RetryAfter(wait))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusTooManyRequests)
+		writeJSON(w, map[string]any{"status": false, "error": "Rate limit exceeded"})
+		return
+	}
+
 	switch mode {
 	case "version":
 		h.handleVersion(w)
@@ -41,29 +145,40 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		h.handleGetCats(w, r)
 	case "addurl":
 		h.handleAddURL(w, r)
+	case "addfile":
+		h.handleAddFile(w, r)
 	case "queue":
 		h.handleQueue(w, r)
 	case "history":
 		h.handleHistory(w, r)
+	case "config":
+		h.handleConfig(w, r)
+	case "pause", "resume":
+		h.handlePause(w, r, mode == "pause")
+	case "switch":
+		h.handleSwitch(w, r)
+	case "retry":
+		h.handleRetry(w, r)
 	default:
 		writeJSON(w, map[string]any{"status": false, "error": "Unknown mode: " + mode})
 	}
 }
 
-func (h *Handler) checkAPIKey(r *http.Request) bool {
-	if h.APIKey == "" {
-		return true
+// checkAPIKey validates the apikey param and returns the matching key's label.
+func (h *Handler) checkAPIKey(r *http.Request) (string, bool) {
+	label, ok := h.Keys.Check(r.URL.Query().Get("apikey"))
+	if !ok {
+		auth.Reject(r, auth.EventAPIKey, "sabnzbd", "mode", r.URL.Query().Get("mode"))
 	}
-	key := r.URL.Query().Get("apikey")
-	return subtle.ConstantTimeCompare([]byte(key), []byte(h.APIKey)) == 1
+	return label, ok
 }
 
 func (h *Handler) handleVersion(w http.ResponseWriter) {
-	writeJSON(w, map[string]string{"version": "4.0.0"})
+	writeJSON(w, map[string]string{"version": cmp.Or(h.Version, DefaultVersion)})
 }
 
 func (h *Handler) handleAuth(w http.ResponseWriter, r *http.Request) {
-	if h.checkAPIKey(r) {
+	if _, ok := h.checkAPIKey(r); ok {
 		writeJSON(w, map[string]any{"auth": "apikey", "status": true})
 	} else {
 		writeJSON(w, map[string]any{"auth": "apikey", "status": false})
@@ -71,47 +186,143 @@ func (h *Handler) handleAuth(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) handleGetConfig(w http.ResponseWriter, r *http.Request) {
-	if !h.checkAPIKey(r) {
+	if _, ok := h.checkAPIKey(r); !ok {
 		writeJSON(w, map[string]any{"status": false, "error": "API Key Incorrect"})
 		return
 	}
 
+	var categories []map[string]string
+	for _, name := range h.categories() {
+		dir := name
+		if name == "Default" {
+			dir = ""
+		}
+		categories = append(categories, map[string]string{"name": name, "dir": dir})
+	}
 	writeJSON(w, map[string]any{
 		"config": map[string]any{
 			"misc": map[string]any{
-				"complete_dir":      h.DownloadDir,
+				"complete_dir":      h.completeDir(),
 				"history_retention": "all",
 			},
-			"categories": []map[string]string{
-				{"name": "Default", "dir": ""},
-				{"name": "radarr", "dir": "radarr"},
-				{"name": "sonarr-tv", "dir": "sonarr-tv"},
-				{"name": "tv-sonarr", "dir": "tv-sonarr"},
-				{"name": "sonarr", "dir": "sonarr"},
-			},
+			"categories": categories,
 		},
 	})
 }
 
+// categories returns the category names offered, "Default" first.
+func (h *Handler) categories() []string {
+	cats := h.Categories
+	if cats == nil {
+		cats = DefaultCategories
+	}
+	return append([]string{"Default"}, cats...)
+}
+
 func (h *Handler) handleGetCats(w http.ResponseWriter, r *http.Request) {
-	if !h.checkAPIKey(r) {
+	if _, ok := h.checkAPIKey(r); !ok {
 		writeJSON(w, map[string]any{"status": false, "error": "API Key Incorrect"})
 		return
 	}
 	writeJSON(w, map[string]any{
-		"categories": []string{"Default", "radarr", "sonarr-tv", "tv-sonarr", "sonarr"},
+		"categories": h.categories(),
 	})
 }
 
+// handleConfig changes settings at runtime. Only speedlimit is supported,
+// which sets slskd's global download speed limit.
+func (h *Handler) handleConfig(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.checkAPIKey(r); !ok {
+		writeJSON(w, map[string]any{"status": false, "error": "API Key Incorrect"})
+		return
+	}
+	q := r.URL.Query()
+	if name := q.Get("name"); name != "speedlimit" {
+		writeJSON(w, map[string]any{"status": false, "error": "Unsupported config: " + name})
+		return
+	}
+
+	limit, err := parseSpeedLimit(q.Get("value"), h.BandwidthMax)
+	if err != nil {
+		writeJSON(w, map[string]any{"status": false, "error": err.Error()})
+		return
+	}
+	kibps := slskd.UnlimitedSpeed
+	if limit > 0 {
+		kibps = int(max(limit/1024, 1))
+	}
+	if err := h.SlskdClient.SetDownloadSpeedLimit(r.Context(), kibps); err != nil {
+		slog.ErrorContext(r.Context(), "failed to set slskd speed limit", "error", err)
+		writeJSON(w, map[string]any{"status": false, "error": "Failed to set slskd speed limit"})
+		return
+	}
+	h.speedLimit.Store(limit)
+	slog.InfoContext(r.Context(), "download speed limit set", "bytesPerSecond", limit)
+	writeJSON(w, map[string]any{"status": true})
+}
+
+// parseSpeedLimit parses a SABnzbd speedlimit value into bytes/s, 0 meaning
+// unlimited: "500K" or "2M" are absolute, a bare number is a percentage of
+// bandwidthMax, and "", "0" and "100" lift the limit.
+func parseSpeedLimit(value string, bandwidthMax int64) (int64, error) {
+	value = strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(value)), "%")
+	if value == "" || value == "0" || value == "100" {
+		return 0, nil
+	}
+	if strings.ContainsAny(value[len(value)-1:], "KMG") {
+		return ParseRate(value)
+	}
+	pct, err := strconv.ParseFloat(value, 64)
+	if err != nil || pct < 0 || pct > 100 {
+		return 0, fmt.Errorf("invalid speedlimit %q", value)
+	}
+	if bandwidthMax <= 0 {
+		return 0, errors.New("a percentage speedlimit needs BANDWIDTH_MAX")
+	}
+	return int64(pct / 100 * float64(bandwidthMax)), nil
+}
+
+// ParseRate parses a rate in bytes/s with an optional K, M or G suffix,
+// e.g. "500K" or "1.5M".
+func ParseRate(value string) (int64, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	unit := 1.0
+	if value != "" {
+		switch value[len(value)-1] {
+		case 'K':
+			unit = 1 << 10
+		case 'M':
+			unit = 1 << 20
+		case 'G':
+			unit = 1 << 30
+		}
+	}
+	if unit > 1 {
+		value = value[:len(value)-1]
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid rate %q", value)
+	}
+	return int64(n * unit), nil
+}
+
 func (h *Handler) handleAddURL(w http.ResponseWriter, r *http.Request) {
-	if !h.checkAPIKey(r) {
+	client, ok := h.checkAPIKey(r)
+	if !ok {
 		writeJSON(w, map[string]any{"status": false, "error": "API Key Incorrect"})
 		return
 	}
 
+	if h.draining.Load() {
+		writeJSON(w, map[string]any{"status": false, "error": "Shutting down, not accepting new downloads"})
+		return
+	}
+
 	q := r.URL.Query()
 	nzbURL := q.Get("name")
 	category := q.Get("cat")
+	nzbName := strings.TrimSpace(strings.TrimSuffix(q.Get("nzbname"), ".nzb"))
 
 	if nzbURL == "" {
 		writeJSON(w, map[string]any{"status": false, "error": "Missing name parameter"})
@@ -121,110 +332,503 @@ func (h *Handler) handleAddURL(w http.ResponseWriter, r *http.Request) {
 	// Parse the URL to extract the token directly instead of HTTP loopback
 	token, err := extractTokenFromURL(nzbURL)
 	if err != nil {
-		slog.Error("failed to extract token from URL", "url", nzbURL, "error", err)
+		slog.ErrorContext(r.Context(), "failed to extract token from URL", "url", nzbURL, "error", err)
 		writeJSON(w, map[string]any{"status": false, "error": "Invalid NZB URL"})
 		return
 	}
 
 	fileToken, err := newznab.DecodeToken(token)
 	if err != nil {
-		slog.Error("failed to decode token", "error", err)
+		auth.Reject(r, auth.EventToken, "sabnzbd", "error", err)
 		writeJSON(w, map[string]any{"status": false, "error": "Invalid token"})
 		return
 	}
+	h.addToken(w, r, client, fileToken, category, nzbName)
+}
 
-	slog.Info("queueing download",
+// maxNZBSize bounds an NZB uploaded with mode=addfile; slskrr's are well
+// under a kilobyte.
+const maxNZBSize = 1 << 20
+
+// handleAddFile queues the download in an NZB uploaded as the multipart
+// field "name" (or "nzbfile"), as served by the Newznab API's t=get, the
+// same as addurl would its link.
+func (h *Handler) handleAddFile(w http.ResponseWriter, r *http.Request) {
+	client, ok := h.checkAPIKey(r)
+	if !ok {
+		writeJSON(w, map[string]any{"status": false, "error": "API Key Incorrect"})
+		return
+	}
+
+	if h.draining.Load() {
+		writeJSON(w, map[string]any{"status": false, "error": "Shutting down, not accepting new downloads"})
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxNZBSize)
+	if err := r.ParseMultipartForm(maxNZBSize); err != nil {
+		slog.ErrorContext(r.Context(), "failed to read NZB upload", "error", err)
+		writeJSON(w, map[string]any{"status": false, "error": "Invalid NZB upload"})
+		return
+	}
+	file, _, err := r.FormFile("name")
+	if errors.Is(err, http.ErrMissingFile) {
+		file, _, err = r.FormFile("nzbfile")
+	}
+	if err != nil {
+		writeJSON(w, map[string]any{"status": false, "error": "Missing NZB file"})
+		return
+	}
+	defer file.Close()
+
+	fileToken, err := newznab.ParseNZB(file)
+	if err != nil {
+		auth.Reject(r, auth.EventToken, "sabnzbd", "error", err)
+		writeJSON(w, map[string]any{"status": false, "error": "Invalid NZB"})
+		return
+	}
+	nzbName := strings.TrimSpace(strings.TrimSuffix(r.FormValue("nzbname"), ".nzb"))
+	h.addToken(w, r, client, fileToken, r.FormValue("cat"), nzbName)
+}
+
+// addToken grabs the files fileToken names for client in category, named
+// nzbName when set, and answers with the NZO they're queued as.
+func (h *Handler) addToken(w http.ResponseWriter, r *http.Request, client string, fileToken *newznab.FileToken, category, nzbName string) {
+	slog.InfoContext(r.Context(), "queueing download",
 		"username", fileToken.Username,
 		"filename", fileToken.Filename,
 		"size", fileToken.Size,
 		"category", category,
+		"client", client,
 	)
 
-	// Queue the download in slskd
-	err = h.SlskdClient.Download(r.Context(), fileToken.Username, []slskd.DownloadRequest{
-		{Filename: fileToken.Filename, Size: fileToken.Size},
-	})
+	var err error
+	files := fileToken.Downloads()
+	if fileToken.Folder {
+		if files, err = h.folderFiles(r.Context(), fileToken.Username, fileToken.Filename); err != nil {
+			slog.ErrorContext(r.Context(), "failed to list release folder", "username", fileToken.Username, "folder", fileToken.Filename, "error", err)
+			writeJSON(w, map[string]any{"status": false, "error": "Failed to list release folder"})
+			return
+		}
+	}
+	if len(files) > 1 && nzbName == "" {
+		// Keep the files together under the release's name.
+		nzbName, _ = postprocess.ReleaseFolder(files[0].Filename)
+	}
+
+	ids, err := h.Grab(r.Context(), fileToken.Username, files, category)
+	if errors.Is(err, slskd.ErrBlacklisted) {
+		slog.WarnContext(r.Context(), "refused grab from blacklisted user", "username", fileToken.Username)
+		writeJSON(w, map[string]any{"status": false, "error": "User is blacklisted in slskd"})
+		return
+	}
 	if err != nil {
-		slog.Error("slskd download failed", "error", err)
+		slog.ErrorContext(r.Context(), "slskd download failed", "error", err)
 		writeJSON(w, map[string]any{"status": false, "error": "Failed to queue download"})
 		return
 	}
-
-	// Track in our store
-	id := h.Store.Add(fileToken.Username, fileToken.Filename, fileToken.Size, category)
-
-	slog.Info("download queued", "id", id, "filename", fileToken.Filename)
+	for _, id := range ids {
+		if len(ids) > 1 {
+			// One NZO for the release, as the app tracks one download per grab.
+			h.Store.SetGroup(id, ids[0])
+		}
+		if fileToken.Artist != "" || fileToken.Album != "" {
+			h.Store.SetAlbum(id, fileToken.Artist, fileToken.Album)
+		}
+		if fileToken.BitRate > 0 {
+			h.Store.SetBitRate(id, fileToken.BitRate)
+		}
+		if nzbName != "" {
+			h.Store.SetName(id, nzbName)
+		}
+	}
 
 	writeJSON(w, map[string]any{
 		"status":  true,
-		"nzo_ids": []string{id},
+		"nzo_ids": ids[:1],
 	})
 }
 
+// folderFiles returns the audio files username shares in dir, for a grab
+// of a whole release, or the video files of a season pack when there is
+// no audio.
+func (h *Handler) folderFiles(ctx context.Context, username, dir string) ([]slskd.DownloadRequest, error) {
+	shared, err := h.SlskdClient.BrowseDirectory(ctx, username, dir)
+	if err != nil {
+		return nil, err
+	}
+	var audio, video []slskd.DownloadRequest
+	for _, f := range shared {
+		switch {
+		case newznab.IsAudio(f.Filename):
+			audio = append(audio, slskd.DownloadRequest{Filename: f.Filename, Size: f.Size})
+		case newznab.IsVideo(f.Filename):
+			video = append(video, slskd.DownloadRequest{Filename: f.Filename, Size: f.Size})
+		}
+	}
+	switch {
+	case len(audio) > 0:
+		return audio, nil
+	case len(video) > 0:
+		return video, nil
+	}
+	return nil, fmt.Errorf("no audio or video files in %s", dir)
+}
+
+// Grab queues files from a peer in slskd and tracks each as a download in
+// category, returning the new download IDs. While the queue is paused the
+// files are only tracked, and reach slskd on Resume.
+func (h *Handler) Grab(ctx context.Context, username string, files []slskd.DownloadRequest, category string) ([]string, error) {
+	if h.SlskdBlacklist {
+		users, err := h.SlskdClient.BlacklistedUsers(ctx)
+		if err != nil {
+			slog.WarnContext(ctx, "failed to read slskd's blacklisted users", "error", err)
+		} else if slices.Contains(users, username) {
+			return nil, fmt.Errorf("%w: %s", slskd.ErrBlacklisted, username)
+		}
+	}
+	paused := h.paused.Load()
+	if !paused {
+		if err := h.SlskdClient.Download(ctx, username, files); err != nil {
+			return nil, fmt.Errorf("queue download: %w", err)
+		}
+	}
+
+	if !paused && slices.ContainsFunc(files, func(f slskd.DownloadRequest) bool { return newznab.IsAudio(f.Filename) }) {
+		go h.queueCompanions(username, files)
+	}
+
+	ids := make([]string, len(files))
+	for i, f := range files {
+		ids[i] = h.Store.Add(username, f.Filename, f.Size, category)
+		if paused {
+			h.Store.Pause(ids[i])
+		}
+		slog.InfoContext(ctx, "download queued", "id", ids[i], "filename", f.Filename)
+		if err := h.Searches.Grabbed(username, f.Filename, ids[i]); err != nil {
+			slog.ErrorContext(ctx, "failed to persist search log", "error", err)
+		}
+		if err := h.Stats.Grab(category); err != nil {
+			slog.ErrorContext(ctx, "failed to persist stats", "error", err)
+		}
+		if dl := h.Store.Get(ids[i]); dl != nil {
+			h.Notifier.Send(notify.NewMessage(notify.EventGrab, dl, ""))
+		}
+	}
+	if !paused {
+		h.wakeSync()
+	}
+	return ids, nil
+}
+
+// queueCompanions queues the companion files next to files in slskd, once
+// per file however many tracks of an album are grabbed.
+func (h *Handler) queueCompanions(username string, files []slskd.DownloadRequest) {
+	if len(h.Companions) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	companions, err := h.SlskdClient.Companions(ctx, username, files, h.Companions)
+	if err != nil {
+		slog.Warn("failed to browse for companion files", "username", username, "error", err)
+	}
+	companions = slices.DeleteFunc(companions, func(f slskd.DownloadRequest) bool {
+		_, queued := h.companions.LoadOrStore(username+"\x00"+f.Filename, true)
+		return queued
+	})
+	if len(companions) == 0 {
+		return
+	}
+	if err := h.SlskdClient.Download(ctx, username, companions); err != nil {
+		slog.Warn("failed to queue companion files", "username", username, "error", err)
+		for _, f := range companions {
+			h.companions.Delete(username + "\x00" + f.Filename)
+		}
+		return
+	}
+	slog.Info("queued companion files", "username", username, "files", len(companions))
+}
+
 func (h *Handler) handleQueue(w http.ResponseWriter, r *http.Request) {
-	if !h.checkAPIKey(r) {
+	if _, ok := h.checkAPIKey(r); !ok {
 		writeJSON(w, map[string]any{"status": false, "error": "API Key Incorrect"})
 		return
 	}
 
 	q := r.URL.Query()
 
-	// Handle delete sub-command
-	if q.Get("name") == "delete" {
+	// Handle delete and purge sub-commands
+	switch q.Get("name") {
+	case "delete":
 		h.handleQueueDelete(w, r)
 		return
+	case "purge":
+		h.writePurge(w, r, queueFilter(q)(h.Store.Queue()))
+		return
 	}
 
 	queue := h.Store.Queue()
+	files := make(map[string][]*store.Download) // by NZO, finished ones included
+	for _, dl := range h.Store.All() {
+		files[dl.NZO()] = append(files[dl.NZO()], dl)
+	}
+	// The queue's totals count every release, whatever the filters show.
+	var size, left, speed int64
+	for _, rel := range releases(queue) {
+		sum := summarize(files[rel[0].NZO()])
+		size += sum.Size
+		left += sum.Size - sum.BytesDownloaded
+		speed += h.speed(rel)
+	}
+	total := len(releases(queue))
+	queue = queueFilter(q)(queue)
 	slots := make([]map[string]any, 0, len(queue))
 
-	for _, dl := range queue {
-		basename := path.Base(strings.ReplaceAll(dl.Filename, "\\", "/"))
-		mb := float64(dl.Size) / (1024 * 1024)
-		mbLeft := mb - (mb * dl.Progress() / 100)
-		pct := fmt.Sprintf("%.0f", dl.Progress())
-
-		timeleft := "00:00:00"
-		if dl.Status == store.StatusDownloading && dl.Progress() > 0 {
-			elapsed := time.Since(dl.AddedAt).Seconds()
-			rate := float64(dl.BytesDownloaded) / elapsed
-			if rate > 0 {
-				remaining := float64(dl.Size-dl.BytesDownloaded) / rate
-				h := int(remaining) / 3600
-				m := (int(remaining) % 3600) / 60
-				s := int(remaining) % 60
-				timeleft = fmt.Sprintf("%02d:%02d:%02d", h, m, s)
-			}
-		}
+	now := time.Now()
+	for _, rel := range releases(queue) {
+		dl := rel[0]
+		r := summarize(files[dl.NZO()])
+		r.Status = queueStatus(rel)
+		mb := float64(r.Size) / (1024 * 1024)
+		mbLeft := float64(r.Size-r.BytesDownloaded) / (1024 * 1024)
+		pct := fmt.Sprintf("%.0f", r.Progress())
+		remaining, eta := timeLeft(r.Size-r.BytesDownloaded, h.speed(rel), now)
 
 		slots = append(slots, map[string]any{
-			"nzo_id":     dl.ID,
-			"filename":   basename,
+			"nzo_id":     r.ID,
+			"filename":   displayName(r),
 			"mb":         fmt.Sprintf("%.2f", mb),
 			"mbleft":     fmt.Sprintf("%.2f", mbLeft),
 			"percentage": pct,
-			"status":     string(dl.Status),
-			"timeleft":   timeleft,
-			"cat":        dl.Category,
-			"eta":        "unknown",
+			"status":     string(r.Status),
+			"timeleft":   remaining,
+			"cat":        r.Category,
+			"eta":        eta,
 			"priority":   "Normal",
+			// Not SABnzbd's: the source, to identify and block bad uploaders.
+			"soulseek_username": r.Username,
+			"soulseek_path":     r.Filename,
 		})
 	}
 
+	speedlimit, speedlimitAbs := "100", ""
+	if limit := h.speedLimit.Load(); limit > 0 {
+		speedlimitAbs = strconv.FormatInt(limit, 10)
+		if h.BandwidthMax > 0 {
+			speedlimit = strconv.FormatInt(min(limit*100/h.BandwidthMax, 100), 10)
+		}
+	}
+
+	status := "Downloading"
+	if h.paused.Load() {
+		status = "Paused"
+	}
+	remaining, eta := timeLeft(left, speed, now)
+
 	writeJSON(w, map[string]any{
 		"queue": map[string]any{
-			"paused":            false,
-			"slots":             slots,
-			"speed":             "0",
-			"size":              "0",
-			"noofslots_total":   len(slots),
-			"status":            "Downloading",
-			"diskspacetotal1":   "100.0",
-			"diskspace1":        "50.0",
+			"paused":          h.paused.Load(),
+			"speedlimit":      speedlimit,
+			"speedlimit_abs":  speedlimitAbs,
+			"slots":           slots,
+			"kbpersec":        fmt.Sprintf("%.2f", float64(speed)/1024),
+			"speed":           toUnits(float64(speed)),
+			"mb":              fmt.Sprintf("%.2f", float64(size)/(1024*1024)),
+			"mbleft":          fmt.Sprintf("%.2f", float64(left)/(1024*1024)),
+			"size":            toUnits(float64(size)) + "B",
+			"sizeleft":        toUnits(float64(left)) + "B",
+			"timeleft":        remaining,
+			"eta":             eta,
+			"noofslots":       len(slots),
+			"noofslots_total": total,
+			"status":          status,
+			"diskspacetotal1": "100.0",
+			"diskspace1":      "50.0",
 		},
 	})
 }
 
+// speed returns how fast a release's files in the queue are transferring
+// together, in bytes per second, by their recent progress.
+func (h *Handler) speed(queued []*store.Download) int64 {
+	var speed int64
+	for _, dl := range queued {
+		if dl.Status == store.StatusDownloading {
+			speed += h.Store.Speed(dl.ID)
+		}
+	}
+	return speed
+}
+
+// timeLeft returns how long bytes take to transfer at speed, as SABnzbd's
+// H:MM:SS timeleft, and when that is from now as its eta, or "unknown"
+// with nothing transferring.
+func timeLeft(bytes, speed int64, now time.Time) (timeleft, eta string) {
+	if speed <= 0 || bytes <= 0 {
+		return "0:00:00", "unknown"
+	}
+	seconds := bytes / speed
+	timeleft = fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	return timeleft, now.Add(time.Duration(seconds) * time.Second).Format("15:04 Mon 02 Jan")
+}
+
+// toUnits formats n like SABnzbd does sizes and speeds: scaled to K, M, G
+// or T with one decimal, e.g. "1.5 M".
+func toUnits(n float64) string {
+	unit := ""
+	for _, u := range []string{"K", "M", "G", "T"} {
+		if n < 1024 {
+			break
+		}
+		n /= 1024
+		unit = u
+	}
+	return fmt.Sprintf("%.1f %s", n, unit)
+}
+
+// releases gathers dls by the NZO they belong to, in the order each NZO
+// first appears, so the files of a multi-file grab are shown as one.
+func releases(dls []*store.Download) [][]*store.Download {
+	var rels [][]*store.Download
+	index := make(map[string]int)
+	for _, dl := range dls {
+		i, ok := index[dl.NZO()]
+		if !ok {
+			i = len(rels)
+			index[dl.NZO()] = i
+			rels = append(rels, nil)
+		}
+		rels[i] = append(rels[i], dl)
+	}
+	return rels
+}
+
+// summarize returns the download a release's files add up to: the first
+// file's, with the release's NZO as its ID, their total size and progress,
+// the earliest grab and latest completion, the first error, and for several
+// files the remote folder they share as Filename.
+func summarize(files []*store.Download) *store.Download {
+	sum := *files[0]
+	sum.ID = sum.NZO()
+	if len(files) == 1 {
+		return &sum
+	}
+	sum.Filename = slskd.Directory(sum.Filename)
+	sum.Size, sum.BytesDownloaded = 0, 0
+	for _, f := range files {
+		sum.Size += f.Size
+		sum.BytesDownloaded += f.BytesDownloaded
+		if f.AddedAt.Before(sum.AddedAt) {
+			sum.AddedAt = f.AddedAt
+		}
+		if f.CompletedAt.After(sum.CompletedAt) {
+			sum.CompletedAt = f.CompletedAt
+		}
+		sum.Error = cmp.Or(sum.Error, f.Error)
+	}
+	return &sum
+}
+
+// queueStatus is a release's queue status: downloading while any of its
+// files are, else queued while any wait, else paused.
+func queueStatus(queued []*store.Download) store.Status {
+	status := store.StatusPaused
+	for _, dl := range queued {
+		switch dl.Status {
+		case store.StatusDownloading:
+			return dl.Status
+		case store.StatusQueued:
+			status = dl.Status
+		}
+	}
+	return status
+}
+
+// historyStatus is a finished release's status: failed if any of its files
+// failed, else processing while any are, else completed.
+func historyStatus(files []*store.Download) store.Status {
+	status := store.StatusCompleted
+	for _, dl := range files {
+		switch dl.Status {
+		case store.StatusFailed:
+			return dl.Status
+		case store.StatusProcessing:
+			status = dl.Status
+		}
+	}
+	return status
+}
+
+// history returns the releases in history, leaving out multi-file ones
+// with files still in the queue: a release is finished once all of it is.
+func (h *Handler) history() [][]*store.Download {
+	pending := make(map[string]bool)
+	for _, dl := range h.Store.Queue() {
+		pending[dl.NZO()] = true
+	}
+	var rels [][]*store.Download
+	for _, rel := range releases(h.Store.History()) {
+		if !pending[rel[0].NZO()] {
+			rels = append(rels, rel)
+		}
+	}
+	return rels
+}
+
+// commonDir returns the one path given, or the deepest directory holding
+// all of several, where a multi-file release's files are.
+func commonDir(paths []string) string {
+	if len(paths) == 1 {
+		return paths[0]
+	}
+	dir := filepath.Dir(paths[0])
+	for _, p := range paths[1:] {
+		for dir != filepath.Dir(dir) && !strings.HasPrefix(p, dir+string(filepath.Separator)) {
+			dir = filepath.Dir(dir)
+		}
+	}
+	return dir
+}
+
+// displayName is the name a download is shown by: the release name the
+// client asked for, or else the remote file's basename.
+func displayName(dl *store.Download) string {
+	if dl.Name != "" {
+		return dl.Name
+	}
+	return path.Base(strings.ReplaceAll(dl.Filename, "\\", "/"))
+}
+
+// queueFilter returns a filter of downloads by the queue's search, cat and
+// nzo_ids parameters: a case-insensitive substring of the name, and
+// comma-separated categories and IDs. Unset parameters match everything.
+func queueFilter(q url.Values) func([]*store.Download) []*store.Download {
+	search := strings.ToLower(q.Get("search"))
+	list := func(name string) []string {
+		var values []string
+		for _, v := range strings.Split(q.Get(name), ",") {
+			if v = strings.TrimSpace(v); v != "" && v != "*" {
+				values = append(values, strings.ToLower(v))
+			}
+		}
+		return values
+	}
+	cats, ids := list("cat"), list("nzo_ids")
+	return func(dls []*store.Download) []*store.Download {
+		return slices.DeleteFunc(dls, func(dl *store.Download) bool {
+			return (search != "" && !strings.Contains(strings.ToLower(displayName(dl)), search)) ||
+				(cats != nil && !slices.Contains(cats, strings.ToLower(dl.Category))) ||
+				(ids != nil && !slices.Contains(ids, strings.ToLower(dl.NZO())))
+		})
+	}
+}
+
+// handleQueueDelete removes the downloads in value, a comma-separated list
+// of nzo_ids or "all", cancelling their slskd transfers so deleting in the
+// app actually stops them. The finished files of a multi-file release go
+// too, so a partial release doesn't show up in history.
 func (h *Handler) handleQueueDelete(w http.ResponseWriter, r *http.Request) {
 	value := r.URL.Query().Get("value")
 	if value == "" {
@@ -232,13 +836,29 @@ func (h *Handler) handleQueueDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.Store.Remove(value)
-	slog.Info("removed from queue", "id", value)
-	writeJSON(w, map[string]any{"status": true, "nzo_ids": []string{value}})
+	queue := h.Store.Queue()
+	if value != "all" {
+		ids := strings.Split(value, ",")
+		for i := range ids {
+			ids[i] = strings.TrimSpace(ids[i])
+		}
+		queue = slices.DeleteFunc(queue, func(dl *store.Download) bool { return !slices.Contains(ids, dl.NZO()) })
+	}
+	nzos := make(map[string]bool)
+	for _, dl := range queue {
+		nzos[dl.NZO()] = true
+	}
+	dls := queue // in queue order, then the finished files
+	for _, dl := range h.Store.History() {
+		if nzos[dl.NZO()] {
+			dls = append(dls, dl)
+		}
+	}
+	h.writePurge(w, r, dls)
 }
 
 func (h *Handler) handleHistory(w http.ResponseWriter, r *http.Request) {
-	if !h.checkAPIKey(r) {
+	if _, ok := h.checkAPIKey(r); !ok {
 		writeJSON(w, map[string]any{"status": false, "error": "API Key Incorrect"})
 		return
 	}
@@ -251,21 +871,26 @@ func (h *Handler) handleHistory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	history := h.Store.History()
+	history := h.history()
 	slots := make([]map[string]any, 0, len(history))
 
-	for _, dl := range history {
-		basename := path.Base(strings.ReplaceAll(dl.Filename, "\\", "/"))
+	for _, rel := range history {
+		dl := summarize(rel)
 		status := "Completed"
-		if dl.Status == store.StatusFailed {
+		switch historyStatus(rel) {
+		case store.StatusFailed:
 			status = "Failed"
+		case store.StatusProcessing:
+			status = "Running"
 		}
 
-		storagePath := h.DownloadDir
-		if dl.Category != "" {
-			storagePath = path.Join(storagePath, dl.Category)
+		// Unless post-processing moved them, the files are where slskd
+		// saved them; a release's are in the folder they share.
+		var paths []string
+		for _, f := range rel {
+			paths = append(paths, cmp.Or(f.Path, postprocess.LocalPath(h.completeDir(), f.Filename)))
 		}
-		storagePath = path.Join(storagePath, basename)
+		storagePath := commonDir(paths)
 
 		downloadTime := int64(0)
 		if !dl.CompletedAt.IsZero() {
@@ -279,8 +904,8 @@ func (h *Handler) handleHistory(w http.ResponseWriter, r *http.Request) {
 
 		slots = append(slots, map[string]any{
 			"nzo_id":        dl.ID,
-			"name":          basename,
-			"nzb_name":      basename + ".nzb",
+			"name":          displayName(dl),
+			"nzb_name":      displayName(dl) + ".nzb",
 			"status":        status,
 			"storage":       storagePath,
 			"category":      dl.Category,
@@ -288,53 +913,495 @@ func (h *Handler) handleHistory(w http.ResponseWriter, r *http.Request) {
 			"download_time": downloadTime,
 			"completed":     completedTS,
 			"action_line":   "",
-			"fail_message":  "",
+			"fail_message":  dl.Error,
 			"script_line":   "",
 			"loaded":        true,
+			// Not SABnzbd's: the source, to identify and block bad uploaders.
+			"soulseek_username": dl.Username,
+			"soulseek_path":     dl.Filename,
 		})
 	}
 
 	writeJSON(w, map[string]any{
 		"history": map[string]any{
-			"slots":           slots,
-			"noofslots":       len(slots),
+			"slots":               slots,
+			"noofslots":           len(slots),
 			"last_history_update": time.Now().Unix(),
 		},
 	})
 }
 
 func (h *Handler) handleHistoryDelete(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	value := q.Get("value")
+	if value == "" {
+		writeJSON(w, map[string]any{"status": false, "error": "Missing value"})
+		return
+	}
+
+	// Bulk deletes leave downloads still post-processing alone.
+	var only store.Status
+	switch {
+	case value == "failed" || (value == "all" && q.Get("failed_only") == "1"):
+		only = store.StatusFailed
+	case value == "completed":
+		only = store.StatusCompleted
+	case value != "all":
+		for _, rel := range h.history() {
+			if rel[0].NZO() == value {
+				for _, dl := range rel {
+					h.Store.Remove(dl.ID)
+				}
+			}
+		}
+		slog.InfoContext(r.Context(), "removed from history", "id", value)
+		writeJSON(w, map[string]any{"status": true, "nzo_ids": []string{value}})
+		return
+	}
+	var dls []*store.Download
+	for _, rel := range h.history() {
+		if status := historyStatus(rel); status != store.StatusProcessing && (only == "" || status == only) {
+			dls = append(dls, rel...)
+		}
+	}
+	h.writePurge(w, r, dls)
+}
+
+// writePurge purges dls and reports the IDs removed. Downloads whose
+// transfer couldn't be cancelled are kept, so purging again retries them.
+func (h *Handler) writePurge(w http.ResponseWriter, r *http.Request, dls []*store.Download) {
+	ids, err := h.purge(r.Context(), dls)
+	// Report the NZOs the app knows, once per multi-file release.
+	nzos := make(map[string]string, len(dls))
+	for _, dl := range dls {
+		nzos[dl.ID] = dl.NZO()
+	}
+	seen := make(map[string]bool, len(ids))
+	reported := ids[:0]
+	for _, id := range ids {
+		if nzo := nzos[id]; !seen[nzo] {
+			seen[nzo] = true
+			reported = append(reported, nzo)
+		}
+	}
+	ids = reported
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to purge downloads", "purged", len(ids), "error", err)
+		writeJSON(w, map[string]any{"status": false, "error": err.Error(), "nzo_ids": ids})
+		return
+	}
+	writeJSON(w, map[string]any{"status": true, "nzo_ids": ids})
+}
+
+// purge cancels the slskd transfers of dls and forgets them, returning the
+// IDs of those removed.
+func (h *Handler) purge(ctx context.Context, dls []*store.Download) ([]string, error) {
+	ids := []string{}
+	if len(dls) == 0 {
+		return ids, nil
+	}
+	h.syncMu.Lock() // keep a sync from seeing the cancelled transfers as failed
+	defer h.syncMu.Unlock()
+	transferIDs, err := h.transferIDs(ctx)
+	if err != nil {
+		return ids, err
+	}
+
+	var errs []error
+	for _, dl := range dls {
+		if id := transferIDs(dl); id != "" {
+			if err := h.SlskdClient.CancelDownload(ctx, dl.Username, id); err != nil {
+				errs = append(errs, fmt.Errorf("cancel %s: %w", dl.ID, err))
+				continue
+			}
+		}
+		h.Store.Remove(dl.ID)
+		ids = append(ids, dl.ID)
+	}
+	slog.InfoContext(ctx, "downloads purged", "purged", len(ids), "failed", len(errs))
+	return ids, errors.Join(errs...)
+}
+
+// handleRetry re-queues the failed files of the history item value, which
+// moves back to the queue. SABnzbd's optional replacement nzbfile and
+// password have no meaning here and are ignored.
+func (h *Handler) handleRetry(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.checkAPIKey(r); !ok {
+		writeJSON(w, map[string]any{"status": false, "error": "API Key Incorrect"})
+		return
+	}
 	value := r.URL.Query().Get("value")
 	if value == "" {
 		writeJSON(w, map[string]any{"status": false, "error": "Missing value"})
 		return
 	}
+	var failed []string
+	found := false
+	for _, dl := range h.Store.All() {
+		if dl.NZO() == value {
+			found = true
+			if dl.Status == store.StatusFailed {
+				failed = append(failed, dl.ID)
+			}
+		}
+	}
+	switch {
+	case !found:
+		writeJSON(w, map[string]any{"status": false, "error": store.ErrNotFound.Error()})
+		return
+	case len(failed) == 0:
+		writeJSON(w, map[string]any{"status": false, "error": store.ErrNotFailed.Error()})
+		return
+	}
+	var errs []error
+	for _, id := range failed {
+		if err := h.Retry(r.Context(), id); err != nil {
+			errs = append(errs, fmt.Errorf("retry %s: %w", id, err))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		slog.ErrorContext(r.Context(), "retry failed", "id", value, "error", err)
+		if len(errs) == len(failed) {
+			writeJSON(w, map[string]any{"status": false, "error": err.Error()})
+			return
+		}
+	}
+	writeJSON(w, map[string]any{"status": true, "nzo_id": value})
+}
+
+// Retry re-queues a failed download in slskd with a fresh set of automatic
+// retries, from another peer sharing the file when AlternateSearch is set,
+// as automatic retries are.
+func (h *Handler) Retry(ctx context.Context, id string) error {
+	dl := h.Store.Get(id)
+	if dl == nil {
+		return store.ErrNotFound
+	}
+	if dl.Status != store.StatusFailed {
+		return store.ErrNotFailed
+	}
+
+	// slskd refuses to enqueue a file that still has a transfer record.
+	if dl.TransferID != "" {
+		if err := h.SlskdClient.CancelDownload(ctx, dl.Username, dl.TransferID); err != nil {
+			slog.WarnContext(ctx, "failed to remove old transfer", "id", id, "error", err)
+		}
+	}
+	if h.AlternateSearch > 0 {
+		if err := h.Store.Requeue(id); err != nil {
+			return err
+		}
+		h.rerouting.Store(id, true)
+		go h.retryElsewhere(*dl)
+	} else {
+		err := h.SlskdClient.Download(ctx, dl.Username, []slskd.DownloadRequest{
+			{Filename: dl.Filename, Size: dl.Size},
+		})
+		if err != nil {
+			return fmt.Errorf("queue download: %w", err)
+		}
+		if err := h.Store.Requeue(id); err != nil {
+			return err
+		}
+	}
+
+	h.wakeSync()
+	slog.InfoContext(ctx, "download manually retried", "id", id, "filename", dl.Filename)
+	downloadRetries.Inc(dl.Category, "manual")
+	if dl := h.Store.Get(id); dl != nil {
+		h.Notifier.Send(notify.NewMessage(notify.EventRetry, dl, ""))
+	}
+	return nil
+}
+
+func (h *Handler) handlePause(w http.ResponseWriter, r *http.Request, pause bool) {
+	if _, ok := h.checkAPIKey(r); !ok {
+		writeJSON(w, map[string]any{"status": false, "error": "API Key Incorrect"})
+		return
+	}
+	var err error
+	if pause {
+		err = h.Pause(r.Context())
+	} else {
+		err = h.Resume(r.Context())
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to change pause state", "pause", pause, "error", err)
+		writeJSON(w, map[string]any{"status": false, "error": err.Error()})
+		return
+	}
+	writeJSON(w, map[string]any{"status": true})
+}
+
+// handleSwitch moves a queued release (value, an NZO ID) with all its files
+// to a position in the queue (value2), given as a slot index or as the NZO
+// ID of the release to take the place of. Downloads are dispatched to slskd
+// in queue order on resume.
+func (h *Handler) handleSwitch(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.checkAPIKey(r); !ok {
+		writeJSON(w, map[string]any{"status": false, "error": "API Key Incorrect"})
+		return
+	}
+	q := r.URL.Query()
+	id, target := q.Get("value"), q.Get("value2")
+	if id == "" || target == "" {
+		writeJSON(w, map[string]any{"status": false, "error": "Missing value"})
+		return
+	}
+	position, err := strconv.Atoi(target)
+	if err != nil {
+		position = slices.IndexFunc(releases(h.Store.Queue()), func(rel []*store.Download) bool { return rel[0].NZO() == target })
+		if position < 0 {
+			writeJSON(w, map[string]any{"status": false, "error": "Unknown value2"})
+			return
+		}
+	}
+	if position, err = h.Store.Move(id, position); err != nil {
+		writeJSON(w, map[string]any{"status": false, "error": err.Error()})
+		return
+	}
+	slog.InfoContext(r.Context(), "moved in queue", "id", id, "position", position)
+	writeJSON(w, map[string]any{"result": map[string]any{"priority": 0, "position": position}})
+}
+
+// Pause holds the queue: each download's slskd transfer is cancelled and the
+// download marked Paused, so nothing progresses until Resume.
+func (h *Handler) Pause(ctx context.Context) error {
+	h.syncMu.Lock() // keep a sync from seeing the cancelled transfers as failed
+	defer h.syncMu.Unlock()
+	transferIDs, err := h.transferIDs(ctx)
+	if err != nil {
+		return err
+	}
+	h.paused.Store(true)
 
-	h.Store.Remove(value)
-	slog.Info("removed from history", "id", value)
-	writeJSON(w, map[string]any{"status": true, "nzo_ids": []string{value}})
+	var errs []error
+	for _, dl := range h.Store.Queue() {
+		if dl.Status == store.StatusPaused {
+			continue
+		}
+		if id := transferIDs(dl); id != "" {
+			if err := h.SlskdClient.CancelDownload(ctx, dl.Username, id); err != nil {
+				errs = append(errs, fmt.Errorf("cancel %s: %w", dl.ID, err))
+				continue
+			}
+		}
+		h.Store.Pause(dl.ID)
+	}
+	slog.InfoContext(ctx, "queue paused")
+	return errors.Join(errs...)
 }
 
-// SyncDownloads polls slskd for transfer status and updates the store.
+// transferIDs returns a lookup of downloads' slskd transfer IDs. Downloads
+// grabbed since the last sync don't know theirs yet, so slskd's transfers
+// are listed to find them.
+func (h *Handler) transferIDs(ctx context.Context) (func(*store.Download) string, error) {
+	groups, err := h.SlskdClient.GetAllDownloads(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list transfers: %w", err)
+	}
+	ids := make(map[[2]string]string)
+	for _, g := range groups {
+		for _, d := range g.Directories {
+			for _, t := range d.Files {
+				ids[[2]string{g.Username, t.Filename}] = t.ID
+			}
+		}
+	}
+	return func(dl *store.Download) string {
+		return cmp.Or(dl.TransferID, ids[[2]string{dl.Username, dl.Filename}])
+	}, nil
+}
+
+// Resume sends paused downloads back to slskd and lets the queue progress.
+func (h *Handler) Resume(ctx context.Context) error {
+	h.syncMu.Lock()
+	defer h.syncMu.Unlock()
+	h.paused.Store(false)
+
+	var errs []error
+	for _, dl := range h.Store.Queue() {
+		if dl.Status != store.StatusPaused {
+			continue
+		}
+		err := h.SlskdClient.Download(ctx, dl.Username, []slskd.DownloadRequest{
+			{Filename: dl.Filename, Size: dl.Size},
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("queue %s: %w", dl.ID, err))
+			continue
+		}
+		h.Store.Resume(dl.ID)
+	}
+	if len(errs) > 0 {
+		h.paused.Store(true) // the rest stay paused; resuming again retries them
+	}
+	h.wakeSync()
+	slog.InfoContext(ctx, "queue resumed", "failed", len(errs))
+	return errors.Join(errs...)
+}
+
+// Cancel stops a download's slskd transfer and forgets it.
+func (h *Handler) Cancel(ctx context.Context, id string) error {
+	dl := h.Store.Get(id)
+	if dl == nil {
+		return store.ErrNotFound
+	}
+	if dl.TransferID != "" {
+		if err := h.SlskdClient.CancelDownload(ctx, dl.Username, dl.TransferID); err != nil {
+			return fmt.Errorf("cancel transfer: %w", err)
+		}
+	}
+	h.Store.Remove(id)
+	slog.InfoContext(ctx, "download cancelled", "id", id, "filename", dl.Filename)
+	return nil
+}
+
+// Speeds returns a download's recent transfer speed samples, oldest first.
+func (h *Handler) Speeds(id string) ([]store.SpeedSample, error) {
+	if h.Store.Get(id) == nil {
+		return nil, store.ErrNotFound
+	}
+	return h.Store.Speeds(id), nil
+}
+
+// SyncDownloads polls slskd for transfer status and updates the store until
+// ctx is cancelled.
 func (h *Handler) SyncDownloads(ctx context.Context) {
-	ticker := time.NewTicker(5 * time.Second)
+	ticker := time.NewTicker(cmp.Or(h.SyncInterval, 5*time.Second))
 	defer ticker.Stop()
+	// Downloads paused before a restart keep the queue paused.
+	if h.Store.Counts()[store.StatusPaused] > 0 {
+		h.paused.Store(true)
+	}
+	h.lastSync.Store(time.Now().UnixNano())
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			h.syncOnce(ctx)
+			if h.TransferHub.Connected() && time.Since(h.LastSync()) < hubPollInterval {
+				continue
+			}
+		case <-h.wakeChan():
+		}
+		// The iteration runs on a context detached from shutdown so a sync
+		// in progress when we're asked to stop finishes instead of leaving
+		// the store half-updated.
+		iterCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+		if err := h.SyncNow(iterCtx); err != nil {
+			slog.Error("failed to get slskd downloads", "error", err)
+		}
+		cancel()
+	}
+}
+
+func (h *Handler) wakeChan() chan struct{} {
+	h.wakeOnce.Do(func() { h.wake = make(chan struct{}, 1) })
+	return h.wake
+}
+
+// wakeSync asks SyncDownloads for an iteration now, so a transfer just
+// queued in slskd shows up in the queue without waiting for the next tick.
+func (h *Handler) wakeSync() {
+	select {
+	case h.wakeChan() <- struct{}{}:
+	default:
+	}
+}
+
+const (
+	// hubPollInterval is how often SyncDownloads still polls while
+	// TransferHub is connected, in case an event was missed.
+	hubPollInterval = 30 * time.Second
+	// hubSyncDelay gathers a burst of transfer events into one sync.
+	hubSyncDelay = 250 * time.Millisecond
+)
+
+// TransferEvent handles a message from TransferHub, waking SyncDownloads
+// shortly after so the store follows slskd's transfers as they change.
+func (h *Handler) TransferEvent(string, []json.RawMessage) {
+	if h.hubWake.CompareAndSwap(false, true) {
+		time.AfterFunc(hubSyncDelay, func() {
+			h.hubWake.Store(false)
+			h.wakeSync()
+		})
+	}
+}
+
+// SyncNow runs a sync iteration immediately instead of waiting for the next
+// tick. It is safe to call while SyncDownloads is running.
+func (h *Handler) SyncNow(ctx context.Context) error {
+	h.syncMu.Lock()
+	defer h.syncMu.Unlock()
+	err := h.syncOnce(ctx)
+	h.lastSync.Store(time.Now().UnixNano())
+	return err
+}
+
+// RefreshOptions re-reads slskd's options, bypassing the client's cache,
+// picking up a changed download directory when DiscoverDownloadDir is set.
+// It returns the directory in use.
+func (h *Handler) RefreshOptions(ctx context.Context) (string, error) {
+	h.SlskdClient.InvalidateOptions()
+	if !h.DiscoverDownloadDir {
+		if _, err := h.SlskdClient.GetOptions(ctx); err != nil {
+			return "", err
 		}
+		return h.completeDir(), nil
+	}
+	dir, err := h.SlskdClient.GetDownloadDir(ctx)
+	if err != nil {
+		return "", err
+	}
+	if dir != "" && dir != h.completeDir() {
+		slog.InfoContext(ctx, "slskd download directory changed", "dir", dir)
+		h.downloadDir.Store(&dir)
+	}
+	return h.completeDir(), nil
+}
+
+// completeDir returns where finished downloads are reported to be.
+func (h *Handler) completeDir() string {
+	if dir := h.downloadDir.Load(); dir != nil {
+		return *dir
 	}
+	return h.DownloadDir
+}
+
+// LastSync returns when the sync loop last completed an iteration, whether
+// or not slskd was reachable.
+func (h *Handler) LastSync() time.Time {
+	return time.Unix(0, h.lastSync.Load())
 }
 
-func (h *Handler) syncOnce(ctx context.Context) {
+// maxAgeMessage is the failure reported for a download that outlived MaxAge.
+const maxAgeMessage = "exceeded max download age"
+
+func (h *Handler) syncOnce(ctx context.Context) error {
+	// Only downloads still in flight can change; finished ones are history.
+	var pending []*store.Download
+	for _, dl := range h.Store.Active() {
+		switch dl.Status {
+		case store.StatusProcessing:
+			h.startProcessing(dl)
+			continue
+		case store.StatusPaused:
+			continue
+		}
+		if _, ok := h.rerouting.Load(dl.ID); ok {
+			continue
+		}
+		pending = append(pending, dl)
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
 	groups, err := h.SlskdClient.GetAllDownloads(ctx)
 	if err != nil {
-		slog.Error("failed to get slskd downloads", "error", err)
-		return
+		return err
 	}
 
 	// Build a map of username+filename → transfer for quick lookup
@@ -353,17 +1420,18 @@ func (h *Handler) syncOnce(ctx context.Context) {
 		}
 	}
 
-	// Update our tracked downloads
-	for _, dl := range h.Store.All() {
-		if dl.Status == store.StatusCompleted || dl.Status == store.StatusFailed {
-			continue
-		}
-
+	// Update the downloads whose transfer moved since the last sync
+	now := time.Now()
+	for _, dl := range pending {
 		key := transferKey{username: dl.Username, filename: dl.Filename}
 		t, ok := transfers[key]
-		if !ok {
+		expired := h.MaxAge > 0 && now.Sub(dl.Attempted()) > h.MaxAge
+		if !ok && !expired {
 			continue
 		}
+		if !ok {
+			t = &slskd.Transfer{BytesTransferred: dl.BytesDownloaded}
+		}
 
 		// Store the slskd transfer ID for potential cancellation
 		if t.ID != "" {
@@ -371,27 +1439,44 @@ func (h *Handler) syncOnce(ctx context.Context) {
 		}
 
 		mapped := slskd.MapTransferState(t.State)
+		state := t.State
+		if expired && mapped != "completed" {
+			mapped, state = "failed", maxAgeMessage
+		}
 		var newStatus store.Status
 		switch mapped {
 		case "completed":
 			newStatus = store.StatusCompleted
+			if h.PostProcess.Enabled() {
+				newStatus = store.StatusProcessing
+			}
 		case "downloading":
 			newStatus = store.StatusDownloading
+			h.Store.RecordSpeed(dl.ID, t.BytesTransferred, t.AverageSpeed, now)
 		case "failed":
 			// Attempt retry before marking as failed
 			if h.Store.IncrementRetry(dl.ID) {
+				downloadRetries.Inc(dl.Category, "auto")
 				slog.Info("retrying failed download",
 					"id", dl.ID,
 					"filename", dl.Filename,
 					"retry", dl.Retries+1,
-					"state", t.State,
+					"state", state,
 				)
+				if retried := h.Store.Get(dl.ID); retried != nil {
+					h.Notifier.Send(notify.NewMessage(notify.EventRetry, retried, state))
+				}
 				// Cancel the old transfer with two-phase removal
 				if t.ID != "" {
 					go func(username, transferID string) {
 						_ = h.SlskdClient.CancelDownload(context.Background(), username, transferID)
 					}(dl.Username, t.ID)
 				}
+				if h.AlternateSearch > 0 {
+					h.rerouting.Store(dl.ID, true)
+					go h.retryElsewhere(*dl)
+					continue
+				}
 				// Re-queue in slskd
 				go func(username, filename string, size int64) {
 					err := h.SlskdClient.Download(context.Background(), username, []slskd.DownloadRequest{
@@ -404,11 +1489,218 @@ func (h *Handler) syncOnce(ctx context.Context) {
 				continue
 			}
 			newStatus = store.StatusFailed
+			if state == maxAgeMessage && t.ID != "" {
+				// Stop the transfer too, or it could still finish after
+				// the app has given up on it.
+				go func(username, transferID string) {
+					_ = h.SlskdClient.CancelDownload(context.Background(), username, transferID)
+				}(dl.Username, t.ID)
+			}
 		default:
 			newStatus = store.StatusQueued
 		}
+		if newStatus == dl.Status && t.BytesTransferred == dl.BytesDownloaded {
+			continue
+		}
+		if dl.BytesDownloaded == 0 && t.BytesTransferred > 0 && dl.Retries == 0 {
+			downloadFirstByte.Observe(now.Sub(dl.AddedAt).Seconds(), dl.Category)
+		}
 
 		h.Store.UpdateTransfer(dl.ID, t.BytesTransferred, newStatus)
+		if state == maxAgeMessage {
+			slog.Warn("download exceeded max age", "id", dl.ID, "filename", dl.Filename, "age", now.Sub(dl.Attempted()).Round(time.Second))
+			h.Store.Fail(dl.ID, maxAgeMessage)
+		}
+		h.notifyFinished(dl.ID, dl.Status, newStatus, state)
+		if newStatus == store.StatusProcessing {
+			h.startProcessing(h.Store.Get(dl.ID))
+		}
+	}
+	return nil
+}
+
+// retryElsewhere re-queues a failed download from another peer sharing the
+// same file, or from the same peer when no other does. The sync loop skips
+// the download until it is queued again.
+func (h *Handler) retryElsewhere(dl store.Download) {
+	defer h.rerouting.Delete(dl.ID)
+	ctx, cancel := context.WithTimeout(context.Background(), h.AlternateSearch+time.Minute)
+	defer cancel()
+
+	username, file := dl.Username, slskd.DownloadRequest{Filename: dl.Filename, Size: dl.Size}
+	if alt, filename, ok := h.alternatePeer(ctx, &dl); ok {
+		if err := h.Store.Reassign(dl.ID, alt, filename); err != nil {
+			return // removed while searching
+		}
+		downloadFallbacks.Inc(dl.Category)
+		slog.Info("retrying failed download from another peer", "id", dl.ID, "from", dl.Username, "to", alt, "filename", filename)
+		username, file.Filename = alt, filename
+	}
+	if err := h.SlskdClient.Download(ctx, username, []slskd.DownloadRequest{file}); err != nil {
+		slog.Error("retry download failed", "filename", file.Filename, "error", err)
+	}
+}
+
+// alternatePeer searches for dl's file and returns the best-scored other
+// peer sharing a file of the same name and size, with that peer's path
+// for it.
+func (h *Handler) alternatePeer(ctx context.Context, dl *store.Download) (username, filename string, ok bool) {
+	name := remoteBase(dl.Filename)
+	query := strings.Join(strings.FieldsFunc(strings.TrimSuffix(name, path.Ext(name)), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ")
+	if query == "" {
+		return "", "", false
+	}
+	responses, err := h.SlskdClient.SearchAndWait(ctx, query, h.AlternateSearch)
+	if err != nil {
+		slog.Warn("alternate peer search failed", "id", dl.ID, "query", query, "error", err)
+		return "", "", false
+	}
+	var blacklisted []string
+	if h.SlskdBlacklist {
+		blacklisted, _ = h.SlskdClient.BlacklistedUsers(ctx)
+	}
+
+	best := 0
+	for i := range responses {
+		resp := &responses[i]
+		if resp.Username == dl.Username || h.Blocklist.Blocked(resp.Username) || slices.Contains(blacklisted, resp.Username) {
+			continue
+		}
+		if ok && resp.PeerScore() <= best {
+			continue
+		}
+		for f := range resp.AllFiles() {
+			if f.Size == dl.Size && strings.EqualFold(textnorm.NFC(remoteBase(f.Filename)), textnorm.NFC(name)) {
+				username, filename, ok, best = resp.Username, f.Filename, true, resp.PeerScore()
+				break
+			}
+		}
+	}
+	return username, filename, ok
+}
+
+// remoteBase returns the file name part of a peer's path.
+func remoteBase(filename string) string {
+	return path.Base(strings.ReplaceAll(filename, "\\", "/"))
+}
+
+// startProcessing runs the post-processing pipeline over a transferred
+// download in the background, unless a run for it is already in flight.
+// Downloads left in Processing by a restart are picked up again by the next
+// sync, so steps start over on the same file.
+func (h *Handler) startProcessing(dl *store.Download) {
+	if dl == nil {
+		return
+	}
+	if _, running := h.processing.LoadOrStore(dl.ID, true); running {
+		return
+	}
+	go func() {
+		defer h.processing.Delete(dl.ID)
+
+		f := &postprocess.File{
+			ID:       dl.ID,
+			Username: dl.Username,
+			Filename: dl.Filename,
+			Size:     dl.Size,
+			BitRate:  dl.BitRate,
+			AddedAt:  dl.AddedAt,
+			Category: dl.Category,
+			Artist:   dl.Artist,
+			Album:    dl.Album,
+			Name:     dl.Name,
+			Root:     h.completeDir(),
+			Path:     dl.Path,
+		}
+		if f.Path == "" {
+			f.Path = postprocess.LocalPath(f.Root, dl.Filename)
+		}
+		err := h.PostProcess.Run(context.Background(), f)
+		if errors.Is(err, postprocess.ErrCorrupt) && h.retryCorrupt(dl, f.Path, err) {
+			return
+		}
+		if err != nil {
+			slog.Error("post-processing failed", "id", dl.ID, "path", f.Path, "error", err)
+		} else {
+			slog.Info("post-processing done", "id", dl.ID, "path", f.Path)
+		}
+		h.Store.FinishProcessing(dl.ID, f.Path, err)
+		status := store.StatusCompleted
+		state := ""
+		if err != nil {
+			status, state = store.StatusFailed, err.Error()
+		}
+		h.notifyFinished(dl.ID, store.StatusProcessing, status, state)
+	}()
+}
+
+// retryCorrupt fetches a download again after post-processing found the
+// file damaged, removing the damaged copy, and reports false when it is out
+// of retries so the caller fails it instead.
+func (h *Handler) retryCorrupt(dl *store.Download, path string, cause error) bool {
+	if dl.Retries >= dl.MaxRetries {
+		return false
+	}
+	// The old transfer record has to go before the download is queued again,
+	// or the next sync would take it for completed once more.
+	if dl.TransferID != "" {
+		if err := h.SlskdClient.CancelDownload(context.Background(), dl.Username, dl.TransferID); err != nil {
+			slog.Warn("failed to remove old transfer", "id", dl.ID, "error", err)
+		}
+	}
+	if h.AlternateSearch > 0 {
+		h.rerouting.Store(dl.ID, true)
+	}
+	if !h.Store.IncrementRetry(dl.ID) {
+		h.rerouting.Delete(dl.ID)
+		return false
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Warn("failed to remove corrupt file", "id", dl.ID, "path", path, "error", err)
+	}
+
+	downloadRetries.Inc(dl.Category, "corrupt")
+	slog.Warn("retrying corrupt download", "id", dl.ID, "filename", dl.Filename, "retry", dl.Retries+1, "error", cause)
+	if retried := h.Store.Get(dl.ID); retried != nil {
+		h.Notifier.Send(notify.NewMessage(notify.EventRetry, retried, cause.Error()))
+	}
+	if h.AlternateSearch > 0 {
+		go h.retryElsewhere(*dl)
+		return true
+	}
+	err := h.SlskdClient.Download(context.Background(), dl.Username, []slskd.DownloadRequest{
+		{Filename: dl.Filename, Size: dl.Size},
+	})
+	if err != nil {
+		slog.Error("retry download failed", "filename", dl.Filename, "error", err)
+	}
+	return true
+}
+
+// notifyFinished sends a completion or failure notification, and counts the
+// outcome, when a download first reaches a terminal status.
+func (h *Handler) notifyFinished(id string, prev, status store.Status, state string) {
+	if prev == status {
+		return
+	}
+	dl := h.Store.Get(id)
+	if dl == nil {
+		return
+	}
+	var err error
+	switch status {
+	case store.StatusCompleted:
+		h.Notifier.Send(notify.NewMessage(notify.EventComplete, dl, ""))
+		downloadBytes.Add(float64(dl.BytesDownloaded), dl.Category)
+		err = h.Stats.Complete(dl.Category, dl.CompletedAt.Sub(dl.AddedAt))
+	case store.StatusFailed:
+		h.Notifier.Send(notify.NewMessage(notify.EventFailure, dl, state))
+		err = h.Stats.Fail(dl.Category)
+	}
+	if err != nil {
+		slog.Error("failed to persist stats", "error", err)
 	}
 }
 
@@ -1,15 +1,32 @@
 package sabnzbd
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/nerney/slskrr/auth"
+	"github.com/nerney/slskrr/middleware"
 	"github.com/nerney/slskrr/newznab"
+	"github.com/nerney/slskrr/notify"
+	"github.com/nerney/slskrr/postprocess"
 	"github.com/nerney/slskrr/slskd"
+	"github.com/nerney/slskrr/stats"
 	"github.com/nerney/slskrr/store"
 )
 
@@ -17,7 +34,7 @@ func newTestHandler(slskdURL string) *Handler {
 	return &Handler{
 		SlskdClient: slskd.NewClient(slskdURL, "testkey"),
 		Store:       store.New(),
-		APIKey:      "testapikey",
+		Keys:        auth.NewKeyring(auth.Key{Label: "test", Value: "testapikey"}),
 		DownloadDir: "/downloads/complete",
 	}
 }
@@ -90,6 +107,42 @@ func TestHandler_GetConfig(t *testing.T) {
 	}
 }
 
+func TestHandler_ConfiguredIdentity(t *testing.T) {
+	h := newTestHandler("")
+	h.Version = "4.3.2"
+	h.Categories = []string{"music", "books"}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/sabnzbd/api?mode=version", nil))
+	var version map[string]string
+	json.NewDecoder(rec.Body).Decode(&version)
+	if version["version"] != "4.3.2" {
+		t.Errorf("expected version 4.3.2, got %s", version["version"])
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/sabnzbd/api?mode=get_cats&apikey=testapikey", nil))
+	var cats struct {
+		Categories []string `json:"categories"`
+	}
+	json.NewDecoder(rec.Body).Decode(&cats)
+	if !slices.Equal(cats.Categories, []string{"Default", "music", "books"}) {
+		t.Errorf("unexpected categories: %v", cats.Categories)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/sabnzbd/api?mode=get_config&apikey=testapikey", nil))
+	var config struct {
+		Config struct {
+			Categories []struct{ Name, Dir string } `json:"categories"`
+		} `json:"config"`
+	}
+	json.NewDecoder(rec.Body).Decode(&config)
+	if cs := config.Config.Categories; len(cs) != 3 || cs[0].Dir != "" || cs[2].Name != "books" || cs[2].Dir != "books" {
+		t.Errorf("unexpected config categories: %+v", cs)
+	}
+}
+
 func TestHandler_GetCats(t *testing.T) {
 	h := newTestHandler("")
 
@@ -170,6 +223,314 @@ func TestHandler_AddURL(t *testing.T) {
 	}
 }
 
+func TestHandler_AddURL_RecordsAlbum(t *testing.T) {
+	mockSlskd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer mockSlskd.Close()
+
+	h := newTestHandler(mockSlskd.URL)
+	token := newznab.FileToken{Username: "user1", Filename: `Music\Album\01.flac`, Size: 10, Artist: "Artist", Album: "Album"}.Encode()
+	reqURL := "/sabnzbd/api?mode=addurl&apikey=testapikey&cat=lidarr&name=" + url.QueryEscape("http://localhost:6969/api?t=get&id="+token)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", reqURL, nil))
+
+	queue := h.Store.Queue()
+	if len(queue) != 1 || queue[0].Artist != "Artist" || queue[0].Album != "Album" {
+		t.Errorf("expected the album recorded on the download, got %+v", queue)
+	}
+}
+
+func TestHandler_AddURL_SlskdBlacklist(t *testing.T) {
+	downloads := 0
+	mockSlskd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v0/options" {
+			w.Write([]byte(`{"groups":{"blacklisted":{"members":["baduser"]}}}`))
+			return
+		}
+		downloads++
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer mockSlskd.Close()
+
+	h := newTestHandler(mockSlskd.URL)
+	h.SlskdBlacklist = true
+	token := newznab.EncodeToken("baduser", `Movies\Bad.Movie.2024.mkv`, 2000000000)
+	reqURL := "/sabnzbd/api?mode=addurl&apikey=testapikey&cat=radarr&name=" + url.QueryEscape("http://localhost:6969/api?t=get&id="+token)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", reqURL, nil))
+
+	var resp map[string]any
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp["status"] != false || resp["error"] != "User is blacklisted in slskd" {
+		t.Errorf("expected the grab refused, got %v", resp)
+	}
+	if downloads != 0 || len(h.Store.Queue()) != 0 {
+		t.Errorf("expected nothing queued, got %d slskd downloads and %d queued", downloads, len(h.Store.Queue()))
+	}
+}
+
+func TestHandler_AddURL_Folder(t *testing.T) {
+	var queued []slskd.DownloadRequest
+	mockSlskd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/users/peer/directory"):
+			w.Write([]byte(`[{"name":"Music\\Artist - Album\\FLAC","files":[` +
+				`{"filename":"01 - One.flac","size":30},{"filename":"02 - Two.flac","size":20},{"filename":"cover.jpg","size":1}]}]`))
+		case strings.Contains(r.URL.Path, "/transfers/downloads/"):
+			var files []slskd.DownloadRequest
+			json.NewDecoder(r.Body).Decode(&files)
+			queued = append(queued, files...)
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer mockSlskd.Close()
+
+	h := newTestHandler(mockSlskd.URL)
+	token := newznab.FileToken{Username: "peer", Filename: `Music\Artist - Album\FLAC`, Size: 50, Folder: true}.Encode()
+	reqURL := "/sabnzbd/api?mode=addurl&apikey=testapikey&cat=lidarr&name=" + url.QueryEscape("http://localhost:6969/api?t=get&id="+token)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", reqURL, nil))
+
+	var resp map[string]any
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if ids, _ := resp["nzo_ids"].([]any); resp["status"] != true || len(ids) != 1 {
+		t.Fatalf("expected the folder grabbed as one download, got %v", resp)
+	}
+	if len(queued) != 2 || queued[0].Filename != `Music\Artist - Album\FLAC\01 - One.flac` || queued[1].Size != 20 {
+		t.Errorf("expected the folder's tracks queued in slskd, got %+v", queued)
+	}
+	for _, dl := range h.Store.Queue() {
+		if dl.Name != "Artist - Album" || dl.Category != "lidarr" {
+			t.Errorf("expected the tracks named after the release, got %+v", dl)
+		}
+	}
+}
+
+func TestHandler_AddURL_SeasonPack(t *testing.T) {
+	var queued []slskd.DownloadRequest
+	mockSlskd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/users/peer/directory"):
+			w.Write([]byte(`[{"name":"TV\\Show\\Season 1","files":[` +
+				`{"filename":"Show.S01E01.mkv","size":30},{"filename":"Show.S01E02.mkv","size":20},{"filename":"Show.S01E01.srt","size":1}]}]`))
+		case strings.Contains(r.URL.Path, "/transfers/downloads/"):
+			var files []slskd.DownloadRequest
+			json.NewDecoder(r.Body).Decode(&files)
+			queued = append(queued, files...)
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer mockSlskd.Close()
+
+	h := newTestHandler(mockSlskd.URL)
+	token := newznab.FileToken{Username: "peer", Filename: `TV\Show\Season 1`, Size: 50, Folder: true}.Encode()
+	reqURL := "/sabnzbd/api?mode=addurl&apikey=testapikey&cat=sonarr&name=" + url.QueryEscape("http://localhost:6969/api?t=get&id="+token)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", reqURL, nil))
+
+	var resp map[string]any
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if ids, _ := resp["nzo_ids"].([]any); resp["status"] != true || len(ids) != 1 {
+		t.Fatalf("expected the season grabbed as one download, got %v", resp)
+	}
+	if len(queued) != 2 || queued[0].Filename != `TV\Show\Season 1\Show.S01E01.mkv` {
+		t.Errorf("expected the season's episodes queued in slskd, got %+v", queued)
+	}
+}
+
+func TestHandler_AddURL_Release(t *testing.T) {
+	var queued []slskd.DownloadRequest
+	mockSlskd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.Contains(r.URL.Path, "/transfers/downloads/") && r.Method == http.MethodPost:
+			var files []slskd.DownloadRequest
+			json.NewDecoder(r.Body).Decode(&files)
+			queued = append(queued, files...)
+			w.WriteHeader(http.StatusCreated)
+		case strings.HasSuffix(r.URL.Path, "/transfers/downloads"):
+			w.Write([]byte(`[]`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer mockSlskd.Close()
+
+	h := newTestHandler(mockSlskd.URL)
+	token := newznab.FileToken{Username: "peer", Filename: `Movies\Heat.1995`, Size: 3 << 20, Files: []newznab.TokenFile{
+		{Name: `\Heat.1995.mkv`, Size: 2 << 20},
+		{Name: `\Heat.1995.en.srt`, Size: 1 << 20},
+	}}.Encode()
+	reqURL := "/sabnzbd/api?mode=addurl&apikey=testapikey&cat=radarr&name=" + url.QueryEscape("http://localhost:6969/api?t=get&id="+token)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", reqURL, nil))
+
+	var resp struct {
+		Status bool     `json:"status"`
+		IDs    []string `json:"nzo_ids"`
+	}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if !resp.Status || len(resp.IDs) != 1 {
+		t.Fatalf("expected one nzo_id for the release, got %+v", resp)
+	}
+	if len(queued) != 2 || queued[1].Filename != `Movies\Heat.1995\Heat.1995.en.srt` {
+		t.Errorf("expected the release's files queued in slskd, got %+v", queued)
+	}
+
+	// The first file finishing leaves the release in the queue, whole.
+	h.Store.UpdateTransfer(resp.IDs[0], 2<<20, store.StatusCompleted)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/sabnzbd/api?mode=queue&apikey=testapikey", nil))
+	var queue struct {
+		Queue struct {
+			Slots []map[string]any `json:"slots"`
+		} `json:"queue"`
+	}
+	json.NewDecoder(rec.Body).Decode(&queue)
+	if slots := queue.Queue.Slots; len(slots) != 1 || slots[0]["nzo_id"] != resp.IDs[0] || slots[0]["mb"] != "3.00" || slots[0]["mbleft"] != "1.00" {
+		t.Fatalf("expected one slot for the release, got %+v", slots)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/sabnzbd/api?mode=history&apikey=testapikey", nil))
+	if strings.Contains(rec.Body.String(), resp.IDs[0]) {
+		t.Errorf("expected the partly done release kept out of history, got %s", rec.Body)
+	}
+
+	// Deleted along with another download, the release is reported once.
+	release, other := resp.IDs[0], h.Store.Add("peer", `Movies\Ronin.1998.mkv`, 1<<20, "radarr")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/sabnzbd/api?mode=queue&name=delete&value="+release+","+other+"&apikey=testapikey", nil))
+	if n := h.Store.Len(); n != 0 {
+		t.Errorf("expected deleting the release to remove all its files, %d left", n)
+	}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if !slices.Equal(resp.IDs, []string{release, other}) {
+		t.Errorf("expected the release and the other download reported once each, got %v", resp.IDs)
+	}
+}
+
+func TestHandler_AddURL_NZBName(t *testing.T) {
+	mockSlskd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer mockSlskd.Close()
+
+	h := newTestHandler(mockSlskd.URL)
+	token := newznab.EncodeToken("user1", `TV\show.s01e01.mkv`, 10)
+	reqURL := "/sabnzbd/api?mode=addurl&apikey=testapikey&cat=sonarr&nzbname=Show.S01E01.1080p.WEB-DL.nzb&name=" + url.QueryEscape("http://localhost:6969/api?t=get&id="+token)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", reqURL, nil))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/sabnzbd/api?mode=queue&apikey=testapikey", nil))
+	if !strings.Contains(rec.Body.String(), `"filename":"Show.S01E01.1080p.WEB-DL"`) {
+		t.Errorf("expected the release name in the queue, got %s", rec.Body.String())
+	}
+
+	id := h.Store.Queue()[0].ID
+	h.Store.UpdateTransfer(id, 10, store.StatusCompleted)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/sabnzbd/api?mode=history&apikey=testapikey", nil))
+	if !strings.Contains(rec.Body.String(), `"name":"Show.S01E01.1080p.WEB-DL"`) || !strings.Contains(rec.Body.String(), `"nzb_name":"Show.S01E01.1080p.WEB-DL.nzb"`) {
+		t.Errorf("expected the release name in the history, got %s", rec.Body.String())
+	}
+}
+
+func TestHandler_Queue_Speed(t *testing.T) {
+	h := newTestHandler("")
+	id := h.Store.Add("user1", `Movies\movie.mkv`, 100<<20, "radarr")
+	h.Store.Add("user2", `Movies\other.mkv`, 50<<20, "radarr")
+	start := time.Now().Add(-10 * time.Second)
+	h.Store.UpdateTransfer(id, 40<<20, store.StatusDownloading)
+	h.Store.RecordSpeed(id, 30<<20, 0, start)
+	h.Store.RecordSpeed(id, 40<<20, 0, start.Add(10*time.Second))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/sabnzbd/api?mode=queue&apikey=testapikey", nil))
+	var resp struct {
+		Queue struct {
+			KBPerSec string           `json:"kbpersec"`
+			Speed    string           `json:"speed"`
+			MBLeft   string           `json:"mbleft"`
+			TimeLeft string           `json:"timeleft"`
+			Slots    []map[string]any `json:"slots"`
+		} `json:"queue"`
+	}
+	json.NewDecoder(rec.Body).Decode(&resp)
+
+	// 10 MB in 10 seconds, with 60 MB of this download and 50 MB queued left.
+	if q := resp.Queue; q.KBPerSec != "1024.00" || q.Speed != "1.0 M" || q.MBLeft != "110.00" || q.TimeLeft != "0:01:50" {
+		t.Errorf("expected the queue's speed and time left, got %+v", q)
+	}
+	if len(resp.Queue.Slots) != 2 {
+		t.Fatalf("expected 2 slots, got %d", len(resp.Queue.Slots))
+	}
+	slot := resp.Queue.Slots[0]
+	if slot["mbleft"] != "60.00" || slot["timeleft"] != "0:01:00" || slot["eta"] == "unknown" {
+		t.Errorf("expected the download's time left at its current speed, got %v", slot)
+	}
+	if slot := resp.Queue.Slots[1]; slot["timeleft"] != "0:00:00" || slot["eta"] != "unknown" {
+		t.Errorf("expected no estimate for a download not transferring, got %v", slot)
+	}
+}
+
+func TestHandler_AddFile(t *testing.T) {
+	var queued []slskd.DownloadRequest
+	mockSlskd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var files []slskd.DownloadRequest
+		json.NewDecoder(r.Body).Decode(&files)
+		queued = append(queued, files...)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer mockSlskd.Close()
+
+	// The NZB the Newznab API serves for the release.
+	token := newznab.FileToken{Username: "peer", Filename: `Movies\Heat.1995`, Size: 30, Files: []newznab.TokenFile{
+		{Name: `\Heat.1995.mkv`, Size: 20},
+		{Name: `\Heat.1995.srt`, Size: 10},
+	}}.Encode()
+	nzb := httptest.NewRecorder()
+	(&newznab.Handler{BaseURL: "http://localhost:6969"}).ServeHTTP(nzb, httptest.NewRequest("GET", "/api?t=get&id="+token, nil))
+
+	upload := func(field string) map[string]any {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		fw, _ := mw.CreateFormFile(field, "Heat.1995.nzb")
+		fw.Write(nzb.Body.Bytes())
+		mw.Close()
+		req := httptest.NewRequest("POST", "/sabnzbd/api?mode=addfile&apikey=testapikey&cat=radarr&nzbname=Heat.1995.1080p", &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		rec := httptest.NewRecorder()
+		h := newTestHandler(mockSlskd.URL)
+		h.ServeHTTP(rec, req)
+		var resp map[string]any
+		json.NewDecoder(rec.Body).Decode(&resp)
+		if dls := h.Store.Queue(); len(dls) != 2 || dls[0].Name != "Heat.1995.1080p" || dls[1].Category != "radarr" {
+			t.Errorf("expected the release queued like addurl would, got %+v", dls)
+		}
+		return resp
+	}
+	for _, field := range []string{"name", "nzbfile"} {
+		queued = nil
+		if ids, _ := upload(field)["nzo_ids"].([]any); len(ids) != 1 {
+			t.Errorf("%s: expected one nzo_id for the release, got %v", field, ids)
+		}
+		if len(queued) != 2 || queued[1].Filename != `Movies\Heat.1995\Heat.1995.srt` {
+			t.Errorf("%s: expected the release's files queued in slskd, got %+v", field, queued)
+		}
+	}
+
+	h := newTestHandler(mockSlskd.URL)
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/sabnzbd/api?mode=addfile&apikey=testapikey", strings.NewReader("not multipart"))
+	h.ServeHTTP(rec, req)
+	if !strings.Contains(rec.Body.String(), `"status":false`) || h.Store.Len() != 0 {
+		t.Errorf("expected a bad upload refused, got %s", rec.Body)
+	}
+}
+
 func TestHandler_Queue(t *testing.T) {
 	h := newTestHandler("")
 	h.Store.Add("user1", `C:\Movies\movie.mkv`, 1000000000, "radarr")
@@ -195,6 +556,11 @@ func TestHandler_Queue(t *testing.T) {
 	if len(slots) != 2 {
 		t.Errorf("expected 2 slots, got %d", len(slots))
 	}
+	for _, slot := range slots {
+		if slot := slot.(map[string]any); slot["soulseek_username"] == "" || !strings.HasPrefix(slot["soulseek_path"].(string), `C:\`) {
+			t.Errorf("expected the source in the slot, got %v", slot)
+		}
+	}
 }
 
 func TestHandler_History(t *testing.T) {
@@ -227,32 +593,54 @@ func TestHandler_History(t *testing.T) {
 	if slot["status"] != "Completed" {
 		t.Errorf("expected Completed, got %v", slot["status"])
 	}
-	if !strings.Contains(slot["storage"].(string), "radarr") {
-		t.Errorf("expected radarr in storage path, got %s", slot["storage"])
+	if slot["soulseek_username"] != "user1" || slot["soulseek_path"] != `C:\Movies\movie.mkv` {
+		t.Errorf("expected the source in the slot, got %v %v", slot["soulseek_username"], slot["soulseek_path"])
 	}
-	if !strings.Contains(slot["storage"].(string), "movie.mkv") {
-		t.Errorf("expected movie.mkv in storage path, got %s", slot["storage"])
+	// slskd saves into a folder named after the remote parent folder.
+	if slot["storage"] != "/downloads/complete/Movies/movie.mkv" {
+		t.Errorf("expected slskd's path as storage, got %s", slot["storage"])
 	}
 }
 
 func TestHandler_QueueDelete(t *testing.T) {
-	h := newTestHandler("")
+	var cancelled []string
+	mockSlskd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			cancelled = append(cancelled, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		json.NewEncoder(w).Encode([]slskd.UserTransferGroup{{
+			Username: "user1",
+			Directories: []slskd.DirectoryTransferGroup{{
+				Files: []slskd.Transfer{{ID: "t2", Filename: "other.mkv", State: "InProgress"}},
+			}},
+		}})
+	}))
+	defer mockSlskd.Close()
+
+	h := newTestHandler(mockSlskd.URL)
 	id := h.Store.Add("user1", "file.mkv", 1000, "radarr")
+	h.Store.SetTransferID(id, "t1")
+	fresh := h.Store.Add("user1", "other.mkv", 1000, "radarr") // not synced yet
+	kept := h.Store.Add("user1", "kept.mkv", 1000, "radarr")
 
-	req := httptest.NewRequest("GET", "/sabnzbd/api?mode=queue&name=delete&value="+id+"&apikey=testapikey", nil)
+	req := httptest.NewRequest("GET", "/sabnzbd/api?mode=queue&name=delete&value="+id+","+fresh+"&apikey=testapikey", nil)
 	rec := httptest.NewRecorder()
 	h.ServeHTTP(rec, req)
 
 	var resp map[string]any
 	json.NewDecoder(rec.Body).Decode(&resp)
 
-	if resp["status"] != true {
-		t.Error("expected status true")
+	if ids, _ := resp["nzo_ids"].([]any); resp["status"] != true || len(ids) != 2 {
+		t.Errorf("expected both downloads deleted, got %v", resp)
 	}
-
-	dl := h.Store.Get(id)
-	if dl != nil {
-		t.Error("expected download to be removed")
+	if h.Store.Get(id) != nil || h.Store.Get(fresh) != nil || h.Store.Get(kept) == nil {
+		t.Error("expected only the named downloads removed")
+	}
+	want := []string{"/api/v0/transfers/downloads/user1/t1", "/api/v0/transfers/downloads/user1/t2"}
+	if !slices.Equal(slices.Compact(cancelled), want) { // cancelled, then removed
+		t.Errorf("expected the slskd transfers cancelled, got %v", cancelled)
 	}
 }
 
@@ -312,3 +700,952 @@ func TestExtractTokenFromURL_NoID(t *testing.T) {
 		t.Fatal("expected error for URL without id param")
 	}
 }
+
+func TestHandler_RateLimited(t *testing.T) {
+	h := newTestHandler("")
+	h.Limiter = middleware.NewRateLimiter(60, 1)
+
+	req := httptest.NewRequest("GET", "/sabnzbd/api?mode=queue&apikey=testapikey", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected first request allowed, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("expected 429, got %d", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("expected Retry-After header")
+	}
+}
+
+func TestHandler_AddURL_Draining(t *testing.T) {
+	h := newTestHandler("")
+	h.Drain()
+
+	token := newznab.EncodeToken("soulseekuser", "file.mkv", 1000)
+	nzbURL := "http://localhost:6969/api?t=get&id=" + token
+	req := httptest.NewRequest("GET", "/sabnzbd/api?mode=addurl&apikey=testapikey&name="+url.QueryEscape(nzbURL), nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	var resp map[string]any
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp["status"] != false {
+		t.Error("expected grab to be refused while draining")
+	}
+	if h.Store.Len() != 0 {
+		t.Error("expected nothing queued while draining")
+	}
+}
+
+type notifyRecorder struct {
+	mu   sync.Mutex
+	msgs []notify.Message
+}
+
+func (r *notifyRecorder) Notify(_ context.Context, msg notify.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.msgs = append(r.msgs, msg)
+	return nil
+}
+
+func TestHandler_AddURL_NotifiesGrab(t *testing.T) {
+	mockSlskd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer mockSlskd.Close()
+
+	rec := &notifyRecorder{}
+	h := newTestHandler(mockSlskd.URL)
+	h.Notifier = &notify.Dispatcher{}
+	h.Notifier.Add("test", rec, notify.AllEvents)
+
+	token := newznab.EncodeToken("soulseekuser", `C:\Movies\Cool.Movie.2024.mkv`, 2000)
+	nzbURL := "http://localhost:6969/api?t=get&id=" + token
+	req := httptest.NewRequest("GET", "/sabnzbd/api?mode=addurl&apikey=testapikey&cat=radarr&name="+url.QueryEscape(nzbURL), nil)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	h.Notifier.Wait()
+
+	if len(rec.msgs) != 1 {
+		t.Fatalf("expected 1 notification, got %d", len(rec.msgs))
+	}
+	msg := rec.msgs[0]
+	if msg.Event != notify.EventGrab || msg.Download.Title != "Cool.Movie.2024.mkv" || msg.Download.Category != "radarr" {
+		t.Errorf("unexpected notification: %+v", msg)
+	}
+}
+
+func TestHandler_GrabFolder(t *testing.T) {
+	var queued []slskd.DownloadRequest
+	mockSlskd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&queued)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer mockSlskd.Close()
+
+	h := newTestHandler(mockSlskd.URL)
+	ids, err := h.Grab(context.Background(), "peer", []slskd.DownloadRequest{
+		{Filename: `Music\Album\01.flac`, Size: 10},
+		{Filename: `Music\Album\02.flac`, Size: 20},
+	}, "music")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(queued) != 2 || len(ids) != 2 {
+		t.Fatalf("expected 2 files queued and tracked, got %d and %d", len(queued), len(ids))
+	}
+	if dl := h.Store.Get(ids[1]); dl == nil || dl.Username != "peer" || dl.Category != "music" || dl.Size != 20 {
+		t.Errorf("unexpected download: %+v", dl)
+	}
+}
+
+func TestHandler_GrabCompanions(t *testing.T) {
+	var mu sync.Mutex
+	var companions [][]slskd.DownloadRequest
+	mockSlskd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/directory") {
+			w.Write([]byte(`[{"name":"Music\\Album","files":[{"filename":"01.flac"},{"filename":"Album.cue","size":900}]}]`))
+			return
+		}
+		var files []slskd.DownloadRequest
+		json.NewDecoder(r.Body).Decode(&files)
+		if len(files) == 1 && strings.HasSuffix(files[0].Filename, ".cue") {
+			mu.Lock()
+			companions = append(companions, files)
+			mu.Unlock()
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer mockSlskd.Close()
+
+	h := newTestHandler(mockSlskd.URL)
+	h.Companions = slskd.DefaultCompanions
+	for _, name := range []string{`Music\Album\01.flac`, `Music\Album\02.flac`} {
+		if _, err := h.Grab(context.Background(), "peer", []slskd.DownloadRequest{{Filename: name}}, "music"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		n := len(companions)
+		mu.Unlock()
+		if n > 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(companions) != 1 || companions[0][0].Filename != `Music\Album\Album.cue` {
+		t.Errorf("expected the cue sheet queued once, got %v", companions)
+	}
+	if h.Store.Len() != 2 {
+		t.Errorf("expected only the audio tracked, got %d downloads", h.Store.Len())
+	}
+}
+
+func TestHandler_RefreshOptions(t *testing.T) {
+	mockSlskd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"directories":{"downloads":"/data/slskd/downloads"}}`))
+	}))
+	defer mockSlskd.Close()
+
+	h := newTestHandler(mockSlskd.URL)
+	h.DownloadDir = "/downloads/complete"
+	dir, err := h.RefreshOptions(context.Background())
+	if err != nil || dir != "/downloads/complete" {
+		t.Fatalf("expected configured dir kept without discovery, got %q %v", dir, err)
+	}
+
+	h.DiscoverDownloadDir = true
+	dir, err = h.RefreshOptions(context.Background())
+	if err != nil || dir != "/data/slskd/downloads" || h.completeDir() != dir {
+		t.Errorf("expected discovered dir, got %q %v", dir, err)
+	}
+}
+
+func TestHandler_Sync_NotifiesCompletion(t *testing.T) {
+	mockSlskd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]slskd.UserTransferGroup{{
+			Username: "user1",
+			Directories: []slskd.DirectoryTransferGroup{{
+				Files: []slskd.Transfer{{ID: "t1", Filename: "file.mkv", State: "Completed, Succeeded", BytesTransferred: 100}},
+			}},
+		}})
+	}))
+	defer mockSlskd.Close()
+
+	rec := &notifyRecorder{}
+	h := newTestHandler(mockSlskd.URL)
+	h.Notifier = &notify.Dispatcher{}
+	h.Notifier.Add("test", rec, notify.AllEvents)
+	h.Stats = stats.New()
+	h.Store.Add("user1", "file.mkv", 100, "radarr")
+	bytes, firstBytes := downloadBytes.Value("radarr"), downloadFirstByte.Count("radarr")
+
+	h.syncOnce(context.Background())
+	h.syncOnce(context.Background())
+	h.Notifier.Wait()
+
+	if len(rec.msgs) != 1 || rec.msgs[0].Event != notify.EventComplete {
+		t.Fatalf("expected a single completion notification, got %+v", rec.msgs)
+	}
+	if r := h.Stats.Report(1); len(r.Categories) != 1 || r.Categories[0].Completed != 1 {
+		t.Errorf("expected one completion counted, got %+v", r.Categories)
+	}
+	if n := downloadBytes.Value("radarr") - bytes; n != 100 {
+		t.Errorf("expected 100 completed bytes counted, got %v", n)
+	}
+	if n := downloadFirstByte.Count("radarr") - firstBytes; n != 1 {
+		t.Errorf("expected one time to first byte observed, got %d", n)
+	}
+}
+
+func TestHandler_Sync_WakesOnGrab(t *testing.T) {
+	mockSlskd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		json.NewEncoder(w).Encode([]slskd.UserTransferGroup{{
+			Username: "user1",
+			Directories: []slskd.DirectoryTransferGroup{{
+				Files: []slskd.Transfer{{ID: "t1", Filename: "file.mkv", State: "InProgress", BytesTransferred: 50}},
+			}},
+		}})
+	}))
+	defer mockSlskd.Close()
+
+	h := newTestHandler(mockSlskd.URL)
+	h.SyncInterval = time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go h.SyncDownloads(ctx)
+
+	ids, err := h.Grab(context.Background(), "user1", []slskd.DownloadRequest{{Filename: "file.mkv", Size: 100}}, "radarr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for h.Store.Get(ids[0]).Status != store.StatusDownloading {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the grab to be synced before the next tick")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHandler_Sync_WakesOnTransferEvent(t *testing.T) {
+	var polls atomic.Int32
+	mockSlskd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		polls.Add(1)
+		json.NewEncoder(w).Encode([]slskd.UserTransferGroup{{
+			Username: "user1",
+			Directories: []slskd.DirectoryTransferGroup{{
+				Files: []slskd.Transfer{{ID: "t1", Filename: "file.mkv", State: "InProgress", BytesTransferred: 50}},
+			}},
+		}})
+	}))
+	defer mockSlskd.Close()
+
+	h := newTestHandler(mockSlskd.URL)
+	h.SyncInterval = time.Hour
+	id := h.Store.Add("user1", "file.mkv", 100, "radarr")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go h.SyncDownloads(ctx)
+
+	// A burst of events costs one sync.
+	for range 5 {
+		h.TransferEvent("UPDATE", nil)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for h.Store.Get(id).Status != store.StatusDownloading {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the transfer event to sync before the next tick")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(2 * hubSyncDelay)
+	if n := polls.Load(); n != 1 {
+		t.Errorf("expected one sync for the burst, got %d", n)
+	}
+}
+
+func TestHandler_Sync_OnlyInFlight(t *testing.T) {
+	var calls atomic.Int32
+	mockSlskd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		json.NewEncoder(w).Encode([]slskd.UserTransferGroup{{
+			Username: "user1",
+			Directories: []slskd.DirectoryTransferGroup{{
+				Files: []slskd.Transfer{{ID: "t1", Filename: "file.mkv", State: "InProgress", BytesTransferred: 50}},
+			}},
+		}})
+	}))
+	defer mockSlskd.Close()
+
+	h := newTestHandler(mockSlskd.URL)
+	done := h.Store.Add("user1", "old.mkv", 100, "radarr")
+	h.Store.UpdateTransfer(done, 100, store.StatusCompleted)
+
+	h.syncOnce(context.Background())
+	if calls.Load() != 0 {
+		t.Fatal("expected no slskd request with nothing in flight")
+	}
+
+	id := h.Store.Add("user1", "file.mkv", 100, "radarr")
+	h.syncOnce(context.Background())
+	if dl := h.Store.Get(id); dl.Status != store.StatusDownloading || dl.BytesDownloaded != 50 {
+		t.Errorf("expected Downloading at 50 bytes, got %s at %d", dl.Status, dl.BytesDownloaded)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expected one slskd request, got %d", calls.Load())
+	}
+	if samples, err := h.Speeds(id); err != nil || len(samples) != 1 || samples[0].BytesDownloaded != 50 {
+		t.Errorf("expected one speed sample at 50 bytes, got %v (%v)", samples, err)
+	}
+	if _, err := h.Speeds("missing"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestHandler_Sync_MaxAge(t *testing.T) {
+	mockSlskd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		json.NewEncoder(w).Encode([]slskd.UserTransferGroup{{
+			Username: "user1",
+			Directories: []slskd.DirectoryTransferGroup{{
+				Files: []slskd.Transfer{{ID: "t1", Filename: "stuck.mkv", State: "Queued, Remotely"}},
+			}},
+		}})
+	}))
+	defer mockSlskd.Close()
+
+	h := newTestHandler(mockSlskd.URL)
+	h.MaxAge = time.Hour
+	id := h.Store.Add("user1", "stuck.mkv", 100, "radarr")
+	fresh := h.Store.Add("user1", "fresh.mkv", 100, "radarr")
+
+	h.syncOnce(context.Background())
+	if dl := h.Store.Get(id); dl.Status != store.StatusQueued || dl.Retries != 0 {
+		t.Fatalf("expected a young download left alone, got %s after %d retries", dl.Status, dl.Retries)
+	}
+
+	// Each attempt gets its own MaxAge: age every attempt past it.
+	h.MaxAge = time.Nanosecond
+	for range 4 {
+		time.Sleep(time.Millisecond)
+		h.syncOnce(context.Background())
+	}
+	dl := h.Store.Get(id)
+	if dl.Status != store.StatusFailed || dl.Retries != 4 {
+		t.Fatalf("expected Failed after 3 retries, got %s after %d", dl.Status, dl.Retries)
+	}
+	if dl.Error != "exceeded max download age" {
+		t.Errorf("unexpected error: %q", dl.Error)
+	}
+	// A download slskd has no transfer for ages out too.
+	if dl := h.Store.Get(fresh); dl.Status != store.StatusFailed {
+		t.Errorf("expected the untracked download failed, got %s", dl.Status)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/sabnzbd/api?mode=history&apikey=testapikey", nil))
+	if !strings.Contains(rec.Body.String(), `"fail_message":"exceeded max download age"`) {
+		t.Errorf("expected the reason in history, got %s", rec.Body.String())
+	}
+}
+
+func TestHandler_Sync_AlternatePeer(t *testing.T) {
+	var mu sync.Mutex
+	queued := map[string][]slskd.DownloadRequest{}
+	mockSlskd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v0/transfers/downloads":
+			json.NewEncoder(w).Encode([]slskd.UserTransferGroup{{
+				Username: "user1",
+				Directories: []slskd.DirectoryTransferGroup{{
+					Files: []slskd.Transfer{{ID: "t1", Filename: `Movies\Heat.1995.mkv`, State: "Completed, Errored"}},
+				}},
+			}})
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v0/searches":
+			json.NewEncoder(w).Encode(slskd.SearchResult{ID: "s1"})
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/api/v0/searches/"):
+			json.NewEncoder(w).Encode(slskd.SearchResult{ID: "s1", IsComplete: true, Responses: []slskd.SearchResponse{
+				{Username: "user1", HasFreeUploadSlot: true, Files: []slskd.SlskdFile{{Filename: `Movies\Heat.1995.mkv`, Size: 100}}},
+				{Username: "resized", HasFreeUploadSlot: true, Files: []slskd.SlskdFile{{Filename: `Heat.1995.mkv`, Size: 99}}},
+				{Username: "queued", QueueLength: 20, Files: []slskd.SlskdFile{{Filename: `Films\heat.1995.MKV`, Size: 100}}},
+				{Username: "free", HasFreeUploadSlot: true, Files: []slskd.SlskdFile{{Filename: `Films\Heat.1995.mkv`, Size: 100}}},
+			}})
+		case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/api/v0/transfers/downloads/"):
+			var files []slskd.DownloadRequest
+			json.NewDecoder(r.Body).Decode(&files)
+			mu.Lock()
+			queued[strings.TrimPrefix(r.URL.Path, "/api/v0/transfers/downloads/")] = files
+			mu.Unlock()
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer mockSlskd.Close()
+
+	h := newTestHandler(mockSlskd.URL)
+	h.AlternateSearch = 5 * time.Second
+	id := h.Store.Add("user1", `Movies\Heat.1995.mkv`, 100, "radarr")
+
+	h.syncOnce(context.Background())
+	// The download is left alone while another peer is looked for.
+	h.syncOnce(context.Background())
+	if dl := h.Store.Get(id); dl.Retries != 1 {
+		t.Fatalf("expected one retry while searching, got %d", dl.Retries)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if _, ok := h.rerouting.Load(id); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("retry never finished")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	dl := h.Store.Get(id)
+	if dl.Username != "free" || dl.Filename != `Films\Heat.1995.mkv` || dl.Status != store.StatusQueued {
+		t.Errorf("expected the download moved to the free peer, got %+v", dl)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if files := queued["free"]; len(files) != 1 || files[0].Filename != `Films\Heat.1995.mkv` || len(queued) != 1 {
+		t.Errorf("expected the file queued from the free peer only, got %+v", queued)
+	}
+}
+
+type stepFunc func(*postprocess.File) error
+
+func (s stepFunc) Name() string { return "test" }
+
+func (s stepFunc) Run(_ context.Context, f *postprocess.File) error { return s(f) }
+
+func TestHandler_Sync_PostProcess(t *testing.T) {
+	mockSlskd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]slskd.UserTransferGroup{{
+			Username: "user1",
+			Directories: []slskd.DirectoryTransferGroup{{
+				Files: []slskd.Transfer{{ID: "t1", Filename: `Music\Album\01 Track?.flac`, State: "Completed, Succeeded", BytesTransferred: 100}},
+			}},
+		}})
+	}))
+	defer mockSlskd.Close()
+
+	release := make(chan struct{})
+	var got postprocess.File
+	h := newTestHandler(mockSlskd.URL)
+	h.PostProcess = &postprocess.Pipeline{Steps: []postprocess.Step{stepFunc(func(f *postprocess.File) error {
+		<-release
+		got = *f
+		f.Path = "/downloads/complete/Album/01 Track_.flac"
+		return nil
+	})}}
+	id := h.Store.Add("user1", `Music\Album\01 Track?.flac`, 100, "lidarr")
+
+	h.syncOnce(context.Background())
+	if dl := h.Store.Get(id); dl.Status != store.StatusProcessing {
+		t.Fatalf("expected Processing while the pipeline runs, got %s", dl.Status)
+	}
+	req := httptest.NewRequest("GET", "/api?mode=history&apikey=testapikey", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if !strings.Contains(w.Body.String(), `"status":"Running"`) {
+		t.Errorf("expected Running in history, got %s", w.Body.String())
+	}
+
+	h.syncOnce(context.Background()) // must not start a second run
+	close(release)
+	for deadline := time.Now().Add(2 * time.Second); h.Store.Get(id).Status == store.StatusProcessing; {
+		if time.Now().After(deadline) {
+			t.Fatal("post-processing did not finish")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if got.Path != "/downloads/complete/Album/01 Track?.flac" || got.Root != "/downloads/complete" {
+		t.Errorf("unexpected file passed to pipeline: %+v", got)
+	}
+	dl := h.Store.Get(id)
+	if dl.Status != store.StatusCompleted || dl.Path != "/downloads/complete/Album/01 Track_.flac" {
+		t.Errorf("expected Completed at the new path, got %s %q", dl.Status, dl.Path)
+	}
+}
+
+func TestHandler_Sync_PostProcessCorrupt(t *testing.T) {
+	var mu sync.Mutex
+	present, queued, removed := true, 0, 0
+	mockSlskd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodDelete:
+			if r.URL.Query().Get("remove") == "true" {
+				present = false
+				removed++
+			}
+		case http.MethodPost:
+			present = true
+			queued++
+			w.WriteHeader(http.StatusCreated)
+		default:
+			groups := []slskd.UserTransferGroup{}
+			if present {
+				groups = append(groups, slskd.UserTransferGroup{
+					Username: "user1",
+					Directories: []slskd.DirectoryTransferGroup{{
+						Files: []slskd.Transfer{{ID: "t1", Filename: `Music\Album\01 Track.flac`, State: "Completed, Succeeded", BytesTransferred: 100}},
+					}},
+				})
+			}
+			json.NewEncoder(w).Encode(groups)
+		}
+	}))
+	defer mockSlskd.Close()
+
+	h := newTestHandler(mockSlskd.URL)
+	h.DownloadDir = t.TempDir()
+	var paths []string
+	h.PostProcess = &postprocess.Pipeline{Steps: []postprocess.Step{stepFunc(func(f *postprocess.File) error {
+		os.MkdirAll(filepath.Dir(f.Path), 0o755)
+		os.WriteFile(f.Path, []byte("damaged"), 0o644)
+		mu.Lock()
+		paths = append(paths, f.Path)
+		mu.Unlock()
+		return fmt.Errorf("%w: frame CRC mismatch", postprocess.ErrCorrupt)
+	})}}
+	id := h.Store.Add("user1", `Music\Album\01 Track.flac`, 100, "lidarr")
+
+	// Each completion is found corrupt and fetched again, until the retries
+	// run out and the download fails. The download leaves Processing before
+	// it is queued again, so wait for that too before the next sync.
+	finished := func(attempt int) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return h.Store.Get(id).Status != store.StatusProcessing && (attempt == 4 || queued == attempt)
+	}
+	for attempt := 1; attempt <= 4; attempt++ {
+		h.syncOnce(context.Background())
+		for deadline := time.Now().Add(10 * time.Second); !finished(attempt); {
+			if time.Now().After(deadline) {
+				t.Fatal("post-processing did not finish")
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		mu.Lock()
+		path := paths[len(paths)-1]
+		mu.Unlock()
+		if _, err := os.Stat(path); attempt < 4 && !os.IsNotExist(err) {
+			t.Errorf("attempt %d: expected the corrupt file removed, got %v", attempt, err)
+		}
+	}
+
+	dl := h.Store.Get(id)
+	if dl.Status != store.StatusFailed || !strings.Contains(dl.Error, "CRC") {
+		t.Errorf("expected Failed with the corruption after 3 retries, got %s %q", dl.Status, dl.Error)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(paths) != 4 || queued != 3 || removed != 3 {
+		t.Errorf("expected 4 checks, 3 refetches and 3 removed transfers, got %d, %d and %d", len(paths), queued, removed)
+	}
+}
+
+func TestHandler_Retry(t *testing.T) {
+	var queued bool
+	mockSlskd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			queued = true
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer mockSlskd.Close()
+
+	h := newTestHandler(mockSlskd.URL)
+	id := h.Store.Add("user1", "file.mkv", 100, "radarr")
+
+	if err := h.Retry(context.Background(), id); err != store.ErrNotFailed {
+		t.Errorf("expected ErrNotFailed for a queued download, got %v", err)
+	}
+
+	h.Store.UpdateTransfer(id, 10, store.StatusFailed)
+	if err := h.Retry(context.Background(), id); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !queued {
+		t.Error("expected the file to be re-queued in slskd")
+	}
+	if dl := h.Store.Get(id); dl.Status != store.StatusQueued {
+		t.Errorf("expected Queued, got %s", dl.Status)
+	}
+}
+
+func TestHandler_ModeRetry(t *testing.T) {
+	var queued []slskd.DownloadRequest
+	mockSlskd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			var files []slskd.DownloadRequest
+			json.NewDecoder(r.Body).Decode(&files)
+			queued = append(queued, files...)
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer mockSlskd.Close()
+
+	h := newTestHandler(mockSlskd.URL)
+	first := h.Store.Add("user1", `Album\01.flac`, 100, "lidarr")
+	second := h.Store.Add("user1", `Album\02.flac`, 100, "lidarr")
+	h.Store.SetGroup(second, first)
+	h.Store.UpdateTransfer(first, 100, store.StatusCompleted)
+	h.Store.UpdateTransfer(second, 10, store.StatusFailed)
+	for range 3 {
+		h.Store.IncrementRetry(second)
+	}
+	h.Store.Fail(second, "Completed, Errored")
+
+	retry := func(value string) map[string]any {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/sabnzbd/api?mode=retry&apikey=testapikey&value="+value, nil))
+		var resp map[string]any
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return resp
+	}
+	if resp := retry(first); resp["status"] != true || resp["nzo_id"] != first {
+		t.Fatalf("expected the release retried, got %v", resp)
+	}
+	if len(queued) != 1 || queued[0].Filename != `Album\02.flac` {
+		t.Errorf("expected only the failed file re-queued in slskd, got %+v", queued)
+	}
+	if dl := h.Store.Get(second); dl.Status != store.StatusQueued || dl.Retries != 0 || dl.Error != "" {
+		t.Errorf("expected the file back in the queue with its retries reset, got %+v", dl)
+	}
+	if dl := h.Store.Get(first); dl.Status != store.StatusCompleted {
+		t.Errorf("expected the finished file left alone, got %s", dl.Status)
+	}
+
+	if resp := retry(first); resp["status"] != false {
+		t.Errorf("expected a release with nothing failed refused, got %v", resp)
+	}
+	if resp := retry("SABnzbd_nzo_missing"); resp["status"] != false {
+		t.Errorf("expected an unknown nzo_id refused, got %v", resp)
+	}
+}
+
+func TestHandler_Cancel(t *testing.T) {
+	h := newTestHandler("")
+	id := h.Store.Add("user1", "file.mkv", 100, "radarr")
+
+	if err := h.Cancel(context.Background(), id); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if h.Store.Get(id) != nil {
+		t.Error("expected download to be removed")
+	}
+	if err := h.Cancel(context.Background(), id); err != store.ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestParseSpeedLimit(t *testing.T) {
+	tests := []struct {
+		value string
+		max   int64
+		want  int64
+		err   bool
+	}{
+		{"", 0, 0, false},
+		{"100", 0, 0, false},
+		{"500K", 0, 500 << 10, false},
+		{"1.5m", 0, 3 << 19, false},
+		{"50", 10 << 20, 5 << 20, false},
+		{"50%", 10 << 20, 5 << 20, false},
+		{"50", 0, 0, true},
+		{"150", 10 << 20, 0, true},
+		{"fast", 0, 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseSpeedLimit(tt.value, tt.max)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("parseSpeedLimit(%q, %d) = %d, %v; want %d, error %v", tt.value, tt.max, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestHandler_Config_SpeedLimit(t *testing.T) {
+	var overlay map[string]any
+	mockSlskd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch || r.URL.Path != "/api/v0/options" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		json.NewDecoder(r.Body).Decode(&overlay)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer mockSlskd.Close()
+
+	h := newTestHandler(mockSlskd.URL)
+	h.BandwidthMax = 10 << 20
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api?mode=config&name=speedlimit&value=20&apikey=testapikey", nil))
+	if !strings.Contains(w.Body.String(), `"status":true`) {
+		t.Fatalf("expected success, got %s", w.Body.String())
+	}
+	dl := overlay["global"].(map[string]any)["download"].(map[string]any)
+	if dl["speedLimit"] != float64(2048) {
+		t.Errorf("expected 2048 KiB/s sent to slskd, got %v", dl["speedLimit"])
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api?mode=queue&apikey=testapikey", nil))
+	if !strings.Contains(w.Body.String(), `"speedlimit":"20"`) {
+		t.Errorf("expected the limit reported in the queue, got %s", w.Body.String())
+	}
+}
+
+func TestHandler_Purge(t *testing.T) {
+	var mu sync.Mutex
+	var cancelled []string
+	mockSlskd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			json.NewEncoder(w).Encode([]slskd.UserTransferGroup{{
+				Username: "user1",
+				Directories: []slskd.DirectoryTransferGroup{{
+					Files: []slskd.Transfer{{ID: "t1", Filename: "queued.mkv", State: "Queued, Remotely"}},
+				}},
+			}})
+		case "DELETE":
+			if r.URL.RawQuery == "" {
+				mu.Lock()
+				cancelled = append(cancelled, path.Base(r.URL.Path))
+				mu.Unlock()
+			}
+		}
+	}))
+	defer mockSlskd.Close()
+
+	h := newTestHandler(mockSlskd.URL)
+	queued := h.Store.Add("user1", "queued.mkv", 100, "radarr")
+	failed := h.Store.Add("user1", "failed.mkv", 100, "radarr")
+	h.Store.SetTransferID(failed, "t2")
+	h.Store.UpdateTransfer(failed, 10, store.StatusFailed)
+	completed := h.Store.Add("user1", "done.mkv", 100, "radarr")
+	h.Store.UpdateTransfer(completed, 100, store.StatusCompleted)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api?mode=queue&name=purge&apikey=testapikey", nil))
+	if !strings.Contains(w.Body.String(), `"nzo_ids":["`+queued+`"]`) || h.Store.Get(queued) != nil {
+		t.Errorf("expected the queue purged, got %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api?mode=history&name=delete&value=all&failed_only=1&apikey=testapikey", nil))
+	if !strings.Contains(w.Body.String(), `"nzo_ids":["`+failed+`"]`) || h.Store.Get(completed) == nil {
+		t.Errorf("expected only the failed download deleted, got %s", w.Body.String())
+	}
+	if !slices.Equal(cancelled, []string{"t1", "t2"}) {
+		t.Errorf("expected both transfers cancelled in slskd, got %v", cancelled)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api?mode=history&name=delete&value=all&apikey=testapikey", nil))
+	if h.Store.Len() != 0 {
+		t.Errorf("expected the history cleared, got %s", w.Body.String())
+	}
+}
+
+func TestHandler_QueueFilter(t *testing.T) {
+	h := newTestHandler("")
+	movie := h.Store.Add("user1", `Movies\The Matrix (1999).mkv`, 100, "radarr")
+	episode := h.Store.Add("user1", `TV\The Wire S01E01.mkv`, 100, "sonarr")
+	h.Store.Add("user2", `TV\Matrix Reloaded.mkv`, 100, "sonarr")
+
+	slots := func(query string) []string {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/api?mode=queue&apikey=testapikey&"+query, nil))
+		var resp struct {
+			Queue struct {
+				Slots []struct {
+					ID string `json:"nzo_id"`
+				}
+				Total int `json:"noofslots_total"`
+			}
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		if resp.Queue.Total != 3 {
+			t.Errorf("expected the unfiltered total reported, got %d", resp.Queue.Total)
+		}
+		var ids []string
+		for _, s := range resp.Queue.Slots {
+			ids = append(ids, s.ID)
+		}
+		return ids
+	}
+
+	if got := slots("search=matrix&cat=radarr"); !slices.Equal(got, []string{movie}) {
+		t.Errorf("expected only the movie, got %v", got)
+	}
+	if got := slots("cat=Sonarr&search=wire"); !slices.Equal(got, []string{episode}) {
+		t.Errorf("expected only the episode, got %v", got)
+	}
+	if got := slots("nzo_ids=" + movie + "," + episode + "&cat=*"); len(got) != 2 {
+		t.Errorf("expected both listed downloads, got %v", got)
+	}
+	if got := slots("search=nothing"); len(got) != 0 {
+		t.Errorf("expected no matches, got %v", got)
+	}
+}
+
+func TestHandler_Switch(t *testing.T) {
+	h := newTestHandler("")
+	a := h.Store.Add("user1", "a.mkv", 100, "radarr")
+	b := h.Store.Add("user1", "b.mkv", 100, "radarr")
+	c := h.Store.Add("user1", "c.mkv", 100, "radarr")
+
+	switchTo := func(id, target string) string {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/api?mode=switch&apikey=testapikey&value="+id+"&value2="+target, nil))
+		return w.Body.String()
+	}
+	order := func() []string {
+		var ids []string
+		for _, dl := range h.Store.Queue() {
+			ids = append(ids, dl.ID)
+		}
+		return ids
+	}
+
+	if body := switchTo(c, "0"); !strings.Contains(body, `"position":0`) || !slices.Equal(order(), []string{c, a, b}) {
+		t.Errorf("expected c moved to the front, got %s %v", body, order())
+	}
+	if body := switchTo(c, b); !strings.Contains(body, `"position":2`) || !slices.Equal(order(), []string{a, b, c}) {
+		t.Errorf("expected c moved to b's place, got %s %v", body, order())
+	}
+	if body := switchTo("SABnzbd_nzo_unknown", "0"); !strings.Contains(body, `"status":false`) {
+		t.Errorf("expected an error for an unknown download, got %s", body)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api?mode=queue&apikey=testapikey", nil))
+	if i, j := strings.Index(w.Body.String(), a), strings.Index(w.Body.String(), c); i > j {
+		t.Errorf("expected the queue listed in order, got %s", w.Body.String())
+	}
+}
+
+func TestHandler_Switch_MultiFileNZO(t *testing.T) {
+	h := newTestHandler("")
+	a := h.Store.Add("user1", "a.mkv", 100, "lidarr")
+	first := h.Store.Add("user1", `Album\01.flac`, 100, "lidarr")
+	b := h.Store.Add("user1", "b.mkv", 100, "lidarr")
+	second := h.Store.Add("user1", `Album\02.flac`, 100, "lidarr")
+	h.Store.SetGroup(second, first)
+
+	switchTo := func(id, target string) string {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/api?mode=switch&apikey=testapikey&value="+id+"&value2="+target, nil))
+		return w.Body.String()
+	}
+	order := func() []string {
+		var ids []string
+		for _, dl := range h.Store.Queue() {
+			ids = append(ids, dl.ID)
+		}
+		return ids
+	}
+
+	// The queue's slots are a, the album and b; the album moves whole.
+	if body := switchTo(first, "0"); !strings.Contains(body, `"position":0`) || !slices.Equal(order(), []string{first, second, a, b}) {
+		t.Errorf("expected the album moved to the front, got %s %v", body, order())
+	}
+	// b takes the album's slot, given by its NZO ID.
+	if body := switchTo(b, first); !strings.Contains(body, `"position":0`) || !slices.Equal(order(), []string{b, first, second, a}) {
+		t.Errorf("expected b moved to the album's place, got %s %v", body, order())
+	}
+	if body := switchTo(first, "2"); !strings.Contains(body, `"position":2`) || !slices.Equal(order(), []string{b, a, first, second}) {
+		t.Errorf("expected the album moved last, got %s %v", body, order())
+	}
+	// A file's own ID isn't an NZO the client knows.
+	for _, body := range []string{switchTo(second, "0"), switchTo(a, second)} {
+		if !strings.Contains(body, `"status":false`) {
+			t.Errorf("expected an error for a file ID within a release, got %s", body)
+		}
+	}
+}
+
+func TestHandler_PauseResume(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	mockSlskd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls = append(calls, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		if r.Method == "GET" {
+			json.NewEncoder(w).Encode([]slskd.UserTransferGroup{{
+				Username: "user1",
+				Directories: []slskd.DirectoryTransferGroup{{
+					Files: []slskd.Transfer{{ID: "t1", Filename: "file.mkv", State: "InProgress"}},
+				}},
+			}})
+		}
+	}))
+	defer mockSlskd.Close()
+
+	h := newTestHandler(mockSlskd.URL)
+	id := h.Store.Add("user1", "file.mkv", 100, "radarr")
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api?mode=pause&apikey=testapikey", nil))
+	if !strings.Contains(w.Body.String(), `"status":true`) {
+		t.Fatalf("expected pause to succeed, got %s", w.Body.String())
+	}
+	if dl := h.Store.Get(id); dl.Status != store.StatusPaused {
+		t.Errorf("expected Paused, got %s", dl.Status)
+	}
+	if !slices.Contains(calls, "DELETE /api/v0/transfers/downloads/user1/t1") {
+		t.Errorf("expected the transfer cancelled in slskd, got %v", calls)
+	}
+
+	// Grabs while paused are held back from slskd.
+	calls = nil
+	ids, err := h.Grab(context.Background(), "user1", []slskd.DownloadRequest{{Filename: "other.mkv", Size: 10}}, "radarr")
+	if err != nil || h.Store.Get(ids[0]).Status != store.StatusPaused || len(calls) != 0 {
+		t.Errorf("expected a paused grab without slskd calls, got %v %v", err, calls)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api?mode=queue&apikey=testapikey", nil))
+	if !strings.Contains(w.Body.String(), `"paused":true`) {
+		t.Errorf("expected the queue reported paused, got %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api?mode=resume&apikey=testapikey", nil))
+	if !strings.Contains(w.Body.String(), `"status":true`) {
+		t.Fatalf("expected resume to succeed, got %s", w.Body.String())
+	}
+	for _, id := range []string{id, ids[0]} {
+		if dl := h.Store.Get(id); dl.Status != store.StatusQueued {
+			t.Errorf("expected %s Queued after resume, got %s", dl.Filename, dl.Status)
+		}
+	}
+	if n := len(slices.DeleteFunc(calls, func(c string) bool { return !strings.HasPrefix(c, "POST ") })); n != 2 {
+		t.Errorf("expected both downloads re-queued in slskd, got %v", calls)
+	}
+}
@@ -0,0 +1,19 @@
+package sabnzbd
+
+import "github.com/nerney/slskrr/metrics"
+
+// Download pipeline metrics, labeled by the grab's category.
+var (
+	downloadRetries = metrics.NewCounter("slskrr_download_retries_total",
+		"Failed transfers queued again, automatically (kind=auto), after verification found them damaged (kind=corrupt) or through the admin API (kind=manual).",
+		"category", "kind")
+	downloadFallbacks = metrics.NewCounter("slskrr_download_fallbacks_total",
+		"Failed transfers retried from another peer sharing the same file.",
+		"category")
+	downloadBytes = metrics.NewCounter("slskrr_download_completed_bytes_total",
+		"Bytes of completed downloads.",
+		"category")
+	downloadFirstByte = metrics.NewHistogram("slskrr_download_first_byte_seconds",
+		"Time from a grab to the first bytes arriving, for transfers that weren't retried.",
+		metrics.ExponentialBuckets(1, 3, 9), "category")
+)
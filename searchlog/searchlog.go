@@ -0,0 +1,181 @@
+// Package searchlog remembers recent newznab searches: which slskd searches
+// each request ran, what it returned to the app, and which of those results
+// were grabbed, so a search that returned junk can be traced afterwards.
+package searchlog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nerney/slskrr/slskd"
+)
+
+var ErrNotFound = errors.New("search not found")
+
+// Search is one newznab search request.
+type Search struct {
+	RequestID string    `json:"requestId"`
+	Time      time.Time `json:"time"`
+	Client    string    `json:"client,omitempty"` // API key label
+	Action    string    `json:"action"`           // the newznab function, e.g. tvsearch
+	Query     string    `json:"query"`
+	Slskd     []Query   `json:"slskd"`
+	Results   []Result  `json:"results"`
+	Grabs     []string  `json:"grabs,omitempty"` // download IDs
+	Error     string    `json:"error,omitempty"` // why the search failed
+
+	// Responses are the raw peer responses slskd returned. They are kept in
+	// memory only, so they are gone after a restart.
+	Responses []slskd.SearchResponse `json:"responses,omitempty"`
+}
+
+// Query is one slskd search run for a request.
+type Query struct {
+	Query    string `json:"query"`
+	SearchID string `json:"searchId,omitempty"` // empty when the responses came from the query cooldown cache
+}
+
+// Result is a file returned to the app.
+type Result struct {
+	Username string `json:"username"`
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+}
+
+// Log keeps the most recent searches. A nil log records nothing.
+type Log struct {
+	mu       sync.Mutex
+	searches []*Search // oldest first
+	size     int
+	path     string
+}
+
+// New returns a log keeping the last size searches.
+func New(size int) *Log {
+	return &Log{size: size}
+}
+
+// Record adds a search, dropping the oldest beyond the log's size.
+func (l *Log) Record(s *Search) error {
+	if l == nil || l.size <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.searches = append(l.searches, s)
+	if n := len(l.searches) - l.size; n > 0 {
+		l.searches = slices.Delete(l.searches, 0, n)
+	}
+	return l.save()
+}
+
+// Grabbed attributes a download to the most recent search that returned
+// the file, or the release folder it is in.
+func (l *Log) Grabbed(username, filename, downloadID string) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, s := range slices.Backward(l.searches) {
+		if slices.ContainsFunc(s.Results, func(r Result) bool {
+			return r.Username == username && (r.Filename == filename || r.Filename == slskd.Directory(filename))
+		}) {
+			s.Grabs = append(s.Grabs, downloadID)
+			return l.save()
+		}
+	}
+	return nil
+}
+
+// List returns the searches without their raw responses, newest first.
+func (l *Log) List() []Search {
+	if l == nil {
+		return []Search{}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	list := make([]Search, 0, len(l.searches))
+	for _, s := range slices.Backward(l.searches) {
+		cp := *s
+		cp.Responses = nil
+		list = append(list, cp)
+	}
+	return list
+}
+
+// Get returns the search made by the request with the given ID.
+func (l *Log) Get(requestID string) (Search, error) {
+	if l == nil {
+		return Search{}, ErrNotFound
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, s := range l.searches {
+		if s.RequestID == requestID {
+			return *s, nil
+		}
+	}
+	return Search{}, ErrNotFound
+}
+
+// Load reads searches previously saved to path and makes later changes save
+// there. A missing file is not an error.
+func (l *Log) Load(path string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.path = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read search log file: %w", err)
+	}
+	if err := json.Unmarshal(data, &l.searches); err != nil {
+		return fmt.Errorf("decode search log file: %w", err)
+	}
+	if n := len(l.searches) - l.size; n > 0 {
+		l.searches = slices.Delete(l.searches, 0, n)
+	}
+	return nil
+}
+
+// save writes the searches, without their raw responses, atomically.
+// Callers must hold l.mu.
+func (l *Log) save() error {
+	if l.path == "" {
+		return nil
+	}
+	searches := make([]Search, len(l.searches))
+	for i, s := range l.searches {
+		searches[i] = *s
+		searches[i].Responses = nil
+	}
+	data, err := json.MarshalIndent(searches, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode search log: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o755); err != nil {
+		return fmt.Errorf("create search log dir: %w", err)
+	}
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("write search log file: %w", err)
+	}
+	if err := os.Rename(tmp, l.path); err != nil {
+		return fmt.Errorf("replace search log file: %w", err)
+	}
+	return nil
+}
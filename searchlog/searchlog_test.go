@@ -0,0 +1,68 @@
+package searchlog
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/nerney/slskrr/slskd"
+)
+
+func TestLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "searches.json")
+	l := New(2)
+	if err := l.Load(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, id := range []string{"a", "b", "c"} {
+		err := l.Record(&Search{
+			RequestID: id,
+			Query:     "heat",
+			Results:   []Result{{Username: "peer", Filename: "heat.mkv"}},
+			Responses: []slskd.SearchResponse{{Username: "peer"}},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if _, err := l.Get("a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected the oldest search dropped, got %v", err)
+	}
+
+	// A grab goes to the newest search that returned the file.
+	l.Grabbed("peer", "heat.mkv", "nzo_1")
+	l.Grabbed("other", "heat.mkv", "nzo_2")
+	if s, _ := l.Get("c"); len(s.Grabs) != 1 || s.Grabs[0] != "nzo_1" || len(s.Responses) != 1 {
+		t.Errorf("unexpected search: %+v", s)
+	}
+	if s, _ := l.Get("b"); len(s.Grabs) != 0 {
+		t.Errorf("expected no grabs on the older search, got %v", s.Grabs)
+	}
+
+	list := l.List()
+	if len(list) != 2 || list[0].RequestID != "c" || list[0].Responses != nil {
+		t.Errorf("expected newest first without responses, got %+v", list)
+	}
+
+	reloaded := New(2)
+	if err := reloaded.Load(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s, err := reloaded.Get("c")
+	if err != nil || len(s.Grabs) != 1 || s.Responses != nil {
+		t.Errorf("expected the mapping persisted without responses, got %+v (%v)", s, err)
+	}
+
+	// A track of a release result is attributed to the release.
+	l.Record(&Search{RequestID: "d", Results: []Result{{Username: "peer", Filename: `Music\Album`}}})
+	l.Grabbed("peer", `Music\Album\01.flac`, "nzo_3")
+	if s, _ := l.Get("d"); len(s.Grabs) != 1 || s.Grabs[0] != "nzo_3" {
+		t.Errorf("expected the track's grab on the release's search, got %v", s.Grabs)
+	}
+
+	var nilLog *Log
+	if err := nilLog.Record(&Search{}); err != nil || len(nilLog.List()) != 0 {
+		t.Error("expected a nil log to record nothing")
+	}
+}
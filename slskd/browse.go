@@ -0,0 +1,114 @@
+package slskd
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+)
+
+// DefaultCompanions are the patterns of files that complete an audio rip:
+// the cue sheet, the ripper's log, playlists and cover art.
+var DefaultCompanions = []string{"*.cue", "*.log", "*.m3u", "*.m3u8", "*.jpg", "*.jpeg", "*.png"}
+
+// BrowseDirectory lists the files a peer shares in one directory. Filenames
+// are returned in full, with the peer's separators.
+func (c *Client) BrowseDirectory(ctx context.Context, username, directory string) ([]SlskdFile, error) {
+	body, err := json.Marshal(map[string]string{"directory": directory})
+	if err != nil {
+		return nil, fmt.Errorf("marshal directory request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/api/v0/users/"+url.PathEscape(username)+"/directory", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("create directory request: %w", err)
+	}
+	c.setHeaders(req)
+
+	resp, err := c.do(req, "slskd.users.directory")
+	if err != nil {
+		return nil, fmt.Errorf("execute directory request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("directory request failed with status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	var dirs []struct {
+		Name  string      `json:"name"`
+		Files []SlskdFile `json:"files"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&dirs); err != nil {
+		return nil, fmt.Errorf("decode directory response: %w", err)
+	}
+	var files []SlskdFile
+	for _, d := range dirs {
+		for _, f := range d.Files {
+			// slskd lists bare names; make them full paths like search results.
+			if !strings.ContainsAny(f.Filename, `\/`) {
+				f.Filename = strings.TrimRight(cmp.Or(d.Name, directory), `\/`) + `\` + f.Filename
+			}
+			files = append(files, f)
+		}
+	}
+	return files, nil
+}
+
+// Companions browses the directories files come from and returns the
+// other files there whose names match one of patterns (path.Match syntax,
+// case-insensitive), such as an album's cue sheet and cover art.
+func (c *Client) Companions(ctx context.Context, username string, files []DownloadRequest, patterns []string) ([]DownloadRequest, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	grabbed := make(map[string]bool, len(files))
+	var dirs []string
+	for _, f := range files {
+		grabbed[f.Filename] = true
+		if dir := Directory(f.Filename); dir != "" && !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	var companions []DownloadRequest
+	for _, dir := range dirs {
+		shared, err := c.BrowseDirectory(ctx, username, dir)
+		if err != nil {
+			return companions, fmt.Errorf("browse %s: %w", dir, err)
+		}
+		for _, f := range shared {
+			if !grabbed[f.Filename] && matchesAny(f.Filename, patterns) {
+				grabbed[f.Filename] = true
+				companions = append(companions, DownloadRequest{Filename: f.Filename, Size: f.Size})
+			}
+		}
+	}
+	return companions, nil
+}
+
+// Directory returns the directory part of a peer's file path, without the
+// trailing separator.
+func Directory(filename string) string {
+	if i := strings.LastIndexAny(filename, `\/`); i >= 0 {
+		return filename[:i]
+	}
+	return ""
+}
+
+func matchesAny(filename string, patterns []string) bool {
+	name := strings.ToLower(path.Base(strings.ReplaceAll(filename, `\`, "/")))
+	for _, p := range patterns {
+		if ok, _ := path.Match(strings.ToLower(p), name); ok {
+			return true
+		}
+	}
+	return false
+}
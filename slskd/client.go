@@ -4,18 +4,45 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"iter"
 	"log/slog"
 	"math"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/nerney/slskrr/middleware"
+	"github.com/nerney/slskrr/textnorm"
+	"github.com/nerney/slskrr/tracing"
 )
 
 type Client struct {
 	BaseURL    string
 	APIKey     string
 	HTTPClient *http.Client
+	OptionsTTL time.Duration // how long GetOptions reuses a response; zero disables caching
+
+	// MaxSearches caps the searches SearchAndWait runs at once, queueing
+	// the rest; zero means no limit. A queued search fails with
+	// ErrSearchQueueTimeout after QueueTimeout, or waits on its context
+	// when that's zero.
+	MaxSearches  int
+	QueueTimeout time.Duration
+
+	mu       sync.Mutex
+	searches map[string]*activeSearch // in-flight searches started by SearchAndWait
+
+	slotsOnce sync.Once
+	slots     chan struct{} // one per running search, see acquireSearch
+	queued    atomic.Int64  // searches waiting for a slot
+
+	optsMu sync.Mutex
+	opts   map[string]any
+	optsAt time.Time
 }
 
 func NewClient(baseURL, apiKey string) *Client {
@@ -48,18 +75,63 @@ type SearchResult struct {
 	IsComplete    bool             `json:"isComplete"`
 	ResponseCount int              `json:"responseCount"`
 	FileCount     int              `json:"fileCount"`
+	StartedAt     Timestamp        `json:"startedAt"`
 	Responses     []SearchResponse `json:"responses,omitempty"`
 }
 
+// Timestamp is a time from the slskd API. slskd sometimes omits the zone,
+// in which case the time is UTC.
+type Timestamp struct {
+	time.Time
+}
+
+func (t *Timestamp) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil || s == "" {
+		return nil // null or not a string: leave zero
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999"} {
+		if parsed, err := time.Parse(layout, s); err == nil {
+			t.Time = parsed
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid slskd timestamp %q", s)
+}
+
 type SearchResponse struct {
-	Username        string      `json:"username"`
-	FileCount       int         `json:"fileCount"`
-	Files           []SlskdFile `json:"files"`
-	LockedFileCount int         `json:"lockedFileCount"`
-	LockedFiles     []SlskdFile `json:"lockedFiles"`
-	HasFreeUploadSlot bool     `json:"hasFreeUploadSlot"`
-	UploadSpeed     int64       `json:"uploadSpeed"`
-	QueueLength     int         `json:"queueLength"`
+	Username          string      `json:"username"`
+	FileCount         int         `json:"fileCount"`
+	Files             []SlskdFile `json:"files"`
+	LockedFileCount   int         `json:"lockedFileCount"`
+	LockedFiles       []SlskdFile `json:"lockedFiles"`
+	HasFreeUploadSlot bool        `json:"hasFreeUploadSlot"`
+	UploadSpeed       int64       `json:"uploadSpeed"`
+	QueueLength       int         `json:"queueLength"`
+}
+
+// PeerScore ranks a response by how soon its peer is likely to deliver: a
+// free upload slot counts most, then a short queue and fast uploads.
+func (r *SearchResponse) PeerScore() int {
+	score := -min(r.QueueLength, 400) + int(min(r.UploadSpeed/(100*1024), 99))
+	if r.HasFreeUploadSlot {
+		score += 500
+	}
+	return score
+}
+
+// AllFiles yields the response's files followed by its locked files,
+// without copying either slice.
+func (r *SearchResponse) AllFiles() iter.Seq[*SlskdFile] {
+	return func(yield func(*SlskdFile) bool) {
+		for _, files := range [][]SlskdFile{r.Files, r.LockedFiles} {
+			for i := range files {
+				if !yield(&files[i]) {
+					return
+				}
+			}
+		}
+	}
 }
 
 type SlskdFile struct {
@@ -104,7 +176,7 @@ type DirectoryTransferGroup struct {
 // Search starts a new search on slskd.
 func (c *Client) Search(ctx context.Context, query string, timeout time.Duration) (string, error) {
 	req := SearchRequest{
-		SearchText:               query,
+		SearchText:               textnorm.NFC(query),
 		SearchTimeout:            int(timeout.Milliseconds()),
 		FileLimit:                10000,
 		FilterResponses:          true,
@@ -125,7 +197,7 @@ func (c *Client) Search(ctx context.Context, query string, timeout time.Duration
 	}
 	c.setHeaders(httpReq)
 
-	resp, err := c.HTTPClient.Do(httpReq)
+	resp, err := c.do(httpReq, "slskd.search.create")
 	if err != nil {
 		return "", fmt.Errorf("execute search request: %w", err)
 	}
@@ -157,7 +229,7 @@ func (c *Client) GetSearch(ctx context.Context, id string, includeResponses bool
 	}
 	c.setHeaders(req)
 
-	resp, err := c.HTTPClient.Do(req)
+	resp, err := c.do(req, "slskd.search.get")
 	if err != nil {
 		return nil, fmt.Errorf("execute get search request: %w", err)
 	}
@@ -183,7 +255,7 @@ func (c *Client) DeleteSearch(ctx context.Context, id string) error {
 	}
 	c.setHeaders(req)
 
-	resp, err := c.HTTPClient.Do(req)
+	resp, err := c.do(req, "slskd.search.delete")
 	if err != nil {
 		return fmt.Errorf("execute delete search request: %w", err)
 	}
@@ -196,11 +268,53 @@ func (c *Client) DeleteSearch(ctx context.Context, id string) error {
 	return nil
 }
 
+type searchStartedKey struct{}
+
+// OnSearchStarted returns ctx carrying fn, which SearchAndWait calls with
+// the slskd ID of each search it starts, so callers can correlate their
+// requests with slskd's searches.
+func OnSearchStarted(ctx context.Context, fn func(id, query string)) context.Context {
+	return context.WithValue(ctx, searchStartedKey{}, fn)
+}
+
+type returnEarlyKey struct{}
+
+type returnEarly struct {
+	files  int
+	enough func([]SearchResponse) bool
+}
+
+// ReturnEarly returns ctx carrying enough, which SearchAndWait consults
+// with the responses so far each time a search has collected more files,
+// once it has at least files. When enough reports true the search is
+// stopped and those responses returned, rather than waiting out the
+// timeout.
+func ReturnEarly(ctx context.Context, files int, enough func([]SearchResponse) bool) context.Context {
+	return context.WithValue(ctx, returnEarlyKey{}, returnEarly{files: files, enough: enough})
+}
+
 // SearchAndWait starts a search and polls until complete or timeout.
 // It sends searchTimeout to slskd as 80% of the polling timeout so slskd
 // finishes before we give up, and uses adaptive polling that speeds up
-// as results stream in.
-func (c *Client) SearchAndWait(ctx context.Context, query string, timeout time.Duration) ([]SearchResponse, error) {
+// as results stream in. A search can end sooner, see ReturnEarly. With
+// MaxSearches set it first waits its turn, and timeout runs from then.
+func (c *Client) SearchAndWait(ctx context.Context, query string, timeout time.Duration) (_ []SearchResponse, err error) {
+	ctx, span := tracing.Start(ctx, "slskd.search", tracing.KindInternal)
+	span.SetAttr("search.query", query)
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+
+	release, err := c.acquireSearch(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	// Tell slskd to stop searching at 80% of our timeout so it completes
 	// before our polling deadline.
 	slskdTimeout := time.Duration(float64(timeout) * 0.8)
@@ -208,9 +322,22 @@ func (c *Client) SearchAndWait(ctx context.Context, query string, timeout time.D
 	if err != nil {
 		return nil, err
 	}
+	span.SetAttr("search.id", searchID)
+	if fn, ok := ctx.Value(searchStartedKey{}).(func(id, query string)); ok {
+		fn(searchID, query)
+	}
+	early, _ := ctx.Value(returnEarlyKey{}).(returnEarly)
+	checked := 0 // file count at the last early return check
+	wakeFiles := 0
+	if early.enough != nil {
+		wakeFiles = early.files
+	}
+	wake := c.trackSearch(searchID, query, cancel, wakeFiles)
+	defer c.untrackSearch(searchID)
 
 	deadline := time.After(timeout)
-	// Start with a 2-second initial delay before first poll
+	// Start with a 2-second initial delay before first poll. With the search
+	// hub connected, SearchEvent wakes the poll early.
 	timer := time.NewTimer(2 * time.Second)
 	defer timer.Stop()
 
@@ -219,9 +346,13 @@ func (c *Client) SearchAndWait(ctx context.Context, query string, timeout time.D
 	for {
 		select {
 		case <-ctx.Done():
+			// Caller went away or the search was cancelled; don't leave it running in slskd.
+			go func() {
+				_ = c.DeleteSearch(context.Background(), searchID)
+			}()
 			return nil, ctx.Err()
 		case <-deadline:
-			slog.Warn("search timeout reached, returning partial results", "id", searchID, "query", query)
+			slog.WarnContext(ctx, "search timeout reached, returning partial results", "id", searchID, "query", query)
 			result, err := c.GetSearch(ctx, searchID, true)
 			go func() {
 				_ = c.DeleteSearch(context.Background(), searchID)
@@ -229,33 +360,50 @@ func (c *Client) SearchAndWait(ctx context.Context, query string, timeout time.D
 			if err != nil {
 				return nil, fmt.Errorf("get final search responses: %w", err)
 			}
-			slog.Info("search partial results", "id", searchID, "responses", len(result.Responses), "totalFiles", countFiles(result.Responses))
+			slog.InfoContext(ctx, "search partial results", "id", searchID, "responses", len(result.Responses), "totalFiles", countFiles(result.Responses))
 			return result.Responses, nil
 		case <-timer.C:
-			result, err := c.GetSearch(ctx, searchID, false)
+		case <-wake:
+		}
+		result, err := c.GetSearch(ctx, searchID, false)
+		if err != nil {
+			return nil, err
+		}
+		slog.DebugContext(ctx, "search poll", "id", searchID, "state", result.State, "isComplete", result.IsComplete, "responseCount", result.ResponseCount, "fileCount", result.FileCount)
+		c.updateSearch(searchID, result)
+
+		if result.IsComplete {
+			// Fetch final results with responses included in one call
+			full, err := c.GetSearch(ctx, searchID, true)
+			go func() {
+				_ = c.DeleteSearch(context.Background(), searchID)
+			}()
 			if err != nil {
-				return nil, err
+				return nil, fmt.Errorf("get search responses: %w", err)
 			}
-			slog.Debug("search poll", "id", searchID, "state", result.State, "isComplete", result.IsComplete, "responseCount", result.ResponseCount, "fileCount", result.FileCount)
+			slog.InfoContext(ctx, "search completed", "id", searchID, "state", result.State, "responses", len(full.Responses), "totalFiles", countFiles(full.Responses))
+			return full.Responses, nil
+		}
 
-			if result.IsComplete {
-				// Fetch final results with responses included in one call
-				full, err := c.GetSearch(ctx, searchID, true)
+		if early.enough != nil && result.FileCount >= early.files && result.FileCount > checked {
+			checked = result.FileCount
+			full, err := c.GetSearch(ctx, searchID, true)
+			if err != nil {
+				return nil, fmt.Errorf("get search responses: %w", err)
+			}
+			if early.enough(full.Responses) {
 				go func() {
 					_ = c.DeleteSearch(context.Background(), searchID)
 				}()
-				if err != nil {
-					return nil, fmt.Errorf("get search responses: %w", err)
-				}
-				slog.Info("search completed", "id", searchID, "state", result.State, "responses", len(full.Responses), "totalFiles", countFiles(full.Responses))
+				slog.InfoContext(ctx, "search returned early", "id", searchID, "responses", len(full.Responses), "totalFiles", countFiles(full.Responses))
 				return full.Responses, nil
 			}
-
-			// Adaptive delay: U-shaped curve — slow at start/end, fast in the middle
-			progress := math.Min(float64(result.FileCount)/float64(fileLimit), 1.0)
-			delay := adaptiveDelay(progress)
-			timer.Reset(delay)
 		}
+
+		// Adaptive delay: U-shaped curve — slow at start/end, fast in the middle
+		progress := math.Min(float64(result.FileCount)/float64(fileLimit), 1.0)
+		delay := adaptiveDelay(progress)
+		timer.Reset(delay)
 	}
 }
 
@@ -285,7 +433,7 @@ func (c *Client) Download(ctx context.Context, username string, files []Download
 	}
 	c.setHeaders(req)
 
-	resp, err := c.HTTPClient.Do(req)
+	resp, err := c.do(req, "slskd.download.queue")
 	if err != nil {
 		return fmt.Errorf("execute download request: %w", err)
 	}
@@ -308,7 +456,7 @@ func (c *Client) CancelDownload(ctx context.Context, username, id string) error
 		return fmt.Errorf("create cancel request: %w", err)
 	}
 	c.setHeaders(req)
-	resp, err := c.HTTPClient.Do(req)
+	resp, err := c.do(req, "slskd.download.cancel")
 	if err != nil {
 		return fmt.Errorf("execute cancel request: %w", err)
 	}
@@ -324,7 +472,7 @@ func (c *Client) CancelDownload(ctx context.Context, username, id string) error
 		return fmt.Errorf("create remove request: %w", err)
 	}
 	c.setHeaders(req)
-	resp, err = c.HTTPClient.Do(req)
+	resp, err = c.do(req, "slskd.download.remove")
 	if err != nil {
 		return fmt.Errorf("execute remove request: %w", err)
 	}
@@ -341,7 +489,7 @@ func (c *Client) GetAllDownloads(ctx context.Context) ([]UserTransferGroup, erro
 	}
 	c.setHeaders(req)
 
-	resp, err := c.HTTPClient.Do(req)
+	resp, err := c.do(req, "slskd.downloads.list")
 	if err != nil {
 		return nil, fmt.Errorf("execute get downloads request: %w", err)
 	}
@@ -359,15 +507,81 @@ func (c *Client) GetAllDownloads(ctx context.Context) ([]UserTransferGroup, erro
 	return groups, nil
 }
 
-// GetOptions returns slskd's runtime configuration.
+// ServerState is slskd's connection state to the Soulseek network.
+type ServerState struct {
+	Address     string `json:"address"`
+	State       string `json:"state"`
+	IsConnected bool   `json:"isConnected"`
+	IsLoggedIn  bool   `json:"isLoggedIn"`
+}
+
+// GetServerState returns slskd's Soulseek server connection state.
+func (c *Client) GetServerState(ctx context.Context) (*ServerState, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+"/api/v0/server", nil)
+	if err != nil {
+		return nil, fmt.Errorf("create get server request: %w", err)
+	}
+	c.setHeaders(req)
+
+	resp, err := c.do(req, "slskd.server.get")
+	if err != nil {
+		return nil, fmt.Errorf("execute get server request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get server failed with status %d", resp.StatusCode)
+	}
+
+	var state ServerState
+	if err := json.NewDecoder(resp.Body).Decode(&state); err != nil {
+		return nil, fmt.Errorf("decode server response: %w", err)
+	}
+
+	return &state, nil
+}
+
+// WaitReady polls slskd until its API answers, backing off exponentially
+// between attempts, and gives up after maxWait. It logs each failed attempt
+// so a slow-starting slskd is visible in the logs.
+func (c *Client) WaitReady(ctx context.Context, maxWait time.Duration) (*ServerState, error) {
+	ctx, cancel := context.WithTimeout(ctx, maxWait)
+	defer cancel()
+
+	backoff := 500 * time.Millisecond
+	const maxBackoff = 10 * time.Second
+	for attempt := 1; ; attempt++ {
+		state, err := c.GetServerState(ctx)
+		if err == nil {
+			return state, nil
+		}
+		slog.Info("waiting for slskd", "attempt", attempt, "retryIn", backoff, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("slskd not reachable after %s: %w", maxWait, err)
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// GetOptions returns slskd's runtime configuration, reusing the last
+// response for OptionsTTL. The result is shared and must not be modified.
 func (c *Client) GetOptions(ctx context.Context) (map[string]any, error) {
+	c.optsMu.Lock()
+	defer c.optsMu.Unlock()
+	if c.opts != nil && time.Since(c.optsAt) < c.OptionsTTL {
+		return c.opts, nil
+	}
+
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+"/api/v0/options", nil)
 	if err != nil {
 		return nil, fmt.Errorf("create get options request: %w", err)
 	}
 	c.setHeaders(req)
 
-	resp, err := c.HTTPClient.Do(req)
+	resp, err := c.do(req, "slskd.options.get")
 	if err != nil {
 		return nil, fmt.Errorf("execute get options request: %w", err)
 	}
@@ -382,9 +596,51 @@ func (c *Client) GetOptions(ctx context.Context) (map[string]any, error) {
 		return nil, fmt.Errorf("decode options response: %w", err)
 	}
 
+	c.opts, c.optsAt = opts, time.Now()
 	return opts, nil
 }
 
+// UnlimitedSpeed is the speed limit slskd treats as no limit, in KiB/s.
+const UnlimitedSpeed = math.MaxInt32
+
+// SetDownloadSpeedLimit sets slskd's global download speed limit in KiB/s
+// at runtime. slskd only accepts option changes when remote configuration
+// is enabled.
+func (c *Client) SetDownloadSpeedLimit(ctx context.Context, kibps int) error {
+	body, err := json.Marshal(map[string]any{
+		"global": map[string]any{"download": map[string]any{"speedLimit": kibps}},
+	})
+	if err != nil {
+		return fmt.Errorf("marshal options overlay: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPatch, c.BaseURL+"/api/v0/options", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create set options request: %w", err)
+	}
+	c.setHeaders(req)
+
+	resp, err := c.do(req, "slskd.options.patch")
+	if err != nil {
+		return fmt.Errorf("execute set options request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("set options failed with status %d: %s", resp.StatusCode, string(respBody))
+	}
+	c.InvalidateOptions()
+	return nil
+}
+
+// InvalidateOptions drops the cached options so the next GetOptions asks
+// slskd again.
+func (c *Client) InvalidateOptions() {
+	c.optsMu.Lock()
+	defer c.optsMu.Unlock()
+	c.opts = nil
+}
+
 // GetDownloadDir fetches slskd's configured download directory from the options API.
 func (c *Client) GetDownloadDir(ctx context.Context) (string, error) {
 	opts, err := c.GetOptions(ctx)
@@ -403,6 +659,52 @@ func (c *Client) GetDownloadDir(ctx context.Context) (string, error) {
 	return downloads, nil
 }
 
+// ErrBlacklisted is returned for a grab from a user slskd blacklists.
+var ErrBlacklisted = errors.New("user is blacklisted in slskd")
+
+// BlacklistedUsers returns the members of slskd's blacklisted group, the
+// users its operator has barred in slskd's own configuration.
+func (c *Client) BlacklistedUsers(ctx context.Context) ([]string, error) {
+	opts, err := c.GetOptions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	groups, _ := opts["groups"].(map[string]any)
+	blacklisted, _ := groups["blacklisted"].(map[string]any)
+	members, _ := blacklisted["members"].([]any)
+	users := make([]string, 0, len(members))
+	for _, m := range members {
+		if u, ok := m.(string); ok && u != "" {
+			users = append(users, u)
+		}
+	}
+	return users, nil
+}
+
+// do executes req inside a client span so slskd latency shows up in traces,
+// forwarding the caller's request ID for log correlation.
+func (c *Client) do(req *http.Request, name string) (*http.Response, error) {
+	ctx, span := tracing.Start(req.Context(), name, tracing.KindClient)
+	defer span.End()
+	span.SetAttr("http.method", req.Method)
+	span.SetAttr("http.url", req.URL.Path)
+	tracing.Inject(ctx, req.Header)
+	if id := middleware.RequestID(ctx); id != "" {
+		req.Header.Set("X-Request-Id", id)
+	}
+
+	resp, err := c.HTTPClient.Do(req.WithContext(ctx))
+	if err != nil {
+		span.SetError(err)
+		return nil, err
+	}
+	span.SetAttr("http.status_code", resp.StatusCode)
+	if resp.StatusCode >= 400 {
+		span.SetError(fmt.Errorf("status %d", resp.StatusCode))
+	}
+	return resp, nil
+}
+
 func (c *Client) setHeaders(req *http.Request) {
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-API-Key", c.APIKey)
@@ -0,0 +1,384 @@
+package slskd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClient_CancelSearches(t *testing.T) {
+	var deleted atomic.Int32
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && strings.HasPrefix(r.URL.Path, "/api/v0/searches"):
+			json.NewEncoder(w).Encode(SearchResult{ID: "s1", State: "InProgress"})
+		case r.Method == "GET":
+			json.NewEncoder(w).Encode(SearchResult{ID: "s1", State: "InProgress"})
+		case r.Method == "DELETE":
+			deleted.Add(1)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer mock.Close()
+
+	c := NewClient(mock.URL, "key")
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := c.SearchAndWait(context.Background(), "query", time.Minute)
+		errCh <- err
+	}()
+
+	// Wait for the search to be registered
+	deadline := time.Now().Add(time.Second)
+	for {
+		c.mu.Lock()
+		n := len(c.searches)
+		c.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("search was never tracked")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if n := c.CancelSearches(context.Background()); n != 1 {
+		t.Errorf("expected 1 cancelled search, got %d", n)
+	}
+
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("SearchAndWait did not return after cancellation")
+	}
+	if deleted.Load() == 0 {
+		t.Error("expected search to be deleted in slskd")
+	}
+}
+
+func TestClient_ListAndCancelSearch(t *testing.T) {
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "POST":
+			json.NewEncoder(w).Encode(SearchResult{ID: "s1", State: "InProgress"})
+		case "GET":
+			json.NewEncoder(w).Encode(SearchResult{ID: "s1", State: "InProgress", ResponseCount: 3, FileCount: 42})
+		case "DELETE":
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer mock.Close()
+
+	c := NewClient(mock.URL, "key")
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := c.SearchAndWait(context.Background(), "backlog query", time.Minute)
+		errCh <- err
+	}()
+
+	// Wait for the first poll to record progress.
+	deadline := time.Now().Add(5 * time.Second)
+	var searches []SearchInfo
+	for {
+		searches = c.Searches()
+		if len(searches) == 1 && searches[0].FileCount == 42 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("search progress never recorded: %+v", searches)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if searches[0].Query != "backlog query" || searches[0].State != "InProgress" || searches[0].ResponseCount != 3 {
+		t.Errorf("unexpected search info: %+v", searches[0])
+	}
+
+	if err := c.CancelSearch(context.Background(), "missing"); !errors.Is(err, ErrSearchNotFound) {
+		t.Errorf("expected ErrSearchNotFound, got %v", err)
+	}
+	if err := c.CancelSearch(context.Background(), searches[0].ID); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("SearchAndWait did not return after cancellation")
+	}
+}
+
+func TestClient_CleanupSearches(t *testing.T) {
+	old := time.Now().Add(-2 * time.Hour).UTC()
+	var deleted []string
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			// slskd may omit the zone; such times are UTC.
+			fmt.Fprintf(w, `[
+				{"id":"stale","searchText":"a","isComplete":true,"startedAt":%q},
+				{"id":"stuck","searchText":"b","isComplete":false,"startedAt":%q},
+				{"id":"fresh","searchText":"c","isComplete":true,"startedAt":%q},
+				{"id":"mine","searchText":"d","isComplete":false,"startedAt":%q}
+			]`, old.Format(time.RFC3339Nano), old.Format("2006-01-02T15:04:05.9999999"),
+				time.Now().Format(time.RFC3339), old.Format(time.RFC3339))
+		case "DELETE":
+			deleted = append(deleted, strings.TrimPrefix(r.URL.Path, "/api/v0/searches/"))
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer mock.Close()
+
+	c := NewClient(mock.URL, "key")
+	c.trackSearch("mine", "d", func() {}, 0)
+
+	n, err := c.CleanupSearches(context.Background(), time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != 2 || strings.Join(deleted, ",") != "stale,stuck" {
+		t.Errorf("expected stale and stuck deleted, got %d: %v", n, deleted)
+	}
+}
+
+func TestClient_SearchAndWait_ReturnEarly(t *testing.T) {
+	var deleted atomic.Int32
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST":
+			json.NewEncoder(w).Encode(SearchResult{ID: "s1", State: "InProgress"})
+		case r.Method == "GET" && r.URL.Query().Get("includeResponses") == "true":
+			json.NewEncoder(w).Encode(SearchResult{ID: "s1", State: "InProgress", Responses: []SearchResponse{
+				{Username: "peer", Files: []SlskdFile{{Filename: "a"}, {Filename: "b"}}},
+			}})
+		case r.Method == "GET":
+			json.NewEncoder(w).Encode(SearchResult{ID: "s1", State: "InProgress", ResponseCount: 1, FileCount: 2})
+		case r.Method == "DELETE":
+			deleted.Add(1)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer mock.Close()
+
+	c := NewClient(mock.URL, "key")
+	var checks int
+	ctx := ReturnEarly(context.Background(), 2, func(responses []SearchResponse) bool {
+		checks++
+		return countFiles(responses) >= 2
+	})
+	start := time.Now()
+	responses, err := c.SearchAndWait(ctx, "query", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Errorf("expected the search to return early, took %v", time.Since(start))
+	}
+	if checks != 1 || len(responses) != 1 {
+		t.Errorf("expected one check returning the peer's response, got %d checks and %+v", checks, responses)
+	}
+	deadline := time.Now().Add(time.Second)
+	for deleted.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if deleted.Load() == 0 {
+		t.Error("expected the search to be deleted in slskd")
+	}
+}
+
+func TestClient_SearchLimit(t *testing.T) {
+	c := NewClient("", "")
+	c.MaxSearches = 1
+	c.QueueTimeout = 50 * time.Millisecond
+
+	release, err := c.acquireSearch(context.Background(), "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.acquireSearch(context.Background(), "second"); !errors.Is(err, ErrSearchQueueTimeout) {
+		t.Errorf("expected the second search to time out in the queue, got %v", err)
+	}
+
+	got := make(chan error)
+	go func() {
+		_, err := c.acquireSearch(context.Background(), "third")
+		got <- err
+	}()
+	for c.QueuedSearches() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	release()
+	if err := <-got; err != nil {
+		t.Errorf("expected the queued search to run once the slot freed, got %v", err)
+	}
+	if n := c.QueuedSearches(); n != 0 {
+		t.Errorf("expected an empty queue, got %d", n)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.acquireSearch(ctx, "fourth"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected a cancelled search to leave the queue, got %v", err)
+	}
+}
+
+func TestSearchResponse_AllFiles(t *testing.T) {
+	resp := SearchResponse{
+		Files:       make([]SlskdFile, 2, 3),
+		LockedFiles: []SlskdFile{{Filename: "c"}},
+	}
+	resp.Files[0].Filename, resp.Files[1].Filename = "a", "b"
+
+	var names []string
+	for f := range resp.AllFiles() {
+		names = append(names, f.Filename)
+		if f.Filename == "b" {
+			break
+		}
+	}
+	if strings.Join(names, ",") != "a,b" {
+		t.Errorf("expected iteration to stop after b, got %v", names)
+	}
+
+	names = nil
+	for f := range resp.AllFiles() {
+		names = append(names, f.Filename)
+	}
+	if strings.Join(names, ",") != "a,b,c" {
+		t.Errorf("expected a,b,c, got %v", names)
+	}
+	if len(resp.Files) != 2 || resp.Files[:3][2].Filename != "" {
+		t.Error("iterating should not touch Files")
+	}
+}
+
+func TestClient_GetOptions_Cached(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Write([]byte(`{"directories":{"downloads":"/data/downloads"}}`))
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "key")
+	c.OptionsTTL = time.Hour
+	for range 3 {
+		if dir, err := c.GetDownloadDir(context.Background()); err != nil || dir != "/data/downloads" {
+			t.Fatalf("GetDownloadDir = %q, %v", dir, err)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected one options request within the TTL, got %d", n)
+	}
+
+	c.InvalidateOptions()
+	if _, err := c.GetOptions(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("expected a fresh request after invalidation, got %d", n)
+	}
+}
+
+func TestMapTransferState(t *testing.T) {
+	tests := map[string]string{
+		"Completed, Succeeded": "completed",
+		"Completed, Errored":   "failed",
+		"Completed, Whatever":  "failed",
+		"InProgress":           "downloading",
+		"Queued, Remotely":     "queued",
+	}
+	for state, want := range tests {
+		if got := MapTransferState(state); got != want {
+			t.Errorf("MapTransferState(%q) = %s, want %s", state, got, want)
+		}
+	}
+}
+
+func TestClient_WaitReady(t *testing.T) {
+	var calls atomic.Int32
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(ServerState{State: "Connected, LoggedIn", IsConnected: true, IsLoggedIn: true})
+	}))
+	defer mock.Close()
+
+	c := NewClient(mock.URL, "key")
+	state, err := c.WaitReady(context.Background(), 5*time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !state.IsLoggedIn {
+		t.Error("expected logged-in state")
+	}
+	if calls.Load() != 2 {
+		t.Errorf("expected 2 attempts, got %d", calls.Load())
+	}
+}
+
+func TestClient_WaitReady_GivesUp(t *testing.T) {
+	c := NewClient("http://127.0.0.1:1", "key")
+	if _, err := c.WaitReady(context.Background(), 100*time.Millisecond); err == nil {
+		t.Fatal("expected error when slskd never answers")
+	}
+}
+
+func TestClient_Companions(t *testing.T) {
+	var browsed []string
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/api/v0/users/some peer/directory" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		var req struct{ Directory string }
+		json.NewDecoder(r.Body).Decode(&req)
+		browsed = append(browsed, req.Directory)
+		fmt.Fprintf(w, `[{"name":%q,"files":[
+			{"filename":"01.flac","size":30000000},
+			{"filename":"02.flac","size":30000000},
+			{"filename":"Album.CUE","size":900},
+			{"filename":"rip.log","size":4000},
+			{"filename":"cover.jpg","size":200000},
+			{"filename":"notes.txt","size":100}
+		]}]`, req.Directory)
+	}))
+	defer mock.Close()
+
+	c := NewClient(mock.URL, "key")
+	files := []DownloadRequest{
+		{Filename: `Music\Album\01.flac`, Size: 30000000},
+		{Filename: `Music\Album\02.flac`, Size: 30000000},
+	}
+	companions, err := c.Companions(context.Background(), "some peer", files, DefaultCompanions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(browsed) != 1 || browsed[0] != `Music\Album` {
+		t.Errorf("expected the album folder browsed once, got %v", browsed)
+	}
+	var names []string
+	for _, f := range companions {
+		names = append(names, f.Filename)
+	}
+	if got := strings.Join(names, ","); got != `Music\Album\Album.CUE,Music\Album\rip.log,Music\Album\cover.jpg` {
+		t.Errorf("unexpected companions: %s", got)
+	}
+
+	if companions, _ := c.Companions(context.Background(), "some peer", files, nil); companions != nil {
+		t.Errorf("expected no companions without patterns, got %v", companions)
+	}
+}
@@ -0,0 +1,209 @@
+package slskd
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+// slskd's SignalR hubs, see Hub.
+const (
+	TransfersHub = "/hub/transfers"
+	SearchHub    = "/hub/search"
+)
+
+const (
+	// recordSeparator ends every message of SignalR's JSON protocol.
+	recordSeparator = "\x1e"
+	// hubPingInterval is how often Hub pings slskd; SignalR servers drop
+	// clients they haven't heard from in 30 seconds.
+	hubPingInterval = 15 * time.Second
+	// hubMaxBackoff caps the wait between attempts to reconnect.
+	hubMaxBackoff = time.Minute
+)
+
+// SignalR message types used by Hub.
+const (
+	hubInvocation = 1
+	hubClose      = 7
+)
+
+// Hub subscribes to one of slskd's SignalR hubs. Run keeps it connected,
+// reconnecting with backoff when it drops; Connected tells callers whether
+// its events can be relied on or they should poll instead.
+type Hub struct {
+	client    *Client
+	path      string
+	connected atomic.Bool
+}
+
+// Hub returns a subscription to the hub at path, e.g. TransfersHub. It
+// connects once Run is called.
+func (c *Client) Hub(path string) *Hub {
+	return &Hub{client: c, path: path}
+}
+
+// Connected reports whether the hub is connected. A nil hub never is.
+func (h *Hub) Connected() bool {
+	return h != nil && h.connected.Load()
+}
+
+// Run subscribes to the hub until ctx is done, calling handle with the
+// target and arguments of every invocation slskd sends. A dropped
+// connection is retried after a backoff doubling from a second to a minute;
+// only the first failure in a row is logged as a warning.
+func (h *Hub) Run(ctx context.Context, handle func(target string, args []json.RawMessage)) {
+	backoff := time.Second
+	warned := false
+	for {
+		connected, err := h.subscribe(ctx, handle)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			backoff, warned = time.Second, false
+		}
+		if !warned {
+			slog.Warn("slskd hub unavailable, polling instead", "hub", h.path, "error", err)
+			warned = true
+		} else {
+			slog.Debug("slskd hub still unavailable", "hub", h.path, "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, hubMaxBackoff)
+	}
+}
+
+// subscribe connects to the hub and handles its messages until the
+// connection drops, reporting whether it got as far as the handshake.
+func (h *Hub) subscribe(ctx context.Context, handle func(string, []json.RawMessage)) (bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	token, err := h.negotiate(ctx)
+	if err != nil {
+		return false, err
+	}
+	conn, err := h.client.dialWebSocket(ctx, h.path+"?id="+url.QueryEscape(token))
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	go func() {
+		<-ctx.Done()
+		conn.rw.Close() // unblocks readMessage
+	}()
+
+	if err := conn.writeText([]byte(`{"protocol":"json","version":1}` + recordSeparator)); err != nil {
+		return false, err
+	}
+	data, err := conn.readMessage()
+	if err != nil {
+		return false, fmt.Errorf("hub handshake: %w", err)
+	}
+	var handshake struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(bytes.TrimRight(data, recordSeparator), &handshake); err != nil {
+		return false, fmt.Errorf("hub handshake: %w", err)
+	}
+	if handshake.Error != "" {
+		return false, fmt.Errorf("hub handshake: %s", handshake.Error)
+	}
+
+	h.connected.Store(true)
+	defer h.connected.Store(false)
+	slog.Info("slskd hub connected", "hub", h.path)
+
+	go func() {
+		ticker := time.NewTicker(hubPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				ping := []byte(`{"type":6}` + recordSeparator)
+				if err := conn.writeText(ping); err != nil {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		data, err := conn.readMessage()
+		if err != nil {
+			return true, err
+		}
+		// A message can carry several records.
+		for _, record := range bytes.Split(data, []byte(recordSeparator)) {
+			if len(record) == 0 {
+				continue
+			}
+			var msg struct {
+				Type      int               `json:"type"`
+				Target    string            `json:"target"`
+				Arguments []json.RawMessage `json:"arguments"`
+				Error     string            `json:"error"`
+			}
+			if err := json.Unmarshal(record, &msg); err != nil {
+				slog.Debug("ignoring malformed hub message", "hub", h.path, "error", err)
+				continue
+			}
+			switch msg.Type {
+			case hubInvocation:
+				handle(msg.Target, msg.Arguments)
+			case hubClose:
+				return true, fmt.Errorf("closed by slskd: %s", cmp.Or(msg.Error, "no reason given"))
+			}
+		}
+	}
+}
+
+// negotiate asks slskd for a connection token for the hub.
+func (h *Hub) negotiate(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", h.client.BaseURL+h.path+"/negotiate?negotiateVersion=1", nil)
+	if err != nil {
+		return "", err
+	}
+	h.client.setHeaders(req)
+	resp, err := h.client.do(req, "slskd.hub.negotiate")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("hub negotiate: status %d", resp.StatusCode)
+	}
+	var negotiated struct {
+		ConnectionID    string `json:"connectionId"`
+		ConnectionToken string `json:"connectionToken"`
+		Error           string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&negotiated); err != nil {
+		return "", fmt.Errorf("hub negotiate: %w", err)
+	}
+	if negotiated.Error != "" {
+		return "", fmt.Errorf("hub negotiate: %s", negotiated.Error)
+	}
+	// Servers speaking version 0 of the negotiation only send the ID.
+	token := cmp.Or(negotiated.ConnectionToken, negotiated.ConnectionID)
+	if token == "" {
+		return "", errors.New("hub negotiate: no connection token")
+	}
+	return token, nil
+}
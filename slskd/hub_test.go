@@ -0,0 +1,213 @@
+package slskd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeHub serves a SignalR hub at /hub/test that sends each of msgs after
+// the handshake and then closes, counting connections.
+func fakeHub(t *testing.T, connections *atomic.Int32, msgs ...string) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /hub/test/negotiate", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-API-Key") != "key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"negotiateVersion":1,"connectionId":"c1","connectionToken":"tok"}`))
+	})
+	mux.HandleFunc("GET /hub/test", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("id") != "tok" || r.Header.Get("Upgrade") != "websocket" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		connections.Add(1)
+		fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+			acceptKey(r.Header.Get("Sec-WebSocket-Key")))
+		rw.Flush()
+
+		ws := &wsConn{rw: conn, r: rw.Reader}
+		handshake, err := ws.readMessage()
+		if err != nil || string(handshake) != `{"protocol":"json","version":1}`+recordSeparator {
+			t.Errorf("unexpected handshake %q (%v)", handshake, err)
+			return
+		}
+		ws.writeText([]byte("{}" + recordSeparator))
+		for _, msg := range msgs {
+			ws.writeText([]byte(msg))
+		}
+		ws.Close()
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestHub_Run(t *testing.T) {
+	var connections atomic.Int32
+	// One message carrying a ping and an invocation, then a long one
+	// spanning an extended length field, then slskd closing the hub.
+	long := strings.Repeat("x", 70000)
+	srv := fakeHub(t, &connections,
+		`{"type":6}`+recordSeparator+`{"type":1,"target":"UPDATE","arguments":[{"id":"s1"}]}`+recordSeparator,
+		`{"type":1,"target":"LOG","arguments":["`+long+`"]}`+recordSeparator,
+		`{"type":7,"error":"shutting down"}`+recordSeparator,
+	)
+	defer srv.Close()
+
+	hub := NewClient(srv.URL, "key").Hub("/hub/test")
+	type event struct {
+		target    string
+		arg       string
+		connected bool
+	}
+	events := make(chan event, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		hub.Run(ctx, func(target string, args []json.RawMessage) {
+			events <- event{target, string(args[0]), hub.Connected()}
+		})
+		close(done)
+	}()
+
+	for _, want := range []string{"UPDATE", "LOG"} {
+		select {
+		case e := <-events:
+			if e.target != want || !e.connected {
+				t.Errorf("expected %s while connected, got %s (connected %v)", want, e.target, e.connected)
+			}
+			if want == "LOG" && len(e.arg) != len(long)+2 {
+				t.Errorf("expected the long argument whole, got %d bytes", len(e.arg))
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no %s event", want)
+		}
+	}
+
+	// Closed by slskd, the hub reconnects after its backoff.
+	deadline := time.Now().Add(5 * time.Second)
+	for connections.Load() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the hub to reconnect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after cancellation")
+	}
+	if hub.Connected() {
+		t.Error("expected the hub disconnected after Run returned")
+	}
+}
+
+func TestHub_Unavailable(t *testing.T) {
+	var connections atomic.Int32
+	srv := fakeHub(t, &connections)
+	defer srv.Close()
+
+	for path, key := range map[string]string{"/hub/test": "wrong", "/hub/missing": "key"} {
+		hub := NewClient(srv.URL, key).Hub(path)
+		if connected, err := hub.subscribe(context.Background(), nil); connected || err == nil {
+			t.Errorf("%s with key %q: expected an error, got connected %v (%v)", path, key, connected, err)
+		}
+		if hub.Connected() {
+			t.Errorf("%s: expected not connected", path)
+		}
+	}
+	var nilHub *Hub
+	if nilHub.Connected() {
+		t.Error("expected a nil hub never connected")
+	}
+}
+
+func TestClient_SearchEvent(t *testing.T) {
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "POST":
+			json.NewEncoder(w).Encode(SearchResult{ID: "s1", State: "InProgress"})
+		case "GET":
+			json.NewEncoder(w).Encode(SearchResult{ID: "s1", State: "Completed", IsComplete: true})
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer mock.Close()
+
+	c := NewClient(mock.URL, "key")
+	done := make(chan error, 1)
+	start := time.Now()
+	go func() {
+		_, err := c.SearchAndWait(context.Background(), "query", time.Minute)
+		done <- err
+	}()
+	for c.ActiveSearches() == 0 {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	c.SearchEvent("UPDATE", []json.RawMessage{json.RawMessage(`{"id":"other","isComplete":true}`)})
+	c.SearchEvent("UPDATE", []json.RawMessage{json.RawMessage(`{"id":"s1","state":"InProgress","fileCount":7}`)})
+	if s := c.Searches(); len(s) != 1 || s[0].FileCount != 7 {
+		t.Errorf("expected the update's progress recorded, got %+v", s)
+	}
+	c.SearchEvent("UPDATE", []json.RawMessage{json.RawMessage(`{"id":"s1","state":"Completed","isComplete":true}`)})
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The first poll is two seconds in; the event beat it.
+		if time.Since(start) >= 2*time.Second {
+			t.Errorf("expected the completion event to end the wait, took %v", time.Since(start))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("SearchAndWait did not return")
+	}
+}
+
+func TestClient_SearchEvent_Files(t *testing.T) {
+	c := &Client{}
+	wake := c.trackSearch("s1", "query", func() {}, 10)
+	woken := func() bool {
+		select {
+		case <-wake:
+			return true
+		default:
+			return false
+		}
+	}
+
+	update := func(files int) {
+		c.SearchEvent("UPDATE", []json.RawMessage{json.RawMessage(fmt.Sprintf(`{"id":"s1","fileCount":%d}`, files))})
+	}
+	update(5)
+	if woken() {
+		t.Error("expected no wake below the early return's files")
+	}
+	update(12)
+	if !woken() {
+		t.Error("expected a wake once the search has enough files")
+	}
+	update(20)
+	if woken() {
+		t.Error("expected the next wake to wait for twice the files")
+	}
+	update(24)
+	if !woken() {
+		t.Error("expected a wake at twice the files")
+	}
+}
@@ -0,0 +1,64 @@
+package slskd
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// ErrSearchQueueTimeout is returned by SearchAndWait when a search waited
+// QueueTimeout for one of the MaxSearches slots without getting one.
+var ErrSearchQueueTimeout = errors.New("timed out waiting for a search slot")
+
+// searchSlots returns the semaphore limiting searches to MaxSearches, or nil
+// when they aren't limited.
+func (c *Client) searchSlots() chan struct{} {
+	if c.MaxSearches <= 0 {
+		return nil
+	}
+	c.slotsOnce.Do(func() { c.slots = make(chan struct{}, c.MaxSearches) })
+	return c.slots
+}
+
+// acquireSearch waits for a search slot, for at most QueueTimeout, and
+// returns the function that frees it. Searches waiting are logged with the
+// queue's depth, so a backlog search flooding Soulseek shows in the logs.
+func (c *Client) acquireSearch(ctx context.Context, query string) (release func(), err error) {
+	slots := c.searchSlots()
+	if slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	default:
+	}
+
+	c.queued.Add(1)
+	defer c.queued.Add(-1)
+	slog.InfoContext(ctx, "search queued", "query", query, "queued", c.queued.Load(), "running", len(slots))
+
+	var timeout <-chan time.Time
+	if c.QueueTimeout > 0 {
+		t := time.NewTimer(c.QueueTimeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+	start := time.Now()
+	select {
+	case slots <- struct{}{}:
+		slog.DebugContext(ctx, "search dequeued", "query", query, "waited", time.Since(start).Round(time.Millisecond))
+		return func() { <-slots }, nil
+	case <-timeout:
+		slog.WarnContext(ctx, "search gave up waiting for a slot", "query", query, "waited", c.QueueTimeout)
+		return nil, ErrSearchQueueTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// QueuedSearches returns the number of searches waiting for a slot.
+func (c *Client) QueuedSearches() int {
+	return int(c.queued.Load())
+}
@@ -0,0 +1,230 @@
+package slskd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+)
+
+var ErrSearchNotFound = errors.New("search not found")
+
+// activeSearch is a search started by SearchAndWait that hasn't returned yet.
+type activeSearch struct {
+	SearchInfo
+	cancel context.CancelFunc
+	wake   chan struct{} // signalled by SearchEvent to poll at once
+	files  int           // file count at which SearchEvent next wakes the poll; 0 only on completion
+}
+
+// SearchInfo describes an in-flight search as of its last poll.
+type SearchInfo struct {
+	ID            string    `json:"id"`
+	Query         string    `json:"query"`
+	Started       time.Time `json:"started"`
+	State         string    `json:"state,omitempty"`
+	ResponseCount int       `json:"responseCount"`
+	FileCount     int       `json:"fileCount"`
+}
+
+// trackSearch records a search started by SearchAndWait and returns the
+// channel SearchEvent wakes its poll on, once it completes or, with files
+// set, once it has that many files.
+func (c *Client) trackSearch(id, query string, cancel context.CancelFunc, files int) <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.searches == nil {
+		c.searches = make(map[string]*activeSearch)
+	}
+	s := &activeSearch{
+		SearchInfo: SearchInfo{ID: id, Query: query, Started: time.Now()},
+		cancel:     cancel,
+		wake:       make(chan struct{}, 1),
+		files:      files,
+	}
+	c.searches[id] = s
+	return s.wake
+}
+
+// updateSearch records the progress seen by the latest poll.
+func (c *Client) updateSearch(id string, r *SearchResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s, ok := c.searches[id]; ok {
+		s.State = r.State
+		s.ResponseCount = r.ResponseCount
+		s.FileCount = r.FileCount
+	}
+}
+
+// SearchEvent handles a message from SearchHub. Updates to a search this
+// client started record its progress, and wake SearchAndWait to collect it
+// without waiting for the next poll once it is complete or has as many files
+// as an early return looks for.
+func (c *Client) SearchEvent(target string, args []json.RawMessage) {
+	if len(args) == 0 {
+		return
+	}
+	var update struct {
+		ID            string `json:"id"`
+		State         string `json:"state"`
+		IsComplete    bool   `json:"isComplete"`
+		ResponseCount int    `json:"responseCount"`
+		FileCount     int    `json:"fileCount"`
+	}
+	if err := json.Unmarshal(args[0], &update); err != nil || update.ID == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.searches[update.ID]
+	if !ok {
+		return
+	}
+	s.State, s.ResponseCount, s.FileCount = update.State, update.ResponseCount, update.FileCount
+	if update.IsComplete || (s.files > 0 && update.FileCount >= s.files) {
+		if s.files > 0 {
+			// Responses keep coming; the next wake waits for twice the files.
+			s.files = max(s.files, update.FileCount*2)
+		}
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (c *Client) untrackSearch(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.searches, id)
+}
+
+// ActiveSearches returns the number of in-flight searches this client started.
+func (c *Client) ActiveSearches() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.searches)
+}
+
+// Searches returns the in-flight searches this client started, oldest first.
+func (c *Client) Searches() []SearchInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result := make([]SearchInfo, 0, len(c.searches))
+	for _, s := range c.searches {
+		result = append(result, s.SearchInfo)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Started.Before(result[j].Started) })
+	return result
+}
+
+// CancelSearch stops one in-flight search this client started and removes
+// it from slskd. Its caller gets a context.Canceled error.
+func (c *Client) CancelSearch(ctx context.Context, id string) error {
+	c.mu.Lock()
+	s, ok := c.searches[id]
+	c.mu.Unlock()
+	if !ok {
+		return ErrSearchNotFound
+	}
+
+	s.cancel()
+	return c.DeleteSearch(ctx, id)
+}
+
+// CancelSearches stops every in-flight search this client started and
+// removes them from slskd, returning how many were cancelled.
+func (c *Client) CancelSearches(ctx context.Context) int {
+	c.mu.Lock()
+	searches := make([]*activeSearch, 0, len(c.searches))
+	for _, s := range c.searches {
+		searches = append(searches, s)
+	}
+	c.mu.Unlock()
+
+	for _, s := range searches {
+		s.cancel()
+		if err := c.DeleteSearch(ctx, s.ID); err != nil {
+			slog.Warn("failed to delete search", "id", s.ID, "query", s.Query, "error", err)
+		}
+	}
+	return len(searches)
+}
+
+// ListSearches returns every search slskd knows about, including ones
+// started from its web UI or by other clients.
+func (c *Client) ListSearches(ctx context.Context) ([]SearchResult, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+"/api/v0/searches", nil)
+	if err != nil {
+		return nil, fmt.Errorf("create list searches request: %w", err)
+	}
+	c.setHeaders(req)
+
+	resp, err := c.do(req, "slskd.search.list")
+	if err != nil {
+		return nil, fmt.Errorf("execute list searches request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("list searches failed with status %d", resp.StatusCode)
+	}
+
+	var result []SearchResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decode searches response: %w", err)
+	}
+	return result, nil
+}
+
+// CleanupSearches deletes searches started more than maxAge ago, finished
+// or not, that this client isn't waiting on. They are left behind when
+// slskrr crashes or is killed mid-search. It returns how many were deleted.
+func (c *Client) CleanupSearches(ctx context.Context, maxAge time.Duration) (int, error) {
+	searches, err := c.ListSearches(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, s := range searches {
+		c.mu.Lock()
+		_, active := c.searches[s.ID]
+		c.mu.Unlock()
+		if active || s.StartedAt.IsZero() || time.Since(s.StartedAt.Time) < maxAge {
+			continue
+		}
+		if err := c.DeleteSearch(ctx, s.ID); err != nil {
+			slog.WarnContext(ctx, "failed to delete stale search", "id", s.ID, "query", s.SearchText, "error", err)
+			continue
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// RunJanitor runs CleanupSearches every interval until ctx is cancelled.
+func (c *Client) RunJanitor(ctx context.Context, interval, maxAge time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := c.CleanupSearches(ctx, maxAge)
+			if err != nil {
+				slog.Warn("search janitor failed", "error", err)
+			} else if n > 0 {
+				slog.Info("deleted stale slskd searches", "count", n)
+			}
+		}
+	}
+}
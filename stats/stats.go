@@ -0,0 +1,199 @@
+// Package stats tallies grabs and their outcomes per category and day, so
+// users can see how well each *arr app is served through Soulseek.
+package stats
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// keepDays is how many days of tallies are kept.
+const keepDays = 90
+
+const dayLayout = "2006-01-02"
+
+// Row is the tally for one category, on one day or over a period.
+type Row struct {
+	Day       string `json:"day,omitempty"`
+	Category  string `json:"category"`
+	Grabs     int    `json:"grabs"`
+	Completed int    `json:"completed"`
+	Failed    int    `json:"failed"`
+
+	// CompletionSeconds is the total time from grab to completion of the
+	// completed downloads; AvgCompletionSeconds is its mean.
+	CompletionSeconds    float64 `json:"completionSeconds"`
+	AvgCompletionSeconds float64 `json:"avgCompletionSeconds"`
+}
+
+func (r *Row) add(o *Row) {
+	r.Grabs += o.Grabs
+	r.Completed += o.Completed
+	r.Failed += o.Failed
+	r.CompletionSeconds += o.CompletionSeconds
+}
+
+func (r *Row) average() {
+	if r.Completed > 0 {
+		r.AvgCompletionSeconds = r.CompletionSeconds / float64(r.Completed)
+	}
+}
+
+// Report is the tally over a period: totals per category, and each
+// category's tally per day, most recent day first.
+type Report struct {
+	Categories []Row `json:"categories"`
+	Days       []Row `json:"days"`
+}
+
+type key struct{ day, category string }
+
+// Tally counts grabs, completions and failures. A nil tally counts nothing.
+type Tally struct {
+	mu   sync.Mutex
+	rows map[key]*Row
+	path string
+	now  func() time.Time
+}
+
+func New() *Tally {
+	return &Tally{rows: make(map[key]*Row), now: time.Now}
+}
+
+// Grab counts a download grabbed in category.
+func (t *Tally) Grab(category string) error {
+	return t.record(category, func(r *Row) { r.Grabs++ })
+}
+
+// Complete counts a download in category that completed elapsed after it
+// was grabbed.
+func (t *Tally) Complete(category string, elapsed time.Duration) error {
+	return t.record(category, func(r *Row) {
+		r.Completed++
+		r.CompletionSeconds += elapsed.Seconds()
+	})
+}
+
+// Fail counts a download in category that failed for good.
+func (t *Tally) Fail(category string) error {
+	return t.record(category, func(r *Row) { r.Failed++ })
+}
+
+func (t *Tally) record(category string, update func(*Row)) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	k := key{day: now.Format(dayLayout), category: category}
+	r, ok := t.rows[k]
+	if !ok {
+		r = &Row{Day: k.day, Category: category}
+		t.rows[k] = r
+		oldest := now.AddDate(0, 0, -keepDays).Format(dayLayout)
+		for k := range t.rows {
+			if k.day < oldest {
+				delete(t.rows, k)
+			}
+		}
+	}
+	update(r)
+	return t.save()
+}
+
+// Report returns the tally of the last days days, today included.
+func (t *Tally) Report(days int) Report {
+	report := Report{Categories: []Row{}, Days: []Row{}}
+	if t == nil {
+		return report
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	first := t.now().AddDate(0, 0, 1-days).Format(dayLayout)
+	totals := make(map[string]*Row)
+	for k, r := range t.rows {
+		if k.day < first {
+			continue
+		}
+		day := *r
+		day.average()
+		report.Days = append(report.Days, day)
+
+		total, ok := totals[k.category]
+		if !ok {
+			total = &Row{Category: k.category}
+			totals[k.category] = total
+		}
+		total.add(r)
+	}
+	for _, total := range totals {
+		total.average()
+		report.Categories = append(report.Categories, *total)
+	}
+	slices.SortFunc(report.Categories, func(a, b Row) int { return cmp.Compare(a.Category, b.Category) })
+	slices.SortFunc(report.Days, func(a, b Row) int {
+		return cmp.Or(cmp.Compare(b.Day, a.Day), cmp.Compare(a.Category, b.Category))
+	})
+	return report
+}
+
+// Load reads tallies from path and persists future changes there. A
+// missing file is not an error.
+func (t *Tally) Load(path string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.path = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read stats file: %w", err)
+	}
+	var rows []*Row
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return fmt.Errorf("decode stats file: %w", err)
+	}
+	for _, r := range rows {
+		r.AvgCompletionSeconds = 0
+		t.rows[key{day: r.Day, category: r.Category}] = r
+	}
+	return nil
+}
+
+// save writes all tallies atomically. Callers must hold t.mu.
+func (t *Tally) save() error {
+	if t.path == "" {
+		return nil
+	}
+	rows := make([]*Row, 0, len(t.rows))
+	for _, r := range t.rows {
+		rows = append(rows, r)
+	}
+	data, err := json.MarshalIndent(rows, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode stats: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(t.path), 0o755); err != nil {
+		return fmt.Errorf("create stats dir: %w", err)
+	}
+	tmp := t.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("write stats file: %w", err)
+	}
+	if err := os.Rename(tmp, t.path); err != nil {
+		return fmt.Errorf("replace stats file: %w", err)
+	}
+	return nil
+}
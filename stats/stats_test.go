@@ -0,0 +1,82 @@
+package stats
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTally_Report(t *testing.T) {
+	now := time.Date(2024, 5, 2, 12, 0, 0, 0, time.Local)
+	s := New()
+	s.now = func() time.Time { return now }
+
+	s.Grab("radarr")
+	s.Grab("lidarr")
+	s.Complete("radarr", time.Minute)
+	now = now.AddDate(0, 0, 1)
+	s.Grab("radarr")
+	s.Complete("radarr", 3*time.Minute)
+	s.Fail("lidarr")
+
+	r := s.Report(30)
+	if len(r.Categories) != 2 || r.Categories[0].Category != "lidarr" || r.Categories[1].Category != "radarr" {
+		t.Fatalf("unexpected categories: %+v", r.Categories)
+	}
+	radarr := r.Categories[1]
+	if radarr.Grabs != 2 || radarr.Completed != 2 || radarr.AvgCompletionSeconds != 120 {
+		t.Errorf("unexpected radarr totals: %+v", radarr)
+	}
+	if lidarr := r.Categories[0]; lidarr.Grabs != 1 || lidarr.Failed != 1 {
+		t.Errorf("unexpected lidarr totals: %+v", lidarr)
+	}
+	if len(r.Days) != 4 || r.Days[0].Day != "2024-05-03" || r.Days[3].Day != "2024-05-02" {
+		t.Errorf("expected days most recent first, got %+v", r.Days)
+	}
+
+	if r := s.Report(1); len(r.Days) != 2 || r.Categories[1].Grabs != 1 {
+		t.Errorf("expected only today in a one-day report, got %+v", r)
+	}
+}
+
+func TestTally_ForgetsOldDays(t *testing.T) {
+	now := time.Now()
+	s := New()
+	s.now = func() time.Time { return now }
+
+	s.Grab("radarr")
+	now = now.AddDate(0, 0, keepDays+1)
+	s.Grab("sonarr")
+	if len(s.rows) != 1 {
+		t.Errorf("expected tallies older than %d days dropped, got %d rows", keepDays, len(s.rows))
+	}
+}
+
+func TestTally_Nil(t *testing.T) {
+	var s *Tally
+	if err := s.Grab("radarr"); err != nil {
+		t.Fatal(err)
+	}
+	if r := s.Report(30); len(r.Categories) != 0 || r.Days == nil {
+		t.Errorf("expected an empty report, got %+v", r)
+	}
+}
+
+func TestTally_Persist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.json")
+	s := New()
+	if err := s.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	s.Grab("radarr")
+	s.Complete("radarr", 90*time.Second)
+
+	loaded := New()
+	if err := loaded.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	r := loaded.Report(1)
+	if len(r.Categories) != 1 || r.Categories[0].Completed != 1 || r.Categories[0].AvgCompletionSeconds != 90 {
+		t.Errorf("unexpected report after reload: %+v", r)
+	}
+}
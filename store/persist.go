@@ -0,0 +1,99 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Open loads downloads previously flushed to path and makes Flush write
+// there. A missing file is not an error.
+func (s *Store) Open(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.path = path
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read store file: %w", err)
+	}
+	var downloads []*Download
+	if err := json.Unmarshal(b, &downloads); err != nil {
+		return fmt.Errorf("decode store file: %w", err)
+	}
+	for _, dl := range downloads {
+		s.downloads[dl.ID] = dl
+	}
+	return nil
+}
+
+// Flush writes all downloads to the path given to Open if anything changed
+// since the last flush. It is a no-op for a store that was never opened.
+func (s *Store) Flush() error {
+	s.mu.Lock()
+	if s.path == "" || !s.dirty {
+		s.mu.Unlock()
+		return nil
+	}
+	downloads := make([]*Download, 0, len(s.downloads))
+	for _, dl := range s.downloads {
+		cp := *dl
+		downloads = append(downloads, &cp)
+	}
+	path := s.path
+	s.dirty = false
+	s.mu.Unlock()
+
+	if err := writeJSONFile(path, downloads); err != nil {
+		s.mu.Lock()
+		s.dirty = true
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// AutoFlush flushes the store every interval until ctx is cancelled, so a
+// crash loses at most one interval of changes.
+func (s *Store) AutoFlush(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Flush(); err != nil {
+				slog.Error("failed to flush store", "error", err)
+			}
+		}
+	}
+}
+
+// writeJSONFile atomically replaces path with the JSON encoding of v.
+func writeJSONFile(path string, v any) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode store: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("create store dir: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return fmt.Errorf("write store file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("replace store file: %w", err)
+	}
+	return nil
+}
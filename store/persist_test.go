@@ -0,0 +1,54 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStore_FlushAndOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+
+	s := New()
+	if err := s.Open(path); err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	id := s.Add("user1", "file.mkv", 1000, "radarr")
+	s.UpdateTransfer(id, 1000, StatusCompleted)
+	if err := s.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	reopened := New()
+	if err := reopened.Open(path); err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	dl := reopened.Get(id)
+	if dl == nil {
+		t.Fatal("expected download to survive reopen")
+	}
+	if dl.Status != StatusCompleted || dl.CompletedAt.IsZero() {
+		t.Errorf("expected completed download with timestamp, got %+v", dl)
+	}
+}
+
+func TestStore_FlushSkipsWhenClean(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+
+	s := New()
+	s.Open(path)
+	if err := s.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("expected no file written for an unchanged store")
+	}
+}
+
+func TestStore_FlushInMemoryNoop(t *testing.T) {
+	s := New()
+	s.Add("user1", "file.mkv", 1000, "radarr")
+	if err := s.Flush(); err != nil {
+		t.Errorf("expected in-memory flush to be a no-op, got %v", err)
+	}
+}
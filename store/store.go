@@ -1,9 +1,13 @@
 package store
 
 import (
+	"cmp"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 )
@@ -12,11 +16,18 @@ type Status string
 
 const (
 	StatusQueued      Status = "Queued"
+	StatusPaused      Status = "Paused" // transfer cancelled in slskd until resumed
 	StatusDownloading Status = "Downloading"
+	StatusProcessing  Status = "Processing" // transferred, post-processing running
 	StatusCompleted   Status = "Completed"
 	StatusFailed      Status = "Failed"
 )
 
+var (
+	ErrNotFound  = errors.New("download not found")
+	ErrNotFailed = errors.New("download has not failed")
+)
+
 type Download struct {
 	ID              string
 	Username        string
@@ -30,6 +41,29 @@ type Download struct {
 	Retries         int
 	MaxRetries      int
 	TransferID      string // slskd transfer ID for cancellation
+	Path            string // local path after post-processing, if any
+	Error           string // why post-processing failed, or the download was given up on
+	Artist          string // album artist, when the grab was for a known album
+	Album           string
+	BitRate         int       // kbps as shared by the peer; 0 when unknown
+	Name            string    // release name the client asked for; empty means the file's basename
+	Position        int       // order in the queue; lower is dispatched first
+	AttemptedAt     time.Time // when the current attempt was queued; zero means AddedAt
+	Group           string    // NZO of the multi-file release this file is part of; empty on its own
+}
+
+// NZO returns the ID clients know the download by: its release's, for a
+// file grabbed as part of one, or else its own.
+func (d *Download) NZO() string {
+	return cmp.Or(d.Group, d.ID)
+}
+
+// Attempted returns when the current download attempt was queued.
+func (d *Download) Attempted() time.Time {
+	if d.AttemptedAt.IsZero() {
+		return d.AddedAt
+	}
+	return d.AttemptedAt
 }
 
 func (d *Download) Progress() float64 {
@@ -39,14 +73,39 @@ func (d *Download) Progress() float64 {
 	return float64(d.BytesDownloaded) / float64(d.Size) * 100
 }
 
+// keepRemoved is how many removed downloads Lookup remembers, so events
+// about a download that arrive after its deletion can still be resolved.
+const keepRemoved = 256
+
+// SpeedSample is a download's progress at one transfer sync.
+type SpeedSample struct {
+	Time            time.Time `json:"time"`
+	BytesDownloaded int64     `json:"bytesDownloaded"`
+	BytesPerSecond  int64     `json:"bytesPerSecond"` // since the previous sample
+}
+
+// speedSamples is how many samples are kept per download: five minutes at
+// the five-second sync interval.
+const speedSamples = 60
+
+// speedWindow is how far back Speed averages a download's samples: long
+// enough to smooth over a sync that saw no progress, short enough to follow
+// a peer slowing down.
+const speedWindow = 30 * time.Second
+
 type Store struct {
 	mu        sync.RWMutex
 	downloads map[string]*Download
+	speeds    map[string][]SpeedSample // recent samples of downloads in flight; not persisted
+	removed   []*Download              // most recently removed last
+	path      string                   // set by Open; empty means in-memory only
+	dirty     bool                     // changed since the last Flush
 }
 
 func New() *Store {
 	return &Store{
 		downloads: make(map[string]*Download),
+		speeds:    make(map[string][]SpeedSample),
 	}
 }
 
@@ -62,6 +121,11 @@ func (s *Store) Add(username, filename string, size int64, category string) stri
 	defer s.mu.Unlock()
 
 	id := generateID()
+	s.dirty = true
+	position := 0
+	for _, dl := range s.downloads {
+		position = max(position, dl.Position+1)
+	}
 	s.downloads[id] = &Download{
 		ID:         id,
 		Username:   username,
@@ -71,6 +135,7 @@ func (s *Store) Add(username, filename string, size int64, category string) stri
 		Status:     StatusQueued,
 		AddedAt:    time.Now(),
 		MaxRetries: 3,
+		Position:   position,
 	}
 	return id
 }
@@ -93,16 +158,86 @@ func (s *Store) UpdateTransfer(id string, bytesDownloaded int64, status Status)
 	defer s.mu.Unlock()
 
 	dl, ok := s.downloads[id]
-	if !ok {
+	if !ok || (dl.BytesDownloaded == bytesDownloaded && dl.Status == status) {
 		return
 	}
+	s.dirty = true
 	dl.BytesDownloaded = bytesDownloaded
 	dl.Status = status
+	if status != StatusQueued && status != StatusDownloading {
+		delete(s.speeds, id)
+	}
 	if (status == StatusCompleted || status == StatusFailed) && dl.CompletedAt.IsZero() {
 		dl.CompletedAt = time.Now()
 	}
 }
 
+// SetAlbum records which album a music download belongs to, for tagging.
+func (s *Store) SetAlbum(id, artist, album string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if dl, ok := s.downloads[id]; ok {
+		s.dirty = true
+		dl.Artist = artist
+		dl.Album = album
+	}
+}
+
+// SetBitRate records the bitrate the peer advertised for a download.
+func (s *Store) SetBitRate(id string, kbps int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if dl, ok := s.downloads[id]; ok && dl.BitRate != kbps {
+		s.dirty = true
+		dl.BitRate = kbps
+	}
+}
+
+// SetName records the release name a client wants the download known by.
+func (s *Store) SetName(id, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if dl, ok := s.downloads[id]; ok && dl.Name != name {
+		s.dirty = true
+		dl.Name = name
+	}
+}
+
+// SetGroup makes a download part of the multi-file release known as group.
+func (s *Store) SetGroup(id, group string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if dl, ok := s.downloads[id]; ok && dl.Group != group {
+		s.dirty = true
+		dl.Group = group
+	}
+}
+
+// FinishProcessing records the outcome of post-processing: Completed at
+// path, or Failed with err.
+func (s *Store) FinishProcessing(id, path string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dl, ok := s.downloads[id]
+	if !ok {
+		return
+	}
+	s.dirty = true
+	dl.Path = path
+	dl.Status = StatusCompleted
+	dl.Error = ""
+	if err != nil {
+		dl.Status = StatusFailed
+		dl.Error = err.Error()
+	}
+	dl.CompletedAt = time.Now()
+}
+
 // IncrementRetry bumps the retry count and resets status to Queued for re-download.
 // Returns true if a retry is allowed, false if max retries exceeded.
 func (s *Store) IncrementRetry(id string) bool {
@@ -113,6 +248,7 @@ func (s *Store) IncrementRetry(id string) bool {
 	if !ok {
 		return false
 	}
+	s.dirty = true
 	dl.Retries++
 	if dl.Retries > dl.MaxRetries {
 		return false
@@ -120,6 +256,140 @@ func (s *Store) IncrementRetry(id string) bool {
 	dl.Status = StatusQueued
 	dl.BytesDownloaded = 0
 	dl.CompletedAt = time.Time{}
+	dl.AttemptedAt = time.Now()
+	return true
+}
+
+// Fail marks a download Failed for reason, with no retry.
+func (s *Store) Fail(id, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dl, ok := s.downloads[id]
+	if !ok {
+		return
+	}
+	s.dirty = true
+	dl.Status = StatusFailed
+	dl.Error = reason
+	delete(s.speeds, id)
+	if dl.CompletedAt.IsZero() {
+		dl.CompletedAt = time.Now()
+	}
+}
+
+// Requeue resets a failed download to Queued for a manual retry, giving it
+// a fresh set of automatic retries.
+func (s *Store) Requeue(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dl, ok := s.downloads[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if dl.Status != StatusFailed {
+		return ErrNotFailed
+	}
+	s.dirty = true
+	dl.Status = StatusQueued
+	dl.Retries = 0
+	dl.BytesDownloaded = 0
+	dl.CompletedAt = time.Time{}
+	dl.AttemptedAt = time.Now()
+	dl.Path = ""
+	dl.Error = ""
+	return nil
+}
+
+// RecordSpeed adds a sample of a download's progress at the given time,
+// keeping the most recent ones. averageSpeed, slskd's own figure for the
+// transfer, stands in for the first sample's speed, which has nothing to
+// be measured against.
+func (s *Store) RecordSpeed(id string, bytesDownloaded int64, averageSpeed float64, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.downloads[id]; !ok {
+		return
+	}
+	sample := SpeedSample{Time: at, BytesDownloaded: bytesDownloaded, BytesPerSecond: int64(averageSpeed)}
+	samples := s.speeds[id]
+	if n := len(samples); n > 0 {
+		sample.BytesPerSecond = 0
+		prev := samples[n-1]
+		if elapsed := at.Sub(prev.Time).Seconds(); elapsed > 0 && bytesDownloaded > prev.BytesDownloaded {
+			sample.BytesPerSecond = int64(float64(bytesDownloaded-prev.BytesDownloaded) / elapsed)
+		}
+	}
+	if len(samples) == speedSamples {
+		samples = append(samples[:0], samples[1:]...)
+	}
+	s.speeds[id] = append(samples, sample)
+}
+
+// Speed returns a download's current speed in bytes per second: its
+// progress over the speedWindow up to its latest sample, or with only one
+// sample that sample's speed. It is 0 for a download not in flight.
+func (s *Store) Speed(id string) int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	samples := s.speeds[id]
+	if len(samples) == 0 {
+		return 0
+	}
+	last := samples[len(samples)-1]
+	first := last
+	for _, sample := range slices.Backward(samples[:len(samples)-1]) {
+		if last.Time.Sub(sample.Time) > speedWindow {
+			break
+		}
+		first = sample
+	}
+	elapsed := last.Time.Sub(first.Time).Seconds()
+	if elapsed <= 0 {
+		return last.BytesPerSecond
+	}
+	return max(0, int64(float64(last.BytesDownloaded-first.BytesDownloaded)/elapsed))
+}
+
+// Speeds returns a download's recent speed samples, oldest first.
+func (s *Store) Speeds(id string) []SpeedSample {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]SpeedSample{}, s.speeds[id]...)
+}
+
+// Pause marks a queued or downloading download as Paused, reporting whether
+// it was in flight. Its slskd transfer is expected to be gone, so the
+// transfer ID and progress are cleared.
+func (s *Store) Pause(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dl, ok := s.downloads[id]
+	if !ok || (dl.Status != StatusQueued && dl.Status != StatusDownloading) {
+		return false
+	}
+	s.dirty = true
+	dl.Status = StatusPaused
+	dl.TransferID = ""
+	dl.BytesDownloaded = 0
+	return true
+}
+
+// Resume returns a paused download to Queued.
+func (s *Store) Resume(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dl, ok := s.downloads[id]
+	if !ok || dl.Status != StatusPaused {
+		return false
+	}
+	s.dirty = true
+	dl.Status = StatusQueued
 	return true
 }
 
@@ -128,26 +398,141 @@ func (s *Store) SetTransferID(id, transferID string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if dl, ok := s.downloads[id]; ok {
+	if dl, ok := s.downloads[id]; ok && dl.TransferID != transferID {
 		dl.TransferID = transferID
+		s.dirty = true
 	}
 }
 
+// Reassign moves a download to another peer sharing the same file, for a
+// retry from there.
+func (s *Store) Reassign(id, username, filename string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dl, ok := s.downloads[id]
+	if !ok {
+		return ErrNotFound
+	}
+	s.dirty = true
+	dl.Username = username
+	dl.Filename = filename
+	dl.TransferID = ""
+	return nil
+}
+
 // Remove deletes a download entry.
 func (s *Store) Remove(id string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	delete(s.downloads, id)
+	if dl, ok := s.downloads[id]; ok {
+		delete(s.downloads, id)
+		delete(s.speeds, id)
+		s.dirty = true
+		s.removed = append(s.removed, dl)
+		if len(s.removed) > keepRemoved {
+			s.removed = s.removed[len(s.removed)-keepRemoved:]
+		}
+	}
+}
+
+// Lookup returns a download by ID like Get, falling back to recently
+// removed downloads.
+func (s *Store) Lookup(id string) *Download {
+	if dl := s.Get(id); dl != nil {
+		return dl
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for i := len(s.removed) - 1; i >= 0; i-- {
+		if s.removed[i].ID == id {
+			cp := *s.removed[i]
+			return &cp
+		}
+	}
+	return nil
 }
 
-// Queue returns all downloads that are queued or downloading.
+// Queue returns all downloads that are queued, paused or downloading, in
+// queue order.
 func (s *Store) Queue() []*Download {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
+	var result []*Download
+	for _, dl := range s.queue() {
+		cp := *dl
+		result = append(result, &cp)
+	}
+	return result
+}
+
+// queue returns the queued, paused and downloading entries in queue order.
+// The caller must hold s.mu.
+func (s *Store) queue() []*Download {
+	var result []*Download
+	for _, dl := range s.downloads {
+		if dl.Status == StatusQueued || dl.Status == StatusPaused || dl.Status == StatusDownloading {
+			result = append(result, dl)
+		}
+	}
+	slices.SortFunc(result, func(a, b *Download) int {
+		return cmp.Or(cmp.Compare(a.Position, b.Position), a.AddedAt.Compare(b.AddedAt), strings.Compare(a.ID, b.ID))
+	})
+	return result
+}
+
+// Move puts the queued files of the release known as nzo at position in the
+// queue, moving them as one block. Positions count releases, as clients see
+// them, from 0 and are clamped to the queue's length; Move returns where the
+// release ended up.
+func (s *Store) Move(nzo string, position int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var moved, rest []*Download
+	for _, dl := range s.queue() {
+		if dl.NZO() == nzo {
+			moved = append(moved, dl)
+		} else {
+			rest = append(rest, dl)
+		}
+	}
+	if len(moved) == 0 {
+		return 0, ErrNotFound
+	}
+	// Where each remaining release's first file is, in release order.
+	var starts []int
+	seen := make(map[string]bool)
+	for i, dl := range rest {
+		if !seen[dl.NZO()] {
+			seen[dl.NZO()] = true
+			starts = append(starts, i)
+		}
+	}
+	position = min(max(position, 0), len(starts))
+	at := len(rest)
+	if position < len(starts) {
+		at = starts[position]
+	}
+	for i, dl := range slices.Concat(rest[:at], moved, rest[at:]) {
+		if dl.Position != i {
+			dl.Position = i
+			s.dirty = true
+		}
+	}
+	return position, nil
+}
+
+// History returns all transferred downloads: completed, failed, or still
+// post-processing.
+func (s *Store) History() []*Download {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	var result []*Download
 	for _, dl := range s.downloads {
-		if dl.Status == StatusQueued || dl.Status == StatusDownloading {
+		if dl.Status == StatusProcessing || dl.Status == StatusCompleted || dl.Status == StatusFailed {
 			cp := *dl
 			result = append(result, &cp)
 		}
@@ -155,14 +540,15 @@ func (s *Store) Queue() []*Download {
 	return result
 }
 
-// History returns all completed or failed downloads.
-func (s *Store) History() []*Download {
+// Active returns the downloads not yet finished: queued, paused,
+// downloading, or post-processing.
+func (s *Store) Active() []*Download {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	var result []*Download
 	for _, dl := range s.downloads {
-		if dl.Status == StatusCompleted || dl.Status == StatusFailed {
+		if dl.Status != StatusCompleted && dl.Status != StatusFailed {
 			cp := *dl
 			result = append(result, &cp)
 		}
@@ -183,6 +569,25 @@ func (s *Store) All() []*Download {
 	return result
 }
 
+// Len returns the number of tracked downloads.
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.downloads)
+}
+
+// Counts returns the number of downloads in each status.
+func (s *Store) Counts() map[Status]int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := make(map[Status]int)
+	for _, dl := range s.downloads {
+		counts[dl.Status]++
+	}
+	return counts
+}
+
 // FindByFile looks up a download by username and filename.
 func (s *Store) FindByFile(username, filename string) *Download {
 	s.mu.RLock()
@@ -1,9 +1,12 @@
 package store
 
 import (
+	"errors"
+	"slices"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 )
 
 func TestStore_AddAndGet(t *testing.T) {
@@ -116,6 +119,20 @@ func TestStore_Remove(t *testing.T) {
 	}
 }
 
+func TestStore_LookupRemoved(t *testing.T) {
+	s := New()
+	id := s.Add("user1", "file.mkv", 1000, "radarr")
+	s.Remove(id)
+
+	dl := s.Lookup(id)
+	if dl == nil || dl.Username != "user1" {
+		t.Fatalf("expected removed download to be found, got %+v", dl)
+	}
+	if s.Lookup("SABnzbd_nzo_missing") != nil {
+		t.Error("expected nil for unknown id")
+	}
+}
+
 func TestStore_FindByFile(t *testing.T) {
 	s := New()
 	s.Add("user1", "path/to/file.mkv", 1000, "radarr")
@@ -176,3 +193,274 @@ func TestStore_ConcurrentAccess(t *testing.T) {
 		t.Errorf("expected 100 downloads, got %d", len(all))
 	}
 }
+
+func TestStore_Counts(t *testing.T) {
+	s := New()
+	s.Add("user1", "file1.mkv", 100, "radarr")
+	id := s.Add("user2", "file2.mkv", 200, "sonarr")
+	s.UpdateTransfer(id, 200, StatusCompleted)
+
+	counts := s.Counts()
+	if counts[StatusQueued] != 1 || counts[StatusCompleted] != 1 {
+		t.Errorf("unexpected counts: %v", counts)
+	}
+}
+
+func TestStore_Requeue(t *testing.T) {
+	s := New()
+	id := s.Add("user1", "file1.mkv", 100, "radarr")
+
+	if err := s.Requeue(id); !errors.Is(err, ErrNotFailed) {
+		t.Errorf("expected ErrNotFailed for a queued download, got %v", err)
+	}
+	if err := s.Requeue("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	s.UpdateTransfer(id, 40, StatusFailed)
+	if err := s.Requeue(id); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	dl := s.Get(id)
+	if dl.Status != StatusQueued || dl.BytesDownloaded != 0 || !dl.CompletedAt.IsZero() {
+		t.Errorf("expected download reset to queued, got %+v", dl)
+	}
+}
+
+func TestStore_Reassign(t *testing.T) {
+	s := New()
+	id := s.Add("user1", `Movies\Heat.mkv`, 100, "radarr")
+	s.SetTransferID(id, "t1")
+
+	if err := s.Reassign(id, "user2", `Films\Heat.mkv`); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	dl := s.Get(id)
+	if dl.Username != "user2" || dl.Filename != `Films\Heat.mkv` || dl.TransferID != "" {
+		t.Errorf("expected the download moved to user2, got %+v", dl)
+	}
+	if err := s.Reassign("missing", "user2", "x"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestStore_FinishProcessing(t *testing.T) {
+	s := New()
+	id := s.Add("user1", "file1.flac", 100, "lidarr")
+	s.UpdateTransfer(id, 100, StatusProcessing)
+	if dl := s.Get(id); !dl.CompletedAt.IsZero() {
+		t.Error("expected no completion time while processing")
+	}
+	if len(s.History()) != 1 {
+		t.Error("expected processing downloads in history")
+	}
+
+	s.FinishProcessing(id, "/data/file1.flac", nil)
+	dl := s.Get(id)
+	if dl.Status != StatusCompleted || dl.Path != "/data/file1.flac" || dl.CompletedAt.IsZero() {
+		t.Errorf("expected completed at path, got %+v", dl)
+	}
+
+	id = s.Add("user1", "file2.flac", 100, "lidarr")
+	s.UpdateTransfer(id, 100, StatusProcessing)
+	s.FinishProcessing(id, "/data/file2.flac", errors.New("rename: permission denied"))
+	dl = s.Get(id)
+	if dl.Status != StatusFailed || dl.Error != "rename: permission denied" {
+		t.Errorf("expected failed with error, got %+v", dl)
+	}
+	if err := s.Requeue(id); err != nil {
+		t.Fatal(err)
+	}
+	if dl := s.Get(id); dl.Path != "" || dl.Error != "" {
+		t.Errorf("expected requeue to clear processing results, got %+v", dl)
+	}
+}
+
+func TestStore_Active(t *testing.T) {
+	s := New()
+	queued := s.Add("u", "a.mkv", 100, "")
+	done := s.Add("u", "b.mkv", 100, "")
+	processing := s.Add("u", "c.mkv", 100, "")
+	s.UpdateTransfer(done, 100, StatusCompleted)
+	s.UpdateTransfer(processing, 100, StatusProcessing)
+
+	active := s.Active()
+	ids := map[string]bool{}
+	for _, dl := range active {
+		ids[dl.ID] = true
+	}
+	if len(active) != 2 || !ids[queued] || !ids[processing] {
+		t.Errorf("expected the queued and processing downloads, got %+v", active)
+	}
+}
+
+func TestStore_UpdateTransfer_Unchanged(t *testing.T) {
+	s := New()
+	id := s.Add("u", "a.mkv", 100, "")
+	s.UpdateTransfer(id, 50, StatusDownloading)
+	s.dirty = false
+
+	s.UpdateTransfer(id, 50, StatusDownloading)
+	if s.dirty {
+		t.Error("an unchanged transfer should not mark the store dirty")
+	}
+	s.UpdateTransfer(id, 60, StatusDownloading)
+	if !s.dirty {
+		t.Error("progress should mark the store dirty")
+	}
+}
+
+func TestStore_RecordSpeed(t *testing.T) {
+	s := New()
+	id := s.Add("u", "a.mkv", 10000, "")
+	start := time.Now()
+	for i := range speedSamples + 5 {
+		s.RecordSpeed(id, int64(i)*100, 0, start.Add(time.Duration(i)*time.Second))
+	}
+
+	samples := s.Speeds(id)
+	if len(samples) != speedSamples {
+		t.Fatalf("expected %d samples, got %d", speedSamples, len(samples))
+	}
+	if samples[0].BytesDownloaded != 500 {
+		t.Errorf("expected the oldest samples dropped, first is at %d bytes", samples[0].BytesDownloaded)
+	}
+	if last := samples[len(samples)-1]; last.BytesPerSecond != 100 {
+		t.Errorf("expected 100 B/s, got %d", last.BytesPerSecond)
+	}
+
+	s.UpdateTransfer(id, 10000, StatusCompleted)
+	if len(s.Speeds(id)) != 0 {
+		t.Error("expected the history dropped once the download finished")
+	}
+	s.RecordSpeed("missing", 1, 0, start)
+	if len(s.Speeds("missing")) != 0 {
+		t.Error("expected no history for an unknown download")
+	}
+}
+
+func TestStore_Speed(t *testing.T) {
+	s := New()
+	id := s.Add("u", "a.mkv", 1<<30, "")
+	if got := s.Speed(id); got != 0 {
+		t.Errorf("expected no speed before any sample, got %d", got)
+	}
+	start := time.Now()
+	s.RecordSpeed(id, 0, 2048, start)
+	if got := s.Speed(id); got != 2048 {
+		t.Errorf("expected slskd's average for the first sample, got %d", got)
+	}
+
+	// A minute at 100 B/s, then 20 seconds at 1000 B/s: the window only
+	// looks back 30 seconds.
+	var bytes int64
+	for i := 1; i <= 80; i++ {
+		if i <= 60 {
+			bytes += 100
+		} else {
+			bytes += 1000
+		}
+		s.RecordSpeed(id, bytes, 0, start.Add(time.Duration(i)*time.Second))
+	}
+	if got := s.Speed(id); got != (20*1000+10*100)/30 {
+		t.Errorf("expected the speed over the last 30 seconds, got %d", got)
+	}
+}
+
+func TestStore_PauseResume(t *testing.T) {
+	s := New()
+	id := s.Add("u", "a.mkv", 100, "")
+	s.SetTransferID(id, "t1")
+	s.UpdateTransfer(id, 40, StatusDownloading)
+
+	if !s.Pause(id) {
+		t.Fatal("expected a downloading download to pause")
+	}
+	dl := s.Get(id)
+	if dl.Status != StatusPaused || dl.TransferID != "" || dl.BytesDownloaded != 0 {
+		t.Errorf("expected Paused with the transfer cleared, got %+v", dl)
+	}
+	if len(s.Queue()) != 1 {
+		t.Error("expected paused downloads in the queue")
+	}
+	if s.Pause(id) {
+		t.Error("pausing twice should report false")
+	}
+	if !s.Resume(id) || s.Get(id).Status != StatusQueued {
+		t.Errorf("expected Queued after resume, got %s", s.Get(id).Status)
+	}
+}
+
+func TestStore_Move(t *testing.T) {
+	s := New()
+	a := s.Add("u", "a.mkv", 100, "")
+	b := s.Add("u", "b.mkv", 100, "")
+	c := s.Add("u", "c.mkv", 100, "")
+	done := s.Add("u", "done.mkv", 100, "")
+	s.UpdateTransfer(done, 100, StatusCompleted)
+
+	order := func() []string {
+		var ids []string
+		for _, dl := range s.Queue() {
+			ids = append(ids, dl.ID)
+		}
+		return ids
+	}
+	if got := order(); !slices.Equal(got, []string{a, b, c}) {
+		t.Fatalf("expected grab order, got %v", got)
+	}
+
+	if pos, err := s.Move(c, 0); err != nil || pos != 0 {
+		t.Fatalf("unexpected move result: %d %v", pos, err)
+	}
+	if got := order(); !slices.Equal(got, []string{c, a, b}) {
+		t.Errorf("expected c first, got %v", got)
+	}
+	if pos, _ := s.Move(c, 99); pos != 2 {
+		t.Errorf("expected the position clamped to 2, got %d", pos)
+	}
+	if got := order(); !slices.Equal(got, []string{a, b, c}) {
+		t.Errorf("expected c last, got %v", got)
+	}
+
+	d := s.Add("u", "d.mkv", 100, "")
+	if got := order(); got[len(got)-1] != d {
+		t.Errorf("expected new grabs at the end, got %v", got)
+	}
+	if _, err := s.Move(done, 0); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a finished download, got %v", err)
+	}
+}
+
+func TestStore_Move_Release(t *testing.T) {
+	s := New()
+	a := s.Add("u", "a.mkv", 100, "")
+	album := s.Add("u", `Album\01.flac`, 100, "")
+	b := s.Add("u", "b.mkv", 100, "")
+	track := s.Add("u", `Album\02.flac`, 100, "")
+	s.SetGroup(track, album)
+
+	order := func() []string {
+		var ids []string
+		for _, dl := range s.Queue() {
+			ids = append(ids, dl.ID)
+		}
+		return ids
+	}
+	// Positions count releases: a, the album, b.
+	if pos, err := s.Move(album, 2); err != nil || pos != 2 {
+		t.Fatalf("unexpected move result: %d %v", pos, err)
+	}
+	if got := order(); !slices.Equal(got, []string{a, b, album, track}) {
+		t.Errorf("expected the album's files moved last together, got %v", got)
+	}
+	if pos, _ := s.Move(b, 2); pos != 2 {
+		t.Errorf("expected b at 2, got %d", pos)
+	}
+	if got := order(); !slices.Equal(got, []string{a, album, track, b}) {
+		t.Errorf("expected b after the whole album, got %v", got)
+	}
+	if _, err := s.Move(track, 0); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a file's own ID within a release, got %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package textnorm
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// cp1252 maps the bytes 0x80-0x9F of Windows-1252 to runes; the other
+// bytes above 0x7F are the same as in Latin-1. Undefined bytes map to 0.
+var cp1252 = [32]rune{
+	'€', 0, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0, 'Ž', 0,
+	0, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0, 'ž', 'Ÿ',
+}
+
+// Clean makes a name from a Soulseek peer safe to show and to put in XML.
+// Bytes that aren't valid UTF-8 are read as Windows-1252, which most
+// non-UTF-8 clients send; UTF-8 that was mistakenly decoded as
+// Windows-1252 ("CafÃ©") is restored; and control characters XML can't
+// carry are dropped.
+func Clean(s string) string {
+	if !utf8.ValidString(s) {
+		s = decodeCP1252(s)
+	}
+	s = fixMojibake(s)
+	if strings.IndexFunc(s, invalidXML) >= 0 {
+		s = strings.Map(func(r rune) rune {
+			if invalidXML(r) {
+				return -1
+			}
+			return r
+		}, s)
+	}
+	return s
+}
+
+// decodeCP1252 keeps the valid UTF-8 sequences in s and reads every other
+// byte as Windows-1252.
+func decodeCP1252(s string) string {
+	var b strings.Builder
+	for len(s) > 0 {
+		r, size := utf8.DecodeRuneInString(s)
+		if r == utf8.RuneError && size == 1 {
+			r = cp1252Rune(s[0])
+		}
+		b.WriteRune(r)
+		s = s[size:]
+	}
+	return b.String()
+}
+
+func cp1252Rune(c byte) rune {
+	if c >= 0x80 && c < 0xA0 {
+		if r := cp1252[c-0x80]; r != 0 {
+			return r
+		}
+		return utf8.RuneError
+	}
+	return rune(c)
+}
+
+// fixMojibake undoes UTF-8 decoded as Windows-1252: when every rune in s
+// maps back to a single Windows-1252 byte and those bytes are valid UTF-8
+// with at least one multi-byte sequence, the UTF-8 reading is returned.
+func fixMojibake(s string) string {
+	b := make([]byte, 0, len(s))
+	multibyte := false
+	for _, r := range s {
+		c, ok := cp1252Byte(r)
+		if !ok {
+			return s
+		}
+		if c >= 0x80 {
+			multibyte = true
+		}
+		b = append(b, c)
+	}
+	if !multibyte || !utf8.Valid(b) {
+		return s
+	}
+	return string(b)
+}
+
+func cp1252Byte(r rune) (byte, bool) {
+	switch {
+	case r < 0x80 || (r >= 0xA0 && r <= 0xFF):
+		return byte(r), true
+	case r >= 0x80 && r < 0xA0:
+		return 0, false // C1 controls aren't text
+	}
+	for i, c := range cp1252 {
+		if c == r {
+			return byte(0x80 + i), true
+		}
+	}
+	return 0, false
+}
+
+// invalidXML reports whether r can't appear in an XML 1.0 document.
+func invalidXML(r rune) bool {
+	return (r < 0x20 && r != '\t' && r != '\n' && r != '\r') ||
+		(r >= 0xD800 && r <= 0xDFFF) || r == 0xFFFE || r == 0xFFFF
+}
@@ -0,0 +1,120 @@
+// Package textnorm normalizes text for matching Soulseek queries and file
+// names. Peers on macOS share paths with decomposed accents ("e" followed
+// by a combining acute) that don't match the composed letters ("é") queries
+// are typed with, so both sides are composed before comparing.
+package textnorm
+
+import (
+	"strings"
+	"unicode"
+)
+
+// composed maps a base letter and a combining mark to their composition.
+var composed = func() map[[2]rune]rune {
+	m := make(map[[2]rune]rune)
+	for mark, pairs := range compositions {
+		rs := []rune(pairs)
+		for i := 0; i+1 < len(rs); i += 2 {
+			m[[2]rune{rs[i], mark}] = rs[i+1]
+		}
+	}
+	return m
+}()
+
+// Hangul syllable composition constants, from the Unicode standard.
+const (
+	hangulBase = 0xAC00
+	leadBase   = 0x1100
+	vowelBase  = 0x1161
+	trailBase  = 0x11A7
+	vowelCount = 21
+	trailCount = 28
+	syllables  = 11172
+)
+
+// NFC composes decomposed letters in s: Latin, Greek and Cyrillic letters
+// with diacritics, kana with voicing marks, and Hangul syllables. It
+// follows Unicode canonical composition for those scripts but isn't a full
+// NFC implementation: text is not decomposed or reordered first.
+func NFC(s string) string {
+	if !needsComposition(s) {
+		return s
+	}
+	out := make([]rune, 0, len(s))
+	starter := -1       // index in out of the last starter
+	var lastClass uint8 // class of the last mark kept after the starter
+	for _, r := range s {
+		class := combiningClass(r)
+		if starter >= 0 {
+			adjacent := starter == len(out)-1
+			if class == 0 && adjacent {
+				if c, ok := composeHangul(out[starter], r); ok {
+					out[starter] = c
+					continue
+				}
+			}
+			if class != 0 && (adjacent || lastClass < class) {
+				if c, ok := composed[[2]rune{out[starter], r}]; ok {
+					out[starter] = c
+					continue
+				}
+			}
+		}
+		out = append(out, r)
+		if class == 0 {
+			starter, lastClass = len(out)-1, 0
+		} else {
+			lastClass = class
+		}
+	}
+	return string(out)
+}
+
+// Fold cleans and composes s and lowercases it, for case-insensitive
+// comparison.
+func Fold(s string) string {
+	return strings.ToLower(NFC(Clean(s)))
+}
+
+func needsComposition(s string) bool {
+	for _, r := range s {
+		if combiningClass(r) != 0 || (r >= vowelBase && r < trailBase+trailCount) {
+			return true
+		}
+	}
+	return false
+}
+
+// combiningClass returns r's canonical combining class: exact for the
+// combining diacritical marks and kana voicing marks, and the common class
+// 230 for other nonspacing marks.
+func combiningClass(r rune) uint8 {
+	switch {
+	case r < 0x0300:
+		return 0
+	case r <= 0x036F:
+		for _, m := range markClasses {
+			if r <= m.hi {
+				return m.class
+			}
+		}
+	case r == 0x3099 || r == 0x309A:
+		return 8
+	case unicode.Is(unicode.Mn, r):
+		return 230
+	}
+	return 0
+}
+
+// composeHangul composes a leading consonant and vowel, or a syllable
+// without a final consonant and a trailing one.
+func composeHangul(a, b rune) (rune, bool) {
+	switch {
+	case a >= leadBase && a < leadBase+19 && b >= vowelBase && b < vowelBase+vowelCount:
+		return hangulBase + ((a-leadBase)*vowelCount+(b-vowelBase))*trailCount, true
+	case a >= hangulBase && a < hangulBase+syllables && (a-hangulBase)%trailCount == 0 &&
+		b > trailBase && b < trailBase+trailCount:
+		return a + (b - trailBase), true
+	}
+	return 0, false
+}
@@ -0,0 +1,48 @@
+package textnorm
+
+import "testing"
+
+func TestNFC(t *testing.T) {
+	tests := map[string]string{
+		"Café":              "Café",
+		"Mötley Crüe":      "Mötley Crüe",
+		"й":                 "й",  // Cyrillic short i
+		"が":                 "が",  // kana with dakuten
+		"각":                "각",  // Hangul jamo
+		"ộ":                "ộ",  // dot below, then circumflex
+		"ä́":                "ä́", // no composition for the acute
+		"plain ascii":        "plain ascii",
+		"é already composed": "é already composed",
+		"́ leading mark":     "́ leading mark",
+		"ḝ cedilla first":  "ḝ cedilla first",
+	}
+	for in, want := range tests {
+		if got := NFC(in); got != want {
+			t.Errorf("NFC(%+q) = %+q, want %+q", in, got, want)
+		}
+	}
+}
+
+func TestFold(t *testing.T) {
+	if got := Fold("SIGUR RÓS"); got != "sigur rós" {
+		t.Errorf("Fold = %+q", got)
+	}
+}
+
+func TestClean(t *testing.T) {
+	tests := map[string]string{
+		"Beyonc\xe9 - D\xe9j\xe0 Vu.mp3": "Beyoncé - Déjà Vu.mp3", // Latin-1 bytes
+		"\x93Quoted\x94 \x96 Live":       "“Quoted” – Live",       // Windows-1252 punctuation
+		"CafÃ© del Mar":                  "Café del Mar",          // UTF-8 read as Windows-1252
+		"Donâ€™t Stop":                   "Don’t Stop",
+		"Café déjà vu":                   "Café déjà vu",
+		"bell\x07 and\x00 nul":           "bell and nul",
+		"Ãx not mojibake":                "Ãx not mojibake",
+		"plain":                          "plain",
+	}
+	for in, want := range tests {
+		if got := Clean(in); got != want {
+			t.Errorf("Clean(%+q) = %+q, want %+q", in, got, want)
+		}
+	}
+}
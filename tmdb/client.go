@@ -0,0 +1,127 @@
+// Package tmdb looks up movies on The Movie Database, so Radarr's id-based
+// searches can also search a foreign film's original title, which is how
+// Soulseek shares of it are usually named.
+package tmdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBaseURL is the TMDB v3 API.
+const DefaultBaseURL = "https://api.themoviedb.org/3"
+
+// Client talks to the TMDB v3 API. Lookups are cached for the life of the
+// process; a movie's titles don't change between Radarr's searches.
+type Client struct {
+	BaseURL    string
+	APIKey     string
+	HTTPClient *http.Client
+
+	mu    sync.Mutex
+	cache map[string]*Movie
+}
+
+func NewClient(apiKey string) *Client {
+	return &Client{
+		BaseURL:    DefaultBaseURL,
+		APIKey:     apiKey,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Movie is the part of a TMDB movie record searches use. Title is in the
+// API's default language (English), OriginalTitle in the film's own.
+type Movie struct {
+	Title         string `json:"title"`
+	OriginalTitle string `json:"original_title"`
+	ReleaseDate   string `json:"release_date"`
+}
+
+// Year returns the release year, or "" when TMDB doesn't know it.
+func (m *Movie) Year() string {
+	if len(m.ReleaseDate) < 4 {
+		return ""
+	}
+	return m.ReleaseDate[:4]
+}
+
+// Movie looks up a movie by TMDB id, or by IMDb id (with or without its
+// "tt" prefix) when tmdbID is empty.
+func (c *Client) Movie(ctx context.Context, tmdbID, imdbID string) (*Movie, error) {
+	var key, endpoint string
+	switch {
+	case tmdbID != "":
+		key, endpoint = "tmdb:"+tmdbID, "/movie/"+url.PathEscape(tmdbID)
+	case imdbID != "":
+		if !strings.HasPrefix(imdbID, "tt") {
+			imdbID = "tt" + imdbID
+		}
+		key, endpoint = "imdb:"+imdbID, "/find/"+url.PathEscape(imdbID)+"?external_source=imdb_id"
+	default:
+		return nil, fmt.Errorf("no movie id given")
+	}
+
+	c.mu.Lock()
+	m, ok := c.cache[key]
+	c.mu.Unlock()
+	if ok {
+		return m, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(c.BaseURL, "/")+endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create movie request: %w", err)
+	}
+	if tmdbID != "" {
+		m = new(Movie)
+		err = c.do(req, m)
+	} else {
+		var found struct {
+			MovieResults []Movie `json:"movie_results"`
+		}
+		if err = c.do(req, &found); err == nil {
+			if len(found.MovieResults) == 0 {
+				return nil, fmt.Errorf("no movie found for %s", imdbID)
+			}
+			m = &found.MovieResults[0]
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get movie %s: %w", strings.TrimPrefix(key, "tmdb:"), err)
+	}
+
+	c.mu.Lock()
+	if c.cache == nil {
+		c.cache = make(map[string]*Movie)
+	}
+	c.cache[key] = m
+	c.mu.Unlock()
+	return m, nil
+}
+
+// do executes req, decoding the JSON response into out.
+func (c *Client) do(req *http.Request, out any) error {
+	q := req.URL.Query()
+	q.Set("api_key", c.APIKey)
+	req.URL.RawQuery = q.Encode()
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("request failed with status %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package tmdb
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClient_Movie(t *testing.T) {
+	calls := 0
+	mock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Query().Get("api_key") != "key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/movie/11216":
+			w.Write([]byte(`{"title":"Cinema Paradiso","original_title":"Nuovo Cinema Paradiso","release_date":"1988-11-17"}`))
+		case "/find/tt0095765":
+			if r.URL.Query().Get("external_source") != "imdb_id" {
+				t.Errorf("expected an imdb_id lookup, got %s", r.URL.RawQuery)
+			}
+			w.Write([]byte(`{"movie_results":[{"title":"Cinema Paradiso","original_title":"Nuovo Cinema Paradiso","release_date":"1988-11-17"}]}`))
+		case "/find/tt0000000":
+			w.Write([]byte(`{"movie_results":[]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer mock.Close()
+
+	c := NewClient("key")
+	c.BaseURL = mock.URL
+
+	m, err := c.Movie(context.Background(), "11216", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m.OriginalTitle != "Nuovo Cinema Paradiso" || m.Year() != "1988" {
+		t.Errorf("unexpected movie: %+v", m)
+	}
+	if _, err := c.Movie(context.Background(), "11216", ""); err != nil || calls != 1 {
+		t.Errorf("expected the lookup cached, got %d calls (%v)", calls, err)
+	}
+
+	if m, err := c.Movie(context.Background(), "", "0095765"); err != nil || m.Title != "Cinema Paradiso" {
+		t.Errorf("unexpected IMDb lookup: %+v %v", m, err)
+	}
+	if _, err := c.Movie(context.Background(), "", "tt0000000"); err == nil {
+		t.Error("expected an error for an unknown IMDb id")
+	}
+	if _, err := c.Movie(context.Background(), "", ""); err == nil {
+		t.Error("expected an error without an id")
+	}
+
+	c.APIKey = "wrong"
+	if _, err := c.Movie(context.Background(), "603", ""); err == nil {
+		t.Error("expected an error for a rejected key")
+	}
+}
@@ -0,0 +1,44 @@
+package tracing
+
+import (
+	"net/http"
+)
+
+// Middleware starts a server span for every request, joining the caller's
+// trace when a traceparent header is present.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if active.Load() == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := Extract(r.Context(), r.Header)
+		ctx, span := Start(ctx, r.Method+" "+r.URL.Path, KindServer)
+		defer span.End()
+
+		span.SetAttr("http.method", r.Method)
+		span.SetAttr("http.target", r.URL.Path)
+		q := r.URL.Query()
+		if t := q.Get("t"); t != "" {
+			span.SetAttr("newznab.function", t)
+		}
+		if mode := q.Get("mode"); mode != "" {
+			span.SetAttr("sabnzbd.mode", mode)
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		span.SetAttr("http.status_code", rec.status)
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
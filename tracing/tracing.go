@@ -0,0 +1,170 @@
+// Package tracing records request spans and exports them to an OTLP/HTTP
+// collector (Tempo, Jaeger, the OpenTelemetry Collector) using the JSON
+// encoding. When no exporter is configured every operation is a no-op.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Kind is the OTLP span kind.
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+// maxPending bounds the export buffer so an unreachable collector can't
+// grow memory without limit; excess spans are dropped.
+const maxPending = 2048
+
+var active atomic.Pointer[Tracer]
+
+// Tracer buffers finished spans and exports them periodically.
+type Tracer struct {
+	Endpoint    string // OTLP/HTTP base URL, e.g. "http://tempo:4318"
+	ServiceName string
+	HTTPClient  *http.Client
+
+	mu      sync.Mutex
+	pending []*Span
+	dropped int
+}
+
+// Init installs t as the active tracer.
+func Init(t *Tracer) {
+	if t.HTTPClient == nil {
+		t.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	active.Store(t)
+}
+
+// Span is a single timed operation. A nil *Span is valid and ignores all calls.
+type Span struct {
+	tracer   *Tracer
+	name     string
+	kind     Kind
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	start    time.Time
+	end      time.Time
+	attrs    map[string]any
+	errMsg   string
+	ended    atomic.Bool
+}
+
+type spanKey struct{}
+
+// Start begins a span as a child of the span in ctx, if any. It returns a
+// nil span when tracing is disabled.
+func Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	t := active.Load()
+	if t == nil {
+		return ctx, nil
+	}
+	s := &Span{tracer: t, name: name, kind: kind, start: time.Now(), attrs: map[string]any{}}
+	if parent := FromContext(ctx); parent != nil {
+		s.traceID = parent.traceID
+		s.parentID = parent.spanID
+	} else if rc, ok := ctx.Value(remoteKey{}).(remoteContext); ok {
+		s.traceID = rc.traceID
+		s.parentID = rc.spanID
+	} else {
+		_, _ = rand.Read(s.traceID[:])
+	}
+	_, _ = rand.Read(s.spanID[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// FromContext returns the current span, or nil.
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// SetAttr records an attribute on the span.
+func (s *Span) SetAttr(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.attrs[key] = value
+}
+
+// SetError marks the span as failed.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.errMsg = err.Error()
+}
+
+// End finishes the span and queues it for export.
+func (s *Span) End() {
+	if s == nil || s.ended.Swap(true) {
+		return
+	}
+	s.end = time.Now()
+	s.tracer.enqueue(s)
+}
+
+// TraceID returns the hex trace ID, or "" for a nil span.
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return hex.EncodeToString(s.traceID[:])
+}
+
+func (t *Tracer) enqueue(s *Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.pending) >= maxPending {
+		t.dropped++
+		return
+	}
+	t.pending = append(t.pending, s)
+}
+
+type remoteKey struct{}
+
+type remoteContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+}
+
+// Inject writes the W3C traceparent header for the span in ctx.
+func Inject(ctx context.Context, h http.Header) {
+	s := FromContext(ctx)
+	if s == nil {
+		return
+	}
+	h.Set("traceparent", fmt.Sprintf("00-%x-%x-01", s.traceID, s.spanID))
+}
+
+// Extract returns ctx carrying the remote parent from a traceparent header,
+// so spans started from it join the caller's trace.
+func Extract(ctx context.Context, h http.Header) context.Context {
+	parts := strings.Split(h.Get("traceparent"), "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return ctx
+	}
+	var rc remoteContext
+	if _, err := hex.Decode(rc.traceID[:], []byte(parts[1])); err != nil {
+		return ctx
+	}
+	if _, err := hex.Decode(rc.spanID[:], []byte(parts[2])); err != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteKey{}, rc)
+}
@@ -0,0 +1,84 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStart_DisabledIsNoop(t *testing.T) {
+	active.Store(nil)
+
+	ctx, span := Start(context.Background(), "noop", KindInternal)
+	if span != nil {
+		t.Fatal("expected nil span when tracing is disabled")
+	}
+	// Nil spans must tolerate every call
+	span.SetAttr("k", "v")
+	span.SetError(errors.New("boom"))
+	span.End()
+	if FromContext(ctx) != nil {
+		t.Error("expected no span in context")
+	}
+}
+
+func TestInjectExtract(t *testing.T) {
+	tr := &Tracer{ServiceName: "test"}
+	Init(tr)
+	defer active.Store(nil)
+
+	ctx, parent := Start(context.Background(), "parent", KindClient)
+	h := http.Header{}
+	Inject(ctx, h)
+
+	remote := Extract(context.Background(), h)
+	_, child := Start(remote, "child", KindServer)
+
+	if child.TraceID() != parent.TraceID() {
+		t.Errorf("expected child to join trace %s, got %s", parent.TraceID(), child.TraceID())
+	}
+	if child.parentID != parent.spanID {
+		t.Error("expected child's parent to be the injected span")
+	}
+}
+
+func TestFlush_ExportsOTLPJSON(t *testing.T) {
+	var got otlpRequest
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" {
+			t.Errorf("expected /v1/traces, got %s", r.URL.Path)
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer collector.Close()
+
+	tr := &Tracer{Endpoint: collector.URL, ServiceName: "slskrr"}
+	Init(tr)
+	defer active.Store(nil)
+
+	ctx, root := Start(context.Background(), "GET /api", KindServer)
+	_, child := Start(ctx, "slskd.search.create", KindClient)
+	child.SetAttr("query", "The Matrix")
+	child.SetError(errors.New("status 500"))
+	child.End()
+	root.End()
+
+	tr.Flush(context.Background())
+
+	if len(got.ResourceSpans) != 1 || len(got.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("unexpected export shape: %+v", got)
+	}
+	spans := got.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	if spans[0].Name != "slskd.search.create" || spans[0].ParentSpanID != spans[1].SpanID {
+		t.Errorf("expected child span linked to root, got %+v", spans[0])
+	}
+	if spans[0].Status == nil || spans[0].Status.Code != 2 {
+		t.Error("expected error status on failed span")
+	}
+}
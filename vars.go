@@ -0,0 +1,74 @@
+package main
+
+import (
+	"expvar"
+	"runtime"
+	"time"
+
+	"github.com/nerney/slskrr/metrics"
+	"github.com/nerney/slskrr/newznab"
+	"github.com/nerney/slskrr/notify"
+	"github.com/nerney/slskrr/slskd"
+	"github.com/nerney/slskrr/store"
+)
+
+// publishVars registers slskrr's runtime stats with expvar, served at
+// /debug/vars alongside the standard memstats and cmdline.
+func publishVars(st *store.Store, client *slskd.Client, notifiers *notify.Dispatcher) {
+	expvar.Publish("goroutines", expvar.Func(func() any {
+		return runtime.NumGoroutine()
+	}))
+	expvar.Publish("gc", expvar.Func(func() any {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		return map[string]any{
+			"num_gc":         m.NumGC,
+			"pause_total_ms": time.Duration(m.PauseTotalNs).Milliseconds(),
+			"last_pause_us":  time.Duration(m.PauseNs[(m.NumGC+255)%256]).Microseconds(),
+			"heap_alloc":     m.HeapAlloc,
+			"next_gc":        m.NextGC,
+		}
+	}))
+	expvar.Publish("store", expvar.Func(func() any {
+		counts := st.Counts()
+		out := map[string]int{"total": 0}
+		for status, n := range counts {
+			out[string(status)] = n
+			out["total"] += n
+		}
+		return out
+	}))
+	expvar.Publish("searches_in_flight", expvar.Func(func() any {
+		return client.ActiveSearches()
+	}))
+	expvar.Publish("searches_queued", expvar.Func(func() any {
+		return client.QueuedSearches()
+	}))
+	expvar.Publish("search_cache", expvar.Func(func() any {
+		return newznab.CacheStats()
+	}))
+	expvar.Publish("notifications", expvar.Func(func() any {
+		return notifiers.Stats()
+	}))
+}
+
+// registerMetrics registers the Prometheus gauges computed from the store
+// and the slskd client on every scrape.
+func registerMetrics(st *store.Store, client *slskd.Client) {
+	type key struct {
+		status   store.Status
+		category string
+	}
+	metrics.NewGaugeFunc("slskrr_downloads", "Tracked downloads by status and category.", func(emit func(float64, ...string)) {
+		counts := make(map[key]int)
+		for _, dl := range st.All() {
+			counts[key{dl.Status, dl.Category}]++
+		}
+		for k, n := range counts {
+			emit(float64(n), string(k.status), k.category)
+		}
+	}, "status", "category")
+	metrics.NewGaugeFunc("slskrr_searches_queued", "Searches waiting for one of the MAX_SEARCHES slots.", func(emit func(float64, ...string)) {
+		emit(float64(client.QueuedSearches()))
+	})
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nerney/slskrr/metrics"
+	"github.com/nerney/slskrr/slskd"
+	"github.com/nerney/slskrr/store"
+)
+
+func TestPublishVars(t *testing.T) {
+	st := store.New()
+	st.Add("user1", "file.mkv", 100, "radarr")
+	publishVars(st, slskd.NewClient("", ""), nil)
+
+	var storeVars map[string]int
+	if err := json.Unmarshal([]byte(expvar.Get("store").String()), &storeVars); err != nil {
+		t.Fatalf("decode store var: %v", err)
+	}
+	if storeVars["total"] != 1 || storeVars["Queued"] != 1 {
+		t.Errorf("unexpected store vars: %v", storeVars)
+	}
+	if expvar.Get("goroutines").String() == "0" {
+		t.Error("expected goroutine count")
+	}
+	if expvar.Get("searches_in_flight").String() != "0" {
+		t.Errorf("expected no searches in flight, got %s", expvar.Get("searches_in_flight"))
+	}
+	var cache map[string]int
+	if err := json.Unmarshal([]byte(expvar.Get("search_cache").String()), &cache); err != nil {
+		t.Fatalf("decode search_cache var: %v", err)
+	}
+	for _, result := range []string{"hit", "miss", "bypass"} {
+		if _, ok := cache[result]; !ok {
+			t.Errorf("expected a %s count in %v", result, cache)
+		}
+	}
+}
+
+func TestRegisterMetrics(t *testing.T) {
+	st := store.New()
+	st.Add("user1", "a.mkv", 100, "radarr")
+	st.Add("user1", "b.mkv", 100, "radarr")
+	id := st.Add("user2", "c.flac", 100, "lidarr")
+	st.UpdateTransfer(id, 100, store.StatusCompleted)
+	registerMetrics(st, slskd.NewClient("", ""))
+
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		`slskrr_downloads{status="Completed",category="lidarr"} 1`,
+		`slskrr_downloads{status="Queued",category="radarr"} 2`,
+		"slskrr_searches_queued 0",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in:\n%s", want, body)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/nerney/slskrr/notify"
+)
+
+// slskdWatch probes slskd and notifies once it has failed to answer for
+// DownAfter, then again when it answers, so a bridge silently failing to
+// reach slskd doesn't go unnoticed until someone reads the logs.
+type slskdWatch struct {
+	Probe     func(ctx context.Context) error
+	Notifier  *notify.Dispatcher
+	URL       string
+	DownAfter time.Duration
+
+	failingSince time.Time // zero while slskd answers
+	notified     bool      // whether EventUnreachable went out for this outage
+}
+
+// Run probes slskd every interval until ctx is cancelled.
+func (w *slskdWatch) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.check(ctx, time.Now())
+		}
+	}
+}
+
+// check probes slskd once, as of now, sending a notification when the
+// outage crosses DownAfter or ends after one was sent.
+func (w *slskdWatch) check(ctx context.Context, now time.Time) {
+	probeCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	err := w.Probe(probeCtx)
+	cancel()
+	if ctx.Err() != nil {
+		return
+	}
+
+	if err == nil {
+		if w.notified {
+			down := now.Sub(w.failingSince)
+			slog.Info("slskd reachable again", "down", down.Round(time.Second))
+			w.Notifier.Send(notify.NewServiceMessage(notify.EventRecovered, "slskd", w.URL, down, ""))
+		}
+		w.failingSince, w.notified = time.Time{}, false
+		return
+	}
+
+	if w.failingSince.IsZero() {
+		w.failingSince = now
+	}
+	if down := now.Sub(w.failingSince); !w.notified && down >= w.DownAfter {
+		slog.Error("slskd unreachable", "down", down.Round(time.Second), "error", err)
+		w.Notifier.Send(notify.NewServiceMessage(notify.EventUnreachable, "slskd", w.URL, down, err.Error()))
+		w.notified = true
+	}
+}
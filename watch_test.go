@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nerney/slskrr/notify"
+)
+
+type recordNotifier struct {
+	mu   sync.Mutex
+	msgs []notify.Message
+}
+
+func (r *recordNotifier) Notify(_ context.Context, msg notify.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.msgs = append(r.msgs, msg)
+	return nil
+}
+
+func TestSlskdWatch(t *testing.T) {
+	rec := &recordNotifier{}
+	d := &notify.Dispatcher{}
+	d.Add("record", rec, notify.AllEvents)
+	var probeErr error
+	w := &slskdWatch{
+		Probe:     func(context.Context) error { return probeErr },
+		Notifier:  d,
+		URL:       "http://slskd:5030",
+		DownAfter: time.Minute,
+	}
+
+	ctx := context.Background()
+	start := time.Now()
+	w.check(ctx, start)
+	probeErr = errors.New("connection refused")
+	for _, after := range []time.Duration{15 * time.Second, 45 * time.Second, 75 * time.Second, 90 * time.Second} {
+		w.check(ctx, start.Add(after))
+	}
+	probeErr = nil
+	w.check(ctx, start.Add(2*time.Minute))
+	w.check(ctx, start.Add(3*time.Minute))
+	d.Wait()
+
+	if len(rec.msgs) != 2 {
+		t.Fatalf("expected one outage and one recovery notification, got %+v", rec.msgs)
+	}
+	down, up := rec.msgs[0], rec.msgs[1]
+	if down.Event != notify.EventUnreachable || down.Service == nil || down.Service.Error != "connection refused" || down.Service.Downtime != 60 {
+		t.Errorf("unexpected outage notification: %+v", down)
+	}
+	if up.Event != notify.EventRecovered || up.Service.Downtime != 105 {
+		t.Errorf("unexpected recovery notification: %+v", up)
+	}
+}
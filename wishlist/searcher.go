@@ -0,0 +1,174 @@
+package wishlist
+
+import (
+	"context"
+	"log/slog"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/nerney/slskrr/blocklist"
+	"github.com/nerney/slskrr/notify"
+	"github.com/nerney/slskrr/slskd"
+	"github.com/nerney/slskrr/store"
+	"github.com/nerney/slskrr/textnorm"
+)
+
+// Searcher re-runs wishlist searches every Interval and grabs the first
+// matching file into the store, where it is tracked like any SABnzbd grab.
+// A grab that fails re-arms its item; one that completes marks it done.
+type Searcher struct {
+	Wishlist      *Wishlist
+	Slskd         *slskd.Client
+	Store         *store.Store
+	Blocklist     *blocklist.Blocklist
+	Notifier      *notify.Dispatcher
+	Interval      time.Duration
+	SearchTimeout time.Duration
+	MaxSearches   int // items searched per run, least recently searched first
+}
+
+// Run searches immediately and then every Interval until ctx is cancelled.
+func (s *Searcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		s.RunOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce settles finished grabs and searches for up to MaxSearches items.
+func (s *Searcher) RunOnce(ctx context.Context) {
+	var due []Item
+	for _, it := range s.Wishlist.List() {
+		if it.Done {
+			continue
+		}
+		if it.DownloadID != "" {
+			s.checkGrab(it)
+			continue
+		}
+		due = append(due, it)
+	}
+
+	sort.SliceStable(due, func(i, j int) bool { return due[i].LastSearch.Before(due[j].LastSearch) })
+	for i, it := range due {
+		if i >= s.MaxSearches || ctx.Err() != nil {
+			return
+		}
+		s.search(ctx, it)
+	}
+}
+
+// checkGrab marks an item done when its download completed, and re-arms it
+// when the download failed or was removed before completing.
+func (s *Searcher) checkGrab(it Item) {
+	dl := s.Store.Lookup(it.DownloadID)
+	switch {
+	case dl != nil && dl.Status == store.StatusCompleted:
+		slog.Info("wishlist item fulfilled", "item", it.ID, "query", it.Query, "id", it.DownloadID)
+		s.set(it.ID, func(i *Item) { i.Done = true })
+	case dl == nil || dl.Status == store.StatusFailed:
+		slog.Info("wishlist grab failed, searching again", "item", it.ID, "query", it.Query, "id", it.DownloadID)
+		s.set(it.ID, func(i *Item) { i.DownloadID = "" })
+	}
+}
+
+func (s *Searcher) search(ctx context.Context, it Item) {
+	log := slog.With("item", it.ID, "query", it.Query)
+
+	responses, err := s.Slskd.SearchAndWait(ctx, it.Query, s.SearchTimeout)
+	if err != nil {
+		log.Error("wishlist search failed", "error", err)
+		return
+	}
+	s.set(it.ID, func(i *Item) { i.LastSearch = time.Now() })
+
+	responses = slices.DeleteFunc(responses, func(r slskd.SearchResponse) bool {
+		return s.Blocklist.Blocked(r.Username)
+	})
+	username, file, ok := Match(&it, responses)
+	if !ok {
+		log.Debug("no match for wishlist item", "responses", len(responses))
+		return
+	}
+
+	err = s.Slskd.Download(ctx, username, []slskd.DownloadRequest{{Filename: file.Filename, Size: file.Size}})
+	if err != nil {
+		log.Error("failed to queue wishlist grab", "username", username, "error", err)
+		return
+	}
+	id := s.Store.Add(username, file.Filename, file.Size, it.Category)
+	s.Store.SetBitRate(id, file.BitRate)
+	s.set(it.ID, func(i *Item) { i.DownloadID = id })
+	log.Info("grabbed wishlist item", "id", id, "username", username, "filename", file.Filename, "size", file.Size)
+	if dl := s.Store.Get(id); dl != nil {
+		s.Notifier.Send(notify.NewMessage(notify.EventGrab, dl, ""))
+	}
+}
+
+// set updates an item, logging rather than failing when it can't be saved.
+// The item may have been removed meanwhile, which is fine.
+func (s *Searcher) set(id string, fn func(*Item)) {
+	if err := s.Wishlist.update(id, fn); err != nil && err != ErrNotFound {
+		slog.Error("failed to persist wishlist", "error", err)
+	}
+}
+
+// Match returns the best file in responses that meets the item's
+// constraints: every query word in its path, an accepted format, and size
+// and bitrate within bounds. Peers with a free upload slot, short queues and
+// fast uploads are preferred.
+func Match(it *Item, responses []slskd.SearchResponse) (string, slskd.SlskdFile, bool) {
+	words := strings.FieldsFunc(textnorm.Fold(it.Query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	var (
+		bestUser  string
+		bestFile  slskd.SlskdFile
+		bestScore int
+		found     bool
+	)
+	for _, resp := range responses {
+		score := resp.PeerScore()
+		if found && score <= bestScore {
+			continue
+		}
+		for _, f := range resp.Files {
+			if matches(it, words, f) {
+				bestUser, bestFile, bestScore, found = resp.Username, f, score, true
+				break
+			}
+		}
+	}
+	return bestUser, bestFile, found
+}
+
+func matches(it *Item, words []string, f slskd.SlskdFile) bool {
+	if f.IsLocked || f.Size < it.MinSize || (it.MaxSize > 0 && f.Size > it.MaxSize) {
+		return false
+	}
+	if it.MinBitRate > 0 && f.BitRate < it.MinBitRate {
+		return false
+	}
+	name := textnorm.Fold(strings.ReplaceAll(f.Filename, "\\", "/"))
+	if len(it.Formats) > 0 && !slices.Contains(it.Formats, strings.TrimPrefix(path.Ext(name), ".")) {
+		return false
+	}
+	for _, w := range words {
+		if !strings.Contains(name, w) {
+			return false
+		}
+	}
+	return true
+}
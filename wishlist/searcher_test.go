@@ -0,0 +1,110 @@
+package wishlist
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nerney/slskrr/slskd"
+	"github.com/nerney/slskrr/store"
+)
+
+func TestMatch(t *testing.T) {
+	it := &Item{Query: "Artist - Rare Live", Formats: []string{"flac"}, MinSize: 1000}
+	responses := []slskd.SearchResponse{
+		{Username: "mp3peer", HasFreeUploadSlot: true, Files: []slskd.SlskdFile{
+			{Filename: `Music\Artist\Rare Live.mp3`, Size: 5000},
+		}},
+		{Username: "slowpeer", QueueLength: 50, Files: []slskd.SlskdFile{
+			{Filename: `Music\Artist\Rare Live.flac`, Size: 5000},
+		}},
+		{Username: "fastpeer", HasFreeUploadSlot: true, Files: []slskd.SlskdFile{
+			{Filename: `Music\Artist\Rare Live (tiny).flac`, Size: 10},
+			{Filename: `Music\Artist\Rare Live.flac`, Size: 5000},
+		}},
+		{Username: "wrongpeer", HasFreeUploadSlot: true, Files: []slskd.SlskdFile{
+			{Filename: `Music\Artist\Studio.flac`, Size: 5000},
+		}},
+	}
+
+	username, file, ok := Match(it, responses)
+	if !ok || username != "fastpeer" || file.Size != 5000 {
+		t.Errorf("expected fastpeer's full-size flac, got %q %+v %v", username, file, ok)
+	}
+
+	if _, _, ok := Match(&Item{Query: "nothing here"}, responses); ok {
+		t.Error("expected no match")
+	}
+}
+
+func TestSearcher_GrabAndSettle(t *testing.T) {
+	var searches int
+	var queued []slskd.DownloadRequest
+	slskdSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/api/v0/searches":
+			searches++
+			json.NewEncoder(w).Encode(slskd.SearchResult{ID: "s1"})
+		case r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/api/v0/searches/"):
+			json.NewEncoder(w).Encode(slskd.SearchResult{ID: "s1", IsComplete: true, Responses: []slskd.SearchResponse{
+				{Username: "peer", Files: []slskd.SlskdFile{{Filename: `Books\Rare Book.epub`, Size: 2000}}},
+			}})
+		case r.Method == "POST" && strings.HasPrefix(r.URL.Path, "/api/v0/transfers/downloads/"):
+			json.NewDecoder(r.Body).Decode(&queued)
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer slskdSrv.Close()
+
+	st := store.New()
+	wl := New()
+	item, _ := wl.Add(Item{Query: "rare book", Category: "readarr"})
+	s := &Searcher{
+		Wishlist:      wl,
+		Slskd:         slskd.NewClient(slskdSrv.URL, "slskdkey"),
+		Store:         st,
+		SearchTimeout: 10 * time.Second,
+		MaxSearches:   5,
+	}
+
+	s.RunOnce(context.Background())
+	if len(queued) != 1 || queued[0].Filename != `Books\Rare Book.epub` {
+		t.Fatalf("expected the book to be queued, got %+v", queued)
+	}
+	got := wl.List()[0]
+	dl := st.Get(got.DownloadID)
+	if dl == nil || dl.Category != "readarr" {
+		t.Fatalf("expected tracked download, got %+v", dl)
+	}
+
+	// While the grab is in flight the item isn't searched again.
+	s.RunOnce(context.Background())
+	if searches != 1 {
+		t.Errorf("expected 1 search while grabbed, got %d", searches)
+	}
+
+	// A failed grab re-arms the item.
+	st.UpdateTransfer(dl.ID, 0, store.StatusFailed)
+	s.RunOnce(context.Background())
+	if got := wl.List()[0]; got.Done {
+		t.Error("expected failed grab not to mark the item done")
+	}
+
+	// The next run searches again; completing that grab finishes the item.
+	s.RunOnce(context.Background())
+	got = wl.List()[0]
+	if got.DownloadID == "" || got.DownloadID == dl.ID {
+		t.Fatalf("expected a new grab, got %+v", got)
+	}
+	st.UpdateTransfer(got.DownloadID, 2000, store.StatusCompleted)
+	s.RunOnce(context.Background())
+	if got := wl.List()[0]; !got.Done || got.ID != item.ID {
+		t.Errorf("expected item done, got %+v", got)
+	}
+}
@@ -0,0 +1,172 @@
+// Package wishlist keeps searches that are re-run on a schedule until a
+// matching file turns up on Soulseek. Peers come and go, so something a
+// one-shot search misses is often available hours later.
+package wishlist
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotFound = errors.New("wishlist item not found")
+	ErrInvalid  = errors.New("invalid wishlist item")
+)
+
+// Item is a wanted file and the constraints a result must meet.
+type Item struct {
+	ID         string    `json:"id"`
+	Query      string    `json:"query"`
+	Category   string    `json:"category,omitempty"`
+	Formats    []string  `json:"formats,omitempty"` // accepted extensions; empty accepts any
+	MinSize    int64     `json:"minSize,omitempty"` // bytes
+	MaxSize    int64     `json:"maxSize,omitempty"` // bytes; 0 is unlimited
+	MinBitRate int       `json:"minBitRate,omitempty"`
+	AddedAt    time.Time `json:"addedAt"`
+	LastSearch time.Time `json:"lastSearch,omitempty"`
+	DownloadID string    `json:"downloadId,omitempty"` // set while a grab is in progress
+	Done       bool      `json:"done"`                 // the grab completed; no longer searched
+}
+
+// Wishlist holds the wanted items.
+type Wishlist struct {
+	mu    sync.Mutex
+	items map[string]*Item
+	path  string
+}
+
+func New() *Wishlist {
+	return &Wishlist{items: make(map[string]*Item)}
+}
+
+// Add validates item, assigns it an ID and stores it.
+func (w *Wishlist) Add(item Item) (Item, error) {
+	item.Query = strings.TrimSpace(item.Query)
+	if item.Query == "" {
+		return Item{}, fmt.Errorf("%w: query is required", ErrInvalid)
+	}
+	if item.MaxSize > 0 && item.MaxSize < item.MinSize {
+		return Item{}, fmt.Errorf("%w: maxSize is below minSize", ErrInvalid)
+	}
+	for i, f := range item.Formats {
+		item.Formats[i] = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(f), "."))
+	}
+
+	b := make([]byte, 6)
+	_, _ = rand.Read(b)
+	item.ID = hex.EncodeToString(b)
+	item.AddedAt = time.Now()
+	item.LastSearch = time.Time{}
+	item.DownloadID = ""
+	item.Done = false
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.items[item.ID] = &item
+	return item, w.save()
+}
+
+// List returns all items, oldest first.
+func (w *Wishlist) List() []Item {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	result := make([]Item, 0, len(w.items))
+	for _, it := range w.items {
+		result = append(result, *it)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].AddedAt.Before(result[j].AddedAt) })
+	return result
+}
+
+// Remove deletes an item.
+func (w *Wishlist) Remove(id string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.items[id]; !ok {
+		return ErrNotFound
+	}
+	delete(w.items, id)
+	return w.save()
+}
+
+// Len returns the number of items.
+func (w *Wishlist) Len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.items)
+}
+
+// update applies fn to the item with id, if it still exists, and persists
+// the result.
+func (w *Wishlist) update(id string, fn func(*Item)) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	it, ok := w.items[id]
+	if !ok {
+		return ErrNotFound
+	}
+	fn(it)
+	return w.save()
+}
+
+// Load reads items from path and persists future changes there. A missing
+// file is not an error.
+func (w *Wishlist) Load(path string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.path = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read wishlist file: %w", err)
+	}
+	var items []*Item
+	if err := json.Unmarshal(data, &items); err != nil {
+		return fmt.Errorf("decode wishlist file: %w", err)
+	}
+	for _, it := range items {
+		w.items[it.ID] = it
+	}
+	return nil
+}
+
+// save writes all items atomically. Callers must hold w.mu.
+func (w *Wishlist) save() error {
+	if w.path == "" {
+		return nil
+	}
+	items := make([]*Item, 0, len(w.items))
+	for _, it := range w.items {
+		items = append(items, it)
+	}
+	data, err := json.MarshalIndent(items, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode wishlist: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(w.path), 0o755); err != nil {
+		return fmt.Errorf("create wishlist dir: %w", err)
+	}
+	tmp := w.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("write wishlist file: %w", err)
+	}
+	if err := os.Rename(tmp, w.path); err != nil {
+		return fmt.Errorf("replace wishlist file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package wishlist
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestWishlist_AddValidates(t *testing.T) {
+	w := New()
+	if _, err := w.Add(Item{Query: "  "}); err == nil {
+		t.Error("expected error for empty query")
+	}
+	if _, err := w.Add(Item{Query: "x", MinSize: 10, MaxSize: 5}); err == nil {
+		t.Error("expected error for maxSize below minSize")
+	}
+
+	it, err := w.Add(Item{Query: " Some Album ", Formats: []string{".FLAC"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if it.ID == "" || it.Query != "Some Album" || it.Formats[0] != "flac" {
+		t.Errorf("unexpected item: %+v", it)
+	}
+}
+
+func TestWishlist_RemovePersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wishlist.json")
+
+	w := New()
+	if err := w.Load(path); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	keep, _ := w.Add(Item{Query: "keep me"})
+	drop, _ := w.Add(Item{Query: "drop me"})
+	if err := w.Remove(drop.ID); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if err := w.Remove(drop.ID); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	reloaded := New()
+	if err := reloaded.Load(path); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	items := reloaded.List()
+	if len(items) != 1 || items[0].ID != keep.ID {
+		t.Errorf("unexpected reloaded items: %+v", items)
+	}
+}